@@ -33,30 +33,46 @@ func main() {
 
 	if autospotting.RunningFromLambda() {
 		lambda.Start(Handler)
+		return
+	}
+
+	var err error
+	if conf.Daemon {
+		err = as.RunDaemon(func() error { return eventHandler(nil) })
 	} else if eventFile != "" {
-		parseEvent, err := ioutil.ReadFile(eventFile)
-		if err != nil {
-			log.Fatal(err)
+		parseEvent, readErr := ioutil.ReadFile(eventFile)
+		if readErr != nil {
+			log.Fatal(readErr)
 		}
-		Handler(context.TODO(), parseEvent)
+		rawEvent := json.RawMessage(parseEvent)
+		err = eventHandler(&rawEvent)
 	} else {
-		eventHandler(nil)
+		err = eventHandler(nil)
+	}
+
+	// exit with a non-zero code when the run failed in any region
+	if err != nil {
+		log.Fatal(err)
 	}
 }
 
-func eventHandler(event *json.RawMessage) {
+func eventHandler(event *json.RawMessage) error {
 
 	log.Println("Starting autospotting agent, build ", Version, "expiring on", ExpirationDate, "charging", SavingsCut, "percent of savings via AWS Marketplace")
 
 	if isExpired(ExpirationDate) {
 		log.Println("Autospotting expired, please install a newer nightly version, build it from source or get a stable build.")
-		return
+		return nil
 	}
 
 	log.Printf("Configuration flags: %#v", conf)
 
-	as.EventHandler(event)
+	if err := as.EventHandler(event); err != nil {
+		log.Println("Execution failed:", err.Error())
+		return err
+	}
 	log.Println("Execution completed, nothing left to do")
+	return nil
 }
 
 // this is the equivalent of a main for when running from Lambda, but on Lambda
@@ -80,5 +96,7 @@ func init() {
 
 // Handler implements the AWS Lambda handler interface
 func Handler(ctx context.Context, rawEvent json.RawMessage) {
+	// failures are reported in the logs, returning them would make Lambda retry
+	// the whole run
 	eventHandler(&rawEvent)
 }
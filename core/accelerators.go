@@ -0,0 +1,103 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// accelerator describes the GPUs, inference accelerators or FPGAs of an
+// instance type, such as 4 NVIDIA T4 GPUs with 16 GiB of memory each.
+type accelerator struct {
+	manufacturer string
+	model        string
+	count        int64
+
+	// memory of each device
+	memoryMiB int64
+}
+
+func (a accelerator) totalMemoryMiB() int64 {
+	return a.count * a.memoryMiB
+}
+
+// sameModel compares the manufacturer and model names, which aren't
+// consistently capitalized by the EC2 API.
+func (a accelerator) sameModel(other accelerator) bool {
+	return strings.EqualFold(a.manufacturer, other.manufacturer) &&
+		strings.EqualFold(a.model, other.model)
+}
+
+// acceleratorInfo contains the accelerators of an instance type, only known
+// when fetched from the EC2 API, as marked by the detailed flag.
+type acceleratorInfo struct {
+	gpu       accelerator
+	inference accelerator
+	fpga      accelerator
+	detailed  bool
+}
+
+// acceleratorInfoFromAPI converts the accelerators described by the EC2 API.
+// The instance types only have a single model of each kind of accelerator, so
+// only the first one is considered.
+func acceleratorInfoFromAPI(it *ec2.InstanceTypeInfo) acceleratorInfo {
+	info := acceleratorInfo{detailed: true}
+
+	if it.GpuInfo != nil && len(it.GpuInfo.Gpus) > 0 {
+		g := it.GpuInfo.Gpus[0]
+		info.gpu = accelerator{
+			manufacturer: aws.StringValue(g.Manufacturer),
+			model:        aws.StringValue(g.Name),
+			count:        aws.Int64Value(g.Count),
+		}
+		if g.MemoryInfo != nil {
+			info.gpu.memoryMiB = aws.Int64Value(g.MemoryInfo.SizeInMiB)
+		}
+	}
+
+	if it.InferenceAcceleratorInfo != nil && len(it.InferenceAcceleratorInfo.Accelerators) > 0 {
+		a := it.InferenceAcceleratorInfo.Accelerators[0]
+		info.inference = accelerator{
+			manufacturer: aws.StringValue(a.Manufacturer),
+			model:        aws.StringValue(a.Name),
+			count:        aws.Int64Value(a.Count),
+		}
+	}
+
+	if it.FpgaInfo != nil && len(it.FpgaInfo.Fpgas) > 0 {
+		f := it.FpgaInfo.Fpgas[0]
+		info.fpga = accelerator{
+			manufacturer: aws.StringValue(f.Manufacturer),
+			model:        aws.StringValue(f.Name),
+			count:        aws.Int64Value(f.Count),
+		}
+		if f.MemoryInfo != nil {
+			info.fpga.memoryMiB = aws.Int64Value(f.MemoryInfo.SizeInMiB)
+		}
+	}
+
+	return info
+}
+
+// matchesGPUModels checks the GPU model of the candidate against the globs
+// pinning the allowed GPU models, where the special "current" value stands for
+// the model of the current instance type.
+func matchesGPUModels(candidate, current accelerator, patterns string) bool {
+	for _, p := range strings.FieldsFunc(patterns, func(c rune) bool { return c == ',' || c == ' ' }) {
+		if p == "current" {
+			if candidate.sameModel(current) {
+				return true
+			}
+			continue
+		}
+		if match, _ := filepath.Match(strings.ToLower(p), strings.ToLower(candidate.model)); match {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+)
+
+func Test_matchesGPUModels(t *testing.T) {
+	t4 := accelerator{manufacturer: "NVIDIA", model: "T4", count: 1, memoryMiB: 16384}
+	a10g := accelerator{manufacturer: "NVIDIA", model: "A10G", count: 1, memoryMiB: 24576}
+
+	tests := []struct {
+		name      string
+		candidate accelerator
+		patterns  string
+		want      bool
+	}{
+		{name: "current model", candidate: t4, patterns: "current", want: true},
+		{name: "other model than the current one", candidate: a10g, patterns: "current", want: false},
+		{name: "listed model", candidate: a10g, patterns: "current, a10g", want: true},
+		{name: "glob", candidate: a10g, patterns: "A*", want: true},
+		{name: "not listed", candidate: a10g, patterns: "T4,V100", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesGPUModels(tt.candidate, t4, tt.patterns); got != tt.want {
+				t.Errorf("matchesGPUModels() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/organizations"
+)
+
+// DefaultAssumeRoleName is the default name of the role assumed in the
+// accounts discovered from AWS Organizations.
+const DefaultAssumeRoleName = "AutoSpotting"
+
+// account is an AWS account processed by assuming a role in it.
+type account struct {
+	id      string
+	roleARN string
+}
+
+// parseRoleARNs parses a comma or whitespace separated list of IAM role ARNs
+// into the accounts they belong to.
+func parseRoleARNs(roleARNs string) ([]account, error) {
+	var accounts []account
+
+	for _, roleARN := range strings.Fields(strings.Replace(roleARNs, ",", " ", -1)) {
+		parsed, err := arn.Parse(roleARN)
+		if err != nil || parsed.Service != "iam" || !strings.HasPrefix(parsed.Resource, "role/") {
+			return nil, fmt.Errorf("invalid IAM role ARN %q", roleARN)
+		}
+		accounts = append(accounts, account{id: parsed.AccountID, roleARN: roleARN})
+	}
+	return accounts, nil
+}
+
+// listOrganizationalUnitAccounts returns the active accounts directly under
+// the given organizational unit, with the ARN of the role to assume in them.
+func (a *AutoSpotting) listOrganizationalUnitAccounts(ouID string) ([]account, error) {
+	var accounts []account
+
+	err := a.organizations.ListAccountsForParentPages(
+		&organizations.ListAccountsForParentInput{ParentId: aws.String(ouID)},
+		func(page *organizations.ListAccountsForParentOutput, lastPage bool) bool {
+			for _, acc := range page.Accounts {
+				if aws.StringValue(acc.Status) != organizations.AccountStatusActive {
+					debug.Println("Skipping account", aws.StringValue(acc.Id), "in status", aws.StringValue(acc.Status))
+					continue
+				}
+
+				partition := "aws"
+				if parsed, err := arn.Parse(aws.StringValue(acc.Arn)); err == nil {
+					partition = parsed.Partition
+				}
+
+				accounts = append(accounts, account{
+					id: *acc.Id,
+					roleARN: fmt.Sprintf("arn:%s:iam::%s:role/%s",
+						partition, *acc.Id, a.config.AssumeRoleName),
+				})
+			}
+			return true
+		})
+
+	if err != nil {
+		log.Println("Couldn't list the accounts of the organizational unit", ouID, err.Error())
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// getAccounts returns the accounts configured explicitly through their role
+// ARNs and the ones discovered from the configured organizational units. It
+// returns no accounts when none are configured, in which case only the account
+// where AutoSpotting runs is processed.
+func (a *AutoSpotting) getAccounts() ([]account, error) {
+	accounts, err := parseRoleARNs(a.config.AssumeRoleARNs)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, err
+	}
+
+	for _, ouID := range strings.Fields(strings.Replace(a.config.OrganizationalUnitIDs, ",", " ", -1)) {
+		ouAccounts, err := a.listOrganizationalUnitAccounts(ouID)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, ouAccounts...)
+	}
+
+	// the same account may be both listed and discovered
+	var unique []account
+	seen := map[string]bool{}
+	for _, acc := range accounts {
+		if !seen[acc.id] {
+			seen[acc.id] = true
+			unique = append(unique, acc)
+		}
+	}
+	return unique, nil
+}
+
+// getAccountConfigs returns the configuration used for processing each of the
+// accounts, with separate reports added to the report of the run.
+func (a *AutoSpotting) getAccountConfigs() ([]*Config, error) {
+	accounts, err := a.getAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(accounts) == 0 {
+		return []*Config{a.config}, nil
+	}
+
+	var configs []*Config
+	for _, acc := range accounts {
+		log.Println("Processing account", acc.id, "by assuming the role", acc.roleARN)
+		cfg := a.config.accountConfig(acc)
+		a.config.report.addAccount(cfg.report)
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
+
+// accountConfig returns a copy of the configuration used for processing the
+// given account, with its own report.
+func (cfg *Config) accountConfig(acc account) *Config {
+	accountCfg := *cfg
+	accountCfg.accountID = acc.id
+	accountCfg.roleARN = acc.roleARN
+	accountCfg.report = newRunReport()
+	accountCfg.report.Account = acc.id
+	return &accountCfg
+}
+
+// getAccountRegions lists the regions available in the account of the given
+// configuration.
+func (a *AutoSpotting) getAccountRegions(cfg *Config) ([]string, error) {
+	if cfg.roleARN == "" {
+		return a.getRegions()
+	}
+	return describeRegions(ec2.New(newRoleSession(cfg.MainRegion, cfg.roleARN)))
+}
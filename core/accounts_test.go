@@ -0,0 +1,156 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+)
+
+func Test_parseRoleARNs(t *testing.T) {
+	tests := []struct {
+		name     string
+		roleARNs string
+		want     []account
+		wantErr  bool
+	}{
+		{
+			name: "no roles",
+		},
+		{
+			name:     "multiple roles",
+			roleARNs: "arn:aws:iam::111111111111:role/AutoSpotting, arn:aws-cn:iam::222222222222:role/path/Spot",
+			want: []account{
+				{id: "111111111111", roleARN: "arn:aws:iam::111111111111:role/AutoSpotting"},
+				{id: "222222222222", roleARN: "arn:aws-cn:iam::222222222222:role/path/Spot"},
+			},
+		},
+		{
+			name:     "not an ARN",
+			roleARNs: "AutoSpotting",
+			wantErr:  true,
+		},
+		{
+			name:     "not a role",
+			roleARNs: "arn:aws:iam::111111111111:user/AutoSpotting",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRoleARNs(tt.roleARNs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseRoleARNs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRoleARNs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetAccounts(t *testing.T) {
+	ouAccounts := mockOrganizations{
+		lafpo: map[string]*organizations.ListAccountsForParentOutput{
+			"ou-1": {
+				Accounts: []*organizations.Account{
+					{
+						Id:     aws.String("111111111111"),
+						Arn:    aws.String("arn:aws:organizations::999999999999:account/o-1/111111111111"),
+						Status: aws.String(organizations.AccountStatusActive),
+					},
+					{
+						Id:     aws.String("222222222222"),
+						Arn:    aws.String("arn:aws:organizations::999999999999:account/o-1/222222222222"),
+						Status: aws.String(organizations.AccountStatusSuspended),
+					},
+					{
+						Id:     aws.String("333333333333"),
+						Arn:    aws.String("arn:aws:organizations::999999999999:account/o-1/333333333333"),
+						Status: aws.String(organizations.AccountStatusActive),
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		config  Config
+		orgs    mockOrganizations
+		want    []account
+		wantErr bool
+	}{
+		{
+			name: "no accounts configured",
+		},
+		{
+			name: "listed and discovered accounts",
+			config: Config{
+				AssumeRoleARNs:        "arn:aws:iam::111111111111:role/Custom",
+				OrganizationalUnitIDs: "ou-1",
+				AssumeRoleName:        DefaultAssumeRoleName,
+			},
+			orgs: ouAccounts,
+			want: []account{
+				{id: "111111111111", roleARN: "arn:aws:iam::111111111111:role/Custom"},
+				{id: "333333333333", roleARN: "arn:aws:iam::333333333333:role/AutoSpotting"},
+			},
+		},
+		{
+			name:    "organizations failure",
+			config:  Config{OrganizationalUnitIDs: "ou-1"},
+			orgs:    mockOrganizations{lafperr: errors.New("access denied")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &AutoSpotting{config: &tt.config, organizations: tt.orgs}
+			got, err := a.getAccounts()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getAccounts() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getAccounts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetAccountConfigs(t *testing.T) {
+	cfg := &Config{
+		AssumeRoleARNs: "arn:aws:iam::111111111111:role/AutoSpotting",
+		report:         newRunReport(),
+	}
+	a := &AutoSpotting{config: cfg}
+
+	configs, err := a.getAccountConfigs()
+	if err != nil {
+		t.Fatalf("getAccountConfigs() error = %v", err)
+	}
+
+	if len(configs) != 1 || configs[0] == cfg {
+		t.Fatalf("getAccountConfigs() = %v, want a separate configuration", configs)
+	}
+
+	got := configs[0]
+	if got.accountID != "111111111111" || got.roleARN != "arn:aws:iam::111111111111:role/AutoSpotting" {
+		t.Errorf("unexpected account %s and role %s", got.accountID, got.roleARN)
+	}
+
+	if got.report == cfg.report || len(cfg.report.Accounts) != 1 || cfg.report.Accounts[0] != got.report {
+		t.Errorf("the account report wasn't added to the run report")
+	}
+
+	if r := newRegion("us-east-1", got); r.services.roleARN != got.roleARN {
+		t.Errorf("the region doesn't connect using the role of the account")
+	}
+}
@@ -3,7 +3,25 @@
 
 package autospotting
 
-import "log"
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Names of the actions, as shown in the dry-run plan and in the run report
+const (
+	skipRunAction                       = "skip"
+	terminateSpotInstanceAction         = "terminate-spot-instance"
+	launchSpotReplacementAction         = "launch-spot-replacement"
+	terminateUnneededSpotInstanceAction = "terminate-unneeded-spot-instance"
+	swapSpotInstanceAction              = "swap-spot-instance"
+	sqsSendMessageAction                = "sqs-send-message"
+	launchBeforeDetachAction            = "launch-before-detach"
+	terminateScheduledInstanceAction    = "terminate-scheduled-instance"
+)
 
 type target struct {
 	asg              *autoScalingGroup
@@ -13,7 +31,10 @@ type target struct {
 }
 
 type runer interface {
-	run()
+	// run executes the action and reports its outcome
+	run() GroupReport
+	// describe computes what run() would do, without changing anything
+	describe() plannedAction
 }
 
 // No-op run
@@ -21,17 +42,62 @@ type skipRun struct {
 	reason string
 }
 
-func (s skipRun) run() {}
+func (s skipRun) run() GroupReport {
+	return GroupReport{Action: skipRunAction, Reason: s.reason}
+}
+
+func (s skipRun) describe() plannedAction {
+	return plannedAction{Action: skipRunAction, Reason: s.reason}
+}
 
 // terminates a random spot instance after enabling the event-based logic
 type terminateSpotInstance struct {
 	target target
 }
 
-func (tsi terminateSpotInstance) run() {
+func (tsi terminateSpotInstance) run() GroupReport {
 	asg := tsi.target.asg
-	asg.terminateRandomSpotInstanceIfHavingEnough(
+	gr := GroupReport{
+		Action: terminateSpotInstanceAction,
+		Reason: reasonTooFewOnDemandInstances,
+	}
+
+	randomSpot, err := asg.terminateRandomSpotInstanceIfHavingEnough(
 		tsi.target.totalInstances, true)
+
+	if randomSpot == nil {
+		gr.Action, gr.Reason = skipRunAction, reasonNoSpotInstanceToTerminate
+		return gr
+	}
+
+	gr.InstanceIDs = []string{*randomSpot.InstanceId}
+	if err != nil {
+		gr.addError(err)
+		return gr
+	}
+
+	gr.SavingsDelta = -randomSpot.getSavings()
+	return gr
+}
+
+func (tsi terminateSpotInstance) describe() plannedAction {
+	asg := tsi.target.asg
+	reason := fmt.Sprintf("fewer than the required %d on-demand instances are running",
+		asg.config.MinOnDemand)
+
+	randomSpot := asg.spotInstanceToTerminate(tsi.target.totalInstances)
+	if randomSpot == nil {
+		return plannedAction{
+			Region:           asg.region.name,
+			AutoScalingGroup: asg.name,
+			Action:           skipRunAction,
+			Reason:           reason + ", but no spot instance can be terminated",
+		}
+	}
+
+	pa := instanceAction(terminateSpotInstanceAction, randomSpot, reason)
+	pa.AutoScalingGroup = asg.name
+	return pa
 }
 
 // launches a spot instance replacement
@@ -39,47 +105,158 @@ type launchSpotReplacement struct {
 	target target
 }
 
-func (lsr launchSpotReplacement) run() {
-	spotInstanceID, err := lsr.target.onDemandInstance.launchSpotReplacement()
+func (lsr launchSpotReplacement) run() GroupReport {
+	odInstance := lsr.target.onDemandInstance
+	gr := GroupReport{
+		Action:      launchSpotReplacementAction,
+		InstanceIDs: []string{*odInstance.InstanceId},
+		Reason:      reasonOnDemandInstanceToSpot,
+	}
+
+	spotInstanceID, err := odInstance.launchSpotReplacement()
+	if errors.Is(err, errReplacementInProgress) {
+		gr.Action, gr.Reason = skipRunAction, reasonReplacementInProgress
+		return gr
+	}
 	if err != nil {
 		log.Printf("Could not launch replacement spot instance: %s", err)
-		return
+		gr.addError(err)
+		return gr
 	}
 	log.Printf("Successfully launched spot instance %s, exiting...", *spotInstanceID)
+
+	// the savings only materialize once the spot instance is swapped in
+	gr.InstanceIDs = append(gr.InstanceIDs, *spotInstanceID)
+	return gr
+}
+
+func (lsr launchSpotReplacement) describe() plannedAction {
+	i := lsr.target.onDemandInstance
+
+	pa := instanceAction(launchSpotReplacementAction, i,
+		"on-demand instance can be replaced with a cheaper spot instance")
+
+	// price a copy of the instance, planning mustn't change the price compared
+	// by the other actions of the same run
+	priced := *i
+	priced.price = i.typeInfo.pricing.onDemand * i.asg.config.OnDemandPriceMultiplier
+	instanceTypes, err := priced.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(
+		i.asg.getAllowedInstanceTypes(i),
+		i.asg.getDisallowedInstanceTypes(i))
+
+	if err != nil {
+		pa.Action = skipRunAction
+		pa.Reason = err.Error()
+		return pa
+	}
+
+	pa.CandidateInstanceTypes = aws.StringValueSlice(instanceTypes)
+	return pa
 }
 
 type terminateUnneededSpotInstance struct {
 	target target
 }
 
-func (tusi terminateUnneededSpotInstance) run() {
+func (tusi terminateUnneededSpotInstance) run() GroupReport {
 	asg := tusi.target.asg
 	spotInstance := tusi.target.spotInstance
 	spotInstanceID := *spotInstance.InstanceId
 
+	gr := GroupReport{
+		Action:      terminateUnneededSpotInstanceAction,
+		InstanceIDs: []string{spotInstanceID},
+		Reason:      reasonSpotInstanceNotNeeded,
+	}
+
 	log.Println("Spot instance", spotInstanceID, "is not need anymore by ASG",
 		asg.name, "terminating the spot instance.")
-	spotInstance.terminate()
+	if err := spotInstance.terminate(); err != nil {
+		gr.addError(err)
+	}
+	return gr
+}
+
+func (tusi terminateUnneededSpotInstance) describe() plannedAction {
+	pa := instanceAction(terminateUnneededSpotInstanceAction, tusi.target.spotInstance,
+		"unattached spot instance is no longer needed by the group")
+	pa.AutoScalingGroup = tusi.target.asg.name
+	return pa
 }
 
 type swapSpotInstance struct {
 	target target
 }
 
-func (ssi swapSpotInstance) run() {
+func (ssi swapSpotInstance) run() GroupReport {
 	asg := ssi.target.asg
 	spotInstanceID := *ssi.target.spotInstance.InstanceId
-	asg.replaceOnDemandInstanceWithSpot(spotInstanceID)
+
+	gr := GroupReport{
+		Action:      swapSpotInstanceAction,
+		InstanceIDs: []string{spotInstanceID},
+		Reason:      reasonSpotInstanceReady,
+	}
+
+	odInstance, err := asg.replaceOnDemandInstanceWithSpot(spotInstanceID)
+	if errors.Is(err, errReplacementInProgress) {
+		gr.Action, gr.Reason = skipRunAction, reasonReplacementInProgress
+		return gr
+	}
+	if err != nil {
+		gr.addError(err)
+		return gr
+	}
+
+	// when using SQS the swap is delegated to a later event-based run
+	if odInstance == nil {
+		gr.Reason = reasonDelegatedToSQS
+		return gr
+	}
+
+	gr.InstanceIDs = append(gr.InstanceIDs, *odInstance.InstanceId)
+	gr.SavingsDelta = odInstance.price - ssi.target.spotInstance.price
+	return gr
+}
+
+func (ssi swapSpotInstance) describe() plannedAction {
+	spotInstance := ssi.target.spotInstance
+
+	pa := instanceAction(swapSpotInstanceAction, spotInstance,
+		"spot instance is ready to be attached to the group")
+	pa.AutoScalingGroup = ssi.target.asg.name
+
+	if odInstanceID := spotInstance.getReplacementTargetInstanceID(); odInstanceID != nil {
+		pa.TargetInstanceID = *odInstanceID
+	}
+	return pa
 }
 
 type sqsSendMessageOnInstanceLaunch struct {
 	target target
 }
 
-func (ssmoil sqsSendMessageOnInstanceLaunch) run() {
+func (ssmoil sqsSendMessageOnInstanceLaunch) run() GroupReport {
 	asg := ssmoil.target.asg
 	onDemandInstanceID := ssmoil.target.onDemandInstance.InstanceId
 	region := ssmoil.target.onDemandInstance.region
 	state := ssmoil.target.onDemandInstance.State.Name
-	region.sqsSendMessageOnInstanceLaunch(&asg.name, onDemandInstanceID, state, "cron-spot-instance-launch")
+
+	gr := GroupReport{
+		Action:      sqsSendMessageAction,
+		InstanceIDs: []string{*onDemandInstanceID},
+		Reason:      reasonDelegatedToSQS,
+	}
+
+	if err := region.sqsSendMessageOnInstanceLaunch(&asg.name, onDemandInstanceID, state, "cron-spot-instance-launch"); err != nil {
+		gr.addError(err)
+	}
+	return gr
+}
+
+func (ssmoil sqsSendMessageOnInstanceLaunch) describe() plannedAction {
+	pa := instanceAction(sqsSendMessageAction, ssmoil.target.onDemandInstance,
+		"on-demand instance replacement is delegated to the SQS queue")
+	pa.AutoScalingGroup = ssmoil.target.asg.name
+	return pa
 }
@@ -0,0 +1,99 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// attributeRange is an inclusive range of values of an instance type attribute
+// such as the number of vCPUs, parsed from values like "4-16", "4-" for no
+// upper bound, "-16" for no lower bound or "8" for an exact value.
+type attributeRange struct {
+	min float64
+	max float64
+}
+
+func parseAttributeRange(value string) (attributeRange, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return attributeRange{}, errors.New("empty range")
+	}
+
+	parseBound := func(s string, unset float64) (float64, error) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return unset, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || f < 0 {
+			return 0, errors.New("invalid bound " + strconv.Quote(s))
+		}
+		return f, nil
+	}
+
+	lower, upper := value, value
+	if idx := strings.Index(value, "-"); idx >= 0 {
+		lower, upper = value[:idx], value[idx+1:]
+		if strings.TrimSpace(lower) == "" && strings.TrimSpace(upper) == "" {
+			return attributeRange{}, errors.New("missing both bounds")
+		}
+	}
+
+	min, err := parseBound(lower, 0)
+	if err != nil {
+		return attributeRange{}, err
+	}
+
+	max, err := parseBound(upper, math.Inf(1))
+	if err != nil {
+		return attributeRange{}, err
+	}
+
+	if min > max {
+		return attributeRange{}, errors.New("lower bound above the upper bound")
+	}
+
+	return attributeRange{min: min, max: max}, nil
+}
+
+func (r attributeRange) contains(value float64) bool {
+	return value >= r.min && value <= r.max
+}
+
+// instanceAttributeRanges holds the ranges configured for the attributes of
+// the replacement candidates, nil ranges falling back to requiring at least
+// the capacity of the replaced instance, unless allowSmaller is set.
+type instanceAttributeRanges struct {
+	vCPU          *attributeRange
+	memory        *attributeRange
+	memoryPerVCPU *attributeRange
+	allowSmaller  bool
+}
+
+// attributeRanges parses the attribute ranges of the configuration. The values
+// were already validated when loaded, so the invalid ones are just ignored.
+func (c AutoScalingConfig) attributeRanges() instanceAttributeRanges {
+	parse := func(value string) *attributeRange {
+		if value == "" {
+			return nil
+		}
+		r, err := parseAttributeRange(value)
+		if err != nil {
+			debug.Println("Ignoring invalid attribute range", value, err.Error())
+			return nil
+		}
+		return &r
+	}
+
+	return instanceAttributeRanges{
+		vCPU:          parse(c.VCPURange),
+		memory:        parse(c.MemoryGiBRange),
+		memoryPerVCPU: parse(c.MemoryPerVCPURange),
+		allowSmaller:  c.AllowSmaller,
+	}
+}
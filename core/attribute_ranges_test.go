@@ -0,0 +1,60 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_parseAttributeRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    attributeRange
+		wantErr bool
+	}{
+		{value: "4-16", want: attributeRange{min: 4, max: 16}},
+		{value: " 0.5 - 2.5 ", want: attributeRange{min: 0.5, max: 2.5}},
+		{value: "4-", want: attributeRange{min: 4, max: math.Inf(1)}},
+		{value: "-16", want: attributeRange{min: 0, max: 16}},
+		{value: "8", want: attributeRange{min: 8, max: 8}},
+		{value: "", wantErr: true},
+		{value: "-", wantErr: true},
+		{value: "16-4", wantErr: true},
+		{value: "four-16", wantErr: true},
+		{value: "4-16-32", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseAttributeRange(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseAttributeRange() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseAttributeRange() = %+v, expected %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoScalingConfig_attributeRanges(t *testing.T) {
+	got := AutoScalingConfig{
+		VCPURange:      "2-8",
+		MemoryGiBRange: "invalid",
+		AllowSmaller:   true,
+	}.attributeRanges()
+
+	if got.vCPU == nil || *got.vCPU != (attributeRange{min: 2, max: 8}) {
+		t.Errorf("attributeRanges() got vCPU range %+v, expected 2-8", got.vCPU)
+	}
+	if got.memory != nil || got.memoryPerVCPU != nil {
+		t.Errorf("attributeRanges() expected no memory ranges, got %+v and %+v",
+			got.memory, got.memoryPerVCPU)
+	}
+	if !got.allowSmaller {
+		t.Errorf("attributeRanges() expected smaller instance types to be allowed")
+	}
+}
@@ -24,6 +24,30 @@ type autoScalingGroup struct {
 	launchTemplate      *launchTemplate
 	instances           instances
 	config              AutoScalingConfig
+
+	// the global configuration merged with the overrides of the configuration
+	// file matching the region and the name of the group
+	overriddenConfig *AutoScalingConfig
+
+	// the instances moved into service from the warm pool, lazily loaded
+	// from the scaling activities of the group
+	warmPoolInstances map[string]bool
+}
+
+// defaults returns the configuration used for the group unless overridden by
+// its tags: the global one, or a merged copy when the configuration file has
+// overrides for the region or the name of the group.
+func (a *autoScalingGroup) defaults() *AutoScalingConfig {
+	conf := a.region.conf
+	if conf.regionOverrides[a.region.name] == nil && conf.groupOverrides[a.name] == nil {
+		return &conf.AutoScalingConfig
+	}
+
+	if a.overriddenConfig == nil {
+		c := conf.groupConfig(a.region.name, a.name)
+		a.overriddenConfig = &c
+	}
+	return a.overriddenConfig
 }
 
 func (a *autoScalingGroup) loadLaunchConfiguration() (*launchConfiguration, error) {
@@ -62,27 +86,20 @@ func (a *autoScalingGroup) loadLaunchTemplate() (*launchTemplate, error) {
 		return a.launchTemplate, nil
 	}
 
-	lt := a.LaunchTemplate
+	lt := a.getLaunchTemplateSpecification()
 
 	if lt == nil {
 		return nil, errors.New("missing launch template")
 	}
 
-	ltID := lt.LaunchTemplateId
-	ltVer := lt.Version
-
-	if ltID == nil || ltVer == nil {
+	if lt.LaunchTemplateId == nil && lt.LaunchTemplateName == nil {
 		return nil, errors.New("missing launch template")
 	}
 
 	svc := a.region.services.ec2
 
-	params := &ec2.DescribeLaunchTemplateVersionsInput{
-		LaunchTemplateId: ltID,
-		Versions:         []*string{ltVer},
-	}
-
-	resp, err := svc.DescribeLaunchTemplateVersions(params)
+	resp, err := svc.DescribeLaunchTemplateVersions(
+		describeLaunchTemplateVersionInput(lt))
 
 	if err != nil {
 		log.Println(err.Error())
@@ -141,7 +158,10 @@ func (a *autoScalingGroup) needReplaceOnDemandInstances() (bool, int64) {
 	return false, totalRunning
 }
 
-func (a *autoScalingGroup) terminateRandomSpotInstanceIfHavingEnough(totalRunning int64, wait bool) error {
+// spotInstanceToTerminate returns the spot instance that should be terminated
+// in order to make room for more on-demand capacity in the group, or nil if
+// no spot instance should be terminated.
+func (a *autoScalingGroup) spotInstanceToTerminate(totalRunning int64) *instance {
 
 	if totalRunning == 1 {
 		log.Println("Warning: blocking replacement of very last instance - consider raising ASG to >= 2")
@@ -165,25 +185,30 @@ func (a *autoScalingGroup) terminateRandomSpotInstanceIfHavingEnough(totalRunnin
 		log.Println("Couldn't pick a random spot instance")
 		return nil
 	}
+	return randomSpot
+}
+
+// terminateRandomSpotInstanceIfHavingEnough terminates a spot instance when
+// the group has enough of them, returning the instance it picked, if any.
+func (a *autoScalingGroup) terminateRandomSpotInstanceIfHavingEnough(totalRunning int64, wait bool) (*instance, error) {
+
+	randomSpot := a.spotInstanceToTerminate(totalRunning)
+	if randomSpot == nil {
+		return nil, nil
+	}
 
 	log.Println("Terminating randomly-selected spot instance",
 		*randomSpot.Instance.InstanceId)
 
 	var isTerminated error
-	switch a.config.TerminationMethod {
+	switch a.config.terminationMethod() {
 	case DetachTerminationMethod:
 		isTerminated = randomSpot.terminate()
 	default:
 		isTerminated = a.terminateInstanceInAutoScalingGroup(randomSpot.Instance.InstanceId, wait, false)
 	}
 
-	if isTerminated == nil {
-		// add to FinalRecap
-		recapText := fmt.Sprintf("%s Terminated random spot instance %s [too few onDemands]", a.name, *randomSpot.Instance.InstanceId)
-		a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
-	}
-
-	return isTerminated
+	return randomSpot, isTerminated
 }
 
 func (a *autoScalingGroup) allInstancesRunning() (bool, int64) {
@@ -207,7 +232,7 @@ func (a *autoScalingGroup) cronEventAction() runer {
 	if !shouldRun {
 		log.Println(a.region.name, a.name,
 			"Skipping run, outside the enabled cron run schedule")
-		return skipRun{reason: "outside-cron-schedule"}
+		return skipRun{reason: reasonOutsideCronSchedule}
 	}
 
 	if spotInstance == nil {
@@ -224,7 +249,7 @@ func (a *autoScalingGroup) cronEventAction() runer {
 			log.Println(a.region.name, a.name,
 				"No running unprotected on-demand instances were found, nothing to do here...")
 
-			return skipRun{reason: "no-instances-to-replace"}
+			return skipRun{reason: reasonNoInstancesToReplace}
 		}
 
 		a.loadLaunchConfiguration()
@@ -246,9 +271,6 @@ func (a *autoScalingGroup) cronEventAction() runer {
 	log.Println("Found unattached spot instance", spotInstanceID)
 
 	if need, total := a.needReplaceOnDemandInstances(); !need || !shouldRun {
-		// add to FinalRecap
-		recapText := fmt.Sprintf("%s Terminated spot instance %s [not needed]", a.name, spotInstanceID)
-		a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
 		return terminateUnneededSpotInstance{
 			target{
 				asg:            a,
@@ -261,7 +283,7 @@ func (a *autoScalingGroup) cronEventAction() runer {
 		log.Printf("Spot instance %s not yet ready, waiting for next run while processing %s",
 			spotInstanceID,
 			a.name)
-		return skipRun{"spot instance replacement exists but not ready"}
+		return skipRun{reason: reasonSpotInstanceNotReady}
 	}
 
 	log.Println(a.region.name, "Found spot instance:", spotInstanceID,
@@ -291,6 +313,8 @@ func (a *autoScalingGroup) scanInstances() instances {
 		}
 
 		i.asg, i.region = a, a.region
+		i.determinePlatformTypeInformation()
+
 		if inst.ProtectedFromScaleIn != nil {
 			i.protected = i.protected || *inst.ProtectedFromScaleIn
 		}
@@ -306,12 +330,23 @@ func (a *autoScalingGroup) scanInstances() instances {
 			continue
 		}
 
+		// The instances waiting in the warm pool aren't part of the capacity
+		// of the group, and are usually stopped
+		if isWarmPoolLifecycleState(*inst.LifecycleState) || i.isStopped() {
+			debug.Println(a.name, "skipping warmed or stopped instance", *inst.InstanceId,
+				"in state", *inst.LifecycleState)
+			continue
+		}
+
 		a.instances.add(i)
 	}
 	return a.instances
 }
 
-func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(spotInstanceID string) error {
+// replaceOnDemandInstanceWithSpot swaps the given spot instance with one of the
+// on-demand instances of the group, returning the replaced on-demand instance.
+// When using SQS the swap is delegated to the queue and no instance is returned.
+func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(spotInstanceID string) (*instance, error) {
 	var odInstance *instance
 	var err error
 
@@ -319,30 +354,25 @@ func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(spotInstanceID string
 	log.Println(a.name, "Retrieving instance details for ", spotInstanceID)
 	spotInst := a.region.instances.get(spotInstanceID)
 	if spotInst == nil {
-		return errors.New("couldn't find spot instance to use")
+		return nil, errors.New("couldn't find spot instance to use")
 	}
 
 	if len(a.region.conf.SQSQueueURL) == 0 {
 		if odInstance, err = spotInst.swapWithGroupMember(a); err != nil {
 			log.Printf("%s, couldn't perform spot replacement of %s ",
 				a.region.name, *spotInst.InstanceId)
-			return err
+			return nil, err
 		}
-		// add to FinalRecap
-		recapText := fmt.Sprintf("%s OnDemand instance %s replaced with spot instance %s",
+		log.Printf("%s OnDemand instance %s replaced with spot instance %s",
 			a.name, *odInstance.InstanceId, *spotInst.InstanceId)
-		a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
-
-	} else {
+		return odInstance, nil
+	}
 
-		if err := a.region.sqsSendMessageOnInstanceLaunch(&a.name, &spotInstanceID, spotInst.State.Name, "swap-with-on-demand"); err != nil {
-			return err
-		}
-		// add to FinalRecap
-		recapText := fmt.Sprintf("%s Sent spot instance %s event message to SQSQueue", a.name, *spotInst.InstanceId)
-		a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
+	if err := a.region.sqsSendMessageOnInstanceLaunch(&a.name, &spotInstanceID, spotInst.State.Name, "swap-with-on-demand"); err != nil {
+		return nil, err
 	}
-	return nil
+	log.Printf("%s Sent spot instance %s event message to SQSQueue", a.name, *spotInst.InstanceId)
+	return nil, nil
 }
 
 // Returns the information about the first running instance found in
@@ -378,6 +408,10 @@ func (a *autoScalingGroup) getInstance(
 				continue
 			}
 
+			if onDemand && !a.canReplaceWarmPoolGroupInstance(i) {
+				continue
+			}
+
 			if (availabilityZone != nil) && (*availabilityZone != *i.Placement.AvailabilityZone) {
 				debug.Println(a.name, "skipping instance", *i.InstanceId,
 					"placed in a different AZ than what we're looking for")
@@ -406,48 +440,49 @@ func (a *autoScalingGroup) hasMemberInstance(inst *instance) bool {
 	return false
 }
 
+// waitForInstanceStatus polls the lifecycle state of the instance until it
+// reaches the given status, backing off between the attempts.
 func (a *autoScalingGroup) waitForInstanceStatus(instanceID *string, status string, maxRetry int) error {
-	isInstanceInStatus := false
-	for retry := 0; !isInstanceInStatus; retry++ {
-		if retry > maxRetry {
-			log.Printf("Failed waiting instance %s in status %s",
-				*instanceID, status)
-			break
-		} else {
-			result, err := a.region.services.autoScaling.DescribeAutoScalingInstances(
-				&autoscaling.DescribeAutoScalingInstancesInput{
-					InstanceIds: []*string{instanceID},
-				})
+	policy := instanceStatusPolicy.scaled(a.region.sleepMultiplier())
 
-			if err != nil {
-				log.Println(err.Error())
-				continue
-			}
+	for retry := 0; retry <= maxRetry; retry++ {
+		if retry > 0 {
+			time.Sleep(policy.delay(retry - 1))
+		}
 
-			autoScalingInstances := result.AutoScalingInstances
+		result, err := a.region.services.autoScaling.DescribeAutoScalingInstances(
+			&autoscaling.DescribeAutoScalingInstancesInput{
+				InstanceIds: []*string{instanceID},
+			})
 
-			if len(autoScalingInstances) > 0 {
-				if instanceStatus := *autoScalingInstances[0].LifecycleState; instanceStatus != status {
-					log.Printf("Waiting for instance %s to be in status %s [%s]",
-						*instanceID, status, instanceStatus)
-				} else {
-					isInstanceInStatus = true
-					return nil
-				}
-			} else {
-				log.Printf("Waiting for instance %s to be in AutoScalingGroup with status %s",
-					*instanceID, status)
+		if err != nil {
+			err = newAWSError("DescribeAutoScalingInstances", err)
+			log.Println(err.Error())
+			if !policy.retryable(classifyError(err)) {
+				return err
 			}
+			continue
+		}
+
+		autoScalingInstances := result.AutoScalingInstances
 
-			sleepTime := 10 - (2 * retry)
-			if sleepTime <= 0 {
-				sleepTime = 1
+		if len(autoScalingInstances) > 0 {
+			if instanceStatus := *autoScalingInstances[0].LifecycleState; instanceStatus != status {
+				log.Printf("Waiting for instance %s to be in status %s [%s]",
+					*instanceID, status, instanceStatus)
+			} else {
+				return nil
 			}
-			time.Sleep(time.Duration(sleepTime) * time.Second)
+		} else {
+			log.Printf("Waiting for instance %s to be in AutoScalingGroup with status %s",
+				*instanceID, status)
 		}
 	}
 
-	return errors.New("")
+	log.Printf("Failed waiting instance %s in status %s",
+		*instanceID, status)
+	return fmt.Errorf("instance %s didn't reach the %s status after %d attempts",
+		*instanceID, status, maxRetry+1)
 }
 
 func (a *autoScalingGroup) findUnattachedInstanceLaunchedForThisASG() *instance {
@@ -467,7 +502,7 @@ func (a *autoScalingGroup) getAllowedInstanceTypes(baseInstance *instance) []str
 	var allowedInstanceTypesTag string
 
 	// By default take the command line parameter
-	allowed := strings.Replace(a.region.conf.AllowedInstanceTypes, " ", ",", -1)
+	allowed := strings.Replace(a.defaults().AllowedInstanceTypes, " ", ",", -1)
 
 	// Check option of allowed instance types
 	// If we have that option we don't need to calculate the compatible instance type.
@@ -478,6 +513,10 @@ func (a *autoScalingGroup) getAllowedInstanceTypes(baseInstance *instance) []str
 	// ASG Tag config has a priority to override
 	if allowedInstanceTypesTag != "" {
 		allowed = allowedInstanceTypesTag
+	} else if overrides := a.getMixedInstancesPolicyInstanceTypes(); len(overrides) > 0 {
+		// The instance types of the mixed instances policy take precedence over
+		// the global configuration
+		return overrides
 	}
 
 	if allowed == "current" {
@@ -494,7 +533,7 @@ func (a *autoScalingGroup) getDisallowedInstanceTypes(baseInstance *instance) []
 	var disallowedInstanceTypesTag string
 
 	// By default take the command line parameter
-	disallowed := strings.Replace(a.region.conf.DisallowedInstanceTypes, " ", ",", -1)
+	disallowed := strings.Replace(a.defaults().DisallowedInstanceTypes, " ", ",", -1)
 
 	// Check option of disallowed instance types
 	// If we have that option we don't need to calculate the compatible instance type.
@@ -545,7 +584,7 @@ func (a *autoScalingGroup) attachSpotInstance(spotInstanceID string, wait bool)
 
 	}
 
-	resp, err := a.region.services.autoScaling.AttachInstances(
+	_, err := a.region.services.autoScaling.AttachInstances(
 		&autoscaling.AttachInstancesInput{
 			AutoScalingGroupName: aws.String(a.name),
 			InstanceIds: []*string{
@@ -555,9 +594,9 @@ func (a *autoScalingGroup) attachSpotInstance(spotInstanceID string, wait bool)
 	)
 
 	if err != nil {
-		log.Println(err.Error())
-		// Pretty-print the response data.
-		log.Println(resp)
+		err = newAWSError("AttachInstances", err)
+		log.Printf("Spot instance %s couldn't be attached to the group %s: %v",
+			spotInstanceID, a.name, err.Error())
 		return err
 	}
 
@@ -15,7 +15,9 @@ const (
 	// "autospotting_${overridden_command_line_parameter_name}"
 
 	// For example the tag named "autospotting_min_on_demand_number" will override
-	// the command-line option named "min_on_demand_number", and so on.
+	// the command-line option named "min_on_demand_number", and so on. Every
+	// setting listed in autoScalingSettings can be overridden this way, the
+	// constants below only name the tags referenced in the code.
 
 	// OnDemandPercentageTag is the name of a tag that can be defined on a
 	// per-group level for overriding maintained on-demand capacity given as a
@@ -46,6 +48,11 @@ const (
 	// instance types are not allowed in the current group
 	DisallowedInstanceTypesTag = "autospotting_disallowed_instance_types"
 
+	// SpotProductDescriptionTag is the name of a tag that can be defined on a
+	// per-group level for overriding the spot product description, which
+	// otherwise is detected from the platform of the instances' AMI
+	SpotProductDescriptionTag = "autospotting_spot_product_description"
+
 	// Default constant values should be defined below:
 
 	// DefaultSpotProductDescription stores the default operating system
@@ -124,6 +131,15 @@ type AutoScalingConfig struct {
 	OnDemandPriceMultiplier   float64
 	SpotPriceBufferPercentage float64
 
+	// Thresholds above which the spot pools are considered too volatile to be
+	// used, computed from the price history. Zero disables them.
+	SpotPriceMaxVolatility    float64
+	SpotPriceMaxChangesPerDay float64
+
+	// How much the volatility of a spot pool increases its effective price when
+	// sorting the replacement candidates.
+	SpotPriceVolatilityWeight float64
+
 	SpotProductDescription string
 	SpotProductPremium     float64
 
@@ -137,6 +153,9 @@ type AutoScalingConfig struct {
 	// Termination Notification action
 	TerminationNotificationAction string
 
+	// Action taken on the spot instances receiving rebalance recommendations
+	RebalanceRecommendationAction string
+
 	CronSchedule      string
 	CronTimezone      string
 	CronScheduleState string // "on" or "off", dictate whether to run inside the CronSchedule or not
@@ -151,6 +170,55 @@ type AutoScalingConfig struct {
 	// Further information about this is available at
 	// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/ec2-fleet-allocation-strategy.html
 	SpotAllocationStrategy string
+
+	// Ranges of vCPUs, memory in GiB and memory GiB per vCPU accepted for the
+	// replacement candidates, which otherwise need at least the vCPUs and
+	// memory of the replaced instance, unless AllowSmaller is set.
+	VCPURange          string
+	MemoryGiBRange     string
+	MemoryPerVCPURange string
+	AllowSmaller       bool
+
+	// Network compatibility checks of the replacement candidates against the
+	// instance type of the replaced instance.
+	CheckNetworkPerformance bool
+	CheckENASupport         bool
+	CheckEFASupport         bool
+	CheckNetworkInterfaces  bool
+
+	// Allows replacing instances with spot instance types of the other CPU
+	// architecture, launched from the AMI configured for that architecture
+	// either as an AMI ID or an SSM parameter path, or otherwise looked up by
+	// the name of the current AMI.
+	CrossArchitecture bool
+	ARM64AMI          string
+	X86AMI            string
+
+	// GPU models accepted for replacing GPU instances, on top of requiring
+	// the same GPU manufacturer and at least the same GPU memory.
+	GPUModels string
+
+	// Keeps on-demand the instances moved into service from the warm pool of
+	// the group, replacing only the instances launched directly.
+	KeepWarmPoolOnDemand bool
+
+	// Drain steps run on the spot instances receiving interruption warnings,
+	// each with its timeout in seconds
+	DrainDeregisterTargets bool
+	DrainDeregisterTimeout int64
+	DrainSSMDocument       string
+	DrainSSMTimeout        int64
+	DrainLambda            string
+	DrainLambdaTimeout     int64
+}
+
+// terminationMethod returns the method used for terminating the instances of
+// the group, the legacy TerminationMethod taking precedence when set.
+func (c AutoScalingConfig) terminationMethod() string {
+	if c.TerminationMethod != "" {
+		return c.TerminationMethod
+	}
+	return c.InstanceTerminationMethod
 }
 
 func (a *autoScalingGroup) loadPercentageOnDemand(tagValue *string) (int64, bool) {
@@ -172,21 +240,6 @@ func (a *autoScalingGroup) loadPercentageOnDemand(tagValue *string) (int64, bool
 	return DefaultMinOnDemandValue, false
 }
 
-func (a *autoScalingGroup) loadSpotPriceBufferPercentage(tagValue *string) (float64, bool) {
-	spotPriceBufferPercentage, err := strconv.ParseFloat(*tagValue, 64)
-
-	if err != nil {
-		log.Printf("Error with ParseFloat: %s\n", err.Error())
-		return DefaultSpotPriceBufferPercentage, false
-	} else if spotPriceBufferPercentage < 0 {
-		log.Printf("Ignoring out of range value : %f\n", spotPriceBufferPercentage)
-		return DefaultSpotPriceBufferPercentage, false
-	}
-
-	log.Printf("Loaded SpotPriceBufferPercentage value to %f from tag %s\n", spotPriceBufferPercentage, SpotPriceBufferPercentageTag)
-	return spotPriceBufferPercentage, true
-}
-
 func (a *autoScalingGroup) loadNumberOnDemand(tagValue *string) (int64, bool) {
 	onDemand, err := strconv.Atoi(*tagValue)
 	if err != nil {
@@ -200,21 +253,6 @@ func (a *autoScalingGroup) loadNumberOnDemand(tagValue *string) (int64, bool) {
 	return DefaultMinOnDemandValue, false
 }
 
-func (a *autoScalingGroup) loadOnDemandPriceMultiplier(tagValue *string) (float64, bool) {
-	onDemandPriceMultiplier, err := strconv.ParseFloat(*tagValue, 64)
-
-	if err != nil {
-		log.Printf("Error with ParseFloat: %s\n", err.Error())
-		return DefaultOnDemandPriceMultiplier, false
-	} else if onDemandPriceMultiplier <= 0 {
-		log.Printf("Ignoring out of range value : %f\n", onDemandPriceMultiplier)
-		return DefaultOnDemandPriceMultiplier, false
-	}
-
-	log.Printf("Loaded OnDemandPriceMultiplier value to %f from tag %s\n", onDemandPriceMultiplier, OnDemandPriceMultiplierTag)
-	return onDemandPriceMultiplier, true
-}
-
 func (a *autoScalingGroup) getTagValue(keyMatch string) *string {
 	for _, asgTag := range a.Tags {
 		if *asgTag.Key == keyMatch {
@@ -252,162 +290,6 @@ func (a *autoScalingGroup) loadConfOnDemand() bool {
 	return foundLimit
 }
 
-func (a *autoScalingGroup) loadPatchBeanstalkUserdata() bool {
-	tagValue := a.getTagValue(PatchBeanstalkUserdataTag)
-
-	if tagValue != nil {
-		log.Printf("Loaded PatchBeanstalkUserdata value %v from tag %v\n", *tagValue, PatchBeanstalkUserdataTag)
-		val, err := strconv.ParseBool(*tagValue)
-
-		if err != nil {
-			log.Printf("Failed to parse PatchBeanstalkUserdata value %v as a boolean", *tagValue)
-			return false
-		}
-		a.config.PatchBeanstalkUserdata = val
-		return true
-	}
-	debug.Println("Couldn't find tag", PatchBeanstalkUserdataTag, "on the group", a.name, "using the default configuration")
-	a.config.PatchBeanstalkUserdata = a.region.conf.PatchBeanstalkUserdata
-	return false
-}
-
-func (a *autoScalingGroup) loadSpotAllocationStrategy() bool {
-	a.config.SpotAllocationStrategy = a.region.conf.SpotAllocationStrategy
-
-	tagValue := a.getTagValue(SpotAllocationStrategyTag)
-
-	if tagValue != nil {
-		log.Printf("Loaded AllocationStrategy value %v from tag %v\n", *tagValue, SpotAllocationStrategyTag)
-		a.config.SpotAllocationStrategy = *tagValue
-		return true
-	}
-
-	debug.Println("Couldn't find tag", SpotAllocationStrategyTag, "on the group", a.name, "using the default configuration")
-	return false
-}
-
-func (a *autoScalingGroup) loadGP2ConversionThreshold() bool {
-	// setting the default value
-	a.config.GP2ConversionThreshold = a.region.conf.GP2ConversionThreshold
-
-	tagValue := a.getTagValue(GP2ConversionThresholdTag)
-	if tagValue == nil {
-		log.Printf("Couldn't load the GP2ConversionThreshold from tag %v, using the globally configured value of %v\n", GP2ConversionThresholdTag, a.config.GP2ConversionThreshold)
-		return false
-	}
-
-	log.Printf("Loaded GP2ConversionThreshold value %v from tag %v\n", *tagValue, GP2ConversionThresholdTag)
-
-	threshold, err := strconv.Atoi(*tagValue)
-	if err != nil {
-		log.Printf("Error parsing %v qs integer: %s\n", *tagValue, err.Error())
-		return false
-	}
-
-	debug.Println("Successfully parsed", GP2ConversionThresholdTag, "on the group", a.name, "overriding the default configuration")
-	a.config.GP2ConversionThreshold = int64(threshold)
-	return true
-}
-
-func (a *autoScalingGroup) loadBiddingPolicy(tagValue *string) (string, bool) {
-	biddingPolicy := *tagValue
-	if biddingPolicy != "aggressive" {
-		return DefaultBiddingPolicy, false
-	}
-
-	log.Printf("Loaded BiddingPolicy value with %s from tag %s\n", biddingPolicy, BiddingPolicyTag)
-	return biddingPolicy, true
-}
-
-func (a *autoScalingGroup) LoadCronSchedule() bool {
-	tagValue := a.getTagValue(ScheduleTag)
-
-	if tagValue != nil {
-		log.Printf("Loaded CronSchedule value %v from tag %v\n", *tagValue, ScheduleTag)
-		a.config.CronSchedule = *tagValue
-		return true
-	}
-
-	debug.Println("Couldn't find tag", ScheduleTag, "on the group", a.name, "using the default configuration")
-	a.config.CronSchedule = a.region.conf.CronSchedule
-	return false
-}
-
-func (a *autoScalingGroup) LoadCronTimezone() bool {
-	tagValue := a.getTagValue(TimezoneTag)
-
-	if tagValue != nil {
-		log.Printf("Loaded CronTimezone value %v from tag %v\n", *tagValue, TimezoneTag)
-		a.config.CronTimezone = *tagValue
-		return true
-	}
-
-	debug.Println("Couldn't find tag", TimezoneTag, "on the group", a.name, "using the default configuration")
-	a.config.CronTimezone = a.region.conf.CronTimezone
-	return false
-}
-
-func (a *autoScalingGroup) LoadCronScheduleState() bool {
-	tagValue := a.getTagValue(CronScheduleStateTag)
-	if tagValue != nil {
-		log.Printf("Loaded CronScheduleState value %v from tag %v\n", *tagValue, CronScheduleStateTag)
-		a.config.CronScheduleState = *tagValue
-		return true
-	}
-
-	debug.Println("Couldn't find tag", CronScheduleStateTag, "on the group", a.name, "using the default configuration")
-	a.config.CronScheduleState = a.region.conf.CronScheduleState
-	return false
-}
-
-func (a *autoScalingGroup) loadConfSpot() bool {
-	tagValue := a.getTagValue(BiddingPolicyTag)
-	if tagValue == nil {
-		debug.Println("Couldn't find tag", BiddingPolicyTag)
-		return false
-	}
-	if newValue, done := a.loadBiddingPolicy(tagValue); done {
-		a.region.conf.BiddingPolicy = newValue
-		debug.Println("BiddingPolicy =", a.region.conf.BiddingPolicy)
-		return done
-	}
-	return false
-}
-
-func (a *autoScalingGroup) loadConfSpotPrice() bool {
-
-	tagValue := a.getTagValue(SpotPriceBufferPercentageTag)
-	if tagValue == nil {
-		return false
-	}
-
-	newValue, done := a.loadSpotPriceBufferPercentage(tagValue)
-	if !done {
-		debug.Println("Couldn't find tag", SpotPriceBufferPercentageTag)
-		return false
-	}
-
-	a.region.conf.SpotPriceBufferPercentage = newValue
-	return done
-}
-
-func (a *autoScalingGroup) loadConfOnDemandPriceMultiplier() bool {
-	a.config.OnDemandPriceMultiplier = a.region.conf.OnDemandPriceMultiplier
-	tagValue := a.getTagValue(OnDemandPriceMultiplierTag)
-	if tagValue == nil {
-		return false
-	}
-
-	newValue, done := a.loadOnDemandPriceMultiplier(tagValue)
-	if !done {
-		debug.Println("Couldn't find tag", OnDemandPriceMultiplierTag)
-		return false
-	}
-
-	a.config.OnDemandPriceMultiplier = newValue
-	return done
-}
-
 // Add configuration of other elements here: prices, whitelisting, etc
 func (a *autoScalingGroup) loadConfigFromTags() bool {
 	ret := false
@@ -417,48 +299,13 @@ func (a *autoScalingGroup) loadConfigFromTags() bool {
 		ret = true
 	}
 
-	if a.loadConfOnDemandPriceMultiplier() {
-		log.Println("Found and applied configuration for OnDemand Price Multiplier")
-		ret = true
-	}
-
-	if a.loadConfSpot() {
-		log.Println("Found and applied configuration for Spot Bid")
-		ret = true
-	}
-
-	if a.loadConfSpotPrice() {
-		log.Println("Found and applied configuration for Spot Price")
-		ret = true
-	}
-
-	if a.LoadCronSchedule() {
-		log.Println("Found and applied configuration for Spot Price")
-		ret = true
-	}
-
-	if a.LoadCronTimezone() {
-		log.Println("Found and applied configuration for Spot Price")
+	if a.loadMixedInstancesPolicyOnDemand() {
+		log.Println("Found and applied configuration for OnDemand value from the mixed instances policy")
 		ret = true
 	}
 
-	if a.LoadCronScheduleState() {
-		log.Println("Found and applied configuration for Spot Price")
-		ret = true
-	}
-
-	if a.loadPatchBeanstalkUserdata() {
-		log.Println("Found and applied configuration for Spot Price")
-		ret = true
-	}
-
-	if a.loadGP2ConversionThreshold() {
-		log.Println("Found and applied configuration for Spot Price")
-		ret = true
-	}
-
-	if a.loadSpotAllocationStrategy() {
-		log.Println("Found and applied configuration for Spot Price")
+	if a.loadSettingsFromTags() {
+		log.Println("Found and applied configuration from the group tags")
 		ret = true
 	}
 
@@ -466,7 +313,7 @@ func (a *autoScalingGroup) loadConfigFromTags() bool {
 }
 
 func (a *autoScalingGroup) loadDefaultConfigNumber() (int64, bool) {
-	onDemand := a.region.conf.MinOnDemandNumber
+	onDemand := a.defaults().MinOnDemandNumber
 	if onDemand >= 0 && onDemand <= int64(a.instances.count()) {
 		log.Printf("Loaded default value %d from conf number.", onDemand)
 		return onDemand, true
@@ -476,7 +323,7 @@ func (a *autoScalingGroup) loadDefaultConfigNumber() (int64, bool) {
 }
 
 func (a *autoScalingGroup) loadDefaultConfigPercentage() (int64, bool) {
-	percentage := a.region.conf.MinOnDemandPercentage
+	percentage := a.defaults().MinOnDemandPercentage
 	if percentage < 0 || percentage > 100 {
 		log.Printf("Ignoring default value out of range: %f", percentage)
 		return DefaultMinOnDemandValue, false
@@ -491,14 +338,10 @@ func (a *autoScalingGroup) loadDefaultConfig() bool {
 	done := false
 	a.config.MinOnDemand = DefaultMinOnDemandValue
 
-	if a.region.conf.SpotPriceBufferPercentage <= 0 {
-		a.region.conf.SpotPriceBufferPercentage = DefaultSpotPriceBufferPercentage
-	}
-
-	if a.region.conf.MinOnDemandNumber != 0 {
+	if a.defaults().MinOnDemandNumber != 0 {
 		a.config.MinOnDemand, done = a.loadDefaultConfigNumber()
 	}
-	if !done && a.region.conf.MinOnDemandPercentage != 0 {
+	if !done && a.defaults().MinOnDemandPercentage != 0 {
 		a.config.MinOnDemand, done = a.loadDefaultConfigPercentage()
 	} else {
 		log.Println("No default value for on-demand instances specified, skipping.")
@@ -40,11 +40,27 @@ func TestLoadSpotPriceBufferPercentage(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		a := autoScalingGroup{Group: &autoscaling.Group{}}
-		value, loading := a.loadSpotPriceBufferPercentage(tt.tagValue)
+		a := autoScalingGroup{
+			Group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(SpotPriceBufferPercentageTag),
+						Value: tt.tagValue,
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						SpotPriceBufferPercentage: DefaultSpotPriceBufferPercentage,
+					}},
+			},
+		}
+		loading := a.loadSettingFromTag("spot_price_buffer_percentage")
+		value := a.config.SpotPriceBufferPercentage
 
 		if value != tt.valueExpected || loading != tt.loadingExpected {
-			t.Errorf("LoadBiddingPolicy returned: %f, expected: %f", value, tt.valueExpected)
+			t.Errorf("LoadSpotPriceBufferPercentage returned: %f, expected: %f", value, tt.valueExpected)
 		}
 
 	}
@@ -496,8 +512,24 @@ func TestLoadBiddingPolicy(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		a := autoScalingGroup{Group: &autoscaling.Group{}}
-		value, _ := a.loadBiddingPolicy(tt.tagValue)
+		a := autoScalingGroup{
+			Group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(BiddingPolicyTag),
+						Value: tt.tagValue,
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						BiddingPolicy: DefaultBiddingPolicy,
+					}},
+			},
+		}
+		a.loadSettingFromTag("bidding_policy")
+		value := a.config.BiddingPolicy
 
 		if value != tt.valueExpected {
 			t.Errorf("LoadBiddingPolicy returned: %s, expected: %s", value, tt.valueExpected)
@@ -548,7 +580,7 @@ func TestLoadConfSpot(t *testing.T) {
 					Value: aws.String("normal"),
 				},
 			},
-			loadingExpected: false,
+			loadingExpected: true,
 			valueExpected:   "normal",
 		},
 	}
@@ -564,11 +596,13 @@ func TestLoadConfSpot(t *testing.T) {
 			},
 		}
 		a.Tags = tt.asgTags
-		done := a.loadConfSpot()
+		done := a.loadSettingFromTag("bidding_policy")
 		if tt.loadingExpected != done {
 			t.Errorf("LoadSpotConf retured: %t expected %t", done, tt.loadingExpected)
-		} else if tt.valueExpected != a.region.conf.BiddingPolicy {
-			t.Errorf("LoadSpotConf loaded: %s expected %s", a.region.conf.BiddingPolicy, tt.valueExpected)
+		} else if tt.valueExpected != a.config.BiddingPolicy {
+			t.Errorf("LoadSpotConf loaded: %s expected %s", a.config.BiddingPolicy, tt.valueExpected)
+		} else if a.region.conf.BiddingPolicy != "normal" {
+			t.Errorf("LoadSpotConf changed the region configuration to %s", a.region.conf.BiddingPolicy)
 		}
 
 	}
@@ -633,11 +667,13 @@ func TestLoadConfSpotPrice(t *testing.T) {
 			},
 		}
 		a.Tags = tt.asgTags
-		done := a.loadConfSpotPrice()
+		done := a.loadSettingFromTag("spot_price_buffer_percentage")
 		if tt.loadingExpected != done {
 			t.Errorf("LoadSpotConf retured: %t expected %t", done, tt.loadingExpected)
-		} else if tt.valueExpected != a.region.conf.SpotPriceBufferPercentage {
-			t.Errorf("LoadSpotConf loaded: %f expected %f", a.region.conf.SpotPriceBufferPercentage, tt.valueExpected)
+		} else if tt.valueExpected != a.config.SpotPriceBufferPercentage {
+			t.Errorf("LoadSpotConf loaded: %f expected %f", a.config.SpotPriceBufferPercentage, tt.valueExpected)
+		} else if a.region.conf.SpotPriceBufferPercentage != 10.0 {
+			t.Errorf("LoadSpotConf changed the region configuration to %f", a.region.conf.SpotPriceBufferPercentage)
 		}
 
 	}
@@ -680,7 +716,10 @@ func TestLoadConfigFromTags(t *testing.T) {
 					SpotPriceBufferPercentage: 10.0,
 				}},
 			loadingExpected: false,
-			expectedConfig:  AutoScalingConfig{},
+			expectedConfig: AutoScalingConfig{
+				BiddingPolicy:             "normal",
+				SpotPriceBufferPercentage: 10.0,
+			},
 		},
 		{name: OnDemandNumberLong + " OD number is invalid so percentage value is used",
 			asgTags: []*autoscaling.TagDescription{
@@ -721,7 +760,9 @@ func TestLoadConfigFromTags(t *testing.T) {
 				}},
 			loadingExpected: true,
 			expectedConfig: AutoScalingConfig{
-				MinOnDemand: 3,
+				MinOnDemand:               3,
+				BiddingPolicy:             "normal",
+				SpotPriceBufferPercentage: 15.0,
 			},
 		},
 		{name: "OD price multiplier",
@@ -1068,7 +1109,7 @@ func Test_autoScalingGroup_LoadCronSchedule(t *testing.T) {
 				region: tt.region,
 				config: tt.config,
 			}
-			a.LoadCronSchedule()
+			a.loadSettingFromTag("cron_schedule")
 			got := a.config.CronSchedule
 			if got != tt.want {
 				t.Errorf("LoadCronSchedule got %v, expected %v", got, tt.want)
@@ -1127,7 +1168,7 @@ func Test_autoScalingGroup_LoadCronTimezone(t *testing.T) {
 				region: tt.region,
 				config: tt.config,
 			}
-			a.LoadCronTimezone()
+			a.loadSettingFromTag("cron_timezone")
 			got := a.config.CronTimezone
 			if got != tt.want {
 				t.Errorf("LoadCronTimezone got %v, expected %v", got, tt.want)
@@ -1184,7 +1225,7 @@ func Test_autoScalingGroup_LoadCronScheduleState(t *testing.T) {
 				region: tt.region,
 				config: tt.config,
 			}
-			a.LoadCronScheduleState()
+			a.loadSettingFromTag("cron_schedule_state")
 			got := a.config.CronScheduleState
 			if got != tt.want {
 				t.Errorf("LoadCronScheduleState got %v, expected %v", got, tt.want)
@@ -1207,7 +1248,9 @@ func Test_autoScalingGroup_LoadPatchBeanstalkUserdata(t *testing.T) {
 			Group: &autoscaling.Group{},
 			region: &region{
 				conf: &Config{
-					PatchBeanstalkUserdata: false,
+					AutoScalingConfig: AutoScalingConfig{
+						PatchBeanstalkUserdata: false,
+					},
 				},
 			},
 			want: false,
@@ -1217,7 +1260,9 @@ func Test_autoScalingGroup_LoadPatchBeanstalkUserdata(t *testing.T) {
 			Group: &autoscaling.Group{},
 			region: &region{
 				conf: &Config{
-					PatchBeanstalkUserdata: true,
+					AutoScalingConfig: AutoScalingConfig{
+						PatchBeanstalkUserdata: true,
+					},
 				},
 			},
 			want: true,
@@ -1234,7 +1279,9 @@ func Test_autoScalingGroup_LoadPatchBeanstalkUserdata(t *testing.T) {
 			},
 			region: &region{
 				conf: &Config{
-					PatchBeanstalkUserdata: true,
+					AutoScalingConfig: AutoScalingConfig{
+						PatchBeanstalkUserdata: true,
+					},
 				},
 			},
 			want: false,
@@ -1248,7 +1295,7 @@ func Test_autoScalingGroup_LoadPatchBeanstalkUserdata(t *testing.T) {
 				config: tt.config,
 				region: tt.region,
 			}
-			a.loadPatchBeanstalkUserdata()
+			a.loadSettingFromTag("patch_beanstalk_userdata")
 			got := a.config.PatchBeanstalkUserdata
 			if got != tt.want {
 				t.Errorf("LoadPatchBeanstalkUserdata got %v, expected %v", got, tt.want)
@@ -1274,13 +1321,13 @@ func Test_autoScalingGroup_loadSpotAllocationStrategy(t *testing.T) {
 			region: &region{
 				conf: &Config{
 					AutoScalingConfig: AutoScalingConfig{
-						SpotAllocationStrategy: "foo",
+						SpotAllocationStrategy: "capacity-optimized-prioritized",
 					},
 				},
 			},
 			want: false,
 			wantConfig: AutoScalingConfig{
-				SpotAllocationStrategy: "foo",
+				SpotAllocationStrategy: "capacity-optimized-prioritized",
 			},
 		},
 		{
@@ -1289,20 +1336,42 @@ func Test_autoScalingGroup_loadSpotAllocationStrategy(t *testing.T) {
 				Tags: []*autoscaling.TagDescription{
 					{
 						Key:   aws.String(SpotAllocationStrategyTag),
-						Value: aws.String("bar"),
+						Value: aws.String("lowest-price"),
 					},
 				},
 			},
 			region: &region{
 				conf: &Config{
 					AutoScalingConfig: AutoScalingConfig{
-						SpotAllocationStrategy: "foo",
+						SpotAllocationStrategy: "capacity-optimized-prioritized",
 					},
 				},
 			},
 			want: true,
 			wantConfig: AutoScalingConfig{
-				SpotAllocationStrategy: "bar",
+				SpotAllocationStrategy: "lowest-price",
+			},
+		},
+		{
+			name: "Invalid tag set on the group, use region config",
+			group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(SpotAllocationStrategyTag),
+						Value: aws.String("bar"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						SpotAllocationStrategy: "capacity-optimized-prioritized",
+					},
+				},
+			},
+			want: false,
+			wantConfig: AutoScalingConfig{
+				SpotAllocationStrategy: "capacity-optimized-prioritized",
 			},
 		},
 	}
@@ -1314,7 +1383,7 @@ func Test_autoScalingGroup_loadSpotAllocationStrategy(t *testing.T) {
 				region: tt.region,
 				Group:  tt.group,
 			}
-			if got := a.loadSpotAllocationStrategy(); got != tt.want {
+			if got := a.loadSettingFromTag("spot_allocation_strategy"); got != tt.want {
 				t.Errorf("autoScalingGroup.loadSpotAllocationStrategy() = %v, want %v", got, tt.want)
 			}
 			if !reflect.DeepEqual(a.config, tt.wantConfig) {
@@ -1381,7 +1450,7 @@ func Test_autoScalingGroup_loadGP2ConversionThreshold(t *testing.T) {
 				region: tt.region,
 				Group:  tt.group,
 			}
-			if got := a.loadGP2ConversionThreshold(); got != tt.want {
+			if got := a.loadSettingFromTag("ebs_gp2_conversion_threshold"); got != tt.want {
 				t.Errorf("autoScalingGroup.loadGP2ConversionThreshold() = %v, want %v", got, tt.want)
 			}
 
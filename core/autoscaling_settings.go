@@ -0,0 +1,191 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// autoScalingSetting maps a flag registered by registerAutoScalingFlags to its
+// AutoScalingConfig field, whose type determines how the values are parsed,
+// and to the validator of its per-group tag override.
+type autoScalingSetting struct {
+	flag  string
+	field string
+
+	// tag overrides the default autospotting_${flag} tag name
+	tag string
+
+	validate func(string) error
+
+	// custom settings have dedicated loaders, since their tags need more
+	// context than just the value, such as the group's instances
+	custom bool
+
+	// global settings can't be overridden per group using tags
+	global bool
+}
+
+// autoScalingSettings lists all the group-specific settings, every one of
+// them not marked as global being overridable using its tag.
+var autoScalingSettings = []autoScalingSetting{
+	{flag: "allowed_instance_types", field: "AllowedInstanceTypes", validate: validateInstanceTypePatterns, custom: true},
+	{flag: "bidding_policy", field: "BiddingPolicy", validate: validateOneOf(DefaultBiddingPolicy, "aggressive")},
+	{flag: "disallowed_instance_types", field: "DisallowedInstanceTypes", validate: validateInstanceTypePatterns, custom: true},
+	{flag: "instance_termination_method", field: "InstanceTerminationMethod", validate: validateOneOf(AutoScalingTerminationMethod, DetachTerminationMethod)},
+	{flag: "termination_notification_action", field: "TerminationNotificationAction", validate: validateOneOf(AutoTerminationNotificationAction, TerminateTerminationNotificationAction, DetachTerminationNotificationAction)},
+	{flag: "rebalance_recommendation_action", field: "RebalanceRecommendationAction", validate: validateOneOf(DetachRebalanceRecommendationAction, ReplaceRebalanceRecommendationAction)},
+	{flag: "min_on_demand_number", field: "MinOnDemandNumber", validate: validateNonNegativeInt, custom: true},
+	{flag: "min_on_demand_percentage", field: "MinOnDemandPercentage", validate: validateFloatRange(0, 100), custom: true},
+	{flag: "on_demand_price_multiplier", field: "OnDemandPriceMultiplier", validate: validatePositiveFloat},
+	{flag: "spot_price_buffer_percentage", field: "SpotPriceBufferPercentage", validate: validateNonNegativeFloat},
+	{flag: "spot_product_description", field: "SpotProductDescription", validate: validateNotEmpty, custom: true},
+	{flag: "spot_product_premium", field: "SpotProductPremium", validate: validateNonNegativeFloat, global: true},
+	{flag: "cron_schedule", field: "CronSchedule", validate: validateCronSchedule},
+	{flag: "cron_timezone", field: "CronTimezone", validate: validateTimezone},
+	{flag: "cron_schedule_state", field: "CronScheduleState", validate: validateOneOf("on", "off")},
+	{flag: "patch_beanstalk_userdata", field: "PatchBeanstalkUserdata", tag: PatchBeanstalkUserdataTag, validate: validateBool},
+	{flag: "ebs_gp2_conversion_threshold", field: "GP2ConversionThreshold", tag: GP2ConversionThresholdTag, validate: validateNonNegativeInt},
+	{flag: "spot_allocation_strategy", field: "SpotAllocationStrategy", validate: validateOneOf(ec2.SpotAllocationStrategy_Values()...)},
+	{flag: "spot_price_max_volatility", field: "SpotPriceMaxVolatility", validate: validateNonNegativeFloat},
+	{flag: "spot_price_max_changes_per_day", field: "SpotPriceMaxChangesPerDay", validate: validateNonNegativeFloat},
+	{flag: "spot_price_volatility_weight", field: "SpotPriceVolatilityWeight", validate: validateNonNegativeFloat},
+	{flag: "vcpu_range", field: "VCPURange", validate: validateAttributeRange},
+	{flag: "memory_gib_range", field: "MemoryGiBRange", validate: validateAttributeRange},
+	{flag: "memory_per_vcpu_range", field: "MemoryPerVCPURange", validate: validateAttributeRange},
+	{flag: "allow_smaller", field: "AllowSmaller", validate: validateBool},
+	{flag: "check_network_performance", field: "CheckNetworkPerformance", validate: validateBool},
+	{flag: "check_ena_support", field: "CheckENASupport", validate: validateBool},
+	{flag: "check_efa_support", field: "CheckEFASupport", validate: validateBool},
+	{flag: "check_network_interfaces", field: "CheckNetworkInterfaces", validate: validateBool},
+	{flag: "cross_architecture", field: "CrossArchitecture", validate: validateBool},
+	{flag: "arm64_ami", field: "ARM64AMI", validate: validateImage},
+	{flag: "x86_64_ami", field: "X86AMI", validate: validateImage},
+	{flag: "gpu_models", field: "GPUModels", validate: validateGPUModels},
+	{flag: "keep_warm_pool_on_demand", field: "KeepWarmPoolOnDemand", validate: validateBool},
+	{flag: "drain_deregister_targets", field: "DrainDeregisterTargets", validate: validateBool},
+	{flag: "drain_deregister_timeout", field: "DrainDeregisterTimeout", validate: validateNonNegativeInt},
+	{flag: "drain_ssm_document", field: "DrainSSMDocument", validate: validateNotEmpty},
+	{flag: "drain_ssm_timeout", field: "DrainSSMTimeout", validate: validateNonNegativeInt},
+	{flag: "drain_lambda", field: "DrainLambda", validate: validateNotEmpty},
+	{flag: "drain_lambda_timeout", field: "DrainLambdaTimeout", validate: validateNonNegativeInt},
+}
+
+// tagName returns the name of the tag overriding the setting on a group.
+func (s autoScalingSetting) tagName() string {
+	if s.tag != "" {
+		return s.tag
+	}
+	return configTagPrefix + s.flag
+}
+
+// apply validates the value and sets it on the setting's field of the given
+// configuration.
+func (s autoScalingSetting) apply(conf *AutoScalingConfig, value string) error {
+	if err := s.validate(value); err != nil {
+		return err
+	}
+
+	field := reflect.ValueOf(conf).Elem().FieldByName(s.field)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s of field %s", field.Kind(), s.field)
+	}
+	return nil
+}
+
+// copy sets the setting's field of the destination configuration to its value
+// from the source one.
+func (s autoScalingSetting) copy(dst, src *AutoScalingConfig) {
+	reflect.ValueOf(dst).Elem().FieldByName(s.field).Set(
+		reflect.ValueOf(src).Elem().FieldByName(s.field))
+}
+
+func findAutoScalingSetting(flag string) (autoScalingSetting, bool) {
+	for _, s := range autoScalingSettings {
+		if s.flag == flag {
+			return s, true
+		}
+	}
+	return autoScalingSetting{}, false
+}
+
+// settingTagValidators returns the validators of all the tags overriding
+// settings, keyed by tag name.
+func settingTagValidators() map[string]func(string) error {
+	validators := map[string]func(string) error{}
+	for _, s := range autoScalingSettings {
+		if !s.global {
+			validators[s.tagName()] = s.validate
+		}
+	}
+	return validators
+}
+
+// loadSettingFromTag sets the given setting of the group configuration to its
+// default value, then overrides it with the value of its tag when valid.
+func (a *autoScalingGroup) loadSettingFromTag(flag string) bool {
+	s, found := findAutoScalingSetting(flag)
+	if !found || s.global {
+		log.Println("No tag can override the setting", flag)
+		return false
+	}
+
+	s.copy(&a.config, a.defaults())
+
+	tagValue := a.getTagValue(s.tagName())
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", s.tagName(), "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	if err := s.apply(&a.config, *tagValue); err != nil {
+		log.Printf("Ignoring invalid value %s of tag %s on the group %s: %s\n",
+			*tagValue, s.tagName(), a.name, err.Error())
+		return false
+	}
+
+	log.Printf("Loaded %s value %s from tag %s\n", s.field, *tagValue, s.tagName())
+	return true
+}
+
+// loadSettingsFromTags loads all the settings without dedicated loaders from
+// the tags of the group, returning true when any of them were overridden.
+func (a *autoScalingGroup) loadSettingsFromTags() bool {
+	ret := false
+	for _, s := range autoScalingSettings {
+		if s.custom || s.global {
+			continue
+		}
+		if a.loadSettingFromTag(s.flag) {
+			ret = true
+		}
+	}
+	return ret
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/namsral/flag"
+)
+
+// TestAutoScalingSettings_flags checks that every flag registered by
+// registerAutoScalingFlags is mapped to the field it's bound to.
+func TestAutoScalingSettings_flags(t *testing.T) {
+	var conf AutoScalingConfig
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	registerAutoScalingFlags(flagSet, &conf)
+
+	registered := 0
+	flagSet.VisitAll(func(f *flag.Flag) {
+		registered++
+
+		s, found := findAutoScalingSetting(f.Name)
+		if !found {
+			t.Errorf("flag %s has no setting", f.Name)
+			return
+		}
+
+		field := reflect.ValueOf(&conf).Elem().FieldByName(s.field)
+		if !field.IsValid() {
+			t.Errorf("setting %s maps to the unknown field %s", s.flag, s.field)
+			return
+		}
+
+		if field.Addr().Pointer() != reflect.ValueOf(f.Value).Pointer() {
+			t.Errorf("setting %s maps to the field %s, not the one bound to the flag", s.flag, s.field)
+		}
+	})
+
+	if registered != len(autoScalingSettings) {
+		t.Errorf("registered %d flags but found %d settings", registered, len(autoScalingSettings))
+	}
+}
+
+func Test_autoScalingGroup_loadSettingFromTag(t *testing.T) {
+	// valid and invalid tag values for every setting overridable by tags
+	values := map[string][2]string{
+		"allowed_instance_types":          {"m5.*,c5.large", "m5.["},
+		"bidding_policy":                  {"aggressive", "agressive"},
+		"disallowed_instance_types":       {"t2.*", " "},
+		"instance_termination_method":     {DetachTerminationMethod, "delete"},
+		"termination_notification_action": {TerminateTerminationNotificationAction, "stop"},
+		"rebalance_recommendation_action": {ReplaceRebalanceRecommendationAction, "launch"},
+		"min_on_demand_number":            {"3", "-1"},
+		"min_on_demand_percentage":        {"25.5", "101"},
+		"on_demand_price_multiplier":      {"0.7", "0"},
+		"spot_price_buffer_percentage":    {"15", "-5"},
+		"spot_product_description":        {"Windows (Amazon VPC)", ""},
+		"cron_schedule":                   {"9-18 1-5", "9-18"},
+		"cron_timezone":                   {"Europe/Berlin", "Europe/Nowhere"},
+		"cron_schedule_state":             {"off", "maybe"},
+		"patch_beanstalk_userdata":        {"true", "sometimes"},
+		"ebs_gp2_conversion_threshold":    {"200", "big"},
+		"spot_allocation_strategy":        {"lowest-price", "cheapest"},
+		"spot_price_max_volatility":       {"0.25", "-0.1"},
+		"spot_price_max_changes_per_day":  {"4", "often"},
+		"spot_price_volatility_weight":    {"1.5", "heavy"},
+		"vcpu_range":                      {"4-16", "16-4"},
+		"memory_gib_range":                {"8-", "lots"},
+		"memory_per_vcpu_range":           {"2-4", "-"},
+		"allow_smaller":                   {"true", "yes"},
+		"check_network_performance":       {"false", "no"},
+		"check_ena_support":               {"false", "off"},
+		"check_efa_support":               {"false", "0.5"},
+		"check_network_interfaces":        {"false", ""},
+		"cross_architecture":              {"true", "both"},
+		"arm64_ami":                       {"ami-0123456789abcdef0", "myapp-arm64"},
+		"x86_64_ami":                      {"/myapp/ami/x86_64", ""},
+		"gpu_models":                      {"current,A10G", "T[4"},
+		"keep_warm_pool_on_demand":        {"true", "always"},
+		"drain_deregister_targets":        {"true", "yes"},
+		"drain_deregister_timeout":        {"30", "-1"},
+		"drain_ssm_document":              {"my-graceful-shutdown", " "},
+		"drain_ssm_timeout":               {"90", "1m"},
+		"drain_lambda":                    {"arn:aws:lambda:us-east-1:123456789012:function:drain", ""},
+		"drain_lambda_timeout":            {"0", "ten"},
+	}
+
+	defaults := AutoScalingConfig{
+		AllowedInstanceTypes:          "m5.large",
+		BiddingPolicy:                 DefaultBiddingPolicy,
+		InstanceTerminationMethod:     DefaultInstanceTerminationMethod,
+		TerminationNotificationAction: DefaultTerminationNotificationAction,
+		MinOnDemandNumber:             1,
+		OnDemandPriceMultiplier:       DefaultOnDemandPriceMultiplier,
+		SpotPriceBufferPercentage:     DefaultSpotPriceBufferPercentage,
+		SpotProductDescription:        DefaultSpotProductDescription,
+		CronSchedule:                  DefaultCronSchedule,
+		CronTimezone:                  "UTC",
+		CronScheduleState:             "on",
+		GP2ConversionThreshold:        DefaultGP2ConversionThreshold,
+		SpotAllocationStrategy:        "capacity-optimized-prioritized",
+	}
+
+	for _, s := range autoScalingSettings {
+		if s.global {
+			continue
+		}
+
+		v, found := values[s.flag]
+		if !found {
+			t.Errorf("no test values for the setting %s", s.flag)
+			continue
+		}
+
+		for i, value := range v {
+			valid := i == 0
+			t.Run(fmt.Sprintf("%s valid=%t", s.tagName(), valid), func(t *testing.T) {
+				a := &autoScalingGroup{
+					Group: &autoscaling.Group{
+						Tags: []*autoscaling.TagDescription{{
+							Key:   aws.String(s.tagName()),
+							Value: aws.String(value),
+						}},
+					},
+					region: &region{conf: &Config{AutoScalingConfig: defaults}},
+				}
+
+				if got := a.loadSettingFromTag(s.flag); got != valid {
+					t.Errorf("loadSettingFromTag() = %v, expected %v", got, valid)
+				}
+
+				got := fmt.Sprint(reflect.ValueOf(a.config).FieldByName(s.field).Interface())
+				want := fmt.Sprint(reflect.ValueOf(defaults).FieldByName(s.field).Interface())
+				if valid {
+					want = value
+				}
+				if got != want {
+					t.Errorf("loadSettingFromTag() set %s to %s, expected %s", s.field, got, want)
+				}
+			})
+		}
+	}
+}
+
+func Test_autoScalingGroup_loadSettingsFromTags(t *testing.T) {
+	a := &autoScalingGroup{
+		Group: &autoscaling.Group{
+			Tags: []*autoscaling.TagDescription{
+				{Key: aws.String("autospotting_instance_termination_method"), Value: aws.String(DetachTerminationMethod)},
+				{Key: aws.String("autospotting_spot_price_max_volatility"), Value: aws.String("0.3")},
+				{Key: aws.String(BiddingPolicyTag), Value: aws.String("aggressive")},
+			},
+		},
+		region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{
+			InstanceTerminationMethod: DefaultInstanceTerminationMethod,
+			CronTimezone:              "UTC",
+			BiddingPolicy:             DefaultBiddingPolicy,
+		}}},
+	}
+
+	if !a.loadSettingsFromTags() {
+		t.Errorf("loadSettingsFromTags() expected to load the settings")
+	}
+
+	want := AutoScalingConfig{
+		InstanceTerminationMethod: DetachTerminationMethod,
+		SpotPriceMaxVolatility:    0.3,
+		CronTimezone:              "UTC",
+		BiddingPolicy:             "aggressive",
+	}
+	if !reflect.DeepEqual(a.config, want) {
+		t.Errorf("loadSettingsFromTags() got %+v, expected %+v", a.config, want)
+	}
+}
+
+func TestAutoScalingConfig_terminationMethod(t *testing.T) {
+	tests := []struct {
+		name string
+		conf AutoScalingConfig
+		want string
+	}{
+		{
+			name: "instance termination method",
+			conf: AutoScalingConfig{InstanceTerminationMethod: DetachTerminationMethod},
+			want: DetachTerminationMethod,
+		},
+		{
+			name: "legacy termination method",
+			conf: AutoScalingConfig{
+				InstanceTerminationMethod: DetachTerminationMethod,
+				TerminationMethod:         AutoScalingTerminationMethod,
+			},
+			want: AutoScalingTerminationMethod,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.conf.terminationMethod(); got != tt.want {
+				t.Errorf("terminationMethod() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpotTermination_groupTerminationNotificationAction(t *testing.T) {
+	groupWithAction := func(action string) *autoscaling.DescribeAutoScalingGroupsOutput {
+		return &autoscaling.DescribeAutoScalingGroupsOutput{
+			AutoScalingGroups: []*autoscaling.Group{{
+				Tags: []*autoscaling.TagDescription{{
+					Key:   aws.String("autospotting_termination_notification_action"),
+					Value: aws.String(action),
+				}},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name string
+		asg  mockASG
+		want string
+	}{
+		{
+			name: "group not found",
+			asg:  mockASG{},
+			want: AutoTerminationNotificationAction,
+		},
+		{
+			name: "tag set on the group",
+			asg:  mockASG{dasgo: groupWithAction(TerminateTerminationNotificationAction)},
+			want: TerminateTerminationNotificationAction,
+		},
+		{
+			name: "invalid tag set on the group",
+			asg:  mockASG{dasgo: groupWithAction("stop")},
+			want: AutoTerminationNotificationAction,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SpotTermination{asSvc: tt.asg}
+			if got := s.groupTerminationNotificationAction("asg", AutoTerminationNotificationAction); got != tt.want {
+				t.Errorf("groupTerminationNotificationAction() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpotTermination_rebalanceRecommendationAction(t *testing.T) {
+	groupMember := &autoscaling.DescribeAutoScalingInstancesOutput{
+		AutoScalingInstances: []*autoscaling.InstanceDetails{{
+			AutoScalingGroupName: aws.String("asg"),
+		}},
+	}
+
+	groupWithAction := func(action string) *autoscaling.DescribeAutoScalingGroupsOutput {
+		return &autoscaling.DescribeAutoScalingGroupsOutput{
+			AutoScalingGroups: []*autoscaling.Group{{
+				Tags: []*autoscaling.TagDescription{{
+					Key:   aws.String("autospotting_rebalance_recommendation_action"),
+					Value: aws.String(action),
+				}},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		asg    mockASG
+		action string
+		want   string
+	}{
+		{
+			name:   "instance not in a group",
+			asg:    mockASG{dasio: &autoscaling.DescribeAutoScalingInstancesOutput{}},
+			action: ReplaceRebalanceRecommendationAction,
+			want:   DetachRebalanceRecommendationAction,
+		},
+		{
+			name:   "global action without group tag",
+			asg:    mockASG{dasio: groupMember},
+			action: ReplaceRebalanceRecommendationAction,
+			want:   ReplaceRebalanceRecommendationAction,
+		},
+		{
+			name:   "tag set on the group",
+			asg:    mockASG{dasio: groupMember, dasgo: groupWithAction(ReplaceRebalanceRecommendationAction)},
+			action: DetachRebalanceRecommendationAction,
+			want:   ReplaceRebalanceRecommendationAction,
+		},
+		{
+			name:   "invalid tag set on the group",
+			asg:    mockASG{dasio: groupMember, dasgo: groupWithAction("launch")},
+			action: DetachRebalanceRecommendationAction,
+			want:   DetachRebalanceRecommendationAction,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SpotTermination{asSvc: tt.asg}
+			if got := s.rebalanceRecommendationAction(aws.String("i-0123"), tt.action); got != tt.want {
+				t.Errorf("rebalanceRecommendationAction() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1668,9 +1668,7 @@ func TestReplaceOnDemandInstanceWithSpot(t *testing.T) {
 				),
 				region: &region{
 					name: "test-region",
-					conf: &Config{
-						FinalRecap: make(map[string][]string),
-					},
+					conf: &Config{},
 					services: connections{
 						autoScaling: &mockASG{
 							uasgo:     nil,
@@ -1788,9 +1786,7 @@ func TestReplaceOnDemandInstanceWithSpot(t *testing.T) {
 				),
 				region: &region{
 					name: "test-region",
-					conf: &Config{
-						FinalRecap: make(map[string][]string),
-					},
+					conf: &Config{},
 					services: connections{
 						autoScaling: &mockASG{
 							uasgo:     nil,
@@ -1899,9 +1895,7 @@ func TestReplaceOnDemandInstanceWithSpot(t *testing.T) {
 				instances: makeInstances(),
 				region: &region{
 					name: "test-region",
-					conf: &Config{
-						FinalRecap: make(map[string][]string),
-					},
+					conf: &Config{},
 					services: connections{
 						autoScaling: &mockASG{
 							uasgo:     nil,
@@ -1969,9 +1963,7 @@ func TestReplaceOnDemandInstanceWithSpot(t *testing.T) {
 				}),
 				region: &region{
 					name: "test-region",
-					conf: &Config{
-						FinalRecap: make(map[string][]string),
-					},
+					conf: &Config{},
 					services: connections{
 						autoScaling: &mockASG{
 							uasgo:     nil,
@@ -2047,13 +2039,13 @@ func TestReplaceOnDemandInstanceWithSpot(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			fmt.Println(tt.name)
-			returned := tt.asg.replaceOnDemandInstanceWithSpot(tt.spotID)
+			_, returned := tt.asg.replaceOnDemandInstanceWithSpot(tt.spotID)
 			CheckErrors(t, returned, tt.expected)
 		})
 		t.Run(tt.name+"-detach-method", func(t *testing.T) {
 			fmt.Println(tt.name)
 			tt.asg.config.TerminationMethod = "detach"
-			returned := tt.asg.replaceOnDemandInstanceWithSpot(tt.spotID)
+			_, returned := tt.asg.replaceOnDemandInstanceWithSpot(tt.spotID)
 			CheckErrors(t, returned, tt.expected)
 		})
 	}
@@ -3064,7 +3056,7 @@ func Test_autoScalingGroup_terminateRandomSpotInstanceIfHavingEnough(t *testing.
 				instances:           tt.instances,
 				config:              tt.config,
 			}
-			if err := a.terminateRandomSpotInstanceIfHavingEnough(tt.totalRunning, tt.wait); (err != nil) != tt.wantErr {
+			if _, err := a.terminateRandomSpotInstanceIfHavingEnough(tt.totalRunning, tt.wait); (err != nil) != tt.wantErr {
 				t.Errorf("autoScalingGroup.terminateRandomSpotInstanceIfHavingEnough() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -3247,7 +3239,6 @@ func Test_autoScalingGroup_cronEventAction(t *testing.T) {
 
 					MinOnDemandNumber: 1,
 				},
-				FinalRecap:  make(map[string][]string),
 				LicenseType: "custom",
 				Version:     "nightly",
 			},
@@ -3387,7 +3378,7 @@ func Test_autoScalingGroup_cronEventAction(t *testing.T) {
 
 		{name: "allowed to replace instance, spot instance replacement exists but not ready",
 			asg:  &asgExistingSpotReplacementButNotReady,
-			want: skipRun{reason: "spot instance replacement exists but not ready"},
+			want: skipRun{reason: "spot-instance-not-ready"},
 		},
 
 		{name: "allowed to replace instance, spot instance replacement exists and ready",
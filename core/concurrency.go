@@ -0,0 +1,41 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import "sync"
+
+const (
+	// DefaultRegionConcurrency is the default number of regions processed at
+	// the same time
+	DefaultRegionConcurrency = 4
+
+	// DefaultGroupConcurrency is the default number of AutoScaling groups
+	// processed at the same time in each region
+	DefaultGroupConcurrency = 8
+)
+
+// runConcurrently calls fn for each of the n items from a pool of at most
+// limit workers, or from as many workers as items when limit isn't positive,
+// returning once all of them were processed.
+func runConcurrently(n, limit int, fn func(i int)) {
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, limit)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunConcurrently(t *testing.T) {
+	tests := []struct {
+		name        string
+		n           int
+		limit       int
+		wantRunning int
+	}{
+		{name: "no items", n: 0, limit: 4, wantRunning: 0},
+		{name: "bounded by the limit", n: 10, limit: 3, wantRunning: 3},
+		{name: "fewer items than workers", n: 2, limit: 8, wantRunning: 2},
+		{name: "unlimited", n: 6, limit: 0, wantRunning: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			running, maxRunning := 0, 0
+			processed := make([]bool, tt.n)
+
+			runConcurrently(tt.n, tt.limit, func(i int) {
+				mu.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mu.Unlock()
+
+				time.Sleep(20 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				processed[i] = true
+				mu.Unlock()
+			})
+
+			if maxRunning != tt.wantRunning {
+				t.Errorf("runConcurrently() ran %d items at the same time, want %d", maxRunning, tt.wantRunning)
+			}
+			for i, done := range processed {
+				if !done {
+					t.Errorf("runConcurrently() didn't process item %d", i)
+				}
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/endpoints"
@@ -42,6 +43,15 @@ const (
 	// terminate the spot instance (as TerminateTerminationNotificationAction), if not detach it.
 	AutoTerminationNotificationAction = "auto"
 
+	// DetachRebalanceRecommendationAction detaches the spot instance receiving a
+	// rebalance recommendation, so that the group launches a new instance.
+	DetachRebalanceRecommendationAction = "detach"
+
+	// ReplaceRebalanceRecommendationAction launches a spot replacement in another
+	// capacity pool for the instance receiving a rebalance recommendation, and
+	// only terminates the instance after attaching its replacement to the group.
+	ReplaceRebalanceRecommendationAction = "replace"
+
 	// DefaultCronSchedule is the default value for the execution schedule in
 	// simplified Cron-style definition the cron format only accepts the hour and
 	// day of week fields, for example "9-18 1-5" would define the working week
@@ -98,16 +108,19 @@ type Config struct {
 	// The license of this AutoSpotting build - obsolete
 	LicenseType string
 
-	// Controls whether AutoSpotting patches Elastic Beanstalk UserData scripts to use
-	// the instance role when calling CloudFormation helpers instead of the standard CloudFormation
-	// authentication method
-	PatchBeanstalkUserdata bool
-
 	// JSON file containing event data used for locally simulating execution from Lambda.
 	EventFile string
 
-	// Final Recap String Array to show actions taken by ScheduleRun on ASGs
-	FinalRecap map[string][]string
+	// Time window of spot price history used for computing the volatility of
+	// the spot pools. Only the current prices are used when zero.
+	SpotPriceHistoryWindow time.Duration
+
+	// ReportFile is the file where the JSON report of the cron runs is written,
+	// "-" meaning the standard output. No report file is written when empty.
+	ReportFile string
+
+	// The report of the actions taken on the groups during the current cron run
+	report *RunReport
 
 	// SQS Queue URl
 	SQSQueueURL string
@@ -120,6 +133,98 @@ type Config struct {
 
 	// DisableInstanceRebalanceRecommendation disable the handling of Instance Rebalance Recommendation events.
 	DisableInstanceRebalanceRecommendation bool
+
+	// DryRun computes all the actions but only prints them as a plan instead
+	// of executing them
+	DryRun bool
+
+	// DryRunFormat controls how the dry-run plan is printed: text or json
+	DryRunFormat string
+
+	// The actions collected while running in dry-run mode
+	plan *actionPlan
+
+	// AWSEndpointURL overrides the endpoint of all the AWS services, for
+	// example for using a local AWS emulator
+	AWSEndpointURL string
+
+	// AWSEndpoints overrides the endpoints of individual AWS services, given
+	// as a list of service=URL pairs
+	AWSEndpoints string
+
+	// AssumeRoleARNs lists the ARNs of the roles assumed for processing other
+	// accounts instead of the current one
+	AssumeRoleARNs string
+
+	// OrganizationalUnitIDs lists the AWS Organizations units whose accounts
+	// are processed by assuming the AssumeRoleName role in each of them
+	OrganizationalUnitIDs string
+
+	// AssumeRoleName is the name of the role assumed in the accounts
+	// discovered from the organizational units
+	AssumeRoleName string
+
+	// The account processed using this configuration and the role assumed in
+	// it, both empty for the account where AutoSpotting runs
+	accountID string
+	roleARN   string
+
+	// Daemon keeps the process running, processing the regions on every
+	// DaemonInterval instead of performing a single run
+	Daemon bool
+
+	// DaemonInterval is the interval between the runs performed in daemon mode
+	DaemonInterval time.Duration
+
+	// InstanceTypeCacheTTL is the time for which the instance type
+	// information and the spot prices of each region are cached in memory,
+	// nothing being cached when zero
+	InstanceTypeCacheTTL time.Duration
+
+	// InstanceTypeCacheDir is a local directory where the spot prices are
+	// also cached, so that they survive the restarts of the process
+	InstanceTypeCacheDir string
+
+	// RegionConcurrency is the number of regions processed at the same time
+	RegionConcurrency int
+
+	// GroupConcurrency is the number of AutoScaling groups processed at the
+	// same time in each region
+	GroupConcurrency int
+
+	// MetricsAddress is the address where the Prometheus metrics are served
+	// on the /metrics path. The metrics aren't served when empty.
+	MetricsAddress string
+
+	// MetricsPushGatewayURL is the URL of a Prometheus Pushgateway where the
+	// metrics are pushed at the end of each run. Nothing is pushed when empty.
+	MetricsPushGatewayURL string
+
+	// WriteConfigErrorsTag controls whether the invalid or unknown
+	// configuration tags found on the groups are also written to their
+	// autospotting_config_errors tag
+	WriteConfigErrorsTag bool
+
+	// ConfigFile is a YAML or JSON file providing the values of the flags not
+	// set from the environment or the command line
+	ConfigFile string
+
+	// StateTable is the DynamoDB table where the lifecycle of the replacements
+	// is persisted, shared by all the AutoSpotting processes
+	StateTable string
+
+	// StateFile is the local file where the lifecycle of the replacements is
+	// persisted when no StateTable is set. It's only kept in memory when empty.
+	StateFile string
+
+	// The store of the lifecycle of the replacements, used for processing the
+	// events idempotently
+	replacements replacementStore
+
+	// The group-specific configuration overrides of the configuration file,
+	// keyed by region and by AutoScaling group name
+	regionOverrides map[string]configOverrides
+	groupOverrides  map[string]configOverrides
 }
 
 // ParseConfig loads configuration from command line flags, environments variables, and config files.
@@ -146,6 +251,186 @@ func ParseConfig(conf *Config) {
 	conf.SleepMultiplier = 1
 	conf.sqsReceiptHandle = ""
 
+	registerAutoScalingFlags(flagSet, &conf.AutoScalingConfig)
+
+	flagSet.StringVar(&conf.Regions, "regions", "",
+		"\n\tRegions where it should be activated (separated by comma or whitespace, also supports globs).\n"+
+			"\tBy default it runs on all regions.\n"+
+			"\tExample: ./AutoSpotting -regions 'eu-*,us-east-1'\n")
+
+	flagSet.StringVar(&conf.TagFilteringMode, "tag_filtering_mode", "opt-in", "\n\tControls the behavior of the tag_filters option.\n"+
+		"\tValid choices: opt-in | opt-out\n\tDefault value: 'opt-in'\n\tExample: ./AutoSpotting --tag_filtering_mode opt-out\n")
+
+	flagSet.StringVar(&conf.FilterByTags, "tag_filters", "", "\n\tSet of tags to filter the ASGs on.\n"+
+		"\tDefault if no value is set will be the equivalent of -tag_filters 'spot-enabled=true'\n"+
+		"\tIn case the tag_filtering_mode is set to opt-out, it defaults to 'spot-enabled=false'\n"+
+		"\tExample: ./AutoSpotting --tag_filters 'spot-enabled=true,Environment=dev,Team=vision'\n")
+
+	flagSet.StringVar(&conf.LicenseType, "license", "evaluation", "\n\t - obsoleted, kept for compatibility only\n"+
+		"\tExample: ./AutoSpotting --license evaluation\n")
+
+	flagSet.StringVar(&conf.EventFile, "event_file", "", "\n\tJSON file containing event data, "+
+		"used for locally simulating execution from Lambda. AutoSpotting now expects to be "+
+		"triggered by events and won't do anything if no event is passed either as result of "+
+		"AWS instance state notifications or simulated manually using this flag.\n")
+
+	flagSet.StringVar(&conf.SQSQueueURL, "sqs_queue_url", "", "\n\tThe Url of the SQS fifo queue used to manage spot replacement actions. "+
+		"This needs to exist in the same region as the main AutoSpotting Lambda function"+
+		"\tExample: ./AutoSpotting --sqs_queue_url https://sqs.{AwsRegion}.amazonaws.com/{AccountId}/AutoSpotting.fifo\n")
+
+	flagSet.BoolVar(&conf.DisableEventBasedInstanceReplacement, "disable_event_based_instance_replacement", false,
+		"\n\tDisables the event based instance replacement, forcing the legacy cron mode.\n"+
+			"\tExample: ./AutoSpotting --disable_event_based_instance_replacement=true\n")
+
+	flagSet.BoolVar(&conf.DisableInstanceRebalanceRecommendation, "disable_instance_rebalance_recommendation", false,
+		"\n\tDisables handling of instance rebalance recommendation events.\n"+
+			"\tExample: ./AutoSpotting --disable_instance_rebalance_recommendation=true\n")
+
+	flagSet.BoolVar(&conf.DryRun, "dry_run", false,
+		"\n\tComputes all the replacement and termination actions but doesn't execute them, printing\n"+
+			"\tinstead a plan with the instances that would be replaced or terminated and why.\n"+
+			"\tExample: ./AutoSpotting --dry_run=true\n")
+
+	flagSet.StringVar(&conf.DryRunFormat, "dry_run_format", PlanFormatText,
+		"\n\tFormat of the plan printed when running in dry-run mode.\n"+
+			"\tValid choices: "+PlanFormatText+" | "+PlanFormatJSON+"\n"+
+			"\tExample: ./AutoSpotting --dry_run=true --dry_run_format json\n")
+
+	flagSet.DurationVar(&conf.SpotPriceHistoryWindow, "spot_price_history_window", 0,
+		"\n\tTime window of spot price history used for computing the price volatility of each spot pool.\n"+
+			"\tBy default only the current spot prices are used and the volatility is not considered.\n"+
+			"\tExample: ./AutoSpotting --spot_price_history_window 168h\n")
+
+	flagSet.StringVar(&conf.ReportFile, "report_file", "",
+		"\n\tFile where to write a JSON report of the actions taken on each group during cron runs,\n"+
+			"\tuse - for writing it to the standard output. No report file is written by default.\n"+
+			"\tExample: ./AutoSpotting --report_file report.json\n")
+
+	flagSet.StringVar(&conf.AWSEndpointURL, "aws_endpoint_url", "",
+		"\n\tOverrides the endpoint of all the AWS services, for example for using a local AWS emulator\n"+
+			"\tsuch as LocalStack or moto. The default AWS endpoints are used when empty.\n"+
+			"\tExample: ./AutoSpotting --aws_endpoint_url http://localhost:4566\n")
+
+	flagSet.StringVar(&conf.AWSEndpoints, "aws_endpoints", "",
+		"\n\tOverrides the endpoints of individual AWS services, taking precedence over aws_endpoint_url.\n"+
+			"\tAccepts a list of comma or whitespace separated service=URL pairs, the supported services\n"+
+			"\tbeing "+strings.Join(endpointServices, ", ")+".\n"+
+			"\tExample: ./AutoSpotting --aws_endpoints 'ec2=http://localhost:5000,sqs=http://localhost:9324'\n")
+
+	flagSet.StringVar(&conf.AssumeRoleARNs, "assume_role_arns", "",
+		"\n\tIAM roles assumed for processing the AutoScaling groups of other accounts during cron runs,\n"+
+			"\tinstead of the account where AutoSpotting runs. Each account gets its own section in the\n"+
+			"\trun report. Accepts a list of comma or whitespace separated role ARNs.\n"+
+			"\tExample: ./AutoSpotting --assume_role_arns 'arn:aws:iam::123456789012:role/AutoSpotting'\n")
+
+	flagSet.StringVar(&conf.OrganizationalUnitIDs, "organizational_unit_ids", "",
+		"\n\tAWS Organizations units whose active accounts are processed during cron runs by assuming\n"+
+			"\tthe assume_role_name role in each of them, in addition to the assume_role_arns.\n"+
+			"\tAccepts a list of comma or whitespace separated organizational unit IDs.\n"+
+			"\tExample: ./AutoSpotting --organizational_unit_ids ou-ab12-cdef3456\n")
+
+	flagSet.StringVar(&conf.AssumeRoleName, "assume_role_name", DefaultAssumeRoleName,
+		"\n\tName of the IAM role assumed in the accounts discovered from the organizational_unit_ids.\n"+
+			"\tExample: ./AutoSpotting --assume_role_name AutoSpotting\n")
+
+	flagSet.BoolVar(&conf.Daemon, "daemon", false,
+		"\n\tKeeps running as a long-lived process, processing the regions on every daemon_interval\n"+
+			"\tuntil receiving SIGTERM, instead of performing a single run and exiting.\n"+
+			"\tExample: ./AutoSpotting --daemon=true --daemon_interval 5m\n")
+
+	flagSet.DurationVar(&conf.DaemonInterval, "daemon_interval", DefaultDaemonInterval,
+		"\n\tInterval between the runs performed in daemon mode.\n"+
+			"\tExample: ./AutoSpotting --daemon=true --daemon_interval 10m\n")
+
+	flagSet.DurationVar(&conf.InstanceTypeCacheTTL, "instance_type_cache_ttl", DefaultInstanceTypeCacheTTL,
+		"\n\tTime for which the instance type information and the spot prices of each region are cached\n"+
+			"\tin memory, across the warm invocations of the Lambda function. Disabled by default, when 0.\n"+
+			"\tExample: ./AutoSpotting --instance_type_cache_ttl 10m\n")
+
+	flagSet.StringVar(&conf.InstanceTypeCacheDir, "instance_type_cache_dir", "",
+		"\n\tLocal directory where the spot prices are also cached for instance_type_cache_ttl, so that\n"+
+			"\tthey survive the restarts of the process. They're only cached in memory when empty.\n"+
+			"\tExample: ./AutoSpotting --instance_type_cache_dir /tmp\n")
+
+	flagSet.IntVar(&conf.RegionConcurrency, "region_concurrency", DefaultRegionConcurrency,
+		"\n\tNumber of regions processed at the same time, all of them being processed at once\n"+
+			"\twhen set to 0.\n"+
+			"\tExample: ./AutoSpotting --region_concurrency 2\n")
+
+	flagSet.IntVar(&conf.GroupConcurrency, "group_concurrency", DefaultGroupConcurrency,
+		"\n\tNumber of AutoScaling groups processed at the same time in each region, all of them\n"+
+			"\tbeing processed at once when set to 0.\n"+
+			"\tExample: ./AutoSpotting --group_concurrency 4\n")
+
+	flagSet.StringVar(&conf.MetricsAddress, "metrics_address", "",
+		"\n\tAddress where to serve Prometheus metrics on the /metrics path, useful for long-running\n"+
+			"\tdeployments such as containers. The metrics aren't served by default.\n"+
+			"\tExample: ./AutoSpotting --metrics_address :9090\n")
+
+	flagSet.StringVar(&conf.MetricsPushGatewayURL, "metrics_pushgateway_url", "",
+		"\n\tURL of a Prometheus Pushgateway where to push the metrics at the end of each run,\n"+
+			"\tuseful for one-shot runs. The metrics aren't pushed by default.\n"+
+			"\tExample: ./AutoSpotting --metrics_pushgateway_url http://pushgateway:9091\n")
+
+	flagSet.BoolVar(&conf.WriteConfigErrorsTag, "write_config_errors_tag", false,
+		"\n\tWrites the invalid or unknown autospotting_* tags found on each group to its "+ConfigErrorsTag+"\n"+
+			"\ttag, so that the group owners can see them. They are always logged and included in the run report.\n"+
+			"\tExample: ./AutoSpotting --write_config_errors_tag=true\n")
+
+	flagSet.StringVar(&conf.StateTable, "state_table", "",
+		"\n\tDynamoDB table, with the instance_id string partition key, where the lifecycle of each\n"+
+			"\treplacement is persisted so that duplicate events and overlapping runs don't launch more\n"+
+			"\tthan one replacement per instance. Takes precedence over state_file.\n"+
+			"\tExample: ./AutoSpotting --state_table AutoSpottingReplacements\n")
+
+	flagSet.StringVar(&conf.StateFile, "state_file", "",
+		"\n\tLocal JSON file where the lifecycle of each replacement is persisted when no state_table\n"+
+			"\tis set, for local runs and tests. By default it's only kept in memory.\n"+
+			"\tExample: ./AutoSpotting --state_file replacements.json\n")
+
+	flagSet.StringVar(&conf.ConfigFile, configFlagName, "",
+		"\n\tYAML or JSON file (detected by the .json extension) providing the configuration, keyed by\n"+
+			"\tthe flag names. The values set from the environment or the command line take precedence.\n"+
+			"\tThe group-specific flags can also be overridden in the "+regionOverridesKey+" and\n"+
+			"\t"+groupOverridesKey+" sections, keyed by region and by group name, applied on\n"+
+			"\ttop of the global configuration but still overridden by the group tags.\n"+
+			"\tExample: ./AutoSpotting --config_file autospotting.yaml\n")
+
+	printVersion := flagSet.Bool("version", false, "Print version number and exit.\n")
+
+	if err := flagSet.Parse(os.Args[1:]); err != nil {
+		fmt.Printf("Error parsing config: %s\n", err.Error())
+	}
+
+	if conf.ConfigFile != "" {
+		if err := conf.loadConfigFile(flagSet, conf.ConfigFile); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	if conf.SpotPriceBufferPercentage <= 0 {
+		conf.SpotPriceBufferPercentage = DefaultSpotPriceBufferPercentage
+	}
+
+	if *printVersion {
+		fmt.Println("AutoSpotting build:", conf.Version)
+		os.Exit(0)
+	}
+
+	data, err := ec2instancesinfo.Data()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	conf.InstanceData = data
+
+	conf.report = newRunReport()
+	conf.plan = newActionPlan()
+}
+
+// registerAutoScalingFlags defines the flags of the group-specific
+// configurations, which can also be overridden per region and per group in the
+// configuration file.
+func registerAutoScalingFlags(flagSet *flag.FlagSet, conf *AutoScalingConfig) {
 	flagSet.StringVar(&conf.AllowedInstanceTypes, "allowed_instance_types", "",
 		"\n\tIf specified, the spot instances will be searched only among these types.\n\tIf missing, any instance type is allowed.\n"+
 			"\tAccepts a list of comma or whitespace separated instance types (supports globs).\n"+
@@ -172,6 +457,14 @@ func ParseConfig(conf *Config) {
 			"' (terminate if lifecyclehook else detach) | 'terminate' (lifecyclehook triggered)"+
 			" | 'detach' (lifecyclehook not triggered)\n")
 
+	flagSet.StringVar(&conf.RebalanceRecommendationAction, "rebalance_recommendation_action", DefaultRebalanceRecommendationAction,
+		"\n\tAction taken on the spot instances receiving rebalance recommendations.\n"+
+			"\tValid choices:\n"+
+			"\t'"+DefaultRebalanceRecommendationAction+
+			"' (detach the instance and let the group launch a new one) | 'replace' (launch and attach"+
+			" a spot replacement in another capacity pool, then terminate the instance)\n"+
+			"\tCan be overridden on a per-group basis using the tag autospotting_rebalance_recommendation_action.\n")
+
 	flagSet.Int64Var(&conf.MinOnDemandNumber, "min_on_demand_number", DefaultMinOnDemandValue,
 		"\n\tNumber of on-demand nodes to be kept running in each of the groups.\n\t"+
 			"Can be overridden on a per-group basis using the tag "+OnDemandNumberLong+".\n")
@@ -187,11 +480,6 @@ func ParseConfig(conf *Config) {
 			"\tExample: ./AutoSpotting -on_demand_price_multiplier 0.6 will have the on-demand price "+
 			"considered at 60% of the actual value.\n")
 
-	flagSet.StringVar(&conf.Regions, "regions", "",
-		"\n\tRegions where it should be activated (separated by comma or whitespace, also supports globs).\n"+
-			"\tBy default it runs on all regions.\n"+
-			"\tExample: ./AutoSpotting -regions 'eu-*,us-east-1'\n")
-
 	flagSet.Float64Var(&conf.SpotPriceBufferPercentage, "spot_price_buffer_percentage", DefaultSpotPriceBufferPercentage,
 		"\n\tBid a given percentage above the current spot price.\n\tProtects the group from running spot"+
 			"instances that got significantly more expensive than when they were initially launched\n"+
@@ -201,20 +489,14 @@ func ParseConfig(conf *Config) {
 	flagSet.StringVar(&conf.SpotProductDescription, "spot_product_description", DefaultSpotProductDescription,
 		"\n\tThe Spot Product to use when looking up spot price history in the market.\n"+
 			"\tValid choices: Linux/UNIX | SUSE Linux | Windows | Linux/UNIX (Amazon VPC) | \n"+
-			"\tSUSE Linux (Amazon VPC) | Windows (Amazon VPC) | Red Hat Enterprise Linux\n\tDefault value: "+DefaultSpotProductDescription+"\n")
+			"\tSUSE Linux (Amazon VPC) | Windows (Amazon VPC) | Red Hat Enterprise Linux\n\tDefault value: "+DefaultSpotProductDescription+"\n"+
+			"\tInstances running Windows, RHEL or SUSE AMIs are detected and priced accordingly regardless of this value.\n"+
+			"\tCan be overridden on a per-group level using the "+SpotProductDescriptionTag+" tag.\n")
 
 	flagSet.Float64Var(&conf.SpotProductPremium, "spot_product_premium", DefaultSpotProductPremium,
 		"\n\tThe Product Premium to apply to the on demand price to improve spot selection and savings calculations\n"+
 			"\twhen using a premium instance type such as RHEL.")
 
-	flagSet.StringVar(&conf.TagFilteringMode, "tag_filtering_mode", "opt-in", "\n\tControls the behavior of the tag_filters option.\n"+
-		"\tValid choices: opt-in | opt-out\n\tDefault value: 'opt-in'\n\tExample: ./AutoSpotting --tag_filtering_mode opt-out\n")
-
-	flagSet.StringVar(&conf.FilterByTags, "tag_filters", "", "\n\tSet of tags to filter the ASGs on.\n"+
-		"\tDefault if no value is set will be the equivalent of -tag_filters 'spot-enabled=true'\n"+
-		"\tIn case the tag_filtering_mode is set to opt-out, it defaults to 'spot-enabled=false'\n"+
-		"\tExample: ./AutoSpotting --tag_filters 'spot-enabled=true,Environment=dev,Team=vision'\n")
-
 	flagSet.StringVar(&conf.CronSchedule, "cron_schedule", DefaultCronSchedule, "\n\tCron-like schedule in which to"+
 		"\tperform(or not) spot replacement actions. Format: hour day-of-week\n"+
 		"\tExample: ./AutoSpotting --cron_schedule '9-18 1-5' # workdays during the office hours \n")
@@ -227,18 +509,6 @@ func ParseConfig(conf *Config) {
 		"inside or outside the schedule defined by cron_schedule. Allowed values: on|off\n"+
 		"\tExample: ./AutoSpotting --cron_schedule_state='off' --cron_schedule '9-18 1-5'  # would only take action outside the defined schedule\n")
 
-	flagSet.StringVar(&conf.LicenseType, "license", "evaluation", "\n\t - obsoleted, kept for compatibility only\n"+
-		"\tExample: ./AutoSpotting --license evaluation\n")
-
-	flagSet.StringVar(&conf.EventFile, "event_file", "", "\n\tJSON file containing event data, "+
-		"used for locally simulating execution from Lambda. AutoSpotting now expects to be "+
-		"triggered by events and won't do anything if no event is passed either as result of "+
-		"AWS instance state notifications or simulated manually using this flag.\n")
-
-	flagSet.StringVar(&conf.SQSQueueURL, "sqs_queue_url", "", "\n\tThe Url of the SQS fifo queue used to manage spot replacement actions. "+
-		"This needs to exist in the same region as the main AutoSpotting Lambda function"+
-		"\tExample: ./AutoSpotting --sqs_queue_url https://sqs.{AwsRegion}.amazonaws.com/{AccountId}/AutoSpotting.fifo\n")
-
 	flagSet.BoolVar(&conf.PatchBeanstalkUserdata, "patch_beanstalk_userdata", false,
 		"\n\tControls whether AutoSpotting patches Elastic Beanstalk UserData scripts to use the "+
 			"instance role when calling CloudFormation helpers instead of the standard CloudFormation "+
@@ -252,14 +522,6 @@ func ParseConfig(conf *Config) {
 			"1TB GP2 also has better IOPS than a baseline GP3 volume.\n"+
 			"\tExample: ./AutoSpotting --ebs_gp2_conversion_threshold 170\n")
 
-	flagSet.BoolVar(&conf.DisableEventBasedInstanceReplacement, "disable_event_based_instance_replacement", false,
-		"\n\tDisables the event based instance replacement, forcing the legacy cron mode.\n"+
-			"\tExample: ./AutoSpotting --disable_event_based_instance_replacement=true\n")
-
-	flagSet.BoolVar(&conf.DisableInstanceRebalanceRecommendation, "disable_instance_rebalance_recommendation", false,
-		"\n\tDisables handling of instance rebalance recommendation events.\n"+
-			"\tExample: ./AutoSpotting --disable_instance_rebalance_recommendation=true\n")
-
 	flagSet.StringVar(&conf.SpotAllocationStrategy, "spot_allocation_strategy", "capacity-optimized-prioritized",
 		"\n\tControls the Spot allocation strategy for launching Spot instances. Allowed options: \n"+
 			"\t'capacity-optimized-prioritized' (default), 'capacity-optimized', 'lowest-price'.\n"+
@@ -267,22 +529,95 @@ func ParseConfig(conf *Config) {
 			"https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/ec2-fleet-allocation-strategy.html\n"+
 			"\tExample: ./AutoSpotting --spot_allocation_strategy capacity-optimized-prioritized\n")
 
-	printVersion := flagSet.Bool("version", false, "Print version number and exit.\n")
-
-	if err := flagSet.Parse(os.Args[1:]); err != nil {
-		fmt.Printf("Error parsing config: %s\n", err.Error())
-	}
-
-	if *printVersion {
-		fmt.Println("AutoSpotting build:", conf.Version)
-		os.Exit(0)
-	}
-
-	data, err := ec2instancesinfo.Data()
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-	conf.InstanceData = data
-
-	conf.FinalRecap = make(map[string][]string)
+	flagSet.Float64Var(&conf.SpotPriceMaxVolatility, "spot_price_max_volatility", 0,
+		"\n\tMaximum volatility of the spot pools used for launching spot instances, computed as the standard\n"+
+			"\tdeviation of the price divided by its mean over the spot_price_history_window. Disabled when 0.\n"+
+			"\tExample: ./AutoSpotting --spot_price_history_window 168h --spot_price_max_volatility 0.2\n")
+
+	flagSet.Float64Var(&conf.SpotPriceMaxChangesPerDay, "spot_price_max_changes_per_day", 0,
+		"\n\tMaximum number of daily price changes of the spot pools used for launching spot instances,\n"+
+			"\taveraged over the spot_price_history_window. Disabled when 0.\n"+
+			"\tExample: ./AutoSpotting --spot_price_history_window 168h --spot_price_max_changes_per_day 5\n")
+
+	flagSet.Float64Var(&conf.SpotPriceVolatilityWeight, "spot_price_volatility_weight", 0,
+		"\n\tDeprioritizes the volatile spot pools by sorting the compatible instance types by their spot price\n"+
+			"\tmultiplied by (1 + weight * volatility). Disabled when 0.\n"+
+			"\tExample: ./AutoSpotting --spot_price_history_window 168h --spot_price_volatility_weight 2\n")
+
+	flagSet.StringVar(&conf.VCPURange, "vcpu_range", "",
+		"\n\tRange of vCPUs of the spot instance types considered for replacing the on-demand instances,\n"+
+			"\tsuch as '4-16', '4-' or '-16'. By default at least the vCPUs of the replaced instance are required.\n"+
+			"\tExample: ./AutoSpotting --vcpu_range 4-16\n")
+
+	flagSet.StringVar(&conf.MemoryGiBRange, "memory_gib_range", "",
+		"\n\tRange of memory, in GiB, of the spot instance types considered for replacing the on-demand instances.\n"+
+			"\tBy default at least the memory of the replaced instance is required.\n"+
+			"\tExample: ./AutoSpotting --memory_gib_range 8-64\n")
+
+	flagSet.StringVar(&conf.MemoryPerVCPURange, "memory_per_vcpu_range", "",
+		"\n\tRange of memory GiB per vCPU of the spot instance types considered for replacing the on-demand instances.\n"+
+			"\tExample: ./AutoSpotting --memory_per_vcpu_range 2-4 # general purpose instance types\n")
+
+	flagSet.BoolVar(&conf.AllowSmaller, "allow_smaller", false,
+		"\n\tAllows spot instance types with less vCPUs or memory than the replaced instance when no range\n"+
+			"\tis configured for them using vcpu_range or memory_gib_range.\n")
+
+	flagSet.BoolVar(&conf.CheckNetworkPerformance, "check_network_performance", true,
+		"\n\tRequires the spot instance types to have at least the network bandwidth of the replaced instance.\n")
+
+	flagSet.BoolVar(&conf.CheckENASupport, "check_ena_support", true,
+		"\n\tRequires the spot instance types to support ENA when the replaced instance type supports it.\n")
+
+	flagSet.BoolVar(&conf.CheckEFASupport, "check_efa_support", true,
+		"\n\tRequires the spot instance types to support EFA when the replaced instance type supports it.\n")
+
+	flagSet.BoolVar(&conf.CheckNetworkInterfaces, "check_network_interfaces", true,
+		"\n\tRequires the spot instance types to support at least the number of network interfaces and\n"+
+			"\tIPv4/IPv6 addresses per interface of the replaced instance type, as needed by the VPC CNI.\n")
+
+	flagSet.BoolVar(&conf.CrossArchitecture, "cross_architecture", false,
+		"\n\tAllows replacing the on-demand instances with spot instance types of the other CPU architecture,\n"+
+			"\tsuch as Graviton instances replacing Intel or AMD ones, launched using the AMI set for that\n"+
+			"\tarchitecture with arm64_ami or x86_64_ami, or otherwise found by replacing the architecture\n"+
+			"\tin the name of the current AMI, such as x86_64 with arm64.\n")
+
+	flagSet.StringVar(&conf.ARM64AMI, "arm64_ami", "",
+		"\n\tAMI ID, or path of an SSM parameter storing it, used when cross_architecture launches arm64 instances.\n"+
+			"\tExample: ./AutoSpotting --arm64_ami /myapp/ami/arm64\n")
+
+	flagSet.StringVar(&conf.X86AMI, "x86_64_ami", "",
+		"\n\tAMI ID, or path of an SSM parameter storing it, used when cross_architecture launches x86_64 instances.\n")
+
+	flagSet.StringVar(&conf.GPUModels, "gpu_models", "",
+		"\n\tGPU models accepted when replacing GPU instances, which otherwise can be replaced by any GPU model\n"+
+			"\tof the same manufacturer with at least the same GPU memory. Accepts a list of comma or whitespace\n"+
+			"\tseparated GPU models (supports globs), 'current' standing for the GPU model of the replaced instance.\n"+
+			"\tExample: ./AutoSpotting --gpu_models 'current,A10G'\n")
+
+	flagSet.BoolVar(&conf.KeepWarmPoolOnDemand, "keep_warm_pool_on_demand", false,
+		"\n\tKeeps on-demand the pre-initialized instances moved into service from the warm pool of the groups,\n"+
+			"\tonly replacing the instances launched directly. The warmed instances waiting in the pool are never\n"+
+			"\treplaced, and the other instances of groups with warm pools are only replaced once InService.\n")
+
+	flagSet.BoolVar(&conf.DrainDeregisterTargets, "drain_deregister_targets", false,
+		"\n\tDeregisters the spot instances receiving interruption warnings from the target groups of their\n"+
+			"\tAutoScaling group, waiting for the connections to be drained before taking them out of the group.\n")
+
+	flagSet.Int64Var(&conf.DrainDeregisterTimeout, "drain_deregister_timeout", 60,
+		"\n\tTimeout in seconds of the target deregistration, 0 only being bounded by the interruption notice.\n")
+
+	flagSet.StringVar(&conf.DrainSSMDocument, "drain_ssm_document", "",
+		"\n\tSSM Run Command document run on the spot instances receiving interruption warnings, such as a\n"+
+			"\tgraceful shutdown script, waiting for its completion before taking them out of the group.\n"+
+			"\tExample: ./AutoSpotting --drain_ssm_document 'my-graceful-shutdown'\n")
+
+	flagSet.Int64Var(&conf.DrainSSMTimeout, "drain_ssm_timeout", 60,
+		"\n\tTimeout in seconds of the SSM command, 0 only being bounded by the interruption notice.\n")
+
+	flagSet.StringVar(&conf.DrainLambda, "drain_lambda", "",
+		"\n\tName or ARN of a Lambda function invoked synchronously for the spot instances receiving\n"+
+			"\tinterruption warnings, with their instance ID, AutoScaling group and region.\n")
+
+	flagSet.Int64Var(&conf.DrainLambdaTimeout, "drain_lambda_timeout", 30,
+		"\n\tTimeout in seconds of the drain Lambda function, 0 only being bounded by the interruption notice.\n")
 }
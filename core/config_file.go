@@ -0,0 +1,271 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/namsral/flag"
+	"gopkg.in/yaml.v2"
+)
+
+// Sections of the configuration file overriding the group-specific
+// configurations for the groups of a given region or with a given name.
+const (
+	regionOverridesKey = "region_overrides"
+	groupOverridesKey  = "autoscaling_group_overrides"
+)
+
+// configFlagName is the flag pointing to the configuration file, which can't
+// be set from the file itself.
+const configFlagName = "config_file"
+
+// configOverrides stores the validated values of an override section of the
+// configuration file, keyed by flag name.
+type configOverrides map[string]string
+
+// loadConfigFile applies the values of a YAML or JSON configuration file to
+// the flags not already set from the environment or the command line. The
+// keys are the flag names, and the group-specific ones can also be overridden
+// in the per-region and per-group sections. Any unknown key or invalid value
+// is reported with its full path.
+func (conf *Config) loadConfigFile(flagSet *flag.FlagSet, fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("couldn't read the configuration file: %s", err.Error())
+	}
+
+	values, err := parseConfigFile(fileName, data)
+	if err != nil {
+		return fmt.Errorf("couldn't parse the configuration file %s: %s", fileName, err.Error())
+	}
+
+	// the environment and the command line take precedence over the file
+	alreadySet := map[string]bool{}
+	flagSet.Visit(func(f *flag.Flag) { alreadySet[f.Name] = true })
+
+	for _, key := range sortedKeys(values) {
+		switch key {
+		case regionOverridesKey:
+			if conf.regionOverrides, err = parseOverrideSections(key, values[key]); err != nil {
+				return fmt.Errorf("%s: %s", fileName, err.Error())
+			}
+			continue
+		case groupOverridesKey:
+			if conf.groupOverrides, err = parseOverrideSections(key, values[key]); err != nil {
+				return fmt.Errorf("%s: %s", fileName, err.Error())
+			}
+			continue
+		}
+
+		if key == configFlagName || key == "version" || flagSet.Lookup(key) == nil {
+			return fmt.Errorf("%s: unknown key %q", fileName, key)
+		}
+
+		value, err := configValue(key, values[key])
+		if err != nil {
+			return fmt.Errorf("%s: %s", fileName, err.Error())
+		}
+
+		if err := validateConfigValue(flagSet.Lookup(key), value); err != nil {
+			return fmt.Errorf("%s: invalid value %q for key %q: %s", fileName, value, key, err.Error())
+		}
+
+		if alreadySet[key] {
+			debug.Printf("Ignoring %s from the configuration file, already set to %s",
+				key, flagSet.Lookup(key).Value.String())
+			continue
+		}
+
+		if err := flagSet.Set(key, value); err != nil {
+			return fmt.Errorf("%s: invalid value %q for key %q: %s", fileName, value, key, err.Error())
+		}
+	}
+	return nil
+}
+
+// parseConfigFile decodes a JSON file or otherwise a YAML one.
+func parseConfigFile(fileName string, data []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+
+	if strings.EqualFold(filepath.Ext(fileName), ".json") {
+		err := json.Unmarshal(data, &values)
+		return values, err
+	}
+
+	var raw map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	for k, v := range raw {
+		values[fmt.Sprint(k)] = v
+	}
+	return values, nil
+}
+
+// parseOverrideSections validates the named override sections found under the
+// given key.
+func parseOverrideSections(key string, section interface{}) (map[string]configOverrides, error) {
+	sections, ok := stringMap(section)
+	if !ok {
+		return nil, fmt.Errorf("key %q must be a map of names to overrides", key)
+	}
+
+	result := map[string]configOverrides{}
+	for _, name := range sortedKeys(sections) {
+		values, ok := stringMap(sections[name])
+		if !ok {
+			return nil, fmt.Errorf("key %q must be a map of overrides", key+"."+name)
+		}
+
+		overrides := configOverrides{}
+		for _, k := range sortedKeys(values) {
+			path := key + "." + name + "." + k
+			value, err := configValue(path, values[k])
+			if err != nil {
+				return nil, err
+			}
+			overrides[k] = value
+		}
+
+		// validate the overrides upfront, so they can't fail later
+		if _, err := overrides.apply(AutoScalingConfig{}, key+"."+name+"."); err != nil {
+			return nil, err
+		}
+		result[name] = overrides
+	}
+	return result, nil
+}
+
+// apply returns a copy of the given configuration with the overrides applied,
+// the path being used for naming the invalid keys.
+func (o configOverrides) apply(base AutoScalingConfig, path string) (AutoScalingConfig, error) {
+	result := base
+
+	// registering the flags resets the configuration to the default values
+	flagSet := flag.NewFlagSet("overrides", flag.ContinueOnError)
+	registerAutoScalingFlags(flagSet, &result)
+	result = base
+
+	for _, key := range sortedKeys(o) {
+		f := flagSet.Lookup(key)
+		if f == nil {
+			return base, fmt.Errorf("unknown key %q, only the group-specific configurations can be overridden", path+key)
+		}
+		if err := validateConfigValue(f, o[key]); err != nil {
+			return base, fmt.Errorf("invalid value %q for key %q: %s", o[key], path+key, err.Error())
+		}
+		if err := flagSet.Set(key, o[key]); err != nil {
+			return base, fmt.Errorf("invalid value %q for key %q: %s", o[key], path+key, err.Error())
+		}
+	}
+	return result, nil
+}
+
+// groupConfig returns the configuration of the group with the given name in
+// the given region: the global configuration overridden by the region section
+// of the configuration file, then by the group section.
+func (conf *Config) groupConfig(region, group string) AutoScalingConfig {
+	return conf.overrideConfig(conf.AutoScalingConfig, region, group)
+}
+
+// overrideConfig returns the given configuration overridden by the region
+// section of the configuration file, then by the group section.
+func (conf *Config) overrideConfig(base AutoScalingConfig, region, group string) AutoScalingConfig {
+	result := base
+
+	for _, o := range []struct {
+		overrides configOverrides
+		path      string
+	}{
+		{conf.regionOverrides[region], regionOverridesKey + "." + region + "."},
+		{conf.groupOverrides[group], groupOverridesKey + "." + group + "."},
+	} {
+		if len(o.overrides) == 0 {
+			continue
+		}
+		var err error
+		if result, err = o.overrides.apply(result, o.path); err != nil {
+			// can't happen since the overrides were validated when loaded
+			log.Println("Ignoring invalid configuration overrides:", err.Error())
+		}
+	}
+	return result
+}
+
+// validateConfigValue checks the value of a group-specific setting using the
+// same validator as its tag. Empty values are allowed for the settings which
+// are disabled by default, such as the instance type attribute ranges.
+func validateConfigValue(f *flag.Flag, value string) error {
+	s, found := findAutoScalingSetting(f.Name)
+	if !found || (value == "" && f.DefValue == "") {
+		return nil
+	}
+	return s.validate(value)
+}
+
+// configValue converts a scalar value, or a list joined by commas, to the
+// string representation expected by the flags.
+func configValue(key string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string, bool, int, int64, float64:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configValue(key, item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("key %q must be a scalar value or a list", key)
+	}
+}
+
+// stringMap converts the maps decoded from either YAML or JSON.
+func stringMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[fmt.Sprint(k)] = item
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch v := m.(type) {
+	case map[string]interface{}:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]configOverrides:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case configOverrides:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/namsral/flag"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	fileName := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatalf("couldn't write the configuration file: %v", err)
+	}
+	return fileName
+}
+
+func TestConfig_loadConfigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		content  string
+		args     []string
+		env      map[string]string
+		want     AutoScalingConfig
+		wantErr  string
+	}{
+		{
+			name:     "yaml values",
+			fileName: "autospotting.yaml",
+			content: `
+bidding_policy: aggressive
+min_on_demand_number: 2
+allowed_instance_types:
+  - m5.large
+  - c5.*
+`,
+			want: AutoScalingConfig{
+				BiddingPolicy:        "aggressive",
+				MinOnDemandNumber:    2,
+				AllowedInstanceTypes: "m5.large,c5.*",
+			},
+		},
+		{
+			name:     "json values",
+			fileName: "autospotting.json",
+			content:  `{"bidding_policy": "aggressive", "min_on_demand_number": 2}`,
+			want: AutoScalingConfig{
+				BiddingPolicy:     "aggressive",
+				MinOnDemandNumber: 2,
+			},
+		},
+		{
+			name:     "flags and environment take precedence",
+			fileName: "autospotting.yaml",
+			content: `
+bidding_policy: aggressive
+min_on_demand_number: 2
+spot_price_buffer_percentage: 5
+`,
+			args: []string{"-bidding_policy", "normal"},
+			env:  map[string]string{"MIN_ON_DEMAND_NUMBER": "4"},
+			want: AutoScalingConfig{
+				BiddingPolicy:             "normal",
+				MinOnDemandNumber:         4,
+				SpotPriceBufferPercentage: 5,
+			},
+		},
+		{
+			name:     "unknown key",
+			fileName: "autospotting.yaml",
+			content:  "bidding_polcy: aggressive\n",
+			wantErr:  `unknown key "bidding_polcy"`,
+		},
+		{
+			name:     "invalid value",
+			fileName: "autospotting.yaml",
+			content:  "min_on_demand_number: two\n",
+			wantErr:  `invalid value "two" for key "min_on_demand_number"`,
+		},
+		{
+			name:     "unknown override key",
+			fileName: "autospotting.yaml",
+			content: `
+region_overrides:
+  eu-west-1:
+    regions: eu-west-1
+`,
+			wantErr: `unknown key "region_overrides.eu-west-1.regions"`,
+		},
+		{
+			name:     "invalid override value",
+			fileName: "autospotting.yaml",
+			content: `
+autoscaling_group_overrides:
+  web:
+    min_on_demand_percentage: half
+`,
+			wantErr: `invalid value "half" for key "autoscaling_group_overrides.web.min_on_demand_percentage"`,
+		},
+		{
+			name:     "value rejected by the setting's validator",
+			fileName: "autospotting.yaml",
+			content:  "bidding_policy: bogus\n",
+			wantErr:  `invalid value "bogus" for key "bidding_policy": expected one of normal, aggressive`,
+		},
+		{
+			name:     "percentage out of range",
+			fileName: "autospotting.json",
+			content:  `{"min_on_demand_percentage": 150}`,
+			wantErr:  `invalid value "150" for key "min_on_demand_percentage"`,
+		},
+		{
+			name:     "invalid range",
+			fileName: "autospotting.yaml",
+			content:  "vcpu_range: lots\n",
+			wantErr:  `invalid value "lots" for key "vcpu_range"`,
+		},
+		{
+			name:     "override value rejected by the setting's validator",
+			fileName: "autospotting.yaml",
+			content: `
+region_overrides:
+  eu-west-1:
+    spot_allocation_strategy: cheapest
+`,
+			wantErr: `invalid value "cheapest" for key "region_overrides.eu-west-1.spot_allocation_strategy"`,
+		},
+		{
+			name:     "override percentage out of range",
+			fileName: "autospotting.yaml",
+			content: `
+autoscaling_group_overrides:
+  web:
+    min_on_demand_percentage: -5
+`,
+			wantErr: `invalid value "-5" for key "autoscaling_group_overrides.web.min_on_demand_percentage"`,
+		},
+		{
+			name:     "empty value of a setting disabled by default",
+			fileName: "autospotting.yaml",
+			content:  "vcpu_range:\nbidding_policy: aggressive\n",
+			want:     AutoScalingConfig{BiddingPolicy: "aggressive"},
+		},
+		{
+			name:     "invalid yaml",
+			fileName: "autospotting.yaml",
+			content:  "bidding_policy: [aggressive\n",
+			wantErr:  "couldn't parse the configuration file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			conf := &Config{}
+			flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+			registerAutoScalingFlags(flagSet, &conf.AutoScalingConfig)
+			flagSet.StringVar(&conf.Regions, "regions", "", "")
+			if err := flagSet.Parse(tt.args); err != nil {
+				t.Fatalf("couldn't parse the flags: %v", err)
+			}
+
+			fileName := writeConfigFile(t, tt.fileName, tt.content)
+			err := conf.loadConfigFile(flagSet, fileName)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("loadConfigFile() error = %v, expected %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfigFile() unexpected error: %v", err)
+			}
+
+			if conf.BiddingPolicy != tt.want.BiddingPolicy ||
+				conf.MinOnDemandNumber != tt.want.MinOnDemandNumber ||
+				conf.AllowedInstanceTypes != tt.want.AllowedInstanceTypes ||
+				(tt.want.SpotPriceBufferPercentage != 0 &&
+					conf.SpotPriceBufferPercentage != tt.want.SpotPriceBufferPercentage) {
+				t.Errorf("loadConfigFile() got %+v, expected %+v", conf.AutoScalingConfig, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_groupConfig(t *testing.T) {
+	conf := &Config{
+		AutoScalingConfig: AutoScalingConfig{
+			BiddingPolicy:        "normal",
+			MinOnDemandNumber:    1,
+			AllowedInstanceTypes: "m5.large",
+		},
+		regionOverrides: map[string]configOverrides{
+			"eu-west-1": {"min_on_demand_number": "2", "bidding_policy": "aggressive"},
+		},
+		groupOverrides: map[string]configOverrides{
+			"web": {"min_on_demand_number": "3"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		region string
+		group  string
+		want   AutoScalingConfig
+	}{
+		{
+			name:   "no overrides",
+			region: "us-east-1",
+			group:  "api",
+			want:   conf.AutoScalingConfig,
+		},
+		{
+			name:   "region overrides",
+			region: "eu-west-1",
+			group:  "api",
+			want: AutoScalingConfig{
+				BiddingPolicy:        "aggressive",
+				MinOnDemandNumber:    2,
+				AllowedInstanceTypes: "m5.large",
+			},
+		},
+		{
+			name:   "group overrides applied over the region ones",
+			region: "eu-west-1",
+			group:  "web",
+			want: AutoScalingConfig{
+				BiddingPolicy:        "aggressive",
+				MinOnDemandNumber:    3,
+				AllowedInstanceTypes: "m5.large",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := conf.groupConfig(tt.region, tt.group)
+			if got.BiddingPolicy != tt.want.BiddingPolicy ||
+				got.MinOnDemandNumber != tt.want.MinOnDemandNumber ||
+				got.AllowedInstanceTypes != tt.want.AllowedInstanceTypes {
+				t.Errorf("groupConfig() got %+v, expected %+v", got, tt.want)
+			}
+		})
+	}
+
+	if conf.MinOnDemandNumber != 1 || conf.BiddingPolicy != "normal" {
+		t.Errorf("groupConfig() changed the global configuration: %+v", conf.AutoScalingConfig)
+	}
+}
+
+func Test_autoScalingGroup_defaults(t *testing.T) {
+	conf := &Config{
+		AutoScalingConfig: AutoScalingConfig{MinOnDemandNumber: 1},
+		groupOverrides: map[string]configOverrides{
+			"web": {"min_on_demand_number": "3"},
+		},
+	}
+	r := &region{name: "us-east-1", conf: conf}
+
+	api := &autoScalingGroup{name: "api", region: r}
+	if api.defaults() != &conf.AutoScalingConfig {
+		t.Errorf("defaults() expected the global configuration for a group without overrides")
+	}
+
+	web := &autoScalingGroup{name: "web", region: r}
+	if got := web.defaults().MinOnDemandNumber; got != 3 {
+		t.Errorf("defaults() got MinOnDemandNumber %d, expected 3", got)
+	}
+}
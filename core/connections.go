@@ -7,12 +7,19 @@
 package autospotting
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/lambda"
@@ -29,11 +36,136 @@ type connections struct {
 	lambda         lambdaiface.LambdaAPI
 	sqs            sqsiface.SQSAPI
 	region         string
+
+	// ARN of the role assumed for connecting to the services of another
+	// account, the ambient credentials are used when empty
+	roleARN string
+}
+
+// endpointServices are the AWS services whose endpoints can be overridden,
+// named after their endpoint IDs.
+var endpointServices = []string{
+	autoscaling.EndpointsID,
+	cloudformation.EndpointsID,
+	dynamodb.EndpointsID,
+	ec2.EndpointsID,
+	lambda.EndpointsID,
+	sqs.EndpointsID,
+	"ssm",
+}
+
+// endpointOverrides maps AWS service endpoint IDs to custom endpoint URLs, for
+// example for pointing AutoSpotting at a local AWS emulator. The services
+// missing from it use the default endpoints.
+type endpointOverrides map[string]string
+
+// parseEndpointOverrides builds the endpoint overrides from a URL used for all
+// the services and a comma or whitespace separated list of service=URL pairs
+// taking precedence over it.
+func parseEndpointOverrides(url, perService string) (endpointOverrides, error) {
+	overrides := make(endpointOverrides)
+
+	if url != "" {
+		for _, service := range endpointServices {
+			overrides[service] = url
+		}
+	}
+
+	for _, pair := range strings.Fields(strings.Replace(perService, ",", " ", -1)) {
+		service, serviceURL := splitServiceEndpoint(pair)
+		if serviceURL == "" || !itemInSlice(service, endpointServices) {
+			return nil, fmt.Errorf("invalid endpoint override %q, expected one of %s followed by =URL",
+				pair, strings.Join(endpointServices, ", "))
+		}
+		overrides[service] = serviceURL
+	}
+	return overrides, nil
+}
+
+func splitServiceEndpoint(pair string) (string, string) {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 {
+		return pair, ""
+	}
+	return parts[0], parts[1]
+}
+
+// EndpointFor implements the endpoints.Resolver interface, resolving the
+// overridden endpoints and falling back to the default ones.
+func (e endpointOverrides) EndpointFor(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+	if url, found := e[service]; found {
+		return endpoints.ResolvedEndpoint{
+			URL:           url,
+			SigningRegion: region,
+		}, nil
+	}
+	return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+}
+
+// sessions caches the AWS sessions of each region and assumed role, so that
+// long-running processes reuse them across runs.
+var sessions = struct {
+	sync.Mutex
+	byRegion  map[string]*session.Session
+	endpoints endpointOverrides
+}{byRegion: make(map[string]*session.Session)}
+
+// assumedRoleSessionName is the session name used when assuming roles in other
+// accounts, showing up in their CloudTrail logs.
+const assumedRoleSessionName = "AutoSpotting"
+
+// setEndpointOverrides makes all the sessions created from now on use the
+// given endpoints.
+func setEndpointOverrides(overrides endpointOverrides) {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	sessions.endpoints = overrides
+	sessions.byRegion = make(map[string]*session.Session)
+}
+
+// newSession returns the session used for connecting to the AWS services in
+// the given region, using the ambient credentials.
+func newSession(region string) *session.Session {
+	return newRoleSession(region, "")
+}
+
+// newRoleSession returns the session used for connecting to the AWS services
+// in the given region, using the credentials of the given role when set.
+func newRoleSession(region, roleARN string) *session.Session {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	return cachedSession(region, roleARN)
+}
+
+// cachedSession must be called with the sessions lock held.
+func cachedSession(region, roleARN string) *session.Session {
+	key := region + "/" + roleARN
+	if sess, found := sessions.byRegion[key]; found {
+		return sess
+	}
+
+	cfg := &aws.Config{Region: aws.String(region)}
+	cfg.Retryer = sessionRetryer{policy: defaultRetryPolicy}
+	if len(sessions.endpoints) > 0 {
+		cfg.EndpointResolver = sessions.endpoints
+	}
+
+	if roleARN != "" {
+		cfg.Credentials = stscreds.NewCredentials(cachedSession(region, ""), roleARN,
+			func(p *stscreds.AssumeRoleProvider) {
+				p.RoleSessionName = assumedRoleSessionName
+			})
+	}
+
+	sess := limitSession(instrumentSession(session.Must(session.NewSession(cfg))))
+	sessions.byRegion[key] = sess
+	return sess
 }
 
 func (c *connections) setSession(region string) {
-	c.session = session.Must(
-		session.NewSession(&aws.Config{Region: aws.String(region)}))
+	c.session = newRoleSession(region, c.roleARN)
 }
 
 func (c *connections) connect(region, mainRegion string) {
@@ -54,7 +186,8 @@ func (c *connections) connect(region, mainRegion string) {
 	go func() { ec2Conn <- ec2.New(c.session) }()
 	go func() { lambdaConn <- lambda.New(c.session) }()
 	go func() { cloudformationConn <- cloudformation.New(c.session) }()
-	go func() { sqsConn <- sqs.New(c.session, aws.NewConfig().WithRegion(mainRegion)) }()
+	// the SQS queue is always in the main account, regardless of the role
+	go func() { sqsConn <- sqs.New(newSession(mainRegion)) }()
 
 	c.autoScaling, c.ec2, c.cloudFormation, c.lambda, c.sqs, c.region = <-asConn, <-ec2Conn, <-cloudformationConn, <-lambdaConn, <-sqsConn, region
 
@@ -4,6 +4,7 @@
 package autospotting
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -31,3 +32,105 @@ func Test_connections_connect(t *testing.T) {
 		})
 	}
 }
+
+func Test_connections_setSession(t *testing.T) {
+	first, second := &connections{}, &connections{}
+	first.setSession("foo")
+	second.setSession("foo")
+
+	if first.session != second.session {
+		t.Errorf("connections.setSession() created a new session for the same region")
+	}
+
+	other := &connections{}
+	other.setSession("bar")
+	if other.session == first.session {
+		t.Errorf("connections.setSession() reused the session of another region")
+	}
+}
+
+func Test_parseEndpointOverrides(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		perService string
+		want       endpointOverrides
+		wantErr    bool
+	}{
+		{
+			name: "no overrides",
+			want: endpointOverrides{},
+		},
+		{
+			name: "all services",
+			url:  "http://localhost:4566",
+			want: endpointOverrides{
+				"autoscaling":    "http://localhost:4566",
+				"cloudformation": "http://localhost:4566",
+				"dynamodb":       "http://localhost:4566",
+				"ec2":            "http://localhost:4566",
+				"lambda":         "http://localhost:4566",
+				"sqs":            "http://localhost:4566",
+				"ssm":            "http://localhost:4566",
+			},
+		},
+		{
+			name:       "per-service overrides",
+			perService: "ec2=http://localhost:5000, sqs=http://localhost:9324",
+			want: endpointOverrides{
+				"ec2": "http://localhost:5000",
+				"sqs": "http://localhost:9324",
+			},
+		},
+		{
+			name:       "per-service overrides take precedence",
+			url:        "http://localhost:4566",
+			perService: "sqs=http://localhost:9324",
+			want: endpointOverrides{
+				"autoscaling":    "http://localhost:4566",
+				"cloudformation": "http://localhost:4566",
+				"dynamodb":       "http://localhost:4566",
+				"ec2":            "http://localhost:4566",
+				"lambda":         "http://localhost:4566",
+				"sqs":            "http://localhost:9324",
+				"ssm":            "http://localhost:4566",
+			},
+		},
+		{
+			name:       "unknown service",
+			perService: "s3=http://localhost:4566",
+			wantErr:    true,
+		},
+		{
+			name:       "missing URL",
+			perService: "ec2",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEndpointOverrides(tt.url, tt.perService)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseEndpointOverrides() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEndpointOverrides() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_endpointOverrides_EndpointFor(t *testing.T) {
+	overrides := endpointOverrides{"ec2": "http://localhost:5000"}
+
+	got, err := overrides.EndpointFor("ec2", "eu-west-1")
+	if err != nil || got.URL != "http://localhost:5000" || got.SigningRegion != "eu-west-1" {
+		t.Errorf("EndpointFor(ec2) = %v, %v", got, err)
+	}
+
+	got, err = overrides.EndpointFor("sqs", "eu-west-1")
+	if err != nil || got.URL != "https://sqs.eu-west-1.amazonaws.com" {
+		t.Errorf("EndpointFor(sqs) = %v, %v", got, err)
+	}
+}
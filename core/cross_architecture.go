@@ -0,0 +1,275 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// cross_architecture.go contains the functions replacing instances with spot
+// instance types of the other CPU architecture, launched from an AMI built for
+// that architecture.
+
+const (
+	x86Architecture   = ec2.ArchitectureValuesX8664
+	arm64Architecture = ec2.ArchitectureValuesArm64
+
+	ssmParameterImagePrefix = "resolve:ssm:"
+)
+
+// The tokens of the AMI names identifying their architecture, used when
+// looking up the AMI built for the other architecture by name.
+var architectureNameTokens = map[string][]string{
+	x86Architecture:   {"x86_64", "amd64"},
+	arm64Architecture: {"arm64", "aarch64"},
+}
+
+// cpuArchitecture returns the AMI architecture of the instance types using
+// the given physical processor, or an empty string when unknown.
+func cpuArchitecture(cpuName string) string {
+	switch {
+	case isARM(cpuName):
+		return arm64Architecture
+	case isIntelCompatible(cpuName):
+		return x86Architecture
+	}
+	return ""
+}
+
+// isArchCompatible accepts the candidates of the same architecture, and also
+// those of the other one when cross-architecture replacement is enabled.
+func (i *instance) isArchCompatible(candidate *instanceTypeInformation) bool {
+	if i.asg != nil && i.asg.config.CrossArchitecture &&
+		cpuArchitecture(i.typeInfo.PhysicalProcessor) != "" &&
+		cpuArchitecture(candidate.PhysicalProcessor) != "" {
+		return true
+	}
+	return i.isSameArch(candidate)
+}
+
+// architectureImage returns the configured AMI of the given architecture,
+// which may be an AMI ID or the path of an SSM parameter storing the AMI ID.
+func (c AutoScalingConfig) architectureImage(arch string) string {
+	if arch == arm64Architecture {
+		return c.ARM64AMI
+	}
+	return c.X86AMI
+}
+
+// getArchitectureImageID returns the image ID used for launching instances of
+// the given architecture. SSM parameters are resolved by EC2 when launching
+// the instances, and the AMIs without explicit configuration are looked up by
+// the name of the current AMI.
+func (i *instance) getArchitectureImageID(arch string) (string, error) {
+	image := strings.TrimSpace(i.asg.config.architectureImage(arch))
+
+	switch {
+	case strings.HasPrefix(image, ssmParameterImagePrefix):
+		return image, nil
+	case strings.HasPrefix(image, "/"):
+		return ssmParameterImagePrefix + image, nil
+	case image != "":
+		return image, nil
+	}
+
+	return i.lookupArchitectureImage(arch)
+}
+
+// lookupArchitectureImage finds the most recent AMI of the given architecture
+// owned by the owner of the current AMI, named like the current AMI with its
+// architecture tokens replaced by the ones of the other architecture.
+func (i *instance) lookupArchitectureImage(arch string) (string, error) {
+	svc := i.region.services.ec2
+
+	resp, err := svc.DescribeImages(&ec2.DescribeImagesInput{
+		ImageIds: []*string{i.ImageId},
+	})
+	if err != nil {
+		log.Println(i.region.name, "Couldn't describe image", aws.StringValue(i.ImageId), err.Error())
+		return "", err
+	}
+	if len(resp.Images) == 0 || resp.Images[0].Name == nil {
+		return "", fmt.Errorf("couldn't find the name of the image %s", aws.StringValue(i.ImageId))
+	}
+
+	current := resp.Images[0]
+	names := architectureImageNames(*current.Name, arch)
+	if len(names) == 0 {
+		return "", fmt.Errorf("the name of the image %s doesn't contain its architecture", *current.Name)
+	}
+
+	resp, err = svc.DescribeImages(&ec2.DescribeImagesInput{
+		Owners: []*string{current.OwnerId},
+		Filters: []*ec2.Filter{
+			{Name: aws.String("name"), Values: aws.StringSlice(names)},
+			{Name: aws.String("architecture"), Values: []*string{aws.String(arch)}},
+		},
+	})
+	if err != nil {
+		log.Println(i.region.name, "Couldn't look up the", arch, "images named", names, err.Error())
+		return "", err
+	}
+
+	var images []*ec2.Image
+	for _, image := range resp.Images {
+		if aws.StringValue(image.Architecture) == arch {
+			images = append(images, image)
+		}
+	}
+	if len(images) == 0 {
+		return "", fmt.Errorf("couldn't find any %s image named %s", arch, strings.Join(names, " or "))
+	}
+
+	sort.Slice(images, func(a, b int) bool {
+		return aws.StringValue(images[a].CreationDate) > aws.StringValue(images[b].CreationDate)
+	})
+
+	log.Println(i.region.name, "Found the", arch, "image", *images[0].ImageId,
+		"matching the name of the image", *current.Name)
+	return *images[0].ImageId, nil
+}
+
+// architectureImageNames returns the possible names of the image of the given
+// architecture, obtained by replacing the architecture tokens found in the
+// name of the current image.
+func architectureImageNames(name string, arch string) []string {
+	var names []string
+	for otherArch, tokens := range architectureNameTokens {
+		if otherArch == arch {
+			continue
+		}
+		for _, token := range tokens {
+			if !strings.Contains(name, token) {
+				continue
+			}
+			for _, replacement := range architectureNameTokens[arch] {
+				names = append(names, strings.ReplaceAll(name, token, replacement))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// createArchitectureLaunchTemplateData returns a copy of the launch template
+// data of the current architecture, launching the image of the given one.
+func (i *instance) createArchitectureLaunchTemplateData(ltData *ec2.RequestLaunchTemplateData, arch string) (*ec2.RequestLaunchTemplateData, error) {
+	imageID, err := i.getArchitectureImageID(arch)
+	if err != nil {
+		return nil, err
+	}
+
+	archLTData := *ltData
+	archLTData.ImageId = aws.String(imageID)
+
+	// the block devices of the images referenced by SSM parameters can't be
+	// converted, so their own block device mappings are used
+	archLTData.BlockDeviceMappings = nil
+	if !strings.HasPrefix(imageID, ssmParameterImagePrefix) {
+		if bdm, found := i.imageBlockDeviceMappings(archLTData.ImageId); found {
+			archLTData.BlockDeviceMappings = bdm
+		}
+	}
+
+	return &archLTData, nil
+}
+
+// otherArchitectureInstanceTypes returns the other architecture than the one
+// of the current instance and its instance types, which need their own launch
+// template.
+func (i *instance) otherArchitectureInstanceTypes(instanceTypes []*string) (string, map[string]bool) {
+	currentArch := cpuArchitecture(i.typeInfo.PhysicalProcessor)
+	otherArch := ""
+	types := make(map[string]bool)
+
+	typeInformation := i.region.instanceTypeInformation
+	for _, it := range instanceTypes {
+		arch := cpuArchitecture(typeInformation[*it].PhysicalProcessor)
+		if arch != "" && arch != currentArch {
+			otherArch = arch
+			types[*it] = true
+		}
+	}
+	return otherArch, types
+}
+
+// addArchitectureLaunchTemplate moves the overrides of the given instance
+// types to a separate launch template configuration of the fleet, keeping
+// their priorities.
+func addArchitectureLaunchTemplate(cfi *ec2.CreateFleetInput, ltName *string, instanceTypes map[string]bool) {
+	current := cfi.LaunchTemplateConfigs[0]
+
+	var kept, moved []*ec2.FleetLaunchTemplateOverridesRequest
+	for _, o := range current.Overrides {
+		if instanceTypes[aws.StringValue(o.InstanceType)] {
+			moved = append(moved, o)
+		} else {
+			kept = append(kept, o)
+		}
+	}
+
+	current.Overrides = kept
+	cfi.LaunchTemplateConfigs = append(cfi.LaunchTemplateConfigs,
+		&ec2.FleetLaunchTemplateConfigRequest{
+			LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
+				LaunchTemplateName: ltName,
+				Version:            aws.String("$Latest"),
+			},
+			Overrides: moved,
+		})
+
+	if len(kept) == 0 {
+		cfi.LaunchTemplateConfigs = cfi.LaunchTemplateConfigs[1:]
+	}
+}
+
+// createCrossArchitectureFleetInput creates the fleet input, adding a launch
+// template for the instance types of the other architecture when any were
+// selected. Those instance types are dropped when their image can't be found,
+// and a nil input is returned when no instance types are left.
+func (i *instance) createCrossArchitectureFleetInput(ltData *ec2.RequestLaunchTemplateData,
+	ltName *string, instanceTypes []*string) (*ec2.CreateFleetInput, *string) {
+
+	otherArch, otherTypes := i.otherArchitectureInstanceTypes(instanceTypes)
+	if len(otherTypes) == 0 {
+		return i.createFleetInput(ltName, instanceTypes), nil
+	}
+
+	archLTData, err := i.createArchitectureLaunchTemplateData(ltData, otherArch)
+	var otherLT *string
+	if err == nil {
+		otherLT, err = i.createNamedFleetLaunchTemplate(*ltName+"-"+otherArch, archLTData)
+	}
+
+	if err != nil {
+		log.Println(i.region.name, i.asg.name, "Couldn't create the", otherArch,
+			"launch template, skipping its instance types:", err.Error())
+		instanceTypes = removeInstanceTypes(instanceTypes, otherTypes)
+		if len(instanceTypes) == 0 {
+			return nil, nil
+		}
+		return i.createFleetInput(ltName, instanceTypes), nil
+	}
+
+	cfi := i.createFleetInput(ltName, instanceTypes)
+	addArchitectureLaunchTemplate(cfi, otherLT, otherTypes)
+	return cfi, otherLT
+}
+
+// removeInstanceTypes returns the instance types not found in the given set.
+func removeInstanceTypes(instanceTypes []*string, remove map[string]bool) []*string {
+	var result []*string
+	for _, it := range instanceTypes {
+		if !remove[*it] {
+			result = append(result, it)
+		}
+	}
+	return result
+}
@@ -0,0 +1,302 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	intelProcessor    = "Intel Xeon Platinum 8175"
+	gravitonProcessor = "AWS Graviton2 Processor"
+)
+
+func Test_instance_isArchCompatible(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   string
+		candidate string
+		config    AutoScalingConfig
+		want      bool
+	}{
+		{
+			name:      "same architecture",
+			current:   intelProcessor,
+			candidate: "AMD EPYC 7571",
+			want:      true,
+		},
+		{
+			name:      "other architecture",
+			current:   intelProcessor,
+			candidate: gravitonProcessor,
+			want:      false,
+		},
+		{
+			name:      "other architecture with cross-architecture enabled",
+			current:   intelProcessor,
+			candidate: gravitonProcessor,
+			config:    AutoScalingConfig{CrossArchitecture: true},
+			want:      true,
+		},
+		{
+			name:      "unknown architecture with cross-architecture enabled",
+			current:   gravitonProcessor,
+			candidate: "Unknown",
+			config:    AutoScalingConfig{CrossArchitecture: true},
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				typeInfo: instanceTypeInformation{PhysicalProcessor: tt.current},
+				asg:      &autoScalingGroup{config: tt.config},
+			}
+			if got := i.isArchCompatible(&instanceTypeInformation{PhysicalProcessor: tt.candidate}); got != tt.want {
+				t.Errorf("isArchCompatible() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_architectureImageNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		imageName string
+		arch      string
+		want      []string
+	}{
+		{
+			name:      "x86_64 to arm64",
+			imageName: "myapp-x86_64-2021-10-01",
+			arch:      arm64Architecture,
+			want:      []string{"myapp-aarch64-2021-10-01", "myapp-arm64-2021-10-01"},
+		},
+		{
+			name:      "arm64 to x86_64",
+			imageName: "myapp-arm64-v2",
+			arch:      x86Architecture,
+			want:      []string{"myapp-amd64-v2", "myapp-x86_64-v2"},
+		},
+		{
+			name:      "no architecture in the name",
+			imageName: "myapp-v2",
+			arch:      x86Architecture,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := architectureImageNames(tt.imageName, tt.arch); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("architectureImageNames() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_getArchitectureImageID(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  AutoScalingConfig
+		ec2     mockEC2
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "AMI ID",
+			config: AutoScalingConfig{ARM64AMI: "ami-arm64"},
+			want:   "ami-arm64",
+		},
+		{
+			name:   "SSM parameter path",
+			config: AutoScalingConfig{ARM64AMI: "/myapp/ami/arm64"},
+			want:   "resolve:ssm:/myapp/ami/arm64",
+		},
+		{
+			name:   "SSM parameter reference",
+			config: AutoScalingConfig{ARM64AMI: "resolve:ssm:/myapp/ami/arm64"},
+			want:   "resolve:ssm:/myapp/ami/arm64",
+		},
+		{
+			name: "looked up by name",
+			ec2: mockEC2{damio: &ec2.DescribeImagesOutput{
+				// the current image is described first, and the mock
+				// returns the same images for the name lookup
+				Images: []*ec2.Image{
+					{
+						ImageId:      aws.String("ami-x86"),
+						Name:         aws.String("myapp-x86_64"),
+						Architecture: aws.String(x86Architecture),
+						CreationDate: aws.String("2021-11-01T00:00:00.000Z"),
+					},
+					{
+						ImageId:      aws.String("ami-old"),
+						Name:         aws.String("myapp-arm64"),
+						Architecture: aws.String(arm64Architecture),
+						CreationDate: aws.String("2021-09-01T00:00:00.000Z"),
+					},
+					{
+						ImageId:      aws.String("ami-new"),
+						Name:         aws.String("myapp-arm64"),
+						Architecture: aws.String(arm64Architecture),
+						CreationDate: aws.String("2021-10-01T00:00:00.000Z"),
+					},
+				},
+			}},
+			want: "ami-new",
+		},
+		{
+			name: "lookup error",
+			ec2: mockEC2{
+				damio:   &ec2.DescribeImagesOutput{},
+				damierr: errors.New("access denied"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "no image found",
+			ec2: mockEC2{damio: &ec2.DescribeImagesOutput{
+				Images: []*ec2.Image{{
+					ImageId:      aws.String("ami-x86"),
+					Name:         aws.String("myapp-x86_64"),
+					Architecture: aws.String(x86Architecture),
+				}},
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{ImageId: aws.String("ami-x86")},
+				asg:      &autoScalingGroup{config: tt.config},
+				region: &region{
+					name:     "us-east-1",
+					services: connections{ec2: tt.ec2},
+				},
+			}
+
+			got, err := i.getArchitectureImageID(arm64Architecture)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getArchitectureImageID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("getArchitectureImageID() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_createCrossArchitectureFleetInput(t *testing.T) {
+	typeInformation := map[string]instanceTypeInformation{
+		"m5.large":  {instanceType: "m5.large", PhysicalProcessor: intelProcessor},
+		"m6g.large": {instanceType: "m6g.large", PhysicalProcessor: gravitonProcessor},
+		"c5.large":  {instanceType: "c5.large", PhysicalProcessor: intelProcessor},
+	}
+
+	overrides := func(types ...string) []*ec2.FleetLaunchTemplateOverridesRequest {
+		var result []*ec2.FleetLaunchTemplateOverridesRequest
+		for _, it := range types {
+			result = append(result, &ec2.FleetLaunchTemplateOverridesRequest{InstanceType: aws.String(it)})
+		}
+		return result
+	}
+
+	launchTemplates := func(cfi *ec2.CreateFleetInput) map[string][]*ec2.FleetLaunchTemplateOverridesRequest {
+		result := make(map[string][]*ec2.FleetLaunchTemplateOverridesRequest)
+		for _, c := range cfi.LaunchTemplateConfigs {
+			result[*c.LaunchTemplateSpecification.LaunchTemplateName] = c.Overrides
+		}
+		return result
+	}
+
+	tests := []struct {
+		name          string
+		instanceTypes []string
+		config        AutoScalingConfig
+		ec2           mockEC2
+		want          map[string][]*ec2.FleetLaunchTemplateOverridesRequest
+		wantOtherLT   bool
+	}{
+		{
+			name:          "same architecture",
+			instanceTypes: []string{"m5.large", "c5.large"},
+			want:          map[string][]*ec2.FleetLaunchTemplateOverridesRequest{"lt": overrides("m5.large", "c5.large")},
+		},
+		{
+			name:          "both architectures",
+			instanceTypes: []string{"m6g.large", "m5.large"},
+			config:        AutoScalingConfig{ARM64AMI: "ami-arm64"},
+			ec2:           mockEC2{damio: &ec2.DescribeImagesOutput{}},
+			want: map[string][]*ec2.FleetLaunchTemplateOverridesRequest{
+				"lt":       overrides("m5.large"),
+				"lt-arm64": overrides("m6g.large"),
+			},
+			wantOtherLT: true,
+		},
+		{
+			name:          "only the other architecture",
+			instanceTypes: []string{"m6g.large"},
+			config:        AutoScalingConfig{ARM64AMI: "/myapp/ami/arm64"},
+			want:          map[string][]*ec2.FleetLaunchTemplateOverridesRequest{"lt-arm64": overrides("m6g.large")},
+			wantOtherLT:   true,
+		},
+		{
+			name:          "image of the other architecture not found",
+			instanceTypes: []string{"m6g.large", "m5.large"},
+			ec2:           mockEC2{damierr: errors.New("access denied")},
+			want:          map[string][]*ec2.FleetLaunchTemplateOverridesRequest{"lt": overrides("m5.large")},
+		},
+		{
+			name:          "launch template of the other architecture not created",
+			instanceTypes: []string{"m6g.large"},
+			config:        AutoScalingConfig{ARM64AMI: "ami-arm64"},
+			ec2:           mockEC2{damio: &ec2.DescribeImagesOutput{}, clterr: errors.New("limit exceeded")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.SpotAllocationStrategy = "capacity-optimized"
+			i := &instance{
+				Instance: &ec2.Instance{ImageId: aws.String("ami-x86")},
+				typeInfo: typeInformation["m5.large"],
+				asg:      &autoScalingGroup{name: "asg", config: tt.config},
+				region: &region{
+					name:                    "us-east-1",
+					instanceTypeInformation: typeInformation,
+					services:                connections{ec2: tt.ec2},
+				},
+			}
+
+			cfi, otherLT := i.createCrossArchitectureFleetInput(
+				&ec2.RequestLaunchTemplateData{ImageId: aws.String("ami-x86")},
+				aws.String("lt"), aws.StringSlice(tt.instanceTypes))
+
+			if (otherLT != nil) != tt.wantOtherLT {
+				t.Errorf("createCrossArchitectureFleetInput() other launch template %v, expected %v",
+					aws.StringValue(otherLT), tt.wantOtherLT)
+			}
+
+			if tt.want == nil {
+				if cfi != nil {
+					t.Errorf("createCrossArchitectureFleetInput() = %v, expected nil", cfi)
+				}
+				return
+			}
+
+			if got := launchTemplates(cfi); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("createCrossArchitectureFleetInput() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// DefaultDaemonInterval is the default interval between the cron runs
+// performed when running as a daemon.
+const DefaultDaemonInterval = 5 * time.Minute
+
+// newDaemonScheduler creates a cron scheduler running the given job on the
+// given interval.
+func newDaemonScheduler(interval time.Duration, job cron.Job) (*cron.Cron, error) {
+	if interval < time.Second {
+		return nil, errors.New("the daemon interval must be at least one second")
+	}
+
+	c := cron.New()
+	c.Schedule(cron.Every(interval), job)
+	return c, nil
+}
+
+// RunDaemon keeps the process alive, calling the run function right away and
+// then on every DaemonInterval, until receiving SIGTERM or SIGINT. The
+// instance type data and the AWS sessions are reused across runs. On shutdown
+// it waits for the run in progress to complete.
+func (a *AutoSpotting) RunDaemon(run func() error) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(stop)
+
+	return a.runDaemon(run, stop)
+}
+
+func (a *AutoSpotting) runDaemon(run func() error, stop <-chan os.Signal) error {
+	// a run is skipped when the previous one is still in progress, so that the
+	// regions are never processed concurrently
+	job := cron.NewChain(cron.SkipIfStillRunning(cron.DefaultLogger)).Then(
+		cron.FuncJob(func() {
+			if err := run(); err != nil {
+				log.Println("Daemon run failed:", err.Error())
+			}
+		}))
+
+	c, err := newDaemonScheduler(a.config.DaemonInterval, job)
+	if err != nil {
+		log.Println("Couldn't schedule the daemon runs:", err.Error())
+		return err
+	}
+
+	log.Println("Running as a daemon, processing the regions every", a.config.DaemonInterval)
+
+	// the scheduler would only start the first run after a full interval
+	var firstRun sync.WaitGroup
+	firstRun.Add(1)
+	go func() {
+		job.Run()
+		firstRun.Done()
+	}()
+	c.Start()
+
+	sig := <-stop
+	log.Println("Received", sig, "signal, waiting for the run in progress to complete")
+	<-c.Stop().Done()
+	firstRun.Wait()
+	log.Println("Daemon stopped")
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunDaemon(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		wantErr  bool
+		minRuns  int32
+	}{
+		{
+			name:     "invalid interval",
+			interval: 0,
+			wantErr:  true,
+		},
+		{
+			name:     "runs right away until stopped",
+			interval: time.Hour,
+			minRuns:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &AutoSpotting{config: &Config{DaemonInterval: tt.interval}}
+
+			var runs int32
+			started := make(chan struct{}, 1)
+			run := func() error {
+				atomic.AddInt32(&runs, 1)
+				started <- struct{}{}
+				return errors.New("failed run")
+			}
+
+			stop := make(chan os.Signal, 1)
+			if !tt.wantErr {
+				go func() {
+					<-started
+					stop <- syscall.SIGTERM
+				}()
+			}
+
+			err := a.runDaemon(run, stop)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runDaemon() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&runs); got < tt.minRuns {
+				t.Errorf("runDaemon() ran %d times, want at least %d", got, tt.minRuns)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// drain.go contains the drain steps run on the spot instances which received
+// an interruption warning, before they are detached or terminated.
+
+const (
+	// spotInterruptionNotice is the time between the interruption warning and
+	// the interruption of the spot instance.
+	spotInterruptionNotice = 2 * time.Minute
+
+	// detachMargin is the part of the interruption notice kept for taking the
+	// instance out of its group once drained, which takes several API calls
+	// subject to retries and rate limiting.
+	detachMargin = 45 * time.Second
+
+	// drainPollInterval is the interval between the checks of the target
+	// deregistration and of the SSM command status.
+	drainPollInterval = 5 * time.Second
+)
+
+// Names of the drain steps, as shown in the logs and metrics
+const (
+	deregisterTargetsDrainStep = "deregister-targets"
+	ssmCommandDrainStep        = "ssm-command"
+	lambdaDrainStep            = "lambda"
+)
+
+// Outcomes of the drain steps
+const (
+	drainStepSucceeded = "success"
+	drainStepFailed    = "failure"
+	drainStepTimedOut  = "timeout"
+)
+
+// drainSettings are the settings of the drain steps, which can be overridden
+// on a per-group basis.
+var drainSettings = []string{
+	"drain_deregister_targets",
+	"drain_deregister_timeout",
+	"drain_ssm_document",
+	"drain_ssm_timeout",
+	"drain_lambda",
+	"drain_lambda_timeout",
+}
+
+type drainStep struct {
+	name    string
+	timeout time.Duration
+	run     func(ctx context.Context) error
+}
+
+// drainOutcome is the result of running a drain step.
+type drainOutcome struct {
+	Step     string        `json:"step"`
+	Result   string        `json:"result"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// drainTimeout converts the timeout configured in seconds, zero meaning that
+// the step is only bounded by the drain deadline.
+func drainTimeout(seconds int64) time.Duration {
+	if seconds <= 0 {
+		return spotInterruptionNotice
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// drainDeadline returns the time by which the drain steps must end, leaving
+// the detach margin before the interruption of the instance. The interruption
+// is expected two minutes after the warning, or after now when its time isn't
+// known.
+func drainDeadline(warningTime time.Time, now time.Time) time.Time {
+	if warningTime.IsZero() || warningTime.After(now) {
+		warningTime = now
+	}
+	return warningTime.Add(spotInterruptionNotice - detachMargin)
+}
+
+// drainError returns an error listing the drain steps which didn't succeed.
+func drainError(outcomes []drainOutcome) error {
+	var failed []string
+	for _, o := range outcomes {
+		if o.Result != drainStepSucceeded {
+			failed = append(failed, fmt.Sprintf("%s (%s)", o.Step, o.Result))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("drain steps didn't succeed: %s", strings.Join(failed, ", "))
+}
+
+// drainSteps returns the drain steps configured for the instance.
+func (s *SpotTermination) drainSteps(instanceID *string, asgName string, conf AutoScalingConfig) []drainStep {
+	var steps []drainStep
+
+	if conf.DrainDeregisterTargets {
+		steps = append(steps, drainStep{
+			name:    deregisterTargetsDrainStep,
+			timeout: drainTimeout(conf.DrainDeregisterTimeout),
+			run: func(ctx context.Context) error {
+				return s.deregisterTargets(ctx, instanceID, asgName)
+			},
+		})
+	}
+
+	if conf.DrainSSMDocument != "" {
+		steps = append(steps, drainStep{
+			name:    ssmCommandDrainStep,
+			timeout: drainTimeout(conf.DrainSSMTimeout),
+			run: func(ctx context.Context) error {
+				return s.runSSMDocument(ctx, instanceID, conf.DrainSSMDocument)
+			},
+		})
+	}
+
+	if conf.DrainLambda != "" {
+		steps = append(steps, drainStep{
+			name:    lambdaDrainStep,
+			timeout: drainTimeout(conf.DrainLambdaTimeout),
+			run: func(ctx context.Context) error {
+				return s.invokeDrainLambda(ctx, instanceID, asgName, conf.DrainLambda)
+			},
+		})
+	}
+
+	return steps
+}
+
+// drainInstance runs the drain steps configured for the instance and its
+// group until the drain deadline, returning their outcomes.
+func (s *SpotTermination) drainInstance(instanceID *string, asgName string) []drainOutcome {
+	var conf AutoScalingConfig
+	if s.config != nil {
+		conf = s.config.AutoScalingConfig
+	}
+	conf = s.groupConfig(asgName, s.fileConfig(asgName, conf), drainSettings...)
+
+	steps := s.drainSteps(instanceID, asgName, conf)
+	if len(steps) == 0 {
+		return nil
+	}
+
+	deadline := drainDeadline(s.warningTime, time.Now())
+	log.Println(asgName, "Draining instance", *instanceID, "until", deadline.Format(time.RFC3339))
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	outcomes := runDrainSteps(ctx, steps)
+	for _, o := range outcomes {
+		promMetrics.countDrainStep(s.region, o)
+	}
+
+	if report, err := json.Marshal(outcomes); err == nil {
+		log.Println(asgName, "Drained instance", *instanceID, string(report))
+	}
+	return outcomes
+}
+
+// runDrainSteps runs the steps one after the other, each of them within its
+// own timeout and the deadline of the given context.
+func runDrainSteps(ctx context.Context, steps []drainStep) []drainOutcome {
+	var outcomes []drainOutcome
+
+	for _, step := range steps {
+		start := time.Now()
+		stepCtx, cancel := context.WithTimeout(ctx, step.timeout)
+		err := step.run(stepCtx)
+
+		o := drainOutcome{
+			Step:     step.name,
+			Result:   drainStepSucceeded,
+			Duration: time.Since(start),
+		}
+
+		if err != nil {
+			o.Result = drainStepFailed
+			if errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+				o.Result = drainStepTimedOut
+			}
+			o.Error = err.Error()
+			log.Printf("Drain step %s ended with %s after %s: %s\n",
+				o.Step, o.Result, o.Duration, o.Error)
+		} else {
+			log.Printf("Drain step %s succeeded after %s\n", o.Step, o.Duration)
+		}
+
+		cancel()
+		outcomes = append(outcomes, o)
+	}
+	return outcomes
+}
+
+// deregisterTargets deregisters the instance from the target groups of its
+// group and waits until the deregistration completes, once the connections
+// are drained.
+func (s *SpotTermination) deregisterTargets(ctx context.Context, instanceID *string, asgName string) error {
+	resp, err := s.asSvc.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgName)},
+	})
+	if err != nil {
+		log.Println("Couldn't describe the group", asgName, err.Error())
+		return err
+	}
+	if len(resp.AutoScalingGroups) == 0 {
+		return fmt.Errorf("group %s not found", asgName)
+	}
+
+	targets := []*elbv2.TargetDescription{{Id: instanceID}}
+
+	for _, tg := range resp.AutoScalingGroups[0].TargetGroupARNs {
+		log.Println("Deregistering instance", *instanceID, "from the target group", *tg)
+		if _, err := s.elbSvc.DeregisterTargetsWithContext(ctx, &elbv2.DeregisterTargetsInput{
+			TargetGroupArn: tg,
+			Targets:        targets,
+		}); err != nil {
+			log.Println("Couldn't deregister instance", *instanceID, "from", *tg, err.Error())
+			return err
+		}
+	}
+
+	for _, tg := range resp.AutoScalingGroups[0].TargetGroupARNs {
+		if err := s.elbSvc.WaitUntilTargetDeregisteredWithContext(ctx,
+			&elbv2.DescribeTargetHealthInput{
+				TargetGroupArn: tg,
+				Targets:        targets,
+			},
+			request.WithWaiterDelay(request.ConstantWaiterDelay(drainPollInterval)),
+			request.WithWaiterMaxAttempts(int(spotInterruptionNotice/drainPollInterval)),
+		); err != nil {
+			log.Println("Instance", *instanceID, "wasn't deregistered from", *tg, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// runSSMDocument runs the SSM Run Command document on the instance, such as a
+// graceful shutdown script, and waits for its completion.
+func (s *SpotTermination) runSSMDocument(ctx context.Context, instanceID *string, document string) error {
+	resp, err := s.ssmSvc.SendCommandWithContext(ctx, &ssm.SendCommandInput{
+		DocumentName: aws.String(document),
+		InstanceIds:  []*string{instanceID},
+		Comment:      aws.String("Draining the spot instance before its interruption"),
+	})
+	if err != nil {
+		log.Println("Couldn't run the SSM document", document, "on", *instanceID, err.Error())
+		return err
+	}
+
+	return s.ssmSvc.WaitUntilCommandExecutedWithContext(ctx,
+		&ssm.GetCommandInvocationInput{
+			CommandId:  resp.Command.CommandId,
+			InstanceId: instanceID,
+		},
+		request.WithWaiterDelay(request.ConstantWaiterDelay(drainPollInterval)),
+		request.WithWaiterMaxAttempts(int(spotInterruptionNotice/drainPollInterval)),
+	)
+}
+
+// drainLambdaPayload is the event sent to the drain Lambda function.
+type drainLambdaPayload struct {
+	InstanceID       string `json:"instance_id"`
+	AutoScalingGroup string `json:"autoscaling_group"`
+	Region           string `json:"region"`
+}
+
+// invokeDrainLambda synchronously invokes the user's Lambda function with the
+// details of the instance being drained.
+func (s *SpotTermination) invokeDrainLambda(ctx context.Context, instanceID *string, asgName string, function string) error {
+	payload, err := json.Marshal(drainLambdaPayload{
+		InstanceID:       *instanceID,
+		AutoScalingGroup: asgName,
+		Region:           s.region,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.lambdaSvc.InvokeWithContext(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(function),
+		InvocationType: aws.String(lambda.InvocationTypeRequestResponse),
+		Payload:        payload,
+	})
+	if err != nil {
+		log.Println("Couldn't invoke the Lambda function", function, err.Error())
+		return err
+	}
+
+	if resp.FunctionError != nil {
+		return fmt.Errorf("the Lambda function %s failed with %s: %s",
+			function, *resp.FunctionError, string(resp.Payload))
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestDrainTimeout(t *testing.T) {
+	tests := []struct {
+		seconds int64
+		want    time.Duration
+	}{
+		{seconds: 0, want: spotInterruptionNotice},
+		{seconds: -1, want: spotInterruptionNotice},
+		{seconds: 30, want: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := drainTimeout(tt.seconds); got != tt.want {
+			t.Errorf("drainTimeout(%d) = %v, want %v", tt.seconds, got, tt.want)
+		}
+	}
+}
+
+func TestDrainSteps(t *testing.T) {
+	tests := []struct {
+		name string
+		conf AutoScalingConfig
+		want []string
+	}{
+		{
+			name: "no drain steps configured",
+			conf: AutoScalingConfig{},
+			want: nil,
+		},
+		{
+			name: "all drain steps configured",
+			conf: AutoScalingConfig{
+				DrainDeregisterTargets: true,
+				DrainSSMDocument:       "my-graceful-shutdown",
+				DrainLambda:            "drain",
+			},
+			want: []string{deregisterTargetsDrainStep, ssmCommandDrainStep, lambdaDrainStep},
+		},
+		{
+			name: "only the Lambda function configured",
+			conf: AutoScalingConfig{DrainLambda: "drain"},
+			want: []string{lambdaDrainStep},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SpotTermination{}
+			var got []string
+			for _, step := range s.drainSteps(aws.String("i-0123"), "asg", tt.conf) {
+				got = append(got, step.name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("drainSteps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunDrainSteps(t *testing.T) {
+	steps := []drainStep{
+		{
+			name:    "succeeding",
+			timeout: time.Second,
+			run:     func(ctx context.Context) error { return nil },
+		},
+		{
+			name:    "failing",
+			timeout: time.Second,
+			run:     func(ctx context.Context) error { return errors.New("failed") },
+		},
+		{
+			name:    "timing-out",
+			timeout: 10 * time.Millisecond,
+			run: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		},
+	}
+
+	want := map[string]string{
+		"succeeding": drainStepSucceeded,
+		"failing":    drainStepFailed,
+		"timing-out": drainStepTimedOut,
+	}
+
+	outcomes := runDrainSteps(context.Background(), steps)
+	if len(outcomes) != len(steps) {
+		t.Fatalf("runDrainSteps() returned %d outcomes, want %d", len(outcomes), len(steps))
+	}
+	for _, o := range outcomes {
+		if o.Result != want[o.Step] {
+			t.Errorf("step %s ended with %s, want %s", o.Step, o.Result, want[o.Step])
+		}
+	}
+}
+
+func TestDeregisterTargets(t *testing.T) {
+	groupWithTargetGroups := &autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: []*autoscaling.Group{{
+			TargetGroupARNs: []*string{aws.String("arn:tg-1"), aws.String("arn:tg-2")},
+		}},
+	}
+
+	tests := []struct {
+		name       string
+		asg        mockASG
+		elb        mockELBV2
+		timeout    time.Duration
+		wantResult string
+	}{
+		{
+			name:       "targets deregistered",
+			asg:        mockASG{dasgo: groupWithTargetGroups},
+			elb:        mockELBV2{},
+			timeout:    time.Second,
+			wantResult: drainStepSucceeded,
+		},
+		{
+			name:       "group not found",
+			asg:        mockASG{dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{}},
+			elb:        mockELBV2{},
+			timeout:    time.Second,
+			wantResult: drainStepFailed,
+		},
+		{
+			name:       "deregistration failure",
+			asg:        mockASG{dasgo: groupWithTargetGroups},
+			elb:        mockELBV2{dterr: errors.New("access denied")},
+			timeout:    time.Second,
+			wantResult: drainStepFailed,
+		},
+		{
+			name:       "connections still draining at the timeout",
+			asg:        mockASG{dasgo: groupWithTargetGroups},
+			elb:        mockELBV2{wutdBlock: true},
+			timeout:    10 * time.Millisecond,
+			wantResult: drainStepTimedOut,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SpotTermination{asSvc: tt.asg, elbSvc: tt.elb}
+			outcomes := runDrainSteps(context.Background(), []drainStep{{
+				name:    deregisterTargetsDrainStep,
+				timeout: tt.timeout,
+				run: func(ctx context.Context) error {
+					return s.deregisterTargets(ctx, aws.String("i-0123"), "asg")
+				},
+			}})
+			if outcomes[0].Result != tt.wantResult {
+				t.Errorf("deregisterTargets() ended with %s, want %s", outcomes[0].Result, tt.wantResult)
+			}
+		})
+	}
+}
+
+func TestRunSSMDocument(t *testing.T) {
+	sent := &ssm.SendCommandOutput{Command: &ssm.Command{CommandId: aws.String("cmd-1")}}
+
+	tests := []struct {
+		name    string
+		ssm     mockSSM
+		wantErr bool
+	}{
+		{name: "command succeeded", ssm: mockSSM{sco: sent}},
+		{name: "command not sent", ssm: mockSSM{scerr: errors.New("invalid document")}, wantErr: true},
+		{name: "command failed", ssm: mockSSM{sco: sent, wucerr: errors.New("failed")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SpotTermination{ssmSvc: tt.ssm}
+			err := s.runSSMDocument(context.Background(), aws.String("i-0123"), "my-graceful-shutdown")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runSSMDocument() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInvokeDrainLambda(t *testing.T) {
+	tests := []struct {
+		name    string
+		lambda  mockLambda
+		wantErr bool
+	}{
+		{name: "function succeeded", lambda: mockLambda{io: &lambda.InvokeOutput{}}},
+		{name: "function not invoked", lambda: mockLambda{ierr: errors.New("not found")}, wantErr: true},
+		{
+			name: "function failed",
+			lambda: mockLambda{io: &lambda.InvokeOutput{
+				FunctionError: aws.String("Unhandled"),
+				Payload:       []byte(`{"errorMessage":"boom"}`),
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SpotTermination{lambdaSvc: tt.lambda, region: "us-east-1"}
+			err := s.invokeDrainLambda(context.Background(), aws.String("i-0123"), "asg", "drain")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("invokeDrainLambda() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDrainInstance(t *testing.T) {
+	groupWithSSMDocumentTag := &autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: []*autoscaling.Group{{
+			Tags: []*autoscaling.TagDescription{{
+				Key:   aws.String("autospotting_drain_ssm_document"),
+				Value: aws.String("my-graceful-shutdown"),
+			}},
+		}},
+	}
+
+	s := &SpotTermination{
+		asSvc:     mockASG{dasgo: groupWithSSMDocumentTag},
+		ssmSvc:    mockSSM{sco: &ssm.SendCommandOutput{Command: &ssm.Command{CommandId: aws.String("cmd-1")}}},
+		lambdaSvc: mockLambda{ierr: errors.New("not found")},
+		region:    "us-east-1",
+		config: &Config{
+			AutoScalingConfig: AutoScalingConfig{
+				DrainLambda:        "drain",
+				DrainLambdaTimeout: 1,
+			},
+		},
+	}
+
+	got := s.drainInstance(aws.String("i-0123"), "asg")
+
+	want := map[string]string{
+		ssmCommandDrainStep: drainStepSucceeded,
+		lambdaDrainStep:     drainStepFailed,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("drainInstance() = %v, want the outcomes %v", got, want)
+	}
+	for _, o := range got {
+		if o.Result != want[o.Step] {
+			t.Errorf("step %s ended with %s, want %s", o.Step, o.Result, want[o.Step])
+		}
+	}
+}
+
+func TestDrainDeadline(t *testing.T) {
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	budget := spotInterruptionNotice - detachMargin
+
+	tests := []struct {
+		name        string
+		warningTime time.Time
+		want        time.Time
+	}{
+		{name: "warning received earlier", warningTime: now.Add(-30 * time.Second), want: now.Add(budget - 30*time.Second)},
+		{name: "warning time unknown", want: now.Add(budget)},
+		{name: "warning time ahead of the local clock", warningTime: now.Add(time.Minute), want: now.Add(budget)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := drainDeadline(tt.warningTime, now); !got.Equal(tt.want) {
+				t.Errorf("drainDeadline() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDrainError(t *testing.T) {
+	if err := drainError([]drainOutcome{{Step: lambdaDrainStep, Result: drainStepSucceeded}}); err != nil {
+		t.Errorf("drainError() = %v, want nil when all the steps succeeded", err)
+	}
+
+	err := drainError([]drainOutcome{
+		{Step: deregisterTargetsDrainStep, Result: drainStepTimedOut},
+		{Step: ssmCommandDrainStep, Result: drainStepSucceeded},
+		{Step: lambdaDrainStep, Result: drainStepFailed},
+	})
+	want := "drain steps didn't succeed: deregister-targets (timeout), lambda (failure)"
+	if err == nil || err.Error() != want {
+		t.Errorf("drainError() = %v, want %q", err, want)
+	}
+}
+
+func TestDrainInstance_configFileOverrides(t *testing.T) {
+	s := &SpotTermination{
+		asSvc:     mockASG{dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{}},
+		ssmSvc:    mockSSM{sco: &ssm.SendCommandOutput{Command: &ssm.Command{CommandId: aws.String("cmd-1")}}},
+		lambdaSvc: mockLambda{io: &lambda.InvokeOutput{}},
+		region:    "us-east-1",
+		config: &Config{
+			AutoScalingConfig: AutoScalingConfig{DrainLambda: "drain"},
+			groupOverrides: map[string]configOverrides{
+				"asg": {"drain_ssm_document": "my-graceful-shutdown"},
+			},
+		},
+	}
+
+	got := s.drainInstance(aws.String("i-0123"), "asg")
+
+	var steps []string
+	for _, o := range got {
+		steps = append(steps, o.Step)
+	}
+	if want := []string{ssmCommandDrainStep, lambdaDrainStep}; !reflect.DeepEqual(steps, want) {
+		t.Errorf("drainInstance() ran the steps %v, want %v", steps, want)
+	}
+
+	if got := s.drainInstance(aws.String("i-0123"), "other-asg"); len(got) != 1 || got[0].Step != lambdaDrainStep {
+		t.Errorf("drainInstance() of a group without overrides = %v, want only the %s step", got, lambdaDrainStep)
+	}
+}
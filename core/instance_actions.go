@@ -7,7 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
@@ -32,8 +34,32 @@ func (i *instance) handleInstanceStates() (bool, error) {
 	return false, nil
 }
 
-// returns an instance ID or error
-func (i *instance) launchSpotReplacement() (*string, error) {
+// returns an instance ID or error, the spot replacement being launched with
+// any compatible instance type except for the excluded ones
+func (i *instance) launchSpotReplacement(excludedPools ...capacityPool) (*string, error) {
+	claimed, err := i.region.claimReplacement(i)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		log.Println(i.region.name, "Skipping the replacement of", *i.InstanceId,
+			"already replaced or being replaced")
+		return nil, errReplacementInProgress
+	}
+
+	spotInstanceID, err := i.launchSpotInstance(excludedPools...)
+	if err != nil {
+		i.region.recordReplacement(*i.InstanceId, replacementFailed, "", err.Error())
+		return nil, err
+	}
+
+	i.region.recordReplacement(*i.InstanceId, replacementLaunched, *spotInstanceID, "")
+	return spotInstanceID, nil
+}
+
+// launchSpotInstance launches the cheapest compatible spot instance which can
+// replace the instance, outside the given capacity pools.
+func (i *instance) launchSpotInstance(excludedPools ...capacityPool) (*string, error) {
 
 	ltData, err := i.createLaunchTemplateData()
 
@@ -61,15 +87,44 @@ func (i *instance) launchSpotReplacement() (*string, error) {
 		return nil, err
 	}
 
-	cfi := i.createFleetInput(lt, instanceTypes)
+	cfi, otherLT := i.createCrossArchitectureFleetInput(ltData, lt, instanceTypes)
+	if otherLT != nil {
+		defer i.deleteLaunchTemplate(otherLT)
+	}
+	if cfi == nil {
+		return nil, fmt.Errorf("no instance types could be launched for %s", *i.InstanceId)
+	}
 
-	resp, err := i.region.services.ec2.CreateFleet(cfi)
+	if len(excludedPools) > 0 {
+		if err := i.excludeCapacityPools(cfi, excludedPools); err != nil {
+			return nil, err
+		}
+	}
 
+	// the client token makes the retried calls idempotent, so they can't
+	// launch more than one replacement
+	if cfi.ClientToken == nil {
+		cfi.ClientToken = aws.String(fmt.Sprintf("%s-%d", *i.InstanceId, time.Now().UnixNano()))
+	}
+
+	resp, err := i.region.services.ec2.CreateFleet(cfi)
 	if err != nil {
+		promMetrics.countCreateFleetError(i.region.name, err)
+		err = newAWSError("CreateFleet", err)
 		log.Println(i.region, i.asg.name, "CreateFleet() failure:", err.Error())
 		return nil, err
 	}
 
+	promMetrics.countCreateFleetResponseErrors(i.region.name, resp.Errors)
+
+	if len(resp.Instances) == 0 || len(resp.Instances[0].InstanceIds) == 0 {
+		log.Println(i.region, i.asg.name, "CreateFleet() didn't launch any instances")
+		if err := createFleetResponseError(resp.Errors); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("couldn't launch spot replacement for %s", *i.InstanceId)
+	}
+
 	return resp.Instances[0].InstanceIds[0], nil
 
 }
@@ -82,6 +137,16 @@ func (i *instance) swapWithGroupMember(asg *autoScalingGroup) (*instance, error)
 		return nil, err
 	}
 
+	claimed, err := i.region.claimSwap(odInstance, *i.InstanceId)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		log.Println(i.region.name, "Skipping the swap of", *odInstance.InstanceId,
+			"already replaced or being replaced")
+		return nil, errReplacementInProgress
+	}
+
 	asg.suspendProcesses()
 	defer asg.resumeProcesses()
 
@@ -103,17 +168,21 @@ func (i *instance) swapWithGroupMember(asg *autoScalingGroup) (*instance, error)
 		log.Printf("Spot instance %s couldn't be attached to the group %s, terminating it...",
 			*i.InstanceId, asg.name)
 		i.terminate()
+		i.region.recordReplacement(*odInstance.InstanceId, replacementFailed, *i.InstanceId, err.Error())
 		return nil, fmt.Errorf("couldn't attach spot instance %s ", *i.InstanceId)
 	}
+	i.region.recordReplacement(*odInstance.InstanceId, replacementAttached, *i.InstanceId, "")
 
 	log.Printf("Terminating on-demand instance %s from the group %s",
 		*odInstance.InstanceId, asg.name)
 	if err := asg.terminateInstanceInAutoScalingGroup(odInstance.Instance.InstanceId, true, true); err != nil {
 		log.Printf("On-demand instance %s couldn't be terminated, re-trying...",
 			*odInstance.InstanceId)
+		i.region.recordReplacement(*odInstance.InstanceId, replacementFailed, *i.InstanceId, err.Error())
 		return nil, fmt.Errorf("couldn't terminate on-demand instance %s",
 			*odInstance.InstanceId)
 	}
+	i.region.recordReplacement(*odInstance.InstanceId, replacementCompleted, *i.InstanceId, "")
 
 	return odInstance, nil
 }
@@ -178,3 +247,21 @@ func (i *instance) deleteLaunchTemplate(ltName *string) {
 		log.Printf("Issue while deleting launch template %v, error: %v", *ltName, err.Error())
 	}
 }
+
+// createFleetResponseError returns the classified error explaining why
+// CreateFleet didn't launch any instances, based on the first of the errors
+// reported for its launch template overrides.
+func createFleetResponseError(errs []*ec2.CreateFleetError) error {
+	for _, e := range errs {
+		if e == nil || e.ErrorCode == nil {
+			continue
+		}
+		return &awsError{
+			Op:    "CreateFleet",
+			Class: classifyErrorCode(*e.ErrorCode),
+			Code:  *e.ErrorCode,
+			Err:   fmt.Errorf("%s: %s", *e.ErrorCode, aws.StringValue(e.ErrorMessage)),
+		}
+	}
+	return nil
+}
@@ -131,136 +131,153 @@ func Test_instance_handleInstanceStates(t *testing.T) {
 }
 
 func Test_instance_launchSpotReplacement(t *testing.T) {
-	tests := []struct {
-		name     string
-		instance instance
-		want     *string
-		wantErr  bool
-	}{
-		{
-			name: "happy-path-no-errors",
-			instance: instance{
-				Instance: &ec2.Instance{
-					InstanceId:         aws.String("i-dummy"),
-					VirtualizationType: aws.String("paravirtual"),
-					Placement: &ec2.Placement{
-						AvailabilityZone: aws.String("eu-central-1"),
+	baseInstance := instance{
+		Instance: &ec2.Instance{
+			InstanceId:         aws.String("i-dummy"),
+			VirtualizationType: aws.String("paravirtual"),
+			Placement: &ec2.Placement{
+				AvailabilityZone: aws.String("eu-central-1"),
+			},
+		},
+
+		typeInfo: instanceTypeInformation{
+			instanceType:             "typeX",
+			PhysicalProcessor:        "Intel",
+			vCPU:                     10,
+			memory:                   2.5,
+			instanceStoreDeviceCount: 1,
+			instanceStoreDeviceSize:  50.0,
+			instanceStoreIsSSD:       false,
+			pricing: prices{
+				onDemand: 1.2,
+			},
+		},
+		price: 0.75,
+		asg: &autoScalingGroup{
+			Group: &autoscaling.Group{
+				DesiredCapacity: aws.Int64(4),
+			},
+			instances: makeInstancesWithCatalog(
+				instanceMap{
+					"id-1": {
+						Instance: &ec2.Instance{
+							InstanceId:        aws.String("id-1"),
+							InstanceType:      aws.String("typeX"),
+							Placement:         &ec2.Placement{AvailabilityZone: aws.String("eu-west-1")},
+							InstanceLifecycle: aws.String(Spot),
+						},
 					},
 				},
-
-				typeInfo: instanceTypeInformation{
-					instanceType:             "typeX",
-					PhysicalProcessor:        "Intel",
+			),
+			config: AutoScalingConfig{
+				OnDemandPriceMultiplier: 1.0,
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						AllowedInstanceTypes: "",
+					},
+				},
+			},
+		},
+		region: &region{
+			instanceTypeInformation: map[string]instanceTypeInformation{
+				"1": {
+					instanceType: "type1", // cheapest, cheaper than ondemand
+					pricing: prices{
+						spot: map[string]float64{
+							"eu-central-1": 0.5,
+							"eu-west-1":    1.0,
+							"eu-west-2":    2.0,
+						},
+					},
 					vCPU:                     10,
+					PhysicalProcessor:        "Intel",
 					memory:                   2.5,
 					instanceStoreDeviceCount: 1,
 					instanceStoreDeviceSize:  50.0,
 					instanceStoreIsSSD:       false,
-					pricing: prices{
-						onDemand: 1.2,
-					},
+					virtualizationTypes:      []string{"PV", "else"},
 				},
-				price: 0.75,
-				asg: &autoScalingGroup{
-					Group: &autoscaling.Group{
-						DesiredCapacity: aws.Int64(4),
-					},
-					instances: makeInstancesWithCatalog(
-						instanceMap{
-							"id-1": {
-								Instance: &ec2.Instance{
-									InstanceId:        aws.String("id-1"),
-									InstanceType:      aws.String("typeX"),
-									Placement:         &ec2.Placement{AvailabilityZone: aws.String("eu-west-1")},
-									InstanceLifecycle: aws.String(Spot),
-								},
-							},
-						},
-					),
-					config: AutoScalingConfig{
-						OnDemandPriceMultiplier: 1.0,
-					},
-					region: &region{
-						conf: &Config{
-							AutoScalingConfig: AutoScalingConfig{
-								AllowedInstanceTypes: "",
-							},
+				"2": {
+					instanceType: "type2", // less cheap, but cheaper than ondemand
+					pricing: prices{
+						spot: map[string]float64{
+							"eu-central-1": 0.7,
+							"eu-west-1":    1.0,
+							"eu-west-2":    2.0,
 						},
 					},
+					vCPU:                     10,
+					PhysicalProcessor:        "Intel",
+					memory:                   2.5,
+					instanceStoreDeviceCount: 1,
+					instanceStoreDeviceSize:  50.0,
+					instanceStoreIsSSD:       false,
+					virtualizationTypes:      []string{"PV", "else"},
 				},
-				region: &region{
-					instanceTypeInformation: map[string]instanceTypeInformation{
-						"1": {
-							instanceType: "type1", // cheapest, cheaper than ondemand
-							pricing: prices{
-								spot: map[string]float64{
-									"eu-central-1": 0.5,
-									"eu-west-1":    1.0,
-									"eu-west-2":    2.0,
-								},
-							},
-							vCPU:                     10,
-							PhysicalProcessor:        "Intel",
-							memory:                   2.5,
-							instanceStoreDeviceCount: 1,
-							instanceStoreDeviceSize:  50.0,
-							instanceStoreIsSSD:       false,
-							virtualizationTypes:      []string{"PV", "else"},
-						},
-						"2": {
-							instanceType: "type2", // less cheap, but cheaper than ondemand
-							pricing: prices{
-								spot: map[string]float64{
-									"eu-central-1": 0.7,
-									"eu-west-1":    1.0,
-									"eu-west-2":    2.0,
-								},
-							},
-							vCPU:                     10,
-							PhysicalProcessor:        "Intel",
-							memory:                   2.5,
-							instanceStoreDeviceCount: 1,
-							instanceStoreDeviceSize:  50.0,
-							instanceStoreIsSSD:       false,
-							virtualizationTypes:      []string{"PV", "else"},
-						},
-						"3": {
-							instanceType: "type3", // more expensive than ondemand
-							pricing: prices{
-								spot: map[string]float64{
-									"eu-central-1": 0.8,
-									"eu-west-1":    1.0,
-									"eu-west-2":    2.0,
-								},
-							},
-							vCPU:                     10,
-							PhysicalProcessor:        "Intel",
-							memory:                   2.5,
-							instanceStoreDeviceCount: 1,
-							instanceStoreDeviceSize:  50.0,
-							instanceStoreIsSSD:       false,
-							virtualizationTypes:      []string{"PV", "else"},
+				"3": {
+					instanceType: "type3", // more expensive than ondemand
+					pricing: prices{
+						spot: map[string]float64{
+							"eu-central-1": 0.8,
+							"eu-west-1":    1.0,
+							"eu-west-2":    2.0,
 						},
 					},
-					services: connections{
-						ec2: mockEC2{
-							cferr: nil,
-							cfo: &ec2.CreateFleetOutput{
-								Instances: []*ec2.CreateFleetInstance{
-									{
-										InstanceIds: []*string{
-											aws.String("i-dummy-spot-instance-id"),
-										},
-									},
+					vCPU:                     10,
+					PhysicalProcessor:        "Intel",
+					memory:                   2.5,
+					instanceStoreDeviceCount: 1,
+					instanceStoreDeviceSize:  50.0,
+					instanceStoreIsSSD:       false,
+					virtualizationTypes:      []string{"PV", "else"},
+				},
+			},
+			services: connections{
+				ec2: mockEC2{
+					cferr: nil,
+					cfo: &ec2.CreateFleetOutput{
+						Instances: []*ec2.CreateFleetInstance{
+							{
+								InstanceIds: []*string{
+									aws.String("i-dummy-spot-instance-id"),
 								},
 							},
-							damierr: nil,
-							damio:   &ec2.DescribeImagesOutput{},
 						},
 					},
+					damierr: nil,
+					damio:   &ec2.DescribeImagesOutput{},
 				},
 			},
-			want: aws.String("i-dummy-spot-instance-id"),
+		},
+	}
+
+	tests := []struct {
+		name          string
+		instance      instance
+		excludedPools []capacityPool
+		want          *string
+		wantErr       bool
+	}{
+		{
+			name:     "happy-path-no-errors",
+			instance: baseInstance,
+			want:     aws.String("i-dummy-spot-instance-id"),
+		},
+		{
+			name:          "excluding-the-current-capacity-pool",
+			instance:      baseInstance,
+			excludedPools: []capacityPool{{"type1", "eu-central-1"}},
+			want:          aws.String("i-dummy-spot-instance-id"),
+		},
+		{
+			name:     "excluding-all-the-compatible-capacity-pools",
+			instance: baseInstance,
+			excludedPools: []capacityPool{
+				{"type1", "eu-central-1"}, {"type2", "eu-central-1"}, {"type3", "eu-central-1"},
+			},
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
@@ -273,7 +290,7 @@ func Test_instance_launchSpotReplacement(t *testing.T) {
 				protected: tt.instance.protected,
 				asg:       tt.instance.asg,
 			}
-			got, err := i.launchSpotReplacement()
+			got, err := i.launchSpotReplacement(tt.excludedPools...)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("instance.launchSpotReplacement() error = %v, wantErr %v", err, tt.wantErr)
 				return
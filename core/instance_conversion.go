@@ -29,19 +29,23 @@ var unsupportedIO2Regions = [...]string{
 	"ap-northeast-3",
 }
 
+// getPriceToBid computes the bid price from the bidding policy and the spot
+// price buffer of the instance's group, never from the region-wide defaults,
+// since each group may override them using its tags.
 func (i *instance) getPriceToBid(
 	baseOnDemandPrice float64, currentSpotPrice float64, spotPremium float64) float64 {
 
-	debug.Println("BiddingPolicy: ", i.region.conf.BiddingPolicy)
+	cfg := i.asg.config
+	debug.Println("BiddingPolicy: ", cfg.BiddingPolicy)
 
-	if i.region.conf.BiddingPolicy == DefaultBiddingPolicy {
+	if cfg.BiddingPolicy == DefaultBiddingPolicy {
 		log.Println("Bidding base on demand price", baseOnDemandPrice, "to replace instance", *i.InstanceId)
 		return baseOnDemandPrice
 	}
 
-	bufferPrice := math.Min(baseOnDemandPrice, ((currentSpotPrice-spotPremium)*(1.0+i.region.conf.SpotPriceBufferPercentage/100.0))+spotPremium)
+	bufferPrice := math.Min(baseOnDemandPrice, ((currentSpotPrice-spotPremium)*(1.0+cfg.SpotPriceBufferPercentage/100.0))+spotPremium)
 	log.Println("Bidding buffer-based price of", bufferPrice, "based on current spot price of", currentSpotPrice,
-		"and buffer percentage of", i.region.conf.SpotPriceBufferPercentage, "to replace instance", i.InstanceId)
+		"and buffer percentage of", cfg.SpotPriceBufferPercentage, "to replace instance", i.InstanceId)
 	return bufferPrice
 }
 
@@ -237,16 +241,38 @@ func (i *instance) convertSecurityGroups() []*string {
 	return groupIDs
 }
 
-func (i *instance) getlaunchTemplate(id, ver *string) (*ec2.ResponseLaunchTemplateData, error) {
+// launchTemplateVersion returns the version of the launch template used by a
+// group, which falls back to the default version when not set.
+func launchTemplateVersion(lt *autoscaling.LaunchTemplateSpecification) *string {
+	if lt.Version == nil {
+		return aws.String("$Default")
+	}
+	return lt.Version
+}
+
+// describeLaunchTemplateVersionInput builds the request for describing the
+// version of the launch template, which can be referenced by either ID or name.
+func describeLaunchTemplateVersionInput(lt *autoscaling.LaunchTemplateSpecification) *ec2.DescribeLaunchTemplateVersionsInput {
+	input := &ec2.DescribeLaunchTemplateVersionsInput{
+		Versions: []*string{launchTemplateVersion(lt)},
+	}
+
+	if lt.LaunchTemplateId != nil {
+		input.LaunchTemplateId = lt.LaunchTemplateId
+	} else {
+		input.LaunchTemplateName = lt.LaunchTemplateName
+	}
+	return input
+}
+
+func (i *instance) getlaunchTemplate(lt *autoscaling.LaunchTemplateSpecification) (*ec2.ResponseLaunchTemplateData, error) {
 	res, err := i.region.services.ec2.DescribeLaunchTemplateVersions(
-		&ec2.DescribeLaunchTemplateVersionsInput{
-			Versions:         []*string{ver},
-			LaunchTemplateId: id,
-		},
-	)
+		describeLaunchTemplateVersionInput(lt))
 
 	if err != nil {
-		log.Println("Failed to describe launch template", *id, "version", *ver,
+		log.Println("Failed to describe launch template",
+			aws.StringValue(lt.LaunchTemplateId), aws.StringValue(lt.LaunchTemplateName),
+			"version", *launchTemplateVersion(lt),
 			"encountered error:", err.Error())
 		return nil, err
 	}
@@ -257,10 +283,7 @@ func (i *instance) getlaunchTemplate(id, ver *string) (*ec2.ResponseLaunchTempla
 }
 
 func (i *instance) processLaunchTemplate(retval *ec2.RequestLaunchTemplateData) error {
-	ver := i.asg.LaunchTemplate.Version
-	id := i.asg.LaunchTemplate.LaunchTemplateId
-
-	ltData, err := i.getlaunchTemplate(id, ver)
+	ltData, err := i.getlaunchTemplate(i.asg.getLaunchTemplateSpecification())
 	if err != nil {
 		return err
 	}
@@ -384,23 +407,31 @@ func (i *instance) processLaunchConfiguration(retval *ec2.RequestLaunchTemplateD
 }
 
 func (i *instance) processImageBlockDevices(rii *ec2.RequestLaunchTemplateData) {
+	if bdm, found := i.imageBlockDeviceMappings(i.ImageId); found {
+		rii.BlockDeviceMappings = bdm
+	}
+}
+
+// imageBlockDeviceMappings returns the converted block device mappings of the
+// given image, or false if the image couldn't be described.
+func (i *instance) imageBlockDeviceMappings(imageID *string) ([]*ec2.LaunchTemplateBlockDeviceMappingRequest, bool) {
 	svc := i.region.services.ec2
 
 	resp, err := svc.DescribeImages(
 		&ec2.DescribeImagesInput{
-			ImageIds: []*string{i.ImageId},
+			ImageIds: []*string{imageID},
 		})
 
 	if err != nil {
 		log.Println(err.Error())
-		return
+		return nil, false
 	}
 	if len(resp.Images) == 0 {
 		log.Println("missing image data")
-		return
+		return nil, false
 	}
 
-	rii.BlockDeviceMappings = i.convertImageBlockDeviceMappings(resp.Images[0].BlockDeviceMappings)
+	return i.convertImageBlockDeviceMappings(resp.Images[0].BlockDeviceMappings), true
 }
 
 func (i *instance) createLaunchTemplateData() (*ec2.RequestLaunchTemplateData, error) {
@@ -417,7 +448,7 @@ func (i *instance) createLaunchTemplateData() (*ec2.RequestLaunchTemplateData, e
 
 	ltData.SecurityGroupIds = i.convertSecurityGroups()
 
-	if i.asg.LaunchTemplate != nil {
+	if i.asg.getLaunchTemplateSpecification() != nil {
 		err := i.processLaunchTemplate(&ltData)
 		if err != nil {
 			log.Println("failed to process launch template, the resulting instance configuration may be incomplete", err.Error())
@@ -447,8 +478,11 @@ func (i *instance) createLaunchTemplateData() (*ec2.RequestLaunchTemplateData, e
 }
 
 func (i *instance) createFleetLaunchTemplate(ltData *ec2.RequestLaunchTemplateData) (*string, error) {
-	ltName := "AutoSpotting-Temporary-LaunchTemplate-for-" + *i.Instance.InstanceId
+	return i.createNamedFleetLaunchTemplate(
+		"AutoSpotting-Temporary-LaunchTemplate-for-"+*i.Instance.InstanceId, ltData)
+}
 
+func (i *instance) createNamedFleetLaunchTemplate(ltName string, ltData *ec2.RequestLaunchTemplateData) (*string, error) {
 	_, err := i.region.services.ec2.CreateLaunchTemplate(&ec2.CreateLaunchTemplateInput{
 		LaunchTemplateName: aws.String(ltName),
 		LaunchTemplateData: ltData,
@@ -523,14 +557,21 @@ func (i *instance) generateTagsList() []*ec2.LaunchTemplateTagSpecificationReque
 		},
 	}
 
-	if i.asg.LaunchTemplate != nil {
-		tags.Tags = append(tags.Tags, &ec2.Tag{
-			Key:   aws.String("LaunchTemplateID"),
-			Value: i.asg.LaunchTemplate.LaunchTemplateId,
-		})
+	if lt := i.asg.getLaunchTemplateSpecification(); lt != nil {
+		if lt.LaunchTemplateId != nil {
+			tags.Tags = append(tags.Tags, &ec2.Tag{
+				Key:   aws.String("LaunchTemplateID"),
+				Value: lt.LaunchTemplateId,
+			})
+		} else {
+			tags.Tags = append(tags.Tags, &ec2.Tag{
+				Key:   aws.String("LaunchTemplateName"),
+				Value: lt.LaunchTemplateName,
+			})
+		}
 		tags.Tags = append(tags.Tags, &ec2.Tag{
 			Key:   aws.String("LaunchTemplateVersion"),
-			Value: i.asg.LaunchTemplate.Version,
+			Value: launchTemplateVersion(lt),
 		})
 	} else if i.asg.LaunchConfigurationName != nil {
 		tags.Tags = append(tags.Tags, &ec2.Tag{
@@ -552,6 +593,7 @@ func filterTags(tags []*ec2.Tag) []*ec2.Tag {
 		"launched-for-asg",
 		"launched-for-replacing-instance",
 		"LaunchTemplateID",
+		"LaunchTemplateName",
 		"LaunchTemplateVersion",
 		"LaunchConfigurationName",
 	}
@@ -31,6 +31,8 @@ type instances interface {
 type acceptableInstance struct {
 	instanceTI instanceTypeInformation
 	price      float64
+	// the price adjusted for the volatility of the spot pool, used for sorting
+	score float64
 }
 
 type instanceTypeInformation struct {
@@ -47,6 +49,8 @@ type instanceTypeInformation struct {
 	instanceStoreIsSSD       bool
 	hasEBSOptimization       bool
 	EBSThroughput            float32
+	network                  networkInfo
+	accelerators             acceleratorInfo
 }
 
 func makeInstances() instances {
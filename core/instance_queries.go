@@ -97,6 +97,7 @@ func (i *instance) canTerminate() bool {
 func (i *instance) shouldBeReplacedWithSpot() bool {
 	protT, _ := i.isProtectedFromTermination()
 	return i.belongsToEnabledASG() &&
+		i.asg.canReplaceWarmPoolGroupInstance(i) &&
 		i.asgNeedsReplacement() &&
 		!i.isSpot() &&
 		!i.isProtectedFromScaleIn() &&
@@ -113,7 +114,7 @@ func (i *instance) belongsToEnabledASG() bool {
 
 	for _, asg := range i.region.enabledASGs {
 		if asg.name == *asgName {
-			asg.config = i.region.conf.AutoScalingConfig
+			asg.config = *asg.defaults()
 			asg.scanInstances()
 			asg.loadDefaultConfig()
 			asg.loadConfigFromTags()
@@ -166,6 +167,9 @@ func (i *instance) isPriceCompatible(spotPrice float64) bool {
 	return false
 }
 
+// isClassCompatible checks that the candidate has the same CPU architecture,
+// at least the GPUs of the current instance, and vCPUs and memory within the
+// ranges configured on the group, defaulting to at least the current ones.
 func (i *instance) isClassCompatible(spotCandidate *instanceTypeInformation) bool {
 	current := i.typeInfo
 
@@ -175,9 +179,15 @@ func (i *instance) isClassCompatible(spotCandidate *instanceTypeInformation) boo
 	debug.Println("\tInstance CPU/memory/GPU: ", current.vCPU,
 		" / ", current.memory, " / ", current.GPU)
 
-	if i.isSameArch(spotCandidate) &&
-		spotCandidate.vCPU >= current.vCPU &&
-		spotCandidate.memory >= current.memory &&
+	var ranges instanceAttributeRanges
+	if i.asg != nil {
+		ranges = i.asg.config.attributeRanges()
+	}
+
+	if i.isArchCompatible(spotCandidate) &&
+		isAttributeCompatible(float64(spotCandidate.vCPU), float64(current.vCPU), ranges.vCPU, ranges.allowSmaller) &&
+		isAttributeCompatible(float64(spotCandidate.memory), float64(current.memory), ranges.memory, ranges.allowSmaller) &&
+		isMemoryPerVCPUCompatible(spotCandidate, ranges.memoryPerVCPU) &&
 		spotCandidate.GPU >= current.GPU {
 		return true
 	}
@@ -185,6 +195,25 @@ func (i *instance) isClassCompatible(spotCandidate *instanceTypeInformation) boo
 	return false
 }
 
+// isAttributeCompatible checks the candidate's value of an attribute against
+// the configured range, or against the current value when no range is set.
+func isAttributeCompatible(candidate, current float64, r *attributeRange, allowSmaller bool) bool {
+	if r != nil {
+		return r.contains(candidate)
+	}
+	return allowSmaller || candidate >= current
+}
+
+func isMemoryPerVCPUCompatible(candidate *instanceTypeInformation, r *attributeRange) bool {
+	if r == nil {
+		return true
+	}
+	if candidate.vCPU == 0 {
+		return false
+	}
+	return r.contains(float64(candidate.memory) / float64(candidate.vCPU))
+}
+
 func (i *instance) isSameArch(other *instanceTypeInformation) bool {
 	thisCPU := i.typeInfo.PhysicalProcessor
 	otherCPU := other.PhysicalProcessor
@@ -225,6 +254,95 @@ func (i *instance) isEBSCompatible(spotCandidate *instanceTypeInformation) bool
 	return true
 }
 
+// isNetworkCompatible compares the networking capabilities of the candidate
+// against those of the current instance type, skipping the checks disabled on
+// the group and those for which the information isn't available.
+func (i *instance) isNetworkCompatible(spotCandidate *instanceTypeInformation) bool {
+	if i.asg == nil {
+		return true
+	}
+
+	cfg := i.asg.config
+	current, candidate := i.typeInfo.network, spotCandidate.network
+
+	if cfg.CheckNetworkPerformance && current.performance.known() &&
+		candidate.performance.known() && !candidate.performance.atLeast(current.performance) {
+		debug.Println("\tNetwork performance insufficient:", candidate.performance, "<", current.performance)
+		return false
+	}
+
+	if cfg.CheckENASupport && current.enaSupported && !candidate.enaSupported {
+		debug.Println("\tENA not supported")
+		return false
+	}
+
+	if !current.detailed || !candidate.detailed {
+		return true
+	}
+
+	if cfg.CheckEFASupport && current.efaSupported && !candidate.efaSupported {
+		debug.Println("\tEFA not supported")
+		return false
+	}
+
+	if cfg.CheckNetworkInterfaces &&
+		(candidate.maxENIs < current.maxENIs ||
+			candidate.ipv4PerENI < current.ipv4PerENI ||
+			candidate.ipv6PerENI < current.ipv6PerENI) {
+		debug.Println("\tNetwork interface limits insufficient, ENIs/IPv4/IPv6 per ENI:",
+			candidate.maxENIs, "/", candidate.ipv4PerENI, "/", candidate.ipv6PerENI, "<",
+			current.maxENIs, "/", current.ipv4PerENI, "/", current.ipv6PerENI)
+		return false
+	}
+
+	return true
+}
+
+// isAcceleratorCompatible requires the candidate to have GPUs of the same
+// manufacturer with at least the same total memory, optionally of the GPU
+// models pinned on the group, and the same inference accelerators and FPGAs as
+// the current instance type. The checks are skipped when the accelerators of
+// either instance type are unknown.
+func (i *instance) isAcceleratorCompatible(spotCandidate *instanceTypeInformation) bool {
+	current, candidate := i.typeInfo.accelerators, spotCandidate.accelerators
+	if !current.detailed || !candidate.detailed {
+		return true
+	}
+
+	if current.gpu.count > 0 {
+		if !strings.EqualFold(candidate.gpu.manufacturer, current.gpu.manufacturer) {
+			debug.Println("\tGPU manufacturer mismatch:", candidate.gpu.manufacturer, "!=", current.gpu.manufacturer)
+			return false
+		}
+
+		if candidate.gpu.totalMemoryMiB() < current.gpu.totalMemoryMiB() {
+			debug.Println("\tGPU memory insufficient:", candidate.gpu.totalMemoryMiB(), "<",
+				current.gpu.totalMemoryMiB(), "MiB")
+			return false
+		}
+
+		if i.asg != nil && i.asg.config.GPUModels != "" &&
+			!matchesGPUModels(candidate.gpu, current.gpu, i.asg.config.GPUModels) {
+			debug.Println("\tGPU model", candidate.gpu.model, "not in", i.asg.config.GPUModels)
+			return false
+		}
+	}
+
+	if current.inference.count > 0 && (!candidate.inference.sameModel(current.inference) ||
+		candidate.inference.count < current.inference.count) {
+		debug.Println("\tInference accelerators mismatch:", candidate.inference, "!=", current.inference)
+		return false
+	}
+
+	if current.fpga.count > 0 && (!candidate.fpga.sameModel(current.fpga) ||
+		candidate.fpga.count < current.fpga.count) {
+		debug.Println("\tFPGA mismatch:", candidate.fpga, "!=", current.fpga)
+		return false
+	}
+
+	return true
+}
+
 // Here we check the storage compatibility, with the following evaluation
 // criteria:
 // - speed: don't accept spinning disks when we used to have SSDs
@@ -313,9 +431,14 @@ func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allo
 	usedMappings := max(lcMappings, ltMappings)
 	attachedVolumesNumber := min(usedMappings, current.instanceStoreDeviceCount)
 
-	// Iterate alphabetically by instance type
+	// Iterate alphabetically by instance type, priced for the platform of the
+	// current instance
+	typeInformation := i.region.instanceTypeInformation
+	if pd, found := i.getPlatformSpotProductDescription(); found {
+		typeInformation = i.region.getInstanceTypeInformation(pd)
+	}
 	keys := make([]string, 0)
-	for k := range i.region.instanceTypeInformation {
+	for k := range typeInformation {
 		keys = append(keys, k)
 	}
 
@@ -327,14 +450,20 @@ func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allo
 
 	// Find all compatible and not blocked instance types
 	for _, k := range keys {
-		candidate := i.region.instanceTypeInformation[k]
+		candidate := typeInformation[k]
 
 		candidatePrice := i.calculatePrice(candidate)
 		debug.Println("Comparing current type", current.instanceType, "with price", i.price,
 			"with candidate", candidate.instanceType, "with price", candidatePrice)
 
-		if i.isAllowed(candidate.instanceType, allowedList, disallowedList) && i.isCompatible(&candidate, candidatePrice, attachedVolumesNumber) {
-			acceptableInstanceTypes = append(acceptableInstanceTypes, acceptableInstance{candidate, candidatePrice})
+		if i.isAllowed(candidate.instanceType, allowedList, disallowedList) &&
+			i.isCompatible(&candidate, candidatePrice, attachedVolumesNumber) &&
+			i.isVolatilityCompatible(&candidate) {
+			acceptableInstanceTypes = append(acceptableInstanceTypes, acceptableInstance{
+				instanceTI: candidate,
+				price:      candidatePrice,
+				score:      i.getSpotPoolScore(&candidate, candidatePrice),
+			})
 			log.Println("\tMATCH FOUND, added", candidate.instanceType, "to launch candidates list for instance", *i.InstanceId)
 		} else if candidate.instanceType != "" {
 			debug.Println("Non compatible option found:", candidate.instanceType, "at", candidatePrice, " - discarding")
@@ -342,10 +471,10 @@ func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allo
 	}
 
 	if acceptableInstanceTypes != nil {
-		sort.Slice(acceptableInstanceTypes, func(i, j int) bool {
-			return acceptableInstanceTypes[i].price < acceptableInstanceTypes[j].price
+		sort.SliceStable(acceptableInstanceTypes, func(i, j int) bool {
+			return acceptableInstanceTypes[i].score < acceptableInstanceTypes[j].score
 		})
-		debug.Println("List of cheapest compatible spot instances found, sorted ascending by price: ",
+		debug.Println("List of cheapest compatible spot instances found, sorted ascending by price adjusted for volatility: ",
 			acceptableInstanceTypes)
 		var result []*string
 		for _, ai := range acceptableInstanceTypes {
@@ -362,9 +491,47 @@ func (i *instance) isCompatible(candidate *instanceTypeInformation, candidatePri
 		i.isEBSCompatible(candidate) &&
 		i.isClassCompatible(candidate) &&
 		i.isStorageCompatible(candidate, attachedVolumesNumber) &&
+		i.isNetworkCompatible(candidate) &&
+		i.isAcceleratorCompatible(candidate) &&
 		i.isVirtualizationCompatible(candidate.virtualizationTypes)
 }
 
+// isVolatilityCompatible excludes the spot pools whose price history is more
+// volatile than the configured thresholds.
+func (i *instance) isVolatilityCompatible(candidate *instanceTypeInformation) bool {
+	stats, found := candidate.pricing.spotStats[*i.Placement.AvailabilityZone]
+	if !found || i.asg == nil {
+		return true
+	}
+
+	cfg := i.asg.config
+
+	if cfg.SpotPriceMaxVolatility > 0 && stats.volatility() > cfg.SpotPriceMaxVolatility {
+		debug.Printf("\tSpot price volatility %f above the threshold %f",
+			stats.volatility(), cfg.SpotPriceMaxVolatility)
+		return false
+	}
+
+	if cfg.SpotPriceMaxChangesPerDay > 0 && stats.changesPerDay > cfg.SpotPriceMaxChangesPerDay {
+		debug.Printf("\tSpot price changing %f times a day, above the threshold %f",
+			stats.changesPerDay, cfg.SpotPriceMaxChangesPerDay)
+		return false
+	}
+	return true
+}
+
+// getSpotPoolScore computes the effective price used when sorting the
+// replacement candidates, increased proportionally to the volatility of the
+// spot pool so that stable pools are preferred.
+func (i *instance) getSpotPoolScore(candidate *instanceTypeInformation, candidatePrice float64) float64 {
+	if i.asg == nil || i.asg.config.SpotPriceVolatilityWeight == 0 {
+		return candidatePrice
+	}
+
+	stats := candidate.pricing.spotStats[*i.Placement.AvailabilityZone]
+	return candidatePrice * (1 + i.asg.config.SpotPriceVolatilityWeight*stats.volatility())
+}
+
 func (i *instance) getReplacementTargetInstanceID() *string {
 	for _, tag := range i.Tags {
 		if *tag.Key == "launched-for-replacing-instance" {
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"math"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -202,6 +203,7 @@ func TestIsClassCompatible(t *testing.T) {
 		instanceCPU    int
 		instanceMemory float32
 		instanceGPU    int
+		config         AutoScalingConfig
 		expected       bool
 	}{
 		{name: "Spot is higher in both CPU & memory",
@@ -269,6 +271,85 @@ func TestIsClassCompatible(t *testing.T) {
 			instanceGPU:    2,
 			expected:       true,
 		},
+		{name: "Spot is lower in CPU & memory but smaller types are allowed",
+			spotInfo: &instanceTypeInformation{
+				vCPU:              2,
+				memory:            4,
+				PhysicalProcessor: "Intel",
+			},
+			instanceCPU:    4,
+			instanceMemory: 16,
+			config:         AutoScalingConfig{AllowSmaller: true},
+			expected:       true,
+		},
+		{name: "Spot is lower in CPU but within the vCPU range",
+			spotInfo: &instanceTypeInformation{
+				vCPU:              4,
+				memory:            32,
+				PhysicalProcessor: "Intel",
+			},
+			instanceCPU:    8,
+			instanceMemory: 32,
+			config:         AutoScalingConfig{VCPURange: "4-16"},
+			expected:       true,
+		},
+		{name: "Spot is higher in CPU but above the vCPU range",
+			spotInfo: &instanceTypeInformation{
+				vCPU:              32,
+				memory:            64,
+				PhysicalProcessor: "Intel",
+			},
+			instanceCPU:    8,
+			instanceMemory: 32,
+			config:         AutoScalingConfig{VCPURange: "4-16"},
+			expected:       false,
+		},
+		{name: "Spot is lower in memory and no memory range is set",
+			spotInfo: &instanceTypeInformation{
+				vCPU:              8,
+				memory:            16,
+				PhysicalProcessor: "Intel",
+			},
+			instanceCPU:    8,
+			instanceMemory: 32,
+			config:         AutoScalingConfig{VCPURange: "4-16"},
+			expected:       false,
+		},
+		{name: "Spot is lower in memory but within the memory range",
+			spotInfo: &instanceTypeInformation{
+				vCPU:              8,
+				memory:            16,
+				PhysicalProcessor: "Intel",
+			},
+			instanceCPU:    8,
+			instanceMemory: 32,
+			config:         AutoScalingConfig{MemoryGiBRange: "16-"},
+			expected:       true,
+		},
+		{name: "Spot memory per vCPU outside the range",
+			spotInfo: &instanceTypeInformation{
+				vCPU:              8,
+				memory:            64,
+				PhysicalProcessor: "Intel",
+			},
+			instanceCPU:    8,
+			instanceMemory: 32,
+			config:         AutoScalingConfig{MemoryPerVCPURange: "2-4"},
+			expected:       false,
+		},
+		{name: "Spot is lower in GPU even if smaller types are allowed",
+			spotInfo: &instanceTypeInformation{
+				vCPU:              8,
+				memory:            32,
+				GPU:               0,
+				PhysicalProcessor: "Intel",
+			},
+			instanceCPU:    8,
+			instanceMemory: 32,
+			instanceGPU:    1,
+			config:         AutoScalingConfig{AllowSmaller: true},
+			expected:       false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -276,8 +357,10 @@ func TestIsClassCompatible(t *testing.T) {
 			i := &instance{typeInfo: instanceTypeInformation{
 				vCPU:              tt.instanceCPU,
 				memory:            tt.instanceMemory,
+				GPU:               tt.instanceGPU,
 				PhysicalProcessor: "Intel",
 			},
+				asg: &autoScalingGroup{config: tt.config},
 			}
 			retValue := i.isClassCompatible(tt.spotInfo)
 			if retValue != tt.expected {
@@ -287,6 +370,232 @@ func TestIsClassCompatible(t *testing.T) {
 	}
 }
 
+func TestIsNetworkCompatible(t *testing.T) {
+	allChecks := AutoScalingConfig{
+		CheckNetworkPerformance: true,
+		CheckENASupport:         true,
+		CheckEFASupport:         true,
+		CheckNetworkInterfaces:  true,
+	}
+
+	current := networkInfo{
+		performance:  networkPerformance{gbps: 25},
+		enaSupported: true,
+		efaSupported: true,
+		maxENIs:      8,
+		ipv4PerENI:   30,
+		ipv6PerENI:   30,
+		detailed:     true,
+	}
+
+	tests := []struct {
+		name      string
+		candidate networkInfo
+		config    AutoScalingConfig
+		expected  bool
+	}{
+		{
+			name:      "same capabilities",
+			candidate: current,
+			config:    allChecks,
+			expected:  true,
+		},
+		{
+			name: "lower network performance",
+			candidate: networkInfo{
+				performance: networkPerformance{gbps: 10, burstable: true}, enaSupported: true,
+				efaSupported: true, maxENIs: 8, ipv4PerENI: 30, ipv6PerENI: 30, detailed: true,
+			},
+			config:   allChecks,
+			expected: false,
+		},
+		{
+			name: "lower network performance with the check disabled",
+			candidate: networkInfo{
+				performance: networkPerformance{gbps: 10, burstable: true}, enaSupported: true,
+				efaSupported: true, maxENIs: 8, ipv4PerENI: 30, ipv6PerENI: 30, detailed: true,
+			},
+			config: AutoScalingConfig{
+				CheckENASupport:        true,
+				CheckEFASupport:        true,
+				CheckNetworkInterfaces: true,
+			},
+			expected: true,
+		},
+		{
+			name: "unknown network performance",
+			candidate: networkInfo{
+				enaSupported: true, efaSupported: true, maxENIs: 8, ipv4PerENI: 30,
+				ipv6PerENI: 30, detailed: true,
+			},
+			config:   allChecks,
+			expected: true,
+		},
+		{
+			name: "no ENA support",
+			candidate: networkInfo{
+				performance: networkPerformance{gbps: 25}, efaSupported: true, maxENIs: 8,
+				ipv4PerENI: 30, ipv6PerENI: 30, detailed: true,
+			},
+			config:   allChecks,
+			expected: false,
+		},
+		{
+			name: "no EFA support",
+			candidate: networkInfo{
+				performance: networkPerformance{gbps: 25}, enaSupported: true, maxENIs: 8,
+				ipv4PerENI: 30, ipv6PerENI: 30, detailed: true,
+			},
+			config:   allChecks,
+			expected: false,
+		},
+		{
+			name: "no EFA support with the check disabled",
+			candidate: networkInfo{
+				performance: networkPerformance{gbps: 25}, enaSupported: true, maxENIs: 8,
+				ipv4PerENI: 30, ipv6PerENI: 30, detailed: true,
+			},
+			config: AutoScalingConfig{
+				CheckNetworkPerformance: true,
+				CheckENASupport:         true,
+				CheckNetworkInterfaces:  true,
+			},
+			expected: true,
+		},
+		{
+			name: "less IPv4 addresses per ENI",
+			candidate: networkInfo{
+				performance: networkPerformance{gbps: 25}, enaSupported: true,
+				efaSupported: true, maxENIs: 8, ipv4PerENI: 15, ipv6PerENI: 30, detailed: true,
+			},
+			config:   allChecks,
+			expected: false,
+		},
+		{
+			name: "less ENIs only known from the static data",
+			candidate: networkInfo{
+				performance: networkPerformance{gbps: 25}, enaSupported: true,
+			},
+			config:   allChecks,
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				typeInfo: instanceTypeInformation{network: current},
+				asg:      &autoScalingGroup{config: tt.config},
+			}
+			if got := i.isNetworkCompatible(&instanceTypeInformation{network: tt.candidate}); got != tt.expected {
+				t.Errorf("isNetworkCompatible() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsAcceleratorCompatible(t *testing.T) {
+	t4 := accelerator{manufacturer: "NVIDIA", model: "T4", count: 1, memoryMiB: 16384}
+	inferentia := accelerator{manufacturer: "AWS", model: "Inferentia", count: 1}
+
+	tests := []struct {
+		name      string
+		current   acceleratorInfo
+		candidate acceleratorInfo
+		config    AutoScalingConfig
+		expected  bool
+	}{
+		{
+			name:      "no accelerators",
+			current:   acceleratorInfo{detailed: true},
+			candidate: acceleratorInfo{detailed: true},
+			expected:  true,
+		},
+		{
+			name:      "accelerators unknown",
+			current:   acceleratorInfo{gpu: t4},
+			candidate: acceleratorInfo{detailed: true},
+			expected:  true,
+		},
+		{
+			name:      "same GPU",
+			current:   acceleratorInfo{gpu: t4, detailed: true},
+			candidate: acceleratorInfo{gpu: t4, detailed: true},
+			expected:  true,
+		},
+		{
+			name:    "GPU of another manufacturer",
+			current: acceleratorInfo{gpu: t4, detailed: true},
+			candidate: acceleratorInfo{
+				gpu:      accelerator{manufacturer: "AMD", model: "Radeon Pro V520", count: 1, memoryMiB: 16384},
+				detailed: true,
+			},
+			expected: false,
+		},
+		{
+			name:    "less GPU memory",
+			current: acceleratorInfo{gpu: t4, detailed: true},
+			candidate: acceleratorInfo{
+				gpu:      accelerator{manufacturer: "NVIDIA", model: "M60", count: 1, memoryMiB: 8192},
+				detailed: true,
+			},
+			expected: false,
+		},
+		{
+			name:    "more GPU memory from more GPUs",
+			current: acceleratorInfo{gpu: t4, detailed: true},
+			candidate: acceleratorInfo{
+				gpu:      accelerator{manufacturer: "nvidia", model: "M60", count: 2, memoryMiB: 8192},
+				detailed: true,
+			},
+			expected: true,
+		},
+		{
+			name:    "GPU model not pinned",
+			current: acceleratorInfo{gpu: t4, detailed: true},
+			candidate: acceleratorInfo{
+				gpu:      accelerator{manufacturer: "NVIDIA", model: "A10G", count: 1, memoryMiB: 24576},
+				detailed: true,
+			},
+			config:   AutoScalingConfig{GPUModels: "current"},
+			expected: false,
+		},
+		{
+			name:      "same inference accelerator",
+			current:   acceleratorInfo{inference: inferentia, detailed: true},
+			candidate: acceleratorInfo{inference: inferentia, detailed: true},
+			expected:  true,
+		},
+		{
+			name:      "missing inference accelerator",
+			current:   acceleratorInfo{inference: inferentia, detailed: true},
+			candidate: acceleratorInfo{detailed: true},
+			expected:  false,
+		},
+		{
+			name:    "less FPGAs",
+			current: acceleratorInfo{fpga: accelerator{manufacturer: "Xilinx", model: "Virtex UltraScale (VU9P)", count: 2}, detailed: true},
+			candidate: acceleratorInfo{
+				fpga:     accelerator{manufacturer: "Xilinx", model: "Virtex UltraScale (VU9P)", count: 1},
+				detailed: true,
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				typeInfo: instanceTypeInformation{accelerators: tt.current},
+				asg:      &autoScalingGroup{config: tt.config},
+			}
+			if got := i.isAcceleratorCompatible(&instanceTypeInformation{accelerators: tt.candidate}); got != tt.expected {
+				t.Errorf("isAcceleratorCompatible() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
 func TestIsStorageCompatible(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -912,6 +1221,94 @@ func Test_getCompatibleSpotInstanceTypesListSortedAscendingByPrice(t *testing.T)
 	}
 }
 
+func TestIsVolatilityCompatible(t *testing.T) {
+	stats := spotPriceStatsMap{
+		"us-east-1a": {mean: 0.1, max: 0.15, stdDev: 0.03, changesPerDay: 4},
+	}
+
+	tests := []struct {
+		name   string
+		config AutoScalingConfig
+		stats  spotPriceStatsMap
+		want   bool
+	}{
+		{
+			name:  "no thresholds",
+			stats: stats,
+			want:  true,
+		},
+		{
+			name:   "no price history",
+			config: AutoScalingConfig{SpotPriceMaxVolatility: 0.1},
+			want:   true,
+		},
+		{
+			name:   "volatility below the threshold",
+			config: AutoScalingConfig{SpotPriceMaxVolatility: 0.5},
+			stats:  stats,
+			want:   true,
+		},
+		{
+			name:   "volatility above the threshold",
+			config: AutoScalingConfig{SpotPriceMaxVolatility: 0.2},
+			stats:  stats,
+			want:   false,
+		},
+		{
+			name:   "too many price changes",
+			config: AutoScalingConfig{SpotPriceMaxChangesPerDay: 2},
+			stats:  stats,
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				},
+				asg: &autoScalingGroup{config: tt.config},
+			}
+			candidate := &instanceTypeInformation{
+				pricing: prices{spotStats: tt.stats},
+			}
+			if got := i.isVolatilityCompatible(candidate); got != tt.want {
+				t.Errorf("isVolatilityCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetSpotPoolScore(t *testing.T) {
+	candidate := &instanceTypeInformation{
+		pricing: prices{spotStats: spotPriceStatsMap{
+			"us-east-1a": {mean: 0.1, stdDev: 0.05},
+		}},
+	}
+
+	tests := []struct {
+		name   string
+		weight float64
+		want   float64
+	}{
+		{name: "volatility ignored", weight: 0, want: 0.1},
+		{name: "volatility weighted", weight: 2, want: 0.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				},
+				asg: &autoScalingGroup{config: AutoScalingConfig{SpotPriceVolatilityWeight: tt.weight}},
+			}
+			if got := i.getSpotPoolScore(candidate, 0.1); math.Abs(got-tt.want) > 0.000001 {
+				t.Errorf("getSpotPoolScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestGetPriceToBid(t *testing.T) {
 	tests := []struct {
 		spotPercentage       float64
@@ -979,7 +1376,10 @@ func TestGetPriceToBid(t *testing.T) {
 		i := &instance{
 			region: &region{
 				name: "us-east-1",
-				conf: cfg,
+				conf: &Config{},
+			},
+			asg: &autoScalingGroup{
+				config: cfg.AutoScalingConfig,
 			},
 			Instance: &ec2.Instance{
 				InstanceId: aws.String("i-0000000"),
@@ -997,6 +1397,74 @@ func TestGetPriceToBid(t *testing.T) {
 	}
 }
 
+// TestGetPriceToBid_concurrentGroups checks that the bidding configuration of
+// a group doesn't leak into the other groups of the region when they're
+// processed concurrently.
+func TestGetPriceToBid_concurrentGroups(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{
+			AutoScalingConfig: AutoScalingConfig{
+				BiddingPolicy:             DefaultBiddingPolicy,
+				SpotPriceBufferPercentage: DefaultSpotPriceBufferPercentage,
+			},
+		},
+	}
+
+	groupTags := map[string][]*autoscaling.TagDescription{
+		"aggressive": {
+			{Key: aws.String(BiddingPolicyTag), Value: aws.String("aggressive")},
+			{Key: aws.String(SpotPriceBufferPercentageTag), Value: aws.String("50")},
+		},
+		"normal": {},
+	}
+	want := map[string]float64{
+		"aggressive": 0.03,
+		"normal":     0.1,
+	}
+
+	var wg sync.WaitGroup
+	got := make(map[string]float64)
+	var mu sync.Mutex
+
+	for name, tags := range groupTags {
+		wg.Add(1)
+		go func(name string, tags []*autoscaling.TagDescription) {
+			defer wg.Done()
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{Tags: tags, MaxSize: aws.Int64(1)},
+				name:   name,
+				region: r,
+			}
+			a.config = *a.defaults()
+			a.loadConfigFromTags()
+
+			i := &instance{
+				region:   r,
+				asg:      a,
+				Instance: &ec2.Instance{InstanceId: aws.String("i-" + name)},
+			}
+			price := i.getPriceToBid(0.1, 0.02, 0)
+
+			mu.Lock()
+			got[name] = price
+			mu.Unlock()
+		}(name, tags)
+	}
+	wg.Wait()
+
+	for name, price := range want {
+		if math.Abs(got[name]-price) > 0.000001 {
+			t.Errorf("group %s bid %f, expected %f", name, got[name], price)
+		}
+	}
+
+	if r.conf.BiddingPolicy != DefaultBiddingPolicy ||
+		r.conf.SpotPriceBufferPercentage != DefaultSpotPriceBufferPercentage {
+		t.Errorf("the region configuration was changed: %+v", r.conf.AutoScalingConfig)
+	}
+}
+
 func Test_instance_isSameArch(t *testing.T) {
 
 	tests := []struct {
@@ -0,0 +1,181 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// instance_type_cache.go contains the cache of the instance type information
+// of each region, kept in memory between the warm invocations of the Lambda
+// function and between the runs in daemon mode. The spot prices can also be
+// persisted to a local directory, such as /tmp, surviving the restarts.
+
+// DefaultInstanceTypeCacheTTL is the default time for which the instance type
+// information and the spot prices are cached, the cache being opt-in so the
+// prices are fetched again on every run unless configured otherwise
+const DefaultInstanceTypeCacheTTL = 0
+
+// instanceTypeCatalog is the instance type information of a region, priced for
+// a spot product description.
+type instanceTypeCatalog struct {
+	information map[string]instanceTypeInformation
+	details     map[string]instanceTypeDetails
+	expires     time.Time
+}
+
+// instanceTypeCache holds the catalogs of the regions, which are never
+// changed once built so they can be shared by concurrent invocations.
+type instanceTypeCache struct {
+	sync.Mutex
+	catalogs map[string]instanceTypeCatalog
+}
+
+var catalogCache = &instanceTypeCache{catalogs: make(map[string]instanceTypeCatalog)}
+
+func (c *instanceTypeCache) get(key string, now time.Time) (instanceTypeCatalog, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	catalog, found := c.catalogs[key]
+	if !found {
+		return instanceTypeCatalog{}, false
+	}
+	if !now.Before(catalog.expires) {
+		delete(c.catalogs, key)
+		return instanceTypeCatalog{}, false
+	}
+	return catalog, true
+}
+
+func (c *instanceTypeCache) put(key string, catalog instanceTypeCatalog) {
+	c.Lock()
+	defer c.Unlock()
+	c.catalogs[key] = catalog
+}
+
+// instanceTypeCacheKey identifies the catalog of the region for the spot
+// product description, along with the configuration used for pricing it. The
+// account is part of it since the availability zone names differ between
+// accounts.
+func (r *region) instanceTypeCacheKey(productDescription string) string {
+	return fmt.Sprintf("%s/%s/%s/%v/%v/%s", r.conf.accountID, r.name, productDescription,
+		r.conf.OnDemandPriceMultiplier, r.conf.SpotProductPremium, r.conf.SpotPriceHistoryWindow)
+}
+
+// cachedInstanceTypeCatalog returns the cached catalog of the region for the
+// spot product description, if it's still fresh.
+func (r *region) cachedInstanceTypeCatalog(productDescription string) (instanceTypeCatalog, bool) {
+	if r.conf.InstanceTypeCacheTTL <= 0 {
+		return instanceTypeCatalog{}, false
+	}
+	return catalogCache.get(r.instanceTypeCacheKey(productDescription), time.Now())
+}
+
+// cacheInstanceTypeCatalog caches the catalog of the region for the spot
+// product description.
+func (r *region) cacheInstanceTypeCatalog(productDescription string, information map[string]instanceTypeInformation) {
+	if r.conf.InstanceTypeCacheTTL <= 0 {
+		return
+	}
+	catalogCache.put(r.instanceTypeCacheKey(productDescription), instanceTypeCatalog{
+		information: information,
+		details:     r.instanceTypeDetails,
+		expires:     time.Now().Add(r.conf.InstanceTypeCacheTTL),
+	})
+}
+
+// spotPriceSnapshot is the content of the files persisting the spot prices.
+type spotPriceSnapshot struct {
+	FetchedAt time.Time        `json:"fetched_at"`
+	Prices    []*ec2.SpotPrice `json:"prices"`
+}
+
+// spotPriceCacheFile returns the file persisting the spot prices of the
+// region for the spot product description, or an empty string when they
+// aren't persisted.
+func (r *region) spotPriceCacheFile(productDescription string) string {
+	if r.conf.InstanceTypeCacheDir == "" || r.conf.InstanceTypeCacheTTL <= 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(r.instanceTypeCacheKey(productDescription)))
+	return filepath.Join(r.conf.InstanceTypeCacheDir,
+		"autospotting-spot-prices-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// loadSpotPrices returns the spot prices persisted for the region and the
+// spot product description, if they're still fresh.
+func (r *region) loadSpotPrices(productDescription string) ([]*ec2.SpotPrice, bool) {
+	path := r.spotPriceCacheFile(productDescription)
+	if path == "" {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(r.name, "Couldn't read the cached spot prices from", path, err.Error())
+		}
+		return nil, false
+	}
+
+	var snapshot spotPriceSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		log.Println(r.name, "Ignoring the invalid cached spot prices from", path, err.Error())
+		return nil, false
+	}
+
+	if time.Since(snapshot.FetchedAt) >= r.conf.InstanceTypeCacheTTL {
+		return nil, false
+	}
+	return snapshot.Prices, true
+}
+
+// saveSpotPrices persists the spot prices of the region for the spot product
+// description, only logging the failures since they can be fetched again.
+func (r *region) saveSpotPrices(productDescription string, prices []*ec2.SpotPrice) {
+	path := r.spotPriceCacheFile(productDescription)
+	if path == "" {
+		return
+	}
+
+	data, err := json.Marshal(spotPriceSnapshot{FetchedAt: time.Now(), Prices: prices})
+	if err != nil {
+		log.Println(r.name, "Couldn't encode the spot prices", err.Error())
+		return
+	}
+
+	// written to a temporary file renamed over the cache file, so that the
+	// concurrent invocations never read it partially written
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		log.Println(r.name, "Couldn't cache the spot prices in", path, err.Error())
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		log.Println(r.name, "Couldn't cache the spot prices in", path, err.Error())
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		log.Println(r.name, "Couldn't cache the spot prices in", path, err.Error())
+		return
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		log.Println(r.name, "Couldn't cache the spot prices in", path, err.Error())
+	}
+}
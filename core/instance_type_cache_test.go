@@ -0,0 +1,130 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+func TestInstanceTypeCache(t *testing.T) {
+	c := &instanceTypeCache{catalogs: make(map[string]instanceTypeCatalog)}
+	now := time.Now()
+
+	c.put("fresh", instanceTypeCatalog{expires: now.Add(time.Minute)})
+	c.put("expired", instanceTypeCatalog{expires: now})
+
+	if _, found := c.get("fresh", now); !found {
+		t.Error("get() didn't return the fresh catalog")
+	}
+	if _, found := c.get("expired", now); found {
+		t.Error("get() returned the expired catalog")
+	}
+	if _, found := c.catalogs["expired"]; found {
+		t.Error("get() didn't evict the expired catalog")
+	}
+	if _, found := c.get("missing", now); found {
+		t.Error("get() returned a missing catalog")
+	}
+}
+
+func cachingRegion(account string, cfg *Config, ec2Svc mockEC2) *region {
+	cfg.accountID = account
+	return &region{name: "us-east-1", conf: cfg, services: connections{ec2: ec2Svc}}
+}
+
+func TestDetermineInstanceTypeInformation_cached(t *testing.T) {
+	cfg := &Config{
+		InstanceData: &ec2instancesinfo.InstanceData{
+			0: {
+				InstanceType: "m5.large",
+				Pricing: map[string]ec2instancesinfo.RegionPrices{
+					"us-east-1": {Linux: ec2instancesinfo.Pricing{OnDemand: 0.096}},
+				},
+			},
+		},
+		AutoScalingConfig: AutoScalingConfig{
+			OnDemandPriceMultiplier: 1,
+			SpotProductDescription:  DefaultSpotProductDescription,
+		},
+		InstanceTypeCacheTTL: time.Minute,
+	}
+
+	prices := mockEC2{dsphpo: []*ec2.DescribeSpotPriceHistoryOutput{{
+		SpotPriceHistory: []*ec2.SpotPrice{{
+			AvailabilityZone: aws.String("us-east-1a"),
+			InstanceType:     aws.String("m5.large"),
+			SpotPrice:        aws.String("0.03"),
+		}},
+	}}}
+	unavailable := mockEC2{dsphperr: errors.New("throttled")}
+
+	// a failed fetch isn't cached
+	r := cachingRegion("cache-failure", cfg, unavailable)
+	r.determineInstanceTypeInformation(cfg)
+	r = cachingRegion("cache-failure", cfg, prices)
+	r.determineInstanceTypeInformation(cfg)
+	if got := r.instanceTypeInformation["m5.large"].pricing.spot["us-east-1a"]; got != 0.03 {
+		t.Errorf("spot price after a failed fetch = %v, want 0.03", got)
+	}
+
+	// the cached spot prices are used even when they can't be fetched anymore
+	r = cachingRegion("cache-hit", cfg, prices)
+	r.determineInstanceTypeInformation(cfg)
+	r = cachingRegion("cache-hit", cfg, unavailable)
+	r.determineInstanceTypeInformation(cfg)
+	if got := r.instanceTypeInformation["m5.large"].pricing.spot["us-east-1a"]; got != 0.03 {
+		t.Errorf("cached spot price = %v, want 0.03", got)
+	}
+
+	// nothing is cached without a TTL
+	uncached := *cfg
+	uncached.InstanceTypeCacheTTL = 0
+	r = cachingRegion("cache-disabled", &uncached, prices)
+	r.determineInstanceTypeInformation(&uncached)
+	r = cachingRegion("cache-disabled", &uncached, unavailable)
+	r.determineInstanceTypeInformation(&uncached)
+	if got := r.instanceTypeInformation["m5.large"].pricing.spot["us-east-1a"]; got != 0 {
+		t.Errorf("spot price without caching = %v, want 0", got)
+	}
+}
+
+func TestSpotPriceCacheFile(t *testing.T) {
+	cfg := &Config{
+		InstanceTypeCacheTTL: time.Minute,
+		InstanceTypeCacheDir: t.TempDir(),
+	}
+	r := cachingRegion("123456789012", cfg, mockEC2{})
+
+	if _, found := r.loadSpotPrices("Linux/UNIX"); found {
+		t.Fatal("loadSpotPrices() found prices before they were saved")
+	}
+
+	saved := []*ec2.SpotPrice{{
+		AvailabilityZone: aws.String("us-east-1a"),
+		InstanceType:     aws.String("m5.large"),
+		SpotPrice:        aws.String("0.03"),
+	}}
+	r.saveSpotPrices("Linux/UNIX", saved)
+
+	got, found := r.loadSpotPrices("Linux/UNIX")
+	if !found || len(got) != 1 || *got[0].SpotPrice != "0.03" {
+		t.Errorf("loadSpotPrices() = %v, %v, want the saved prices", got, found)
+	}
+
+	if _, found := r.loadSpotPrices("Windows"); found {
+		t.Error("loadSpotPrices() returned the prices of another product description")
+	}
+
+	cfg.InstanceTypeCacheTTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, found := r.loadSpotPrices("Linux/UNIX"); found {
+		t.Error("loadSpotPrices() returned expired prices")
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// instanceTypeDetails contains the capabilities of an instance type missing
+// from the static instance type data, fetched from the EC2 API.
+type instanceTypeDetails struct {
+	network      networkInfo
+	accelerators acceleratorInfo
+}
+
+// describeInstanceTypes fetches the details of all the instance types
+// available in the region, keyed by instance type.
+func (r *region) describeInstanceTypes() map[string]instanceTypeDetails {
+	result := make(map[string]instanceTypeDetails)
+
+	err := r.services.ec2.DescribeInstanceTypesPages(&ec2.DescribeInstanceTypesInput{},
+		func(page *ec2.DescribeInstanceTypesOutput, lastPage bool) bool {
+			for _, it := range page.InstanceTypes {
+				if it.InstanceType == nil || it.NetworkInfo == nil {
+					continue
+				}
+				result[*it.InstanceType] = instanceTypeDetails{
+					network:      networkInfoFromAPI(it.NetworkInfo),
+					accelerators: acceleratorInfoFromAPI(it),
+				}
+			}
+			return true
+		})
+
+	if err != nil {
+		log.Println(r.name, "Couldn't describe the instance types, the network and",
+			"accelerator compatibility checks are limited to the static data:", err.Error())
+	}
+
+	return result
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_region_describeInstanceTypes(t *testing.T) {
+	tests := []struct {
+		name string
+		ec2  mockEC2
+		want map[string]instanceTypeDetails
+	}{
+		{
+			name: "instance types described",
+			ec2: mockEC2{
+				ditpo: []*ec2.DescribeInstanceTypesOutput{
+					{InstanceTypes: []*ec2.InstanceTypeInfo{{
+						InstanceType: aws.String("g4dn.12xlarge"),
+						NetworkInfo: &ec2.NetworkInfo{
+							NetworkPerformance:        aws.String("50 Gigabit"),
+							EnaSupport:                aws.String(ec2.EnaSupportRequired),
+							EfaSupported:              aws.Bool(true),
+							MaximumNetworkInterfaces:  aws.Int64(8),
+							Ipv4AddressesPerInterface: aws.Int64(30),
+							Ipv6AddressesPerInterface: aws.Int64(30),
+						},
+						GpuInfo: &ec2.GpuInfo{Gpus: []*ec2.GpuDeviceInfo{{
+							Manufacturer: aws.String("NVIDIA"),
+							Name:         aws.String("T4"),
+							Count:        aws.Int64(4),
+							MemoryInfo:   &ec2.GpuDeviceMemoryInfo{SizeInMiB: aws.Int64(16384)},
+						}}},
+					}}},
+					{InstanceTypes: []*ec2.InstanceTypeInfo{
+						{
+							InstanceType: aws.String("inf1.xlarge"),
+							NetworkInfo: &ec2.NetworkInfo{
+								NetworkPerformance: aws.String("Up to 25 Gigabit"),
+								EnaSupport:         aws.String(ec2.EnaSupportRequired),
+							},
+							InferenceAcceleratorInfo: &ec2.InferenceAcceleratorInfo{
+								Accelerators: []*ec2.InferenceDeviceInfo{{
+									Manufacturer: aws.String("AWS"),
+									Name:         aws.String("Inferentia"),
+									Count:        aws.Int64(1),
+								}},
+							},
+						},
+						{
+							InstanceType: aws.String("m1.small"),
+							NetworkInfo: &ec2.NetworkInfo{
+								NetworkPerformance:        aws.String("Low"),
+								EnaSupport:                aws.String(ec2.EnaSupportUnsupported),
+								EfaSupported:              aws.Bool(false),
+								MaximumNetworkInterfaces:  aws.Int64(2),
+								Ipv4AddressesPerInterface: aws.Int64(4),
+							},
+						},
+					}},
+				},
+			},
+			want: map[string]instanceTypeDetails{
+				"g4dn.12xlarge": {
+					network: networkInfo{
+						performance:  networkPerformance{gbps: 50},
+						enaSupported: true,
+						efaSupported: true,
+						maxENIs:      8,
+						ipv4PerENI:   30,
+						ipv6PerENI:   30,
+						detailed:     true,
+					},
+					accelerators: acceleratorInfo{
+						gpu:      accelerator{manufacturer: "NVIDIA", model: "T4", count: 4, memoryMiB: 16384},
+						detailed: true,
+					},
+				},
+				"inf1.xlarge": {
+					network: networkInfo{
+						performance:  networkPerformance{gbps: 25, burstable: true},
+						enaSupported: true,
+						detailed:     true,
+					},
+					accelerators: acceleratorInfo{
+						inference: accelerator{manufacturer: "AWS", model: "Inferentia", count: 1},
+						detailed:  true,
+					},
+				},
+				"m1.small": {
+					network: networkInfo{
+						performance: networkPerformance{gbps: 0.1},
+						maxENIs:     2,
+						ipv4PerENI:  4,
+						detailed:    true,
+					},
+					accelerators: acceleratorInfo{detailed: true},
+				},
+			},
+		},
+		{
+			name: "error describing the instance types",
+			ec2:  mockEC2{ditperr: errors.New("access denied")},
+			want: map[string]instanceTypeDetails{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{name: "us-east-1", services: connections{ec2: tt.ec2}}
+			if got := r.describeInstanceTypes(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("describeInstanceTypes() = %+v, expected %+v", got, tt.want)
+			}
+		})
+	}
+}
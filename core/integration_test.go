@@ -0,0 +1,237 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+//go:build integration
+// +build integration
+
+// The integration tests run full replacement cycles against a local AWS
+// emulator such as moto or LocalStack, for example:
+//
+//   docker run -d -p 5000:5000 motoserver/moto
+//   AUTOSPOTTING_TEST_ENDPOINT=http://localhost:5000 \
+//   AWS_ACCESS_KEY_ID=test AWS_SECRET_ACCESS_KEY=test \
+//   go test -tags integration -run Integration ./core
+
+package autospotting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+const integrationRegion = "us-east-1"
+
+type integrationEnv struct {
+	t        *testing.T
+	services connections
+	queueURL string
+}
+
+func newIntegrationEnv(t *testing.T) *integrationEnv {
+	endpoint := os.Getenv("AUTOSPOTTING_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("AUTOSPOTTING_TEST_ENDPOINT isn't set, skipping integration test")
+	}
+
+	overrides, err := parseEndpointOverrides(endpoint, os.Getenv("AUTOSPOTTING_TEST_ENDPOINTS"))
+	if err != nil {
+		t.Fatalf("invalid endpoints: %v", err)
+	}
+	setEndpointOverrides(overrides)
+
+	data, err := ec2instancesinfo.Data()
+	if err != nil {
+		t.Fatalf("couldn't load the instance type data: %v", err)
+	}
+
+	env := &integrationEnv{t: t}
+	env.services.connect(integrationRegion, integrationRegion)
+
+	queue, err := env.services.sqs.CreateQueue(&sqs.CreateQueueInput{
+		QueueName: aws.String(fmt.Sprintf("autospotting-%d.fifo", time.Now().UnixNano())),
+		Attributes: map[string]*string{
+			"FifoQueue":                 aws.String("true"),
+			"ContentBasedDeduplication": aws.String("true"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("couldn't create the SQS queue: %v", err)
+	}
+	env.queueURL = *queue.QueueUrl
+
+	as.config = &Config{
+		AutoScalingConfig: AutoScalingConfig{
+			AllowedInstanceTypes:      "m5.large,m5a.large,m4.large",
+			BiddingPolicy:             DefaultBiddingPolicy,
+			CronSchedule:              DefaultCronSchedule,
+			CronScheduleState:         "on",
+			CronTimezone:              "UTC",
+			MinOnDemandNumber:         DefaultMinOnDemandValue,
+			OnDemandPriceMultiplier:   DefaultOnDemandPriceMultiplier,
+			SpotAllocationStrategy:    "capacity-optimized-prioritized",
+			SpotPriceBufferPercentage: DefaultSpotPriceBufferPercentage,
+			SpotProductDescription:    DefaultSpotProductDescription,
+			InstanceTerminationMethod: DefaultInstanceTerminationMethod,
+			TerminationMethod:         AutoScalingTerminationMethod,
+		},
+		InstanceData: data,
+		MainRegion:   integrationRegion,
+		Regions:      integrationRegion,
+		SQSQueueURL:  env.queueURL,
+		Version:      "integration",
+	}
+	as.mainEC2Conn = connectEC2(integrationRegion)
+
+	return env
+}
+
+// createGroup creates an enabled group running a single on-demand instance
+// and returns the ID of that instance.
+func (env *integrationEnv) createGroup(name string) string {
+	images, err := env.services.ec2.DescribeImages(&ec2.DescribeImagesInput{})
+	if err != nil || len(images.Images) == 0 {
+		env.t.Fatalf("couldn't find any AMI: %v", err)
+	}
+
+	lt, err := env.services.ec2.CreateLaunchTemplate(&ec2.CreateLaunchTemplateInput{
+		LaunchTemplateName: aws.String(name),
+		LaunchTemplateData: &ec2.RequestLaunchTemplateData{
+			ImageId:      images.Images[0].ImageId,
+			InstanceType: aws.String("m5.large"),
+		},
+	})
+	if err != nil {
+		env.t.Fatalf("couldn't create the launch template: %v", err)
+	}
+
+	_, err = env.services.autoScaling.CreateAutoScalingGroup(&autoscaling.CreateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(name),
+		AvailabilityZones:    []*string{aws.String(integrationRegion + "a")},
+		DesiredCapacity:      aws.Int64(1),
+		MinSize:              aws.Int64(1),
+		MaxSize:              aws.Int64(2),
+		LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+			LaunchTemplateId: lt.LaunchTemplate.LaunchTemplateId,
+		},
+		Tags: []*autoscaling.Tag{
+			{
+				Key:               aws.String("spot-enabled"),
+				Value:             aws.String("true"),
+				PropagateAtLaunch: aws.Bool(false),
+			},
+		},
+	})
+	if err != nil {
+		env.t.Fatalf("couldn't create the AutoScaling group: %v", err)
+	}
+
+	instances := env.groupInstances(name)
+	if len(instances) != 1 {
+		env.t.Fatalf("expected a single instance in %s, found %d", name, len(instances))
+	}
+	return *instances[0].InstanceId
+}
+
+func (env *integrationEnv) groupInstances(name string) []*ec2.Instance {
+	groups, err := env.services.autoScaling.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(name)},
+	})
+	if err != nil || len(groups.AutoScalingGroups) != 1 {
+		env.t.Fatalf("couldn't describe the AutoScaling group %s: %v", name, err)
+	}
+
+	var ids []*string
+	for _, i := range groups.AutoScalingGroups[0].Instances {
+		ids = append(ids, i.InstanceId)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	resp, err := env.services.ec2.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: ids})
+	if err != nil {
+		env.t.Fatalf("couldn't describe the instances of %s: %v", name, err)
+	}
+
+	var instances []*ec2.Instance
+	for _, r := range resp.Reservations {
+		instances = append(instances, r.Instances...)
+	}
+	return instances
+}
+
+// assertSwapped checks that the on-demand instance was replaced by a spot
+// instance in the group.
+func (env *integrationEnv) assertSwapped(name, onDemandInstanceID string) {
+	instances := env.groupInstances(name)
+	if len(instances) != 1 {
+		env.t.Fatalf("expected a single instance in %s, found %d", name, len(instances))
+	}
+
+	i := instances[0]
+	if *i.InstanceId == onDemandInstanceID ||
+		aws.StringValue(i.InstanceLifecycle) != ec2.InstanceLifecycleTypeSpot {
+		env.t.Errorf("instance %s of %s wasn't swapped with a spot instance, found %s (%s)",
+			onDemandInstanceID, name, *i.InstanceId, aws.StringValue(i.InstanceLifecycle))
+	}
+}
+
+func TestIntegrationCronSpotReplacement(t *testing.T) {
+	env := newIntegrationEnv(t)
+	name := fmt.Sprintf("autospotting-cron-%d", time.Now().UnixNano())
+	onDemandInstanceID := env.createGroup(name)
+
+	// the first run launches the spot replacement, the next one swaps it
+	// against the on-demand instance
+	for run := 0; run < 2; run++ {
+		if err := as.ProcessCronEvent(); err != nil {
+			t.Fatalf("cron run %d failed: %v", run, err)
+		}
+	}
+
+	env.assertSwapped(name, onDemandInstanceID)
+}
+
+func TestIntegrationEventSpotReplacement(t *testing.T) {
+	env := newIntegrationEnv(t)
+	name := fmt.Sprintf("autospotting-event-%d", time.Now().UnixNano())
+	onDemandInstanceID := env.createGroup(name)
+
+	detail, _ := json.Marshal(map[string]string{
+		"instance-id": onDemandInstanceID,
+		"state":       "running",
+	})
+	cloudwatchEvent, _ := json.Marshal(map[string]interface{}{
+		"detail-type": InstanceStateChangeNotificationMessage,
+		"source":      "aws.ec2",
+		"region":      integrationRegion,
+		"detail":      json.RawMessage(detail),
+	})
+
+	// the event is processed as if delivered from the SQS queue, which
+	// launches the spot replacement and swaps it right away
+	sqsEvent, _ := json.Marshal(map[string]interface{}{
+		"Records": []map[string]string{
+			{
+				"receiptHandle": "integration",
+				"body":          string(cloudwatchEvent),
+			},
+		},
+	})
+	event := json.RawMessage(sqsEvent)
+
+	if err := as.EventHandler(&event); err != nil {
+		t.Fatalf("event run failed: %v", err)
+	}
+
+	env.assertSwapped(name, onDemandInstanceID)
+}
@@ -16,37 +16,57 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
 	ec2instancesinfo "github.com/cristim/ec2-instances-info"
 )
 
 var debug *log.Logger
-var totalSavings float64
 
 // AutoSpotting hosts global configuration and has as methods all the public
 // entrypoints of this library
 type AutoSpotting struct {
-	config      *Config
-	mainEC2Conn ec2iface.EC2API
+	config        *Config
+	mainEC2Conn   ec2iface.EC2API
+	organizations organizationsiface.OrganizationsAPI
 }
 
 var as *AutoSpotting
 
 // Init initializes some data structures reusable across multiple event runs
 func (a *AutoSpotting) Init(cfg *Config) {
-	data, err := ec2instancesinfo.Data()
-	if err != nil {
-		log.Fatal(err.Error())
+	// ParseConfig already loaded it, no need to load it again
+	if cfg.InstanceData == nil {
+		data, err := ec2instancesinfo.Data()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		cfg.InstanceData = data
 	}
 
-	cfg.InstanceData = data
 	a.config = cfg
 	a.config.setupLogging()
+
+	overrides, err := parseEndpointOverrides(cfg.AWSEndpointURL, cfg.AWSEndpoints)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	setEndpointOverrides(overrides)
+
+	if cfg.replacements == nil {
+		cfg.replacements = newReplacementStore(cfg)
+	}
+
 	// use this only to list all the other regions
 	a.mainEC2Conn = connectEC2(a.config.MainRegion)
+	a.organizations = organizations.New(newSession(a.config.MainRegion))
 	as = a
+
+	if cfg.MetricsAddress != "" {
+		promMetrics.serveMetrics(cfg.MetricsAddress)
+	}
 }
 
 // RunningFromLambda quite obviously returns true when running from Lambda.
@@ -60,30 +80,42 @@ func RunningFromLambda() bool {
 
 // ProcessCronEvent starts processing all AWS regions looking for AutoScaling groups
 // enabled and taking action by replacing more pricy on-demand instances with
-// compatible and cheaper spot instances.
-func (a *AutoSpotting) ProcessCronEvent() {
-	// Clear FinalRecap map
-	a.config.FinalRecap = make(map[string][]string)
+// compatible and cheaper spot instances. When roles are configured, it
+// processes the regions of each of their accounts instead of the current one.
+// It returns an error when processing failed in any of the regions.
+func (a *AutoSpotting) ProcessCronEvent() error {
+	a.config.report = newRunReport()
 
 	a.config.addDefaultFilteringMode()
 	a.config.addDefaultFilter()
 
-	allRegions, err := a.getRegions()
+	accountConfigs, err := a.getAccountConfigs()
 
 	if err != nil {
 		log.Println(err.Error())
-		return
+		a.config.report.addError(err)
+		return a.finishReport()
 	}
 
-	a.processRegions(allRegions)
+	a.processAccounts(accountConfigs)
 
-	// Print Final Recap
-	log.Println("####### BEGIN FINAL RECAP #######")
-	for r, a := range a.config.FinalRecap {
-		for _, t := range a {
-			log.Printf("%s %s\n", r, t)
+	return a.finishReport()
+}
+
+// finishReport logs the final recap of the cron run and writes its report to
+// the configured file, returning an error if the run failed.
+func (a *AutoSpotting) finishReport() error {
+	report := a.config.report
+	report.finish()
+	report.logRecap()
+
+	if a.config.ReportFile != "" {
+		if err := report.write(a.config.ReportFile); err != nil {
+			log.Println("Couldn't write the run report:", err.Error())
+			return err
 		}
 	}
+	return report.err()
 }
 
 func (cfg *Config) addDefaultFilteringMode() {
@@ -119,74 +151,94 @@ func (cfg *Config) setupLogging() {
 
 }
 
-// processAllRegions iterates all regions in parallel, and replaces instances
-// for each of the ASGs tagged with tags as specified by slice represented by cfg.FilterByTags
-// by default this is all asg with the tag 'spot-enabled=true'.
-func (a *AutoSpotting) processRegions(regions []string) {
-	var wg sync.WaitGroup
-	var savingsMutex sync.RWMutex
+// processAccounts calculates the savings of all the accounts, meters them
+// and then processes the regions of each account.
+func (a *AutoSpotting) processAccounts(configs []*Config) {
+	regions := make([][]string, len(configs))
+	var totalSavings float64
 
-	for _, r := range regions {
-		wg.Add(1)
-		r := region{name: r, conf: a.config}
-		go func() {
-			s := r.calculateSavings()
-			savingsMutex.Lock()
-			totalSavings += s
-			savingsMutex.Unlock()
-			wg.Done()
-		}()
+	for i, cfg := range configs {
+		accountRegions, err := a.getAccountRegions(cfg)
+		if err != nil {
+			log.Println("Couldn't list the regions of account", cfg.accountID, err.Error())
+			cfg.report.addError(err)
+			continue
+		}
+		regions[i] = accountRegions
+		totalSavings += a.calculateSavings(cfg, accountRegions)
 	}
-	wg.Wait()
 
 	log.Println("Total hourly savings:", totalSavings)
-	if strings.Contains(as.config.Version, "stable") {
+	if a.config.DryRun {
+		log.Println("Running in dry-run mode, skipped AWS marketplace metering")
+	} else if strings.Contains(as.config.Version, "stable") {
 		log.Println("Running a stable build, submitting AWS marketplace metering data")
 		if err := meterMarketplaceUsage(totalSavings); err != nil {
 			log.Println("Failed marketplace metering, exiting... Encountered error:", err.Error())
+			a.config.report.addError(err)
 			return
 		}
 	} else {
 		log.Println("Not running a stable build, skipped AWS marketplace metering")
 	}
 
-	for _, r := range regions {
-		wg.Add(1)
-		r := region{name: r, conf: a.config}
-
-		go func() {
-			if r.enabled() {
-				log.Printf("Enabled to run in %s, processing region.\n", r.name)
-				r.processRegion()
-			} else {
-				debug.Println("Not enabled to run in", r.name)
-				debug.Println("List of enabled regions:", r.conf.Regions)
-			}
-
-			wg.Done()
-		}()
+	for i, cfg := range configs {
+		a.processRegions(cfg, regions[i])
 	}
-	wg.Wait()
 }
 
-func connectEC2(region string) *ec2.EC2 {
+// calculateSavings iterates all regions in parallel, returning the total
+// hourly savings of the spot instances launched by AutoSpotting in them.
+func (a *AutoSpotting) calculateSavings(cfg *Config, regions []string) float64 {
+	var savingsMutex sync.RWMutex
+	var totalSavings float64
+
+	runConcurrently(len(regions), cfg.RegionConcurrency, func(i int) {
+		r := newRegion(regions[i], cfg)
+		s := r.calculateSavings()
+		savingsMutex.Lock()
+		totalSavings += s
+		savingsMutex.Unlock()
+		cfg.report.addRegionSavings(r.name, s)
+		promMetrics.setHourlySavings(cfg.accountID, r.name, s)
+	})
+
+	return totalSavings
+}
 
-	sess, err := session.NewSession()
-	if err != nil {
-		panic(err)
-	}
+// processRegions iterates all regions in parallel, and replaces instances
+// for each of the ASGs tagged with tags as specified by slice represented by cfg.FilterByTags
+// by default this is all asg with the tag 'spot-enabled=true'.
+func (a *AutoSpotting) processRegions(cfg *Config, regions []string) {
+	runConcurrently(len(regions), cfg.RegionConcurrency, func(i int) {
+		r := newRegion(regions[i], cfg)
+
+		if r.enabled() {
+			log.Printf("Enabled to run in %s, processing region.\n", r.name)
+			r.processRegion()
+		} else {
+			debug.Println("Not enabled to run in", r.name)
+			debug.Println("List of enabled regions:", r.conf.Regions)
+		}
+	})
+}
 
-	return ec2.New(sess,
-		aws.NewConfig().WithRegion(region))
+func connectEC2(region string) *ec2.EC2 {
+	return ec2.New(newSession(region))
 }
 
 // getRegions generates a list of AWS regions.
 func (a *AutoSpotting) getRegions() ([]string, error) {
+	return describeRegions(a.mainEC2Conn)
+}
+
+// describeRegions lists the AWS regions available to the given connection.
+func describeRegions(svc ec2iface.EC2API) ([]string, error) {
 	var output []string
 
 	log.Println("Scanning for available AWS regions")
 
-	resp, err := a.mainEC2Conn.DescribeRegions(&ec2.DescribeRegionsInput{})
+	resp, err := svc.DescribeRegions(&ec2.DescribeRegionsInput{})
 
 	if err != nil {
 		log.Println(err.Error())
@@ -240,7 +292,7 @@ func (a *AutoSpotting) convertRawEventToCloudwatchEvent(event *json.RawMessage)
 }
 
 // parse instance events and execute the relative methods
-func (a *AutoSpotting) processEventInstance(eventType string, region string, instanceID *string, instanceState *string) error {
+func (a *AutoSpotting) processEventInstance(eventType string, region string, instanceID *string, instanceState *string, eventTime time.Time) error {
 	if eventType == InstanceStateChangeNotificationCode {
 		if a.config.DisableEventBasedInstanceReplacement {
 			log.Println("Event-based instance replacement is disabled, exiting...")
@@ -258,9 +310,37 @@ func (a *AutoSpotting) processEventInstance(eventType string, region string, ins
 		}
 		// If the event is for an Instance Spot Interruption/Rebalance
 		spotTermination := newSpotTermination(region)
+		spotTermination.config = a.config
+		spotTermination.warningTime = eventTime
 
 		if spotTermination.IsInAutoSpottingASG(instanceID, a.config.TagFilteringMode, a.config.FilterByTags) {
-			err := spotTermination.executeAction(instanceID, a.config.TerminationNotificationAction, eventType)
+			replaceFirst := eventType == InstanceRebalanceRecommendationCode &&
+				spotTermination.rebalanceRecommendationAction(instanceID, a.config.RebalanceRecommendationAction) == ReplaceRebalanceRecommendationAction
+
+			if a.config.DryRun {
+				pa, err := spotTermination.planAction(instanceID, a.config.TerminationNotificationAction, eventType)
+				if err != nil {
+					log.Printf("Error planning spot termination/rebalance action: %s\n", err.Error())
+					return err
+				}
+				if replaceFirst && pa.AutoScalingGroup != "" {
+					pa.Action = launchBeforeDetachAction
+				}
+				a.config.plan.add(pa)
+				return nil
+			}
+
+			if replaceFirst {
+				detach, err := a.handleRebalanceRecommendation(region, *instanceID)
+				if !detach {
+					return err
+				}
+				log.Printf("Couldn't replace instance %s before detaching it, detaching it instead: %s\n",
+					*instanceID, err.Error())
+			}
+
+			gr, err := spotTermination.executeAction(instanceID, a.config.TerminationNotificationAction, eventType)
+			a.config.report.addGroup(gr)
 			if err != nil {
 				log.Printf("Error executing spot termination/rebalance action: %s\n", err.Error())
 				return err
@@ -298,31 +378,67 @@ func (a *AutoSpotting) processEvent(event *json.RawMessage) error {
 		instanceID != nil {
 		// Handle Instance Events
 		log.SetPrefix(fmt.Sprintf("%s:%s ", eventType, *instanceID))
-		a.processEventInstance(eventType, cloudwatchEvent.Region, instanceID, instanceState)
+		a.config.report = newRunReport()
+		err := a.processEventInstance(eventType, cloudwatchEvent.Region, instanceID, instanceState, cloudwatchEvent.Time)
+
+		a.terminateScheduledInstances(cloudwatchEvent.Region)
+
+		if reportErr := a.finishReport(); err == nil {
+			err = reportErr
+		}
+		return err
 	} else if eventType == AWSAPICallCloudTrailCode {
 		// CloudTrail
 		a.handleLifecycleHookEvent(*cloudwatchEvent)
 	} else if eventType == ScheduledEventCode {
 		// Cron Scheduling
-		a.ProcessCronEvent()
+		return a.ProcessCronEvent()
 	}
 
 	return nil
 }
 
+// terminateScheduledInstances terminates the instances of the event's region
+// which are due for termination, so they're terminated even in the regions
+// and deployments not processed by cron runs. Only the instances tagged as
+// detached by AutoSpotting are terminated, so the region doesn't need to be
+// enabled.
+func (a *AutoSpotting) terminateScheduledInstances(regionName string) {
+	r := &region{name: regionName, conf: a.config, services: connections{}}
+	r.services.connect(regionName, a.config.MainRegion)
+	r.terminateScheduledInstances(time.Now())
+}
+
 // EventHandler implements the event handling logic and is the main entrypoint of
-// AutoSpotting
-func (a *AutoSpotting) EventHandler(event *json.RawMessage) {
+// AutoSpotting. It returns an error when a cron run failed in any region.
+func (a *AutoSpotting) EventHandler(event *json.RawMessage) error {
+
+	if a.config.MetricsPushGatewayURL != "" {
+		defer promMetrics.push(a.config.MetricsPushGatewayURL)
+	}
+
+	if a.config.DryRun {
+		log.Println("Running in dry-run mode, no changes will be made")
+		a.config.plan = newActionPlan()
+		defer a.printPlan()
+	}
 
 	if event == nil {
 		log.Println("Missing event data, running as if triggered from a cron event...")
 		// Event is Autospotting Cron Scheduling
-		a.ProcessCronEvent()
-		return
+		return a.ProcessCronEvent()
 	}
 
-	a.processEvent(event)
+	err := a.processEvent(event)
 	log.SetPrefix("")
+	return err
+}
+
+// printPlan writes the actions collected in dry-run mode to the standard output
+func (a *AutoSpotting) printPlan() {
+	if err := a.config.plan.print(os.Stdout, a.config.DryRunFormat); err != nil {
+		log.Println("Couldn't print the dry-run plan:", err.Error())
+	}
 }
 
 func isValidLifecycleHookEvent(ctEvent CloudTrailEvent) bool {
@@ -377,6 +493,13 @@ func (a *AutoSpotting) handleLifecycleHookEvent(event events.CloudWatchEvent) er
 		return err
 	}
 
+	if target := i.getReplacementTargetInstanceID(); target != nil &&
+		r.replacementIs(*target, replacementCompleted) {
+		log.Printf("%s Instance %s was launched for replacing %s, which was already replaced, skipping it",
+			i.region.name, *i.InstanceId, *target)
+		return nil
+	}
+
 	asgName := i.getReplacementTargetASGName()
 
 	if asgName == nil || *asgName != eventASGName {
@@ -397,16 +520,26 @@ func (a *AutoSpotting) handleLifecycleHookEvent(event events.CloudWatchEvent) er
 		"attempting to swap it against a running on-demand instance",
 		i.region.name, *i.InstanceId)
 
+	if a.config.DryRun {
+		pa := instanceAction(sqsSendMessageAction, i,
+			"unattached spot instance swap is delegated to the SQS queue after a failed lifecycle hook")
+		pa.AutoScalingGroup = *asgName
+		a.config.plan.add(pa)
+		return nil
+	}
+
 	i.region.sqsSendMessageOnInstanceLaunch(asgName, i.InstanceId, i.State.Name, "lifecycle-hook-handling")
 
 	return nil
 }
 
-func (a *AutoSpotting) handleNewInstanceLaunch(regionName string, instanceID string, state string) error {
+// scanEventInstance loads the enabled groups and the instance type information
+// of the region, as well as the instance the event was triggered for.
+func (a *AutoSpotting) scanEventInstance(regionName string, instanceID string) (*region, *instance, error) {
 	r := &region{name: regionName, conf: a.config, services: connections{}}
 
 	if !r.enabled() {
-		return fmt.Errorf("region %s is not enabled", regionName)
+		return nil, nil, fmt.Errorf("region %s is not enabled", regionName)
 	}
 
 	r.services.connect(regionName, a.config.MainRegion)
@@ -419,14 +552,22 @@ func (a *AutoSpotting) handleNewInstanceLaunch(regionName string, instanceID str
 	if err := r.scanInstance(aws.String(instanceID)); err != nil {
 		log.Printf("%s Couldn't scan instance %s: %s", regionName,
 			instanceID, err.Error())
-		return err
+		return nil, nil, err
 	}
 
 	i := r.instances.get(instanceID)
 	if i == nil {
 		log.Printf("%s Instance %s is missing, skipping...",
 			regionName, instanceID)
-		return errors.New("instance missing")
+		return nil, nil, errors.New("instance missing")
+	}
+	return r, i, nil
+}
+
+func (a *AutoSpotting) handleNewInstanceLaunch(regionName string, instanceID string, state string) error {
+	r, i, err := a.scanEventInstance(regionName, instanceID)
+	if err != nil {
+		return err
 	}
 	log.Printf("%s Found instance %s in state %s",
 		i.region.name, *i.InstanceId, *i.State.Name)
@@ -458,13 +599,31 @@ func (a *AutoSpotting) handleNewOnDemandInstanceLaunch(r *region, i *instance) e
 
 	if i.shouldBeReplacedWithSpot() {
 
+		if r.replacementBlocked(*i.InstanceId) {
+			log.Printf("%s skipping instance %s: it's already replaced or being replaced",
+				i.region.name, *i.InstanceId)
+			if len(a.config.sqsReceiptHandle) > 0 && !a.config.DryRun {
+				i.region.sqsDeleteMessage(i.InstanceId, OnDemand)
+			}
+			return nil
+		}
+
 		// In case we're not triggered by SQS event we generate such an event and send it to the queue.
 		// We want to delay the further below code for until we're processing it through the SQS queue,
 		// in order to avoid launching Spot instances too early and having them run outside their ASG
 		// for too long.
 		if len(a.config.sqsReceiptHandle) == 0 {
+			if a.config.DryRun {
+				a.config.plan.add(sqsSendMessageOnInstanceLaunch{
+					target{asg: i.asg, onDemandInstance: i}}.describe())
+				return nil
+			}
 			return i.region.sqsSendMessageOnInstanceLaunch(&i.asg.name, i.InstanceId, i.State.Name, "on-demand-instance-launch")
 		}
+
+		if a.config.DryRun {
+			return a.planNewOnDemandInstanceLaunch(r, i)
+		}
 		defer i.region.sqsDeleteMessage(i.InstanceId, OnDemand)
 
 		log.Printf("%s instance %s belongs to an enabled ASG and should be "+
@@ -482,7 +641,9 @@ func (a *AutoSpotting) handleNewOnDemandInstanceLaunch(r *region, i *instance) e
 			log.Println("Found unattached spot instance", spotInstanceID)
 		} else {
 			log.Printf("Attempting to launch spot replacement")
-			if spotInstanceID, err = i.launchSpotReplacement(); err != nil {
+			if spotInstanceID, err = i.launchSpotReplacement(); errors.Is(err, errReplacementInProgress) {
+				return nil
+			} else if err != nil {
 				log.Printf("%s Couldn't launch spot replacement for %s",
 					i.region.name, *i.InstanceId)
 				return err
@@ -504,7 +665,9 @@ func (a *AutoSpotting) handleNewOnDemandInstanceLaunch(r *region, i *instance) e
 			return err
 		}
 		spotInstance = r.instances.get(*spotInstanceID)
-		if _, err := spotInstance.swapWithGroupMember(i.asg); err != nil {
+		if _, err := spotInstance.swapWithGroupMember(i.asg); errors.Is(err, errReplacementInProgress) {
+			return nil
+		} else if err != nil {
 			log.Printf("%s, couldn't perform spot replacement of %s ",
 				i.region.name, *i.InstanceId)
 			return err
@@ -519,6 +682,25 @@ func (a *AutoSpotting) handleNewOnDemandInstanceLaunch(r *region, i *instance) e
 	return nil
 }
 
+// planNewOnDemandInstanceLaunch records into the dry-run plan the action that
+// would be taken for a newly launched on-demand instance
+func (a *AutoSpotting) planNewOnDemandInstanceLaunch(r *region, i *instance) error {
+	log.Println("Scanning instances in", r.name)
+	if err := r.scanInstances(); err != nil {
+		log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+	}
+
+	if spotInstance := i.asg.findUnattachedInstanceLaunchedForThisASG(); spotInstance != nil {
+		a.config.plan.add(swapSpotInstance{
+			target{asg: i.asg, spotInstance: spotInstance}}.describe())
+		return nil
+	}
+
+	a.config.plan.add(launchSpotReplacement{
+		target{onDemandInstance: i}}.describe())
+	return nil
+}
+
 func (a *AutoSpotting) handleNewSpotInstanceLaunch(r *region, i *instance) error {
 	log.Printf("%s Checking if %s is a spot instance that should be "+
 		"attached to any ASG", i.region.name, *i.InstanceId)
@@ -529,6 +711,13 @@ func (a *AutoSpotting) handleNewSpotInstanceLaunch(r *region, i *instance) error
 		return nil
 	}
 
+	if target := i.getReplacementTargetInstanceID(); target != nil &&
+		r.replacementIs(*target, replacementCompleted) {
+		log.Printf("%s Instance %s was launched for replacing %s, which was already replaced, skipping it",
+			i.region.name, *i.InstanceId, *target)
+		return nil
+	}
+
 	asgName := i.getReplacementTargetASGName()
 
 	asg := i.region.findEnabledASGByName(*asgName)
@@ -538,13 +727,21 @@ func (a *AutoSpotting) handleNewSpotInstanceLaunch(r *region, i *instance) error
 		return fmt.Errorf("region %s is missing asg data", i.region.name)
 	}
 
+	if a.config.DryRun {
+		a.config.plan.add(swapSpotInstance{
+			target{asg: asg, spotInstance: i}}.describe())
+		return nil
+	}
+
 	defer i.region.sqsDeleteMessage(i.InstanceId, Spot)
 
 	log.Printf("%s Found instance %s is not yet attached to its ASG, "+
 		"attempting to swap it against a running on-demand instance",
 		i.region.name, *i.InstanceId)
 
-	if _, err := i.swapWithGroupMember(asg); err != nil {
+	if _, err := i.swapWithGroupMember(asg); errors.Is(err, errReplacementInProgress) {
+		return nil
+	} else if err != nil {
 		log.Printf("%s, couldn't perform spot replacement of %s ",
 			i.region.name, *i.InstanceId)
 		return err
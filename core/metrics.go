@@ -0,0 +1,183 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	metricsNamespace = "autospotting"
+
+	// metricsPushJob is the job name used when pushing the metrics to a
+	// Prometheus Pushgateway
+	metricsPushJob = "autospotting"
+)
+
+// promMetrics stores the Prometheus metrics collected while running, in their
+// own registry so that only AutoSpotting metrics are exposed.
+var promMetrics = newMetrics()
+
+type metrics struct {
+	registry *prometheus.Registry
+
+	groupInstances      *prometheus.GaugeVec
+	hourlySavings       *prometheus.GaugeVec
+	actions             *prometheus.CounterVec
+	createFleetFailures *prometheus.CounterVec
+	drainSteps          *prometheus.CounterVec
+	apiLatency          *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+
+		groupInstances: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "group_running_instances",
+			Help:      "Number of running instances in the enabled AutoScaling groups, by lifecycle.",
+		}, []string{"account", "region", "autoscaling_group", "lifecycle"}),
+
+		hourlySavings: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "hourly_savings_dollars",
+			Help:      "Hourly savings of the spot instances launched by AutoSpotting.",
+		}, []string{"account", "region"}),
+
+		actions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "actions_total",
+			Help:      "Number of actions taken on the enabled AutoScaling groups, by type and result.",
+		}, []string{"account", "region", "action", "result"}),
+
+		createFleetFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "create_fleet_failures_total",
+			Help:      "Number of failures to launch spot instances using CreateFleet, by error code.",
+		}, []string{"region", "error_code"}),
+
+		drainSteps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "drain_steps_total",
+			Help:      "Number of drain steps run on interrupted spot instances, by step and result.",
+		}, []string{"region", "step", "result"}),
+
+		apiLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "aws_api_request_duration_seconds",
+			Help:      "Latency of the AWS API calls, by service and operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"service", "operation"}),
+	}
+
+	m.registry.MustRegister(
+		m.groupInstances,
+		m.hourlySavings,
+		m.actions,
+		m.createFleetFailures,
+		m.drainSteps,
+		m.apiLatency,
+	)
+	return m
+}
+
+// setGroupInstances records the number of running on-demand and spot instances
+// of a group. The account is empty for the account where AutoSpotting runs.
+func (m *metrics) setGroupInstances(account, region, group string, onDemand, total int64) {
+	m.groupInstances.WithLabelValues(account, region, group, OnDemand).Set(float64(onDemand))
+	m.groupInstances.WithLabelValues(account, region, group, Spot).Set(float64(total - onDemand))
+}
+
+func (m *metrics) setHourlySavings(account, region string, savings float64) {
+	m.hourlySavings.WithLabelValues(account, region).Set(savings)
+}
+
+// countAction records the action taken on a group, failed when any errors
+// were reported.
+func (m *metrics) countAction(account string, gr GroupReport) {
+	result := "success"
+	if len(gr.Errors) > 0 {
+		result = "failure"
+	}
+	m.actions.WithLabelValues(account, gr.Region, gr.Action, result).Inc()
+}
+
+// countCreateFleetError records a failed CreateFleet call, using the AWS error
+// code when available.
+func (m *metrics) countCreateFleetError(region string, err error) {
+	code := "Unknown"
+	if aerr, ok := err.(awserr.Error); ok {
+		code = aerr.Code()
+	}
+	m.createFleetFailures.WithLabelValues(region, code).Inc()
+}
+
+// countCreateFleetResponseErrors records the errors returned by CreateFleet
+// for the instance types or availability zones where it couldn't launch.
+func (m *metrics) countCreateFleetResponseErrors(region string, errs []*ec2.CreateFleetError) {
+	for _, e := range errs {
+		if e != nil && e.ErrorCode != nil {
+			m.createFleetFailures.WithLabelValues(region, *e.ErrorCode).Inc()
+		}
+	}
+}
+
+// countDrainStep records the outcome of a drain step run on an interrupted
+// spot instance.
+func (m *metrics) countDrainStep(region string, o drainOutcome) {
+	m.drainSteps.WithLabelValues(region, o.Step, o.Result).Inc()
+}
+
+// observeRequest is an AWS SDK request handler measuring the latency of the
+// API calls.
+func (m *metrics) observeRequest(r *request.Request) {
+	if r.Operation == nil {
+		return
+	}
+	m.apiLatency.WithLabelValues(r.ClientInfo.ServiceName, r.Operation.Name).
+		Observe(time.Since(r.AttemptTime).Seconds())
+}
+
+// instrumentSession makes all the clients created from the session report the
+// latency of their API calls.
+func instrumentSession(sess *session.Session) *session.Session {
+	sess.Handlers.Complete.PushBack(promMetrics.observeRequest)
+	return sess
+}
+
+// serveMetrics exposes the metrics on the /metrics path of the given address,
+// for long-running deployments scraped by Prometheus.
+func (m *metrics) serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+
+	log.Println("Serving Prometheus metrics on", address)
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			log.Println("Couldn't serve Prometheus metrics:", err.Error())
+		}
+	}()
+}
+
+// push sends the metrics to a Prometheus Pushgateway, for one-shot runs that
+// live too short to be scraped.
+func (m *metrics) push(url string) error {
+	if err := push.New(url, metricsPushJob).Gatherer(m.registry).Push(); err != nil {
+		log.Println("Couldn't push metrics to", url, err.Error())
+		return err
+	}
+	debug.Println("Pushed metrics to", url)
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics_setGroupInstances(t *testing.T) {
+	m := newMetrics()
+	m.setGroupInstances("", "us-east-1", "asg", 1, 4)
+
+	if got := testutil.ToFloat64(m.groupInstances.WithLabelValues("", "us-east-1", "asg", OnDemand)); got != 1 {
+		t.Errorf("on-demand instances = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.groupInstances.WithLabelValues("", "us-east-1", "asg", Spot)); got != 3 {
+		t.Errorf("spot instances = %v, want 3", got)
+	}
+}
+
+func TestMetrics_countAction(t *testing.T) {
+	m := newMetrics()
+	m.countAction("123456789012", GroupReport{Region: "us-east-1", Action: swapSpotInstanceAction})
+	m.countAction("123456789012", GroupReport{Region: "us-east-1", Action: swapSpotInstanceAction})
+	m.countAction("123456789012", GroupReport{Region: "us-east-1", Action: swapSpotInstanceAction, Errors: []string{"boom"}})
+
+	if got := testutil.ToFloat64(m.actions.WithLabelValues("123456789012", "us-east-1", swapSpotInstanceAction, "success")); got != 2 {
+		t.Errorf("successful actions = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.actions.WithLabelValues("123456789012", "us-east-1", swapSpotInstanceAction, "failure")); got != 1 {
+		t.Errorf("failed actions = %v, want 1", got)
+	}
+}
+
+func TestMetrics_countCreateFleetErrors(t *testing.T) {
+	m := newMetrics()
+	m.countCreateFleetError("us-east-1", awserr.New("UnauthorizedOperation", "denied", nil))
+	m.countCreateFleetError("us-east-1", errors.New("boom"))
+	m.countCreateFleetResponseErrors("us-east-1", []*ec2.CreateFleetError{
+		{ErrorCode: aws.String("InsufficientInstanceCapacity")},
+		{ErrorCode: aws.String("InsufficientInstanceCapacity")},
+		nil,
+	})
+
+	tests := []struct {
+		code string
+		want float64
+	}{
+		{code: "UnauthorizedOperation", want: 1},
+		{code: "Unknown", want: 1},
+		{code: "InsufficientInstanceCapacity", want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := testutil.ToFloat64(m.createFleetFailures.WithLabelValues("us-east-1", tt.code)); got != tt.want {
+				t.Errorf("CreateFleet failures = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
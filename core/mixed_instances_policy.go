@@ -0,0 +1,94 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"math"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// getLaunchTemplateSpecification returns the launch template used by the
+// group, either set directly on the group or on its mixed instances policy.
+// The launch templates set on individual overrides are not supported, the
+// replacement spot instances are always launched from the main one.
+func (a *autoScalingGroup) getLaunchTemplateSpecification() *autoscaling.LaunchTemplateSpecification {
+	if a.LaunchTemplate != nil {
+		return a.LaunchTemplate
+	}
+
+	if a.MixedInstancesPolicy != nil &&
+		a.MixedInstancesPolicy.LaunchTemplate != nil {
+		return a.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification
+	}
+	return nil
+}
+
+// getMixedInstancesPolicyInstanceTypes returns the instance types set on the
+// overrides of the group's mixed instances policy.
+func (a *autoScalingGroup) getMixedInstancesPolicyInstanceTypes() []string {
+	var instanceTypes []string
+
+	if a.MixedInstancesPolicy == nil ||
+		a.MixedInstancesPolicy.LaunchTemplate == nil {
+		return instanceTypes
+	}
+
+	for _, o := range a.MixedInstancesPolicy.LaunchTemplate.Overrides {
+		if o != nil && o.InstanceType != nil {
+			instanceTypes = append(instanceTypes, *o.InstanceType)
+		}
+	}
+	return instanceTypes
+}
+
+// getMixedInstancesPolicyMinOnDemand computes the number of on-demand
+// instances required by the instances distribution of the group's mixed
+// instances policy: the on-demand base capacity, plus the on-demand percentage
+// of the capacity above it, rounded up like AutoScaling does.
+func (a *autoScalingGroup) getMixedInstancesPolicyMinOnDemand() (int64, bool) {
+	if a.MixedInstancesPolicy == nil ||
+		a.MixedInstancesPolicy.InstancesDistribution == nil {
+		return DefaultMinOnDemandValue, false
+	}
+
+	distribution := a.MixedInstancesPolicy.InstancesDistribution
+
+	var base int64
+	if distribution.OnDemandBaseCapacity != nil {
+		base = *distribution.OnDemandBaseCapacity
+	}
+
+	// AutoScaling defaults to 100% on-demand above the base capacity
+	percentage := int64(100)
+	if distribution.OnDemandPercentageAboveBaseCapacity != nil {
+		percentage = *distribution.OnDemandPercentageAboveBaseCapacity
+	}
+
+	total := a.instances.count64()
+	if total <= base {
+		return total, true
+	}
+
+	aboveBase := math.Ceil(float64(total-base) * float64(percentage) / 100.0)
+	return base + int64(aboveBase), true
+}
+
+// loadMixedInstancesPolicyOnDemand raises the number of on-demand instances
+// kept in the group to the one required by its mixed instances policy.
+func (a *autoScalingGroup) loadMixedInstancesPolicyOnDemand() bool {
+	onDemand, found := a.getMixedInstancesPolicyMinOnDemand()
+	if !found {
+		return false
+	}
+
+	if onDemand > a.config.MinOnDemand {
+		log.Printf("Loaded MinOnDemand value to %d from the mixed instances policy of %s\n",
+			onDemand, a.name)
+		a.config.MinOnDemand = onDemand
+		return true
+	}
+	return false
+}
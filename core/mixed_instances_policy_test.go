@@ -0,0 +1,228 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func newMixedInstancesPolicy(base, percentage *int64, instanceTypes ...string) *autoscaling.MixedInstancesPolicy {
+	var overrides []*autoscaling.LaunchTemplateOverrides
+	for _, it := range instanceTypes {
+		overrides = append(overrides, &autoscaling.LaunchTemplateOverrides{
+			InstanceType: aws.String(it),
+		})
+	}
+
+	return &autoscaling.MixedInstancesPolicy{
+		InstancesDistribution: &autoscaling.InstancesDistribution{
+			OnDemandBaseCapacity:                base,
+			OnDemandPercentageAboveBaseCapacity: percentage,
+		},
+		LaunchTemplate: &autoscaling.LaunchTemplate{
+			LaunchTemplateSpecification: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateName: aws.String("lt-mixed"),
+				Version:            aws.String("3"),
+			},
+			Overrides: overrides,
+		},
+	}
+}
+
+func TestGetLaunchTemplateSpecification(t *testing.T) {
+	direct := &autoscaling.LaunchTemplateSpecification{
+		LaunchTemplateId: aws.String("lt-direct"),
+	}
+
+	tests := []struct {
+		name  string
+		group *autoscaling.Group
+		want  *autoscaling.LaunchTemplateSpecification
+	}{
+		{
+			name:  "launch configuration",
+			group: &autoscaling.Group{LaunchConfigurationName: aws.String("lc")},
+			want:  nil,
+		},
+		{
+			name:  "launch template",
+			group: &autoscaling.Group{LaunchTemplate: direct},
+			want:  direct,
+		},
+		{
+			name:  "mixed instances policy",
+			group: &autoscaling.Group{MixedInstancesPolicy: newMixedInstancesPolicy(nil, nil)},
+			want: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateName: aws.String("lt-mixed"),
+				Version:            aws.String("3"),
+			},
+		},
+		{
+			name: "mixed instances policy without launch template",
+			group: &autoscaling.Group{
+				MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{},
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{Group: tt.group}
+			if got := a.getLaunchTemplateSpecification(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getLaunchTemplateSpecification() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetMixedInstancesPolicyMinOnDemand(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    *autoscaling.MixedInstancesPolicy
+		instances int
+		want      int64
+		wantFound bool
+	}{
+		{
+			name:      "no mixed instances policy",
+			instances: 4,
+			want:      DefaultMinOnDemandValue,
+		},
+		{
+			name:      "all spot above the base capacity",
+			policy:    newMixedInstancesPolicy(aws.Int64(1), aws.Int64(0)),
+			instances: 4,
+			want:      1,
+			wantFound: true,
+		},
+		{
+			name:      "percentage above base capacity is rounded up",
+			policy:    newMixedInstancesPolicy(aws.Int64(2), aws.Int64(25)),
+			instances: 7,
+			want:      4,
+			wantFound: true,
+		},
+		{
+			name:      "fewer instances than the base capacity",
+			policy:    newMixedInstancesPolicy(aws.Int64(5), aws.Int64(0)),
+			instances: 3,
+			want:      3,
+			wantFound: true,
+		},
+		{
+			name:      "defaults to all on-demand",
+			policy:    newMixedInstancesPolicy(nil, nil),
+			instances: 3,
+			want:      3,
+			wantFound: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			im := makeInstances()
+			for i := 0; i < tt.instances; i++ {
+				im.add(&instance{Instance: &ec2.Instance{
+					InstanceId: aws.String(string(rune('a' + i))),
+				}})
+			}
+
+			a := &autoScalingGroup{
+				Group:     &autoscaling.Group{MixedInstancesPolicy: tt.policy},
+				instances: im,
+			}
+
+			got, found := a.getMixedInstancesPolicyMinOnDemand()
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("getMixedInstancesPolicyMinOnDemand() = %v, %v, want %v, %v",
+					got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestGetAllowedInstanceTypesWithMixedInstancesPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  string
+		tags     []*autoscaling.TagDescription
+		expected []string
+	}{
+		{
+			name:     "overrides take precedence over the global configuration",
+			allowed:  "c5.large",
+			expected: []string{"m5.large", "m5a.large"},
+		},
+		{
+			name:    "tags take precedence over the overrides",
+			allowed: "c5.large",
+			tags: []*autoscaling.TagDescription{
+				{
+					Key:   aws.String(AllowedInstanceTypesTag),
+					Value: aws.String("r5.large"),
+				},
+			},
+			expected: []string{"r5.large"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				region: &region{conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{AllowedInstanceTypes: tt.allowed},
+				}},
+				Group: &autoscaling.Group{
+					MixedInstancesPolicy: newMixedInstancesPolicy(nil, nil, "m5.large", "m5a.large"),
+					Tags:                 tt.tags,
+				},
+			}
+
+			if got := a.getAllowedInstanceTypes(&instance{}); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("getAllowedInstanceTypes() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDescribeLaunchTemplateVersionInput(t *testing.T) {
+	tests := []struct {
+		name string
+		lt   *autoscaling.LaunchTemplateSpecification
+		want *ec2.DescribeLaunchTemplateVersionsInput
+	}{
+		{
+			name: "by ID",
+			lt: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId:   aws.String("lt-id"),
+				LaunchTemplateName: aws.String("lt-name"),
+				Version:            aws.String("$Latest"),
+			},
+			want: &ec2.DescribeLaunchTemplateVersionsInput{
+				LaunchTemplateId: aws.String("lt-id"),
+				Versions:         []*string{aws.String("$Latest")},
+			},
+		},
+		{
+			name: "by name with the default version",
+			lt: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateName: aws.String("lt-name"),
+			},
+			want: &ec2.DescribeLaunchTemplateVersionsInput{
+				LaunchTemplateName: aws.String("lt-name"),
+				Versions:           []*string{aws.String("$Default")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describeLaunchTemplateVersionInput(tt.lt); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("describeLaunchTemplateVersionInput() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -7,14 +7,27 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 )
 
 func CheckErrors(t *testing.T, err error, expected error) {
@@ -71,18 +84,34 @@ type mockEC2 struct {
 	dto   *ec2.DeleteTagsOutput
 	dterr error
 
+	// Create Tags
+	cto   *ec2.CreateTagsOutput
+	cterr error
+
 	// DescribeLaunchTemplateVersionsOutput
 	dltvo   *ec2.DescribeLaunchTemplateVersionsOutput
 	dltverr error
 
 	// WaitUntilInstanceRunning error
 	wuirerr error
+
+	// DescribeInstanceTypesPages output
+	ditpo   []*ec2.DescribeInstanceTypesOutput
+	ditperr error
+
+	// DescribeSubnets output
+	dsno   *ec2.DescribeSubnetsOutput
+	dsnerr error
 }
 
 func (m mockEC2) CreateFleet(in *ec2.CreateFleetInput) (*ec2.CreateFleetOutput, error) {
 	return m.cfo, m.cferr
 }
 
+func (m mockEC2) DescribeSubnets(*ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	return m.dsno, m.dsnerr
+}
+
 func (m mockEC2) CreateLaunchTemplate(in *ec2.CreateLaunchTemplateInput) (*ec2.CreateLaunchTemplateOutput, error) {
 	return m.clto, m.clterr
 }
@@ -98,6 +127,13 @@ func (m mockEC2) DescribeSpotPriceHistoryPages(in *ec2.DescribeSpotPriceHistoryI
 	return m.dsphperr
 }
 
+func (m mockEC2) DescribeInstanceTypesPages(in *ec2.DescribeInstanceTypesInput, f func(*ec2.DescribeInstanceTypesOutput, bool) bool) error {
+	for i, page := range m.ditpo {
+		f(page, i == len(m.ditpo)-1)
+	}
+	return m.ditperr
+}
+
 func (m mockEC2) DescribeInstancesPages(in *ec2.DescribeInstancesInput, f func(*ec2.DescribeInstancesOutput, bool) bool) error {
 	f(m.dio, true)
 	return m.diperr
@@ -123,6 +159,10 @@ func (m mockEC2) DeleteTags(*ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error)
 	return m.dto, m.dterr
 }
 
+func (m mockEC2) CreateTags(*ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	return m.cto, m.cterr
+}
+
 func (m mockEC2) DescribeLaunchTemplateVersions(*ec2.DescribeLaunchTemplateVersionsInput) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
 	return m.dltvo, m.dltverr
 }
@@ -168,6 +208,14 @@ type mockASG struct {
 	// CreateOrUpdateTags
 	couto   *autoscaling.CreateOrUpdateTagsOutput
 	couterr error
+
+	// DeleteTags
+	dtgo   *autoscaling.DeleteTagsOutput
+	dtgerr error
+
+	// DescribeScalingActivities
+	dsao   *autoscaling.DescribeScalingActivitiesOutput
+	dsaerr error
 }
 
 func (m mockASG) DetachInstances(*autoscaling.DetachInstancesInput) (*autoscaling.DetachInstancesOutput, error) {
@@ -216,6 +264,14 @@ func (m mockASG) CreateOrUpdateTags(*autoscaling.CreateOrUpdateTagsInput) (*auto
 	return m.couto, m.couterr
 }
 
+func (m mockASG) DeleteTags(*autoscaling.DeleteTagsInput) (*autoscaling.DeleteTagsOutput, error) {
+	return m.dtgo, m.dtgerr
+}
+
+func (m mockASG) DescribeScalingActivities(*autoscaling.DescribeScalingActivitiesInput) (*autoscaling.DescribeScalingActivitiesOutput, error) {
+	return m.dsao, m.dsaerr
+}
+
 // All fields are composed of the abbreviation of their method
 // This is useful when methods are doing multiple calls to AWS API
 type mockCloudFormation struct {
@@ -250,6 +306,105 @@ func (m mockSQS) DeleteMessage(*sqs.DeleteMessageInput) (*sqs.DeleteMessageOutpu
 	return m.dmo, m.dmerr
 }
 
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockOrganizations struct {
+	organizationsiface.OrganizationsAPI
+	// ListAccountsForParentPages output, by parent ID
+	lafpo   map[string]*organizations.ListAccountsForParentOutput
+	lafperr error
+}
+
+func (m mockOrganizations) ListAccountsForParentPages(in *organizations.ListAccountsForParentInput,
+	fn func(*organizations.ListAccountsForParentOutput, bool) bool) error {
+	if m.lafperr != nil {
+		return m.lafperr
+	}
+	if out, found := m.lafpo[*in.ParentId]; found {
+		fn(out, true)
+	}
+	return nil
+}
+
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockELBV2 struct {
+	elbv2iface.ELBV2API
+	// DeregisterTargets
+	dto   *elbv2.DeregisterTargetsOutput
+	dterr error
+	// WaitUntilTargetDeregistered, blocking until the context is done when set
+	wutderr   error
+	wutdBlock bool
+}
+
+func (m mockELBV2) DeregisterTargetsWithContext(aws.Context, *elbv2.DeregisterTargetsInput, ...request.Option) (*elbv2.DeregisterTargetsOutput, error) {
+	return m.dto, m.dterr
+}
+
+func (m mockELBV2) WaitUntilTargetDeregisteredWithContext(ctx aws.Context, _ *elbv2.DescribeTargetHealthInput, _ ...request.WaiterOption) error {
+	if m.wutdBlock {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return m.wutderr
+}
+
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockSSM struct {
+	ssmiface.SSMAPI
+	// SendCommand
+	sco   *ssm.SendCommandOutput
+	scerr error
+	// WaitUntilCommandExecuted
+	wucerr error
+}
+
+func (m mockSSM) SendCommandWithContext(aws.Context, *ssm.SendCommandInput, ...request.Option) (*ssm.SendCommandOutput, error) {
+	return m.sco, m.scerr
+}
+
+func (m mockSSM) WaitUntilCommandExecutedWithContext(aws.Context, *ssm.GetCommandInvocationInput, ...request.WaiterOption) error {
+	return m.wucerr
+}
+
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockLambda struct {
+	lambdaiface.LambdaAPI
+	// Invoke
+	io   *lambda.InvokeOutput
+	ierr error
+}
+
+func (m mockLambda) InvokeWithContext(aws.Context, *lambda.InvokeInput, ...request.Option) (*lambda.InvokeOutput, error) {
+	return m.io, m.ierr
+}
+
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// PutItem
+	pierr error
+	// UpdateItem
+	uierr error
+	// GetItem
+	gio   *dynamodb.GetItemOutput
+	gierr error
+}
+
+func (m mockDynamoDB) PutItem(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	return &dynamodb.PutItemOutput{}, m.pierr
+}
+
+func (m mockDynamoDB) UpdateItem(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	return &dynamodb.UpdateItemOutput{}, m.uierr
+}
+
+func (m mockDynamoDB) GetItem(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return m.gio, m.gierr
+}
+
 // utility function for checking if error messages are matching
 func errorMatches(got error, wanted error) bool {
 	if got == nil {
@@ -0,0 +1,113 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// networkPerformance is the network bandwidth of an instance type, parsed
+// from descriptions such as "25 Gigabit", "Up to 10 Gigabit" or "Moderate".
+type networkPerformance struct {
+	gbps float64
+
+	// burstable instance types only reach their bandwidth for short periods
+	burstable bool
+}
+
+// The bandwidth of the older instance types, only described qualitatively.
+var namedNetworkPerformance = map[string]float64{
+	"very low":        0.05,
+	"low":             0.1,
+	"low to moderate": 0.3,
+	"moderate":        0.5,
+	"high":            1,
+}
+
+var networkPerformanceRegexp = regexp.MustCompile(`(?i)^(up to\s+)?(?:(\d+)x\s*)?(\d+(?:\.\d+)?)\s*gigabit`)
+
+// parseNetworkPerformance returns the bandwidth of the description, the zero
+// value being returned for the unknown ones.
+func parseNetworkPerformance(description string) networkPerformance {
+	description = strings.TrimSpace(description)
+
+	if gbps, found := namedNetworkPerformance[strings.ToLower(description)]; found {
+		return networkPerformance{gbps: gbps}
+	}
+
+	m := networkPerformanceRegexp.FindStringSubmatch(description)
+	if m == nil {
+		return networkPerformance{}
+	}
+
+	gbps, _ := strconv.ParseFloat(m[3], 64)
+	if m[2] != "" {
+		cards, _ := strconv.ParseFloat(m[2], 64)
+		gbps *= cards
+	}
+
+	return networkPerformance{gbps: gbps, burstable: m[1] != ""}
+}
+
+func (p networkPerformance) String() string {
+	s := strconv.FormatFloat(p.gbps, 'f', -1, 64) + " Gbps"
+	if p.burstable {
+		return "up to " + s
+	}
+	return s
+}
+
+func (p networkPerformance) known() bool {
+	return p.gbps > 0
+}
+
+// atLeast returns true when the bandwidth is at least as high as the other
+// one, a sustained bandwidth being better than the same burstable bandwidth.
+func (p networkPerformance) atLeast(other networkPerformance) bool {
+	if p.gbps != other.gbps {
+		return p.gbps > other.gbps
+	}
+	return !p.burstable || other.burstable
+}
+
+// networkInfo contains the networking capabilities of an instance type. The
+// EFA support and the network interface limits are only known when fetched
+// from the EC2 API, as marked by the detailed flag.
+type networkInfo struct {
+	performance  networkPerformance
+	enaSupported bool
+	efaSupported bool
+	maxENIs      int64
+	ipv4PerENI   int64
+	ipv6PerENI   int64
+	detailed     bool
+}
+
+// staticNetworkInfo returns the networking capabilities available in the
+// static instance type data, used when they couldn't be fetched from the API.
+func staticNetworkInfo(networkPerformanceDescription string, enhancedNetworking bool) networkInfo {
+	return networkInfo{
+		performance:  parseNetworkPerformance(networkPerformanceDescription),
+		enaSupported: enhancedNetworking,
+	}
+}
+
+// networkInfoFromAPI converts the networking capabilities described by the
+// EC2 API.
+func networkInfoFromAPI(n *ec2.NetworkInfo) networkInfo {
+	return networkInfo{
+		performance:  parseNetworkPerformance(aws.StringValue(n.NetworkPerformance)),
+		enaSupported: aws.StringValue(n.EnaSupport) != ec2.EnaSupportUnsupported,
+		efaSupported: aws.BoolValue(n.EfaSupported),
+		maxENIs:      aws.Int64Value(n.MaximumNetworkInterfaces),
+		ipv4PerENI:   aws.Int64Value(n.Ipv4AddressesPerInterface),
+		ipv6PerENI:   aws.Int64Value(n.Ipv6AddressesPerInterface),
+		detailed:     true,
+	}
+}
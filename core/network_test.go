@@ -0,0 +1,80 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+)
+
+func Test_parseNetworkPerformance(t *testing.T) {
+	tests := []struct {
+		description string
+		want        networkPerformance
+	}{
+		{description: "25 Gigabit", want: networkPerformance{gbps: 25}},
+		{description: "Up to 10 Gigabit", want: networkPerformance{gbps: 10, burstable: true}},
+		{description: "up to 12.5 gigabit", want: networkPerformance{gbps: 12.5, burstable: true}},
+		{description: "4x 100 Gigabit", want: networkPerformance{gbps: 400}},
+		{description: "Moderate", want: networkPerformance{gbps: 0.5}},
+		{description: "Low to Moderate", want: networkPerformance{gbps: 0.3}},
+		{description: "", want: networkPerformance{}},
+		{description: "Fast", want: networkPerformance{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			if got := parseNetworkPerformance(tt.description); got != tt.want {
+				t.Errorf("parseNetworkPerformance() = %+v, expected %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_networkPerformance_atLeast(t *testing.T) {
+	tests := []struct {
+		name  string
+		p     networkPerformance
+		other networkPerformance
+		want  bool
+	}{
+		{
+			name:  "higher bandwidth",
+			p:     networkPerformance{gbps: 25},
+			other: networkPerformance{gbps: 10},
+			want:  true,
+		},
+		{
+			name:  "lower bandwidth",
+			p:     networkPerformance{gbps: 10, burstable: true},
+			other: networkPerformance{gbps: 25},
+			want:  false,
+		},
+		{
+			name:  "burstable compared to the same sustained bandwidth",
+			p:     networkPerformance{gbps: 10, burstable: true},
+			other: networkPerformance{gbps: 10},
+			want:  false,
+		},
+		{
+			name:  "sustained compared to the same burstable bandwidth",
+			p:     networkPerformance{gbps: 10},
+			other: networkPerformance{gbps: 10, burstable: true},
+			want:  true,
+		},
+		{
+			name:  "same burstable bandwidth",
+			p:     networkPerformance{gbps: 10, burstable: true},
+			other: networkPerformance{gbps: 10, burstable: true},
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.atLeast(tt.other); got != tt.want {
+				t.Errorf("atLeast() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// PlanFormatText renders the dry-run plan as human-readable text
+	PlanFormatText = "text"
+
+	// PlanFormatJSON renders the dry-run plan as a JSON document
+	PlanFormatJSON = "json"
+)
+
+// plannedAction describes an action that AutoSpotting decided to take, without
+// necessarily having executed it. When running in dry-run mode these are
+// collected into the action plan instead of being executed.
+type plannedAction struct {
+	Region                 string   `json:"region"`
+	AutoScalingGroup       string   `json:"autoscaling_group,omitempty"`
+	Action                 string   `json:"action"`
+	InstanceID             string   `json:"instance_id,omitempty"`
+	InstanceType           string   `json:"instance_type,omitempty"`
+	AvailabilityZone       string   `json:"availability_zone,omitempty"`
+	TargetInstanceID       string   `json:"target_instance_id,omitempty"`
+	CandidateInstanceTypes []string `json:"candidate_instance_types,omitempty"`
+	Reason                 string   `json:"reason"`
+}
+
+// actionPlan collects the actions computed while running in dry-run mode. It
+// is shared by all the regions and groups processed concurrently.
+type actionPlan struct {
+	sync.Mutex
+	actions []plannedAction
+}
+
+func newActionPlan() *actionPlan {
+	return &actionPlan{}
+}
+
+func (p *actionPlan) add(pa plannedAction) {
+	p.Lock()
+	defer p.Unlock()
+	p.actions = append(p.actions, pa)
+}
+
+// sorted returns the planned actions ordered by region, group and instance, so
+// the output is stable regardless of the order in which they were computed.
+func (p *actionPlan) sorted() []plannedAction {
+	p.Lock()
+	defer p.Unlock()
+
+	actions := make([]plannedAction, len(p.actions))
+	copy(actions, p.actions)
+
+	sort.SliceStable(actions, func(i, j int) bool {
+		if actions[i].Region != actions[j].Region {
+			return actions[i].Region < actions[j].Region
+		}
+		if actions[i].AutoScalingGroup != actions[j].AutoScalingGroup {
+			return actions[i].AutoScalingGroup < actions[j].AutoScalingGroup
+		}
+		return actions[i].InstanceID < actions[j].InstanceID
+	})
+	return actions
+}
+
+// print renders the plan in the given format, which is either text or json.
+func (p *actionPlan) print(w io.Writer, format string) error {
+	actions := p.sorted()
+
+	if format == PlanFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Actions []plannedAction `json:"actions"`
+		}{Actions: actions})
+	}
+
+	fmt.Fprintln(w, "####### BEGIN DRY RUN PLAN #######")
+	for _, pa := range actions {
+		fmt.Fprintln(w, pa.String())
+	}
+	fmt.Fprintf(w, "####### END DRY RUN PLAN (%d actions) #######\n", len(actions))
+	return nil
+}
+
+func (pa plannedAction) String() string {
+	var sb strings.Builder
+
+	sb.WriteString(pa.Region)
+	if pa.AutoScalingGroup != "" {
+		sb.WriteString(" " + pa.AutoScalingGroup)
+	}
+	sb.WriteString(": " + pa.Action)
+
+	if pa.InstanceID != "" {
+		sb.WriteString(" " + pa.InstanceID)
+		if pa.InstanceType != "" || pa.AvailabilityZone != "" {
+			sb.WriteString(fmt.Sprintf(" (%s)",
+				strings.Trim(pa.InstanceType+" "+pa.AvailabilityZone, " ")))
+		}
+	}
+
+	if pa.TargetInstanceID != "" {
+		sb.WriteString(" replacing " + pa.TargetInstanceID)
+	}
+
+	if pa.Reason != "" {
+		sb.WriteString(" - " + pa.Reason)
+	}
+
+	if len(pa.CandidateInstanceTypes) > 0 {
+		sb.WriteString(", candidates: " + strings.Join(pa.CandidateInstanceTypes, ", "))
+	}
+	return sb.String()
+}
+
+// instanceAction builds a planned action for the given instance, filling in
+// its location details.
+func instanceAction(action string, i *instance, reason string) plannedAction {
+	pa := plannedAction{
+		Action: action,
+		Reason: reason,
+	}
+
+	if i == nil || i.Instance == nil {
+		return pa
+	}
+
+	if i.region != nil {
+		pa.Region = i.region.name
+	}
+	if i.asg != nil {
+		pa.AutoScalingGroup = i.asg.name
+	}
+	if i.InstanceId != nil {
+		pa.InstanceID = *i.InstanceId
+	}
+	if i.InstanceType != nil {
+		pa.InstanceType = *i.InstanceType
+	}
+	if i.Placement != nil && i.Placement.AvailabilityZone != nil {
+		pa.AvailabilityZone = *i.Placement.AvailabilityZone
+	}
+	return pa
+}
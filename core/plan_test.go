@@ -0,0 +1,241 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_actionPlan_print(t *testing.T) {
+	p := newActionPlan()
+	p.add(plannedAction{
+		Region:           "us-east-1",
+		AutoScalingGroup: "asg-b",
+		Action:           terminateUnneededSpotInstanceAction,
+		InstanceID:       "i-spot",
+		Reason:           "not needed",
+	})
+	p.add(plannedAction{
+		Region:                 "us-east-1",
+		AutoScalingGroup:       "asg-a",
+		Action:                 launchSpotReplacementAction,
+		InstanceID:             "i-ondemand",
+		InstanceType:           "m5.large",
+		AvailabilityZone:       "us-east-1a",
+		CandidateInstanceTypes: []string{"m5a.large", "m5.large"},
+		Reason:                 "cheaper",
+	})
+
+	tests := []struct {
+		name   string
+		format string
+		want   []string
+	}{
+		{
+			name:   "text",
+			format: PlanFormatText,
+			want: []string{
+				"####### BEGIN DRY RUN PLAN #######",
+				"us-east-1 asg-a: launch-spot-replacement i-ondemand (m5.large us-east-1a) - cheaper, candidates: m5a.large, m5.large",
+				"us-east-1 asg-b: terminate-unneeded-spot-instance i-spot - not needed",
+				"####### END DRY RUN PLAN (2 actions) #######",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := p.print(&buf, tt.format); err != nil {
+				t.Fatalf("print() error = %v", err)
+			}
+			got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("print() = \n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := p.print(&buf, PlanFormatJSON); err != nil {
+			t.Fatalf("print() error = %v", err)
+		}
+
+		var got struct {
+			Actions []plannedAction `json:"actions"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("couldn't parse the JSON plan: %v", err)
+		}
+		if len(got.Actions) != 2 || got.Actions[0].AutoScalingGroup != "asg-a" ||
+			got.Actions[1].InstanceID != "i-spot" {
+			t.Errorf("unexpected JSON plan %#v", got.Actions)
+		}
+	})
+}
+
+func Test_runer_describe(t *testing.T) {
+	r := &region{name: "us-east-1", conf: &Config{}}
+
+	asg := &autoScalingGroup{
+		name:   "asg-foo",
+		region: r,
+		Group:  &autoscaling.Group{},
+	}
+
+	spotInstance := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:   aws.String("i-spot"),
+			InstanceType: aws.String("m5.large"),
+			Placement: &ec2.Placement{
+				AvailabilityZone: aws.String("us-east-1a"),
+			},
+			Tags: []*ec2.Tag{
+				{
+					Key:   aws.String("launched-for-replacing-instance"),
+					Value: aws.String("i-ondemand"),
+				},
+			},
+		},
+		region: r,
+	}
+
+	tests := []struct {
+		name   string
+		action runer
+		want   plannedAction
+	}{
+		{
+			name:   "skip",
+			action: skipRun{reason: "outside-cron-schedule"},
+			want: plannedAction{
+				Action: skipRunAction,
+				Reason: "outside-cron-schedule",
+			},
+		},
+		{
+			name:   "terminate unneeded spot instance",
+			action: terminateUnneededSpotInstance{target{asg: asg, spotInstance: spotInstance}},
+			want: plannedAction{
+				Region:           "us-east-1",
+				AutoScalingGroup: "asg-foo",
+				Action:           terminateUnneededSpotInstanceAction,
+				InstanceID:       "i-spot",
+				InstanceType:     "m5.large",
+				AvailabilityZone: "us-east-1a",
+				Reason:           "unattached spot instance is no longer needed by the group",
+			},
+		},
+		{
+			name:   "swap spot instance",
+			action: swapSpotInstance{target{asg: asg, spotInstance: spotInstance}},
+			want: plannedAction{
+				Region:           "us-east-1",
+				AutoScalingGroup: "asg-foo",
+				Action:           swapSpotInstanceAction,
+				InstanceID:       "i-spot",
+				InstanceType:     "m5.large",
+				AvailabilityZone: "us-east-1a",
+				TargetInstanceID: "i-ondemand",
+				Reason:           "spot instance is ready to be attached to the group",
+			},
+		},
+		{
+			name:   "terminate spot instance without having any",
+			action: terminateSpotInstance{target{asg: asg, totalInstances: 1}},
+			want: plannedAction{
+				Region:           "us-east-1",
+				AutoScalingGroup: "asg-foo",
+				Action:           skipRunAction,
+				Reason:           "fewer than the required 0 on-demand instances are running, but no spot instance can be terminated",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.action.describe(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("describe() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_region_processEnabledAutoScalingGroups_dryRun(t *testing.T) {
+	cfg := &Config{
+		AutoScalingConfig: AutoScalingConfig{
+			CronSchedule:      DefaultCronSchedule,
+			CronScheduleState: "off",
+		},
+		DryRun: true,
+		plan:   newActionPlan(),
+	}
+
+	r := &region{
+		name: "us-east-1",
+		conf: cfg,
+		enabledASGs: []autoScalingGroup{
+			{
+				name:  "asg-foo",
+				Group: &autoscaling.Group{},
+			},
+		},
+		instances: makeInstancesWithCatalog(instanceMap{}),
+	}
+	r.enabledASGs[0].region = r
+
+	r.processEnabledAutoScalingGroups()
+
+	want := []plannedAction{
+		{
+			Region:           "us-east-1",
+			AutoScalingGroup: "asg-foo",
+			Action:           skipRunAction,
+			Reason:           "outside-cron-schedule",
+		},
+	}
+
+	if got := cfg.plan.sorted(); !reflect.DeepEqual(got, want) {
+		t.Errorf("plan = %#v, want %#v", got, want)
+	}
+}
+
+func Test_launchSpotReplacement_describe_keepsPrice(t *testing.T) {
+	r := &region{name: "us-east-1", conf: &Config{}}
+	asg := &autoScalingGroup{
+		name:   "asg-foo",
+		region: r,
+		Group:  &autoscaling.Group{},
+		config: AutoScalingConfig{OnDemandPriceMultiplier: 2},
+	}
+	i := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:   aws.String("i-ondemand"),
+			InstanceType: aws.String("m5.large"),
+			Placement: &ec2.Placement{
+				AvailabilityZone: aws.String("us-east-1a"),
+			},
+		},
+		typeInfo: instanceTypeInformation{
+			instanceType: "m5.large",
+			pricing:      prices{onDemand: 0.1},
+		},
+		price:  0.05,
+		region: r,
+		asg:    asg,
+	}
+
+	launchSpotReplacement{target{asg: asg, onDemandInstance: i}}.describe()
+
+	if i.price != 0.05 {
+		t.Errorf("describe() changed the instance price to %v, want 0.05", i.price)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+// Spot product descriptions of the supported platforms, as used when looking
+// up the spot price history.
+const (
+	linuxProductDescription   = DefaultSpotProductDescription
+	windowsProductDescription = "Windows (Amazon VPC)"
+	rhelProductDescription    = "Red Hat Enterprise Linux (Amazon VPC)"
+	suseProductDescription    = "SUSE Linux (Amazon VPC)"
+)
+
+// onDemandPrice returns the on-demand price from the pricing table matching the
+// given spot product description, defaulting to the Linux one.
+func onDemandPrice(rp ec2instancesinfo.RegionPrices, productDescription string) float64 {
+	switch strings.TrimSuffix(productDescription, " (Amazon VPC)") {
+	case "Windows":
+		return rp.MSWin.OnDemand
+	case "Red Hat Enterprise Linux":
+		return rp.RHEL.OnDemand
+	case "SUSE Linux":
+		return rp.SLES.OnDemand
+	default:
+		return rp.Linux.OnDemand
+	}
+}
+
+// imageProductDescription determines the spot product description matching
+// the platform of an AMI, from either its platform details or usage operation.
+// See https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/billing-info-fields.html
+func imageProductDescription(image *ec2.Image) (string, bool) {
+	if image == nil {
+		return "", false
+	}
+
+	details := aws.StringValue(image.PlatformDetails)
+	switch {
+	case strings.HasPrefix(details, "Windows"):
+		return windowsProductDescription, true
+	case strings.HasPrefix(details, "Red Hat Enterprise Linux"):
+		return rhelProductDescription, true
+	case strings.HasPrefix(details, "SUSE Linux"):
+		return suseProductDescription, true
+	case details == "Linux/UNIX":
+		return linuxProductDescription, true
+	}
+
+	switch aws.StringValue(image.UsageOperation) {
+	case "RunInstances":
+		return linuxProductDescription, true
+	case "RunInstances:0002", "RunInstances:0006", "RunInstances:0102",
+		"RunInstances:0202", "RunInstances:0800":
+		return windowsProductDescription, true
+	case "RunInstances:0010", "RunInstances:0014", "RunInstances:0110",
+		"RunInstances:0210", "RunInstances:1010", "RunInstances:1014",
+		"RunInstances:1110", "RunInstances:1210":
+		return rhelProductDescription, true
+	case "RunInstances:000g":
+		return suseProductDescription, true
+	}
+
+	if strings.EqualFold(aws.StringValue(image.Platform), ec2.PlatformValuesWindows) {
+		return windowsProductDescription, true
+	}
+	return "", false
+}
+
+// getImageProductDescription returns the spot product description of the given
+// AMI, describing it only once per run.
+func (r *region) getImageProductDescription(imageID string) (string, bool) {
+	r.platformMutex.Lock()
+	defer r.platformMutex.Unlock()
+
+	if r.imageProductDescriptions == nil {
+		r.imageProductDescriptions = make(map[string]string)
+	}
+
+	if pd, found := r.imageProductDescriptions[imageID]; found {
+		return pd, pd != ""
+	}
+
+	resp, err := r.services.ec2.DescribeImages(&ec2.DescribeImagesInput{
+		ImageIds: []*string{aws.String(imageID)},
+	})
+
+	// the AMI may have been deregistered in the meantime, remember it as
+	// unknown so we don't describe it again
+	var pd string
+	if err != nil {
+		log.Println(r.name, "Couldn't describe image", imageID, err.Error())
+	} else if resp != nil && len(resp.Images) > 0 {
+		pd, _ = imageProductDescription(resp.Images[0])
+	}
+
+	r.imageProductDescriptions[imageID] = pd
+	return pd, pd != ""
+}
+
+// getInstanceTypeInformation returns the instance type information for the
+// given spot product description. Only the globally configured one is loaded
+// upfront, the others are loaded on demand since they're only needed in
+// regions running such instances.
+func (r *region) getInstanceTypeInformation(productDescription string) map[string]instanceTypeInformation {
+	if productDescription == "" || productDescription == r.conf.SpotProductDescription {
+		return r.instanceTypeInformation
+	}
+
+	r.platformMutex.Lock()
+	defer r.platformMutex.Unlock()
+
+	if r.platformInstanceTypeInformation == nil {
+		r.platformInstanceTypeInformation = make(map[string]map[string]instanceTypeInformation)
+	}
+
+	if info, found := r.platformInstanceTypeInformation[productDescription]; found {
+		return info
+	}
+
+	if catalog, found := r.cachedInstanceTypeCatalog(productDescription); found {
+		r.platformInstanceTypeInformation[productDescription] = catalog.information
+		return catalog.information
+	}
+
+	log.Println("Scanning instance information for", productDescription, "in", r.name)
+	info := r.buildInstanceTypeInformation(r.conf, productDescription)
+	r.platformInstanceTypeInformation[productDescription] = info
+	return info
+}
+
+// getPlatformSpotProductDescription returns the spot product description set
+// on the group's tag, or otherwise the one detected from the platform of the
+// instance's AMI, when it's not Linux. When none is found the globally
+// configured spot product description should be used.
+func (i *instance) getPlatformSpotProductDescription() (string, bool) {
+	if i.asg != nil {
+		if tagValue := i.asg.getTagValue(SpotProductDescriptionTag); tagValue != nil {
+			return *tagValue, true
+		}
+	}
+
+	if i.ImageId != nil {
+		if pd, found := i.region.getImageProductDescription(*i.ImageId); found &&
+			pd != linuxProductDescription {
+			return pd, true
+		}
+	}
+
+	if strings.EqualFold(aws.StringValue(i.Platform), ec2.PlatformValuesWindows) {
+		return windowsProductDescription, true
+	}
+	return "", false
+}
+
+// determinePlatformTypeInformation sets the instance type information of the
+// instance from the pricing data of its platform, when it differs from the
+// globally configured one.
+func (i *instance) determinePlatformTypeInformation() {
+	pd, found := i.getPlatformSpotProductDescription()
+	if !found || pd == i.region.conf.SpotProductDescription {
+		return
+	}
+
+	debug.Println("Using", pd, "pricing for instance", *i.InstanceId)
+	i.typeInfo = i.region.getInstanceTypeInformation(pd)[*i.InstanceType]
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+func TestOnDemandPrice(t *testing.T) {
+	rp := ec2instancesinfo.RegionPrices{
+		Linux: ec2instancesinfo.Pricing{OnDemand: 0.1},
+		MSWin: ec2instancesinfo.Pricing{OnDemand: 0.2},
+		RHEL:  ec2instancesinfo.Pricing{OnDemand: 0.3},
+		SLES:  ec2instancesinfo.Pricing{OnDemand: 0.4},
+	}
+
+	tests := []struct {
+		productDescription string
+		want               float64
+	}{
+		{productDescription: "Linux/UNIX (Amazon VPC)", want: 0.1},
+		{productDescription: "Linux/UNIX", want: 0.1},
+		{productDescription: "Windows (Amazon VPC)", want: 0.2},
+		{productDescription: "Windows", want: 0.2},
+		{productDescription: "Red Hat Enterprise Linux (Amazon VPC)", want: 0.3},
+		{productDescription: "SUSE Linux", want: 0.4},
+		{productDescription: "unknown", want: 0.1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.productDescription, func(t *testing.T) {
+			if got := onDemandPrice(rp, tt.productDescription); got != tt.want {
+				t.Errorf("onDemandPrice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageProductDescription(t *testing.T) {
+	tests := []struct {
+		name      string
+		image     *ec2.Image
+		want      string
+		wantFound bool
+	}{
+		{
+			name: "missing image",
+		},
+		{
+			name:      "linux platform details",
+			image:     &ec2.Image{PlatformDetails: aws.String("Linux/UNIX")},
+			want:      linuxProductDescription,
+			wantFound: true,
+		},
+		{
+			name:      "windows with SQL server platform details",
+			image:     &ec2.Image{PlatformDetails: aws.String("Windows with SQL Server Standard")},
+			want:      windowsProductDescription,
+			wantFound: true,
+		},
+		{
+			name:      "RHEL platform details",
+			image:     &ec2.Image{PlatformDetails: aws.String("Red Hat Enterprise Linux")},
+			want:      rhelProductDescription,
+			wantFound: true,
+		},
+		{
+			name:      "SUSE usage operation",
+			image:     &ec2.Image{UsageOperation: aws.String("RunInstances:000g")},
+			want:      suseProductDescription,
+			wantFound: true,
+		},
+		{
+			name:      "windows platform",
+			image:     &ec2.Image{Platform: aws.String("windows")},
+			want:      windowsProductDescription,
+			wantFound: true,
+		},
+		{
+			name:  "unknown platform",
+			image: &ec2.Image{PlatformDetails: aws.String("Something else")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := imageProductDescription(tt.image)
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("imageProductDescription() = %v, %v, want %v, %v",
+					got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestGetPlatformSpotProductDescription(t *testing.T) {
+	tests := []struct {
+		name      string
+		inst      *ec2.Instance
+		asgTags   []*autoscaling.TagDescription
+		ec2       mockEC2
+		want      string
+		wantFound bool
+	}{
+		{
+			name: "group tag takes precedence",
+			inst: &ec2.Instance{ImageId: aws.String("ami-windows")},
+			asgTags: []*autoscaling.TagDescription{
+				{
+					Key:   aws.String(SpotProductDescriptionTag),
+					Value: aws.String(suseProductDescription),
+				},
+			},
+			ec2: mockEC2{damio: &ec2.DescribeImagesOutput{
+				Images: []*ec2.Image{{PlatformDetails: aws.String("Windows")}},
+			}},
+			want:      suseProductDescription,
+			wantFound: true,
+		},
+		{
+			name: "detected from the AMI",
+			inst: &ec2.Instance{ImageId: aws.String("ami-rhel")},
+			ec2: mockEC2{damio: &ec2.DescribeImagesOutput{
+				Images: []*ec2.Image{{UsageOperation: aws.String("RunInstances:0010")}},
+			}},
+			want:      rhelProductDescription,
+			wantFound: true,
+		},
+		{
+			name: "linux AMI uses the global configuration",
+			inst: &ec2.Instance{ImageId: aws.String("ami-linux")},
+			ec2: mockEC2{damio: &ec2.DescribeImagesOutput{
+				Images: []*ec2.Image{{PlatformDetails: aws.String("Linux/UNIX")}},
+			}},
+		},
+		{
+			name: "deregistered windows AMI",
+			inst: &ec2.Instance{
+				ImageId:  aws.String("ami-gone"),
+				Platform: aws.String("windows"),
+			},
+			ec2:       mockEC2{damierr: errors.New("InvalidAMIID.NotFound")},
+			want:      windowsProductDescription,
+			wantFound: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name:     "us-east-1",
+				services: connections{ec2: tt.ec2},
+			}
+			i := &instance{
+				Instance: tt.inst,
+				region:   r,
+				asg: &autoScalingGroup{
+					Group: &autoscaling.Group{Tags: tt.asgTags},
+				},
+			}
+
+			got, found := i.getPlatformSpotProductDescription()
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("getPlatformSpotProductDescription() = %v, %v, want %v, %v",
+					got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestDeterminePlatformTypeInformation(t *testing.T) {
+	cfg := &Config{
+		InstanceData: &ec2instancesinfo.InstanceData{
+			{
+				InstanceType: "m5.large",
+				Pricing: map[string]ec2instancesinfo.RegionPrices{
+					"us-east-1": {
+						Linux: ec2instancesinfo.Pricing{OnDemand: 0.096},
+						MSWin: ec2instancesinfo.Pricing{OnDemand: 0.188},
+					},
+				},
+			},
+		},
+		AutoScalingConfig: AutoScalingConfig{
+			OnDemandPriceMultiplier: 1,
+			SpotProductDescription:  DefaultSpotProductDescription,
+		},
+	}
+
+	r := &region{
+		name: "us-east-1",
+		conf: cfg,
+		services: connections{
+			ec2: mockEC2{
+				dsphpo: []*ec2.DescribeSpotPriceHistoryOutput{
+					{
+						SpotPriceHistory: []*ec2.SpotPrice{
+							{
+								AvailabilityZone:   aws.String("us-east-1a"),
+								InstanceType:       aws.String("m5.large"),
+								ProductDescription: aws.String(windowsProductDescription),
+								SpotPrice:          aws.String("0.11"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	r.determineInstanceTypeInformation(cfg)
+
+	i := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:   aws.String("i-windows"),
+			InstanceType: aws.String("m5.large"),
+			Platform:     aws.String("windows"),
+		},
+		region:   r,
+		typeInfo: r.instanceTypeInformation["m5.large"],
+	}
+
+	i.determinePlatformTypeInformation()
+
+	if i.typeInfo.pricing.onDemand != 0.188 {
+		t.Errorf("on-demand price = %v, want 0.188", i.typeInfo.pricing.onDemand)
+	}
+
+	if i.typeInfo.pricing.spot["us-east-1a"] != 0.11 {
+		t.Errorf("spot price = %v, want 0.11", i.typeInfo.pricing.spot["us-east-1a"])
+	}
+
+	if r.instanceTypeInformation["m5.large"].pricing.onDemand != 0.096 {
+		t.Errorf("default on-demand price = %v, want 0.096",
+			r.instanceTypeInformation["m5.large"].pricing.onDemand)
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// rate_limit.go contains the client-side rate limiting of the AWS API calls,
+// using a token bucket per service and kind of call for each session, so per
+// account and region. The buckets slow down after throttling errors and
+// gradually recover their rate after successful calls.
+
+// rateLimit is the sustained rate of calls per second and the burst of calls
+// allowed on top of it
+type rateLimit struct {
+	rate  float64
+	burst int
+}
+
+// serviceRateLimits are the limits of the calls describing and changing the
+// resources of a service
+type serviceRateLimits struct {
+	describe rateLimit
+	mutate   rateLimit
+}
+
+// apiRateLimits are the rate limits of the AWS services, keyed by their
+// endpoint IDs. The EC2 limits are the documented request token buckets of
+// the non-mutating and mutating actions, the others are conservative as
+// their limits aren't documented. The calls to the other services aren't
+// limited.
+var apiRateLimits = map[string]serviceRateLimits{
+	"ec2": {
+		describe: rateLimit{rate: 20, burst: 100},
+		mutate:   rateLimit{rate: 5, burst: 50},
+	},
+	"autoscaling": {
+		describe: rateLimit{rate: 10, burst: 40},
+		mutate:   rateLimit{rate: 5, burst: 20},
+	},
+	"cloudformation": {
+		describe: rateLimit{rate: 5, burst: 20},
+		mutate:   rateLimit{rate: 2, burst: 10},
+	},
+}
+
+const (
+	// throttledRateFactor is the factor applied to the rate of a bucket after
+	// a throttling error
+	throttledRateFactor = 0.5
+
+	// minRateFactor bounds how much the rate of a bucket is reduced
+	minRateFactor = 0.05
+
+	// rateRecoveryStep is the fraction of the limit added back to the rate of
+	// a bucket after every successful call
+	rateRecoveryStep = 0.02
+)
+
+// tokenBucket lets through a burst of calls and then the calls allowed by its
+// current rate, which is reduced after throttling errors.
+type tokenBucket struct {
+	sync.Mutex
+	limit  rateLimit
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit rateLimit) *tokenBucket {
+	return &tokenBucket{
+		limit:  limit,
+		rate:   limit.rate,
+		tokens: float64(limit.burst),
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens accumulated since the last call, must be called with
+// the lock held.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if burst := float64(b.limit.burst); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+// reserve takes a token from the bucket, returning how long the caller must
+// wait until the token becomes available.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// throttled slows down the bucket after a throttling error.
+func (b *tokenBucket) throttled(now time.Time) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill(now)
+	b.rate *= throttledRateFactor
+	if min := b.limit.rate * minRateFactor; b.rate < min {
+		b.rate = min
+	}
+}
+
+// succeeded gradually brings the rate of the bucket back to its limit.
+func (b *tokenBucket) succeeded(now time.Time) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.rate >= b.limit.rate {
+		return
+	}
+	b.refill(now)
+	b.rate += b.limit.rate * rateRecoveryStep
+	if b.rate > b.limit.rate {
+		b.rate = b.limit.rate
+	}
+}
+
+// apiRateLimiter holds the token buckets of the calls made using a session.
+type apiRateLimiter struct {
+	sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newAPIRateLimiter() *apiRateLimiter {
+	return &apiRateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// isDescribeCall returns whether the API call only reads resources.
+func isDescribeCall(operation string) bool {
+	for _, prefix := range []string{"Describe", "Get", "List"} {
+		if strings.HasPrefix(operation, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket returns the token bucket of the request, or nil when its service
+// isn't rate limited.
+func (l *apiRateLimiter) bucket(r *request.Request) *tokenBucket {
+	if r.Operation == nil {
+		return nil
+	}
+
+	limits, found := apiRateLimits[r.ClientInfo.ServiceName]
+	if !found {
+		return nil
+	}
+
+	key, limit := r.ClientInfo.ServiceName+"/mutate", limits.mutate
+	if isDescribeCall(r.Operation.Name) {
+		key, limit = r.ClientInfo.ServiceName+"/describe", limits.describe
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	b, found := l.buckets[key]
+	if !found {
+		b = newTokenBucket(limit)
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// wait is an AWS SDK Send handler delaying every attempt of the API calls
+// until the rate limit allows it.
+func (l *apiRateLimiter) wait(r *request.Request) {
+	b := l.bucket(r)
+	if b == nil {
+		return
+	}
+	if d := b.reserve(time.Now()); d > 0 {
+		debug.Printf("Delaying %s.%s by %s to stay within the API rate limit\n",
+			r.ClientInfo.ServiceName, r.Operation.Name, d)
+		if err := aws.SleepWithContext(r.Context(), d); err != nil {
+			r.Error = err
+		}
+	}
+}
+
+// observe is an AWS SDK Retry and Complete handler adjusting the rate of the
+// API calls based on their outcome.
+func (l *apiRateLimiter) observe(r *request.Request) {
+	b := l.bucket(r)
+	if b == nil {
+		return
+	}
+	switch {
+	case r.Error == nil:
+		b.succeeded(time.Now())
+	case classifyError(r.Error) == errorClassThrottling:
+		debug.Printf("Slowing down %s calls after being throttled\n", r.ClientInfo.ServiceName)
+		b.throttled(time.Now())
+	}
+}
+
+// limitSession makes all the clients created from the session respect the
+// rate limits of their AWS services.
+func limitSession(sess *session.Session) *session.Session {
+	l := newAPIRateLimiter()
+	sess.Handlers.Send.PushFront(l.wait)
+	sess.Handlers.Retry.PushFront(l.observe)
+	sess.Handlers.Complete.PushBack(func(r *request.Request) {
+		if r.Error == nil {
+			l.observe(r)
+		}
+	})
+	return sess
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+func TestTokenBucket_reserve(t *testing.T) {
+	b := newTokenBucket(rateLimit{rate: 10, burst: 2})
+	now := b.last
+
+	for n := 0; n < 2; n++ {
+		if d := b.reserve(now); d != 0 {
+			t.Fatalf("reserve() within the burst = %v, want 0", d)
+		}
+	}
+	if d := b.reserve(now); d != 100*time.Millisecond {
+		t.Errorf("reserve() after the burst = %v, want 100ms", d)
+	}
+	if d := b.reserve(now.Add(time.Second)); d != 0 {
+		t.Errorf("reserve() after refilling = %v, want 0", d)
+	}
+}
+
+func TestTokenBucket_throttling(t *testing.T) {
+	limit := rateLimit{rate: 20, burst: 1}
+	b := newTokenBucket(limit)
+	now := b.last
+
+	b.throttled(now)
+	if b.rate != 10 {
+		t.Errorf("rate after throttling = %v, want 10", b.rate)
+	}
+
+	for n := 0; n < 10; n++ {
+		b.throttled(now)
+	}
+	if want := limit.rate * minRateFactor; b.rate != want {
+		t.Errorf("rate after repeated throttling = %v, want %v", b.rate, want)
+	}
+
+	for n := 0; n < 100; n++ {
+		b.succeeded(now)
+	}
+	if b.rate != limit.rate {
+		t.Errorf("rate after recovering = %v, want %v", b.rate, limit.rate)
+	}
+}
+
+func testRequest(service, operation string) *request.Request {
+	return &request.Request{
+		ClientInfo: metadata.ClientInfo{ServiceName: service},
+		Operation:  &request.Operation{Name: operation},
+	}
+}
+
+func TestAPIRateLimiter_bucket(t *testing.T) {
+	l := newAPIRateLimiter()
+
+	tests := []struct {
+		name string
+		req  *request.Request
+		want *rateLimit
+	}{
+		{name: "EC2 describe call", req: testRequest("ec2", "DescribeInstances"), want: &rateLimit{rate: 20, burst: 100}},
+		{name: "EC2 mutating call", req: testRequest("ec2", "CreateFleet"), want: &rateLimit{rate: 5, burst: 50}},
+		{name: "AutoScaling mutating call", req: testRequest("autoscaling", "AttachInstances"), want: &rateLimit{rate: 5, burst: 20}},
+		{name: "service without limits", req: testRequest("sqs", "SendMessage")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := l.bucket(tt.req)
+			if (b == nil) != (tt.want == nil) || (b != nil && b.limit != *tt.want) {
+				t.Errorf("bucket() = %+v, want the limit %+v", b, tt.want)
+			}
+		})
+	}
+
+	if l.bucket(testRequest("ec2", "DescribeImages")) != l.bucket(testRequest("ec2", "DescribeInstances")) {
+		t.Error("bucket() returned different buckets for the same kind of calls")
+	}
+}
+
+func TestAPIRateLimiter_observe(t *testing.T) {
+	l := newAPIRateLimiter()
+	req := testRequest("autoscaling", "DescribeAutoScalingGroups")
+	b := l.bucket(req)
+
+	req.Error = awserr.New("Throttling", "Rate exceeded", nil)
+	l.observe(req)
+	if b.rate >= b.limit.rate {
+		t.Errorf("rate after throttling = %v, want it under %v", b.rate, b.limit.rate)
+	}
+
+	req.Error = awserr.New("ValidationError", "", nil)
+	throttledRate := b.rate
+	l.observe(req)
+	if b.rate != throttledRate {
+		t.Errorf("rate changed to %v after a validation error", b.rate)
+	}
+
+	req.Error = nil
+	l.observe(req)
+	if b.rate <= throttledRate {
+		t.Errorf("rate after a successful call = %v, want it over %v", b.rate, throttledRate)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// rebalance.go contains the launch-before-detach handling of the rebalance
+// recommendations, replacing the spot instances at an elevated risk of
+// interruption before they are taken out of their group.
+
+// handleRebalanceRecommendation replaces the instance which received a
+// rebalance recommendation, returning whether the instance still needs to be
+// detached because it couldn't be replaced.
+func (a *AutoSpotting) handleRebalanceRecommendation(regionName string, instanceID string) (bool, error) {
+	_, i, err := a.scanEventInstance(regionName, instanceID)
+	if err != nil {
+		return true, err
+	}
+
+	if !i.belongsToEnabledASG() {
+		return true, fmt.Errorf("instance %s doesn't belong to an enabled group", instanceID)
+	}
+
+	return i.replaceBeforeDetach()
+}
+
+// replaceBeforeDetach replaces the instance using launchBeforeDetach,
+// returning whether it still needs to be detached. That's only the case when
+// its replacement couldn't be attached to the group, unless the instance is
+// already being replaced after an earlier or overlapping recommendation,
+// which mustn't be disrupted by detaching it.
+func (i *instance) replaceBeforeDetach() (bool, error) {
+	attached, err := i.launchBeforeDetach()
+	if errors.Is(err, errReplacementInProgress) {
+		log.Printf("%s Instance %s is already being replaced, leaving it in the group",
+			i.region.name, *i.InstanceId)
+		return false, nil
+	}
+	return err != nil && !attached, err
+}
+
+// launchBeforeDetach launches a spot replacement for the instance in another
+// capacity pool, attaches it to the group and only then terminates the
+// instance, running its termination lifecycle hooks, so that the capacity of
+// the group never drops below the desired capacity. It returns whether the
+// replacement was attached to the group.
+func (i *instance) launchBeforeDetach() (bool, error) {
+	asg := i.asg
+
+	pool := i.capacityPool()
+	log.Printf("%s Launching a spot replacement for %s outside the %s capacity pool",
+		i.region.name, *i.InstanceId, pool)
+
+	spotInstanceID, err := i.launchSpotReplacement(pool)
+	if err != nil {
+		log.Printf("%s Couldn't launch spot replacement for %s: %s",
+			i.region.name, *i.InstanceId, err.Error())
+		return false, err
+	}
+
+	asg.suspendProcesses()
+	defer asg.resumeProcesses()
+
+	desiredCapacity, maxSize := *asg.DesiredCapacity, *asg.MaxSize
+
+	// temporarily increase AutoScaling group in case the desired capacity reaches the max size,
+	// otherwise attachSpotInstance might fail
+	if desiredCapacity == maxSize {
+		log.Println(asg.name, "Temporarily increasing MaxSize")
+		asg.setAutoScalingMaxSize(maxSize + 1)
+		defer asg.setAutoScalingMaxSize(maxSize)
+	}
+
+	log.Printf("Attaching spot instance %s to the group %s",
+		*spotInstanceID, asg.name)
+
+	if err := asg.attachSpotInstance(*spotInstanceID, true); err != nil {
+		log.Printf("Spot instance %s couldn't be attached to the group %s, terminating it...",
+			*spotInstanceID, asg.name)
+
+		if _, err := i.region.services.ec2.TerminateInstances(&ec2.TerminateInstancesInput{
+			InstanceIds: []*string{spotInstanceID},
+		}); err != nil {
+			log.Printf("Issue while terminating %s: %s", *spotInstanceID, err.Error())
+		}
+		i.region.recordReplacement(*i.InstanceId, replacementFailed, *spotInstanceID, err.Error())
+		return false, err
+	}
+	i.region.recordReplacement(*i.InstanceId, replacementAttached, *spotInstanceID, "")
+
+	log.Printf("Terminating instance %s from the group %s, replaced by %s",
+		*i.InstanceId, asg.name, *spotInstanceID)
+
+	if err := asg.terminateInstanceInAutoScalingGroup(i.InstanceId, false, true); err != nil {
+		log.Printf("Instance %s couldn't be terminated after attaching its replacement %s",
+			*i.InstanceId, *spotInstanceID)
+		i.region.recordReplacement(*i.InstanceId, replacementFailed, *spotInstanceID, err.Error())
+		return true, err
+	}
+	i.region.recordReplacement(*i.InstanceId, replacementCompleted, *spotInstanceID, "")
+
+	return true, nil
+}
+
+// capacityPool is a spot capacity pool, made of the instances of a type in an
+// availability zone, which share the same interruption risk.
+type capacityPool struct {
+	instanceType     string
+	availabilityZone string
+}
+
+func (p capacityPool) String() string {
+	return p.instanceType + " " + p.availabilityZone
+}
+
+// capacityPool returns the capacity pool the instance is running in.
+func (i *instance) capacityPool() capacityPool {
+	return capacityPool{
+		instanceType:     aws.StringValue(i.InstanceType),
+		availabilityZone: aws.StringValue(i.Placement.AvailabilityZone),
+	}
+}
+
+// excludeCapacityPools removes the given capacity pools from the fleet input.
+// The instance types excluded in the availability zone of the instance are
+// launched in the other availability zones of its group instead, where their
+// capacity pools aren't at risk.
+func (i *instance) excludeCapacityPools(cfi *ec2.CreateFleetInput, excludedPools []capacityPool) error {
+	excluded := make(map[capacityPool]bool)
+	for _, p := range excludedPools {
+		excluded[p] = true
+	}
+
+	currentAZ := aws.StringValue(i.Placement.AvailabilityZone)
+
+	var otherSubnets map[string]*string
+	var configs []*ec2.FleetLaunchTemplateConfigRequest
+
+	for _, ltc := range cfi.LaunchTemplateConfigs {
+		var overrides []*ec2.FleetLaunchTemplateOverridesRequest
+
+		for _, o := range ltc.Overrides {
+			instanceType := aws.StringValue(o.InstanceType)
+			if !excluded[capacityPool{instanceType, currentAZ}] {
+				overrides = append(overrides, o)
+				continue
+			}
+
+			if otherSubnets == nil {
+				otherSubnets = i.asg.otherAvailabilityZoneSubnets(currentAZ)
+			}
+
+			for _, az := range sortedAvailabilityZones(otherSubnets) {
+				if excluded[capacityPool{instanceType, az}] {
+					continue
+				}
+				debug.Println("Moving", instanceType, "from", currentAZ, "to", az)
+				override := *o
+				if subnet := otherSubnets[az]; subnet != nil {
+					override.SubnetId = subnet
+				} else {
+					override.AvailabilityZone = aws.String(az)
+				}
+				overrides = append(overrides, &override)
+			}
+		}
+
+		if len(overrides) > 0 {
+			ltc.Overrides = overrides
+			configs = append(configs, ltc)
+		}
+	}
+
+	if len(configs) == 0 {
+		return fmt.Errorf("no capacity pools left for %s after excluding %v",
+			*i.InstanceId, excludedPools)
+	}
+	cfi.LaunchTemplateConfigs = configs
+	return nil
+}
+
+// otherAvailabilityZoneSubnets returns a subnet of the group for each of its
+// availability zones except the given one, keyed by availability zone. The
+// subnets are nil for the groups launching instances outside a VPC.
+func (a *autoScalingGroup) otherAvailabilityZoneSubnets(excludedAZ string) map[string]*string {
+	result := make(map[string]*string)
+
+	if a.VPCZoneIdentifier == nil || *a.VPCZoneIdentifier == "" {
+		for _, az := range a.AvailabilityZones {
+			if *az != excludedAZ {
+				result[*az] = nil
+			}
+		}
+		return result
+	}
+
+	var subnetIDs []*string
+	for _, id := range strings.Split(*a.VPCZoneIdentifier, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			subnetIDs = append(subnetIDs, aws.String(id))
+		}
+	}
+
+	resp, err := a.region.services.ec2.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		SubnetIds: subnetIDs,
+	})
+	if err != nil {
+		log.Println(a.region.name, a.name, "Couldn't describe the subnets of the group:", err.Error())
+		return result
+	}
+
+	for _, subnet := range resp.Subnets {
+		az := aws.StringValue(subnet.AvailabilityZone)
+		if _, found := result[az]; !found && az != excludedAZ {
+			result[az] = subnet.SubnetId
+		}
+	}
+	return result
+}
+
+func sortedAvailabilityZones(subnets map[string]*string) []string {
+	var azs []string
+	for az := range subnets {
+		azs = append(azs, az)
+	}
+	sort.Strings(azs)
+	return azs
+}
@@ -0,0 +1,334 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_instance_excludeCapacityPools(t *testing.T) {
+	subnets := &ec2.DescribeSubnetsOutput{
+		Subnets: []*ec2.Subnet{
+			{SubnetId: aws.String("subnet-a"), AvailabilityZone: aws.String("us-east-1a")},
+			{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("us-east-1b")},
+			{SubnetId: aws.String("subnet-c"), AvailabilityZone: aws.String("us-east-1c")},
+		},
+	}
+
+	type pool struct {
+		instanceType string
+		subnet       string
+		az           string
+	}
+
+	tests := []struct {
+		name     string
+		group    *autoscaling.Group
+		ec2      mockEC2
+		excluded []capacityPool
+		want     []pool
+		wantErr  bool
+	}{
+		{
+			name:     "same type launched in the other availability zones",
+			group:    &autoscaling.Group{VPCZoneIdentifier: aws.String("subnet-a,subnet-b,subnet-c")},
+			ec2:      mockEC2{dsno: subnets},
+			excluded: []capacityPool{{"m5.large", "us-east-1a"}},
+			want: []pool{
+				{instanceType: "m5.large", subnet: "subnet-b"},
+				{instanceType: "m5.large", subnet: "subnet-c"},
+				{instanceType: "c5.large"},
+			},
+		},
+		{
+			name:     "other at-risk pools of the same type skipped",
+			group:    &autoscaling.Group{VPCZoneIdentifier: aws.String("subnet-a,subnet-b,subnet-c")},
+			ec2:      mockEC2{dsno: subnets},
+			excluded: []capacityPool{{"m5.large", "us-east-1a"}, {"m5.large", "us-east-1c"}},
+			want: []pool{
+				{instanceType: "m5.large", subnet: "subnet-b"},
+				{instanceType: "c5.large"},
+			},
+		},
+		{
+			name:     "pools of other availability zones aren't excluded",
+			group:    &autoscaling.Group{VPCZoneIdentifier: aws.String("subnet-a,subnet-b")},
+			ec2:      mockEC2{dsno: subnets},
+			excluded: []capacityPool{{"c5.large", "us-east-1b"}},
+			want: []pool{
+				{instanceType: "m5.large"},
+				{instanceType: "c5.large"},
+			},
+		},
+		{
+			name: "groups outside a VPC",
+			group: &autoscaling.Group{
+				AvailabilityZones: []*string{aws.String("us-east-1a"), aws.String("us-east-1b")},
+			},
+			excluded: []capacityPool{{"m5.large", "us-east-1a"}},
+			want: []pool{
+				{instanceType: "m5.large", az: "us-east-1b"},
+				{instanceType: "c5.large"},
+			},
+		},
+		{
+			name:     "subnets can't be described",
+			group:    &autoscaling.Group{VPCZoneIdentifier: aws.String("subnet-a,subnet-b")},
+			ec2:      mockEC2{dsnerr: errors.New("denied")},
+			excluded: []capacityPool{{"m5.large", "us-east-1a"}, {"c5.large", "us-east-1a"}},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{name: "us-east-1", services: connections{ec2: tt.ec2}}
+			i := &instance{
+				Instance: &ec2.Instance{
+					InstanceId:   aws.String("i-spot"),
+					InstanceType: aws.String("m5.large"),
+					SubnetId:     aws.String("subnet-a"),
+					Placement:    &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				},
+				region: r,
+				asg:    &autoScalingGroup{name: "asg", Group: tt.group, region: r},
+			}
+			cfi := i.createFleetInput(aws.String("lt"), aws.StringSlice([]string{"m5.large", "c5.large"}))
+
+			err := i.excludeCapacityPools(cfi, tt.excluded)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("excludeCapacityPools() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var got []pool
+			for _, o := range cfi.LaunchTemplateConfigs[0].Overrides {
+				got = append(got, pool{
+					instanceType: aws.StringValue(o.InstanceType),
+					subnet:       aws.StringValue(o.SubnetId),
+					az:           aws.StringValue(o.AvailabilityZone),
+				})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("excludeCapacityPools() overrides = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// recordingEC2 records the EC2 calls made while replacing an instance.
+type recordingEC2 struct {
+	mockEC2
+	calls *[]string
+}
+
+func (m *recordingEC2) CreateFleet(in *ec2.CreateFleetInput) (*ec2.CreateFleetOutput, error) {
+	*m.calls = append(*m.calls, "CreateFleet")
+	return m.mockEC2.CreateFleet(in)
+}
+
+func (m *recordingEC2) TerminateInstances(in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	*m.calls = append(*m.calls, "TerminateInstances "+*in.InstanceIds[0])
+	return m.mockEC2.TerminateInstances(in)
+}
+
+// recordingASG records the AutoScaling calls made while replacing an
+// instance, in the same list as the EC2 calls.
+type recordingASG struct {
+	mockASG
+	calls *[]string
+}
+
+func (m *recordingASG) SuspendProcesses(*autoscaling.ScalingProcessQuery) (*autoscaling.SuspendProcessesOutput, error) {
+	*m.calls = append(*m.calls, "SuspendProcesses")
+	return &autoscaling.SuspendProcessesOutput{}, nil
+}
+
+func (m *recordingASG) ResumeProcesses(*autoscaling.ScalingProcessQuery) (*autoscaling.ResumeProcessesOutput, error) {
+	*m.calls = append(*m.calls, "ResumeProcesses")
+	return &autoscaling.ResumeProcessesOutput{}, nil
+}
+
+func (m *recordingASG) AttachInstances(in *autoscaling.AttachInstancesInput) (*autoscaling.AttachInstancesOutput, error) {
+	*m.calls = append(*m.calls, "AttachInstances "+*in.InstanceIds[0])
+	return m.mockASG.AttachInstances(in)
+}
+
+func (m *recordingASG) DetachInstances(in *autoscaling.DetachInstancesInput) (*autoscaling.DetachInstancesOutput, error) {
+	*m.calls = append(*m.calls, "DetachInstances "+*in.InstanceIds[0])
+	return m.mockASG.DetachInstances(in)
+}
+
+func (m *recordingASG) TerminateInstanceInAutoScalingGroup(in *autoscaling.TerminateInstanceInAutoScalingGroupInput) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+	*m.calls = append(*m.calls, "TerminateInstanceInAutoScalingGroup "+*in.InstanceId)
+	return m.mockASG.TerminateInstanceInAutoScalingGroup(in)
+}
+
+func Test_instance_replaceBeforeDetach(t *testing.T) {
+	fleet := &ec2.CreateFleetOutput{
+		Instances: []*ec2.CreateFleetInstance{
+			{InstanceIds: []*string{aws.String("i-spot")}},
+		},
+	}
+	inService := &autoscaling.DescribeAutoScalingInstancesOutput{
+		AutoScalingInstances: []*autoscaling.InstanceDetails{
+			{LifecycleState: aws.String("InService")},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		ec2        mockEC2
+		asg        mockASG
+		claimed    bool
+		wantDetach bool
+		wantErr    error
+		wantState  replacementState
+		wantCalls  []string
+	}{
+		{
+			name: "replacement attached before terminating the instance",
+			ec2:  mockEC2{cfo: fleet},
+			asg:  mockASG{dasio: inService, dlho: &autoscaling.DescribeLifecycleHooksOutput{}},
+			wantCalls: []string{
+				"CreateFleet",
+				"SuspendProcesses",
+				"AttachInstances i-spot",
+				"TerminateInstanceInAutoScalingGroup i-rebalance",
+				"ResumeProcesses",
+			},
+			wantState: replacementCompleted,
+		},
+		{
+			name:       "replacement terminated when it can't be attached",
+			ec2:        mockEC2{cfo: fleet},
+			asg:        mockASG{aierr: errors.New("attach failed")},
+			wantDetach: true,
+			wantErr:    errors.New("AttachInstances"),
+			wantCalls: []string{
+				"CreateFleet",
+				"SuspendProcesses",
+				"AttachInstances i-spot",
+				"TerminateInstances i-spot",
+				"ResumeProcesses",
+			},
+			wantState: replacementFailed,
+		},
+		{
+			name:       "detached when no replacement can be launched",
+			ec2:        mockEC2{cferr: errors.New("InsufficientInstanceCapacity")},
+			wantDetach: true,
+			wantErr:    errors.New("CreateFleet"),
+			wantCalls:  []string{"CreateFleet"},
+			wantState:  replacementFailed,
+		},
+		{
+			name:      "left in the group while already being replaced",
+			ec2:       mockEC2{cfo: fleet},
+			claimed:   true,
+			wantState: replacementRequested,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.ec2.damio = &ec2.DescribeImagesOutput{}
+			var calls []string
+			ec2Svc := &recordingEC2{mockEC2: tt.ec2, calls: &calls}
+			asgSvc := &recordingASG{mockASG: tt.asg, calls: &calls}
+			store := newMemoryReplacementStore()
+			if tt.claimed {
+				store.claim(replacementRecord{InstanceID: "i-rebalance"})
+			}
+
+			i := rebalancedInstance(ec2Svc, asgSvc, store)
+			detach, err := i.replaceBeforeDetach()
+
+			if detach != tt.wantDetach {
+				t.Errorf("replaceBeforeDetach() detach = %v, want %v", detach, tt.wantDetach)
+			}
+			if (err == nil) != (tt.wantErr == nil) ||
+				(err != nil && !strings.Contains(err.Error(), tt.wantErr.Error())) {
+				t.Errorf("replaceBeforeDetach() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if len(calls) != len(tt.wantCalls) || (len(calls) > 0 && !reflect.DeepEqual(calls, tt.wantCalls)) {
+				t.Errorf("replaceBeforeDetach() made the calls %v, want %v", calls, tt.wantCalls)
+			}
+
+			if rec, _ := store.get("i-rebalance"); rec == nil || rec.State != tt.wantState {
+				t.Errorf("replaceBeforeDetach() recorded %+v, want %s", rec, tt.wantState)
+			}
+		})
+	}
+}
+
+// rebalancedInstance returns a spot instance which received a rebalance
+// recommendation, in a group using the given services.
+func rebalancedInstance(ec2Svc *recordingEC2, asgSvc *recordingASG, store replacementStore) *instance {
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{replacements: store},
+		instanceTypeInformation: map[string]instanceTypeInformation{
+			"m5.large": {
+				instanceType:        "m5.large",
+				PhysicalProcessor:   "Intel",
+				vCPU:                2,
+				memory:              8,
+				virtualizationTypes: []string{"HVM"},
+				pricing: prices{
+					onDemand: 0.1,
+					spot:     map[string]float64{"us-east-1a": 0.03, "us-east-1b": 0.03},
+				},
+			},
+			"m5a.large": {
+				instanceType:        "m5a.large",
+				PhysicalProcessor:   "AMD",
+				vCPU:                2,
+				memory:              8,
+				virtualizationTypes: []string{"HVM"},
+				pricing: prices{
+					onDemand: 0.09,
+					spot:     map[string]float64{"us-east-1a": 0.04, "us-east-1b": 0.04},
+				},
+			},
+		},
+		services: connections{ec2: ec2Svc, autoScaling: asgSvc},
+	}
+
+	asg := &autoScalingGroup{
+		name: "web",
+		Group: &autoscaling.Group{
+			AutoScalingGroupName: aws.String("web"),
+			DesiredCapacity:      aws.Int64(2),
+			MaxSize:              aws.Int64(4),
+			AvailabilityZones:    []*string{aws.String("us-east-1a"), aws.String("us-east-1b")},
+		},
+		config: AutoScalingConfig{OnDemandPriceMultiplier: 1},
+		region: r,
+	}
+
+	return &instance{
+		Instance: &ec2.Instance{
+			InstanceId:         aws.String("i-rebalance"),
+			InstanceType:       aws.String("m5.large"),
+			InstanceLifecycle:  aws.String(Spot),
+			VirtualizationType: aws.String("hvm"),
+			Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			State:              &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		},
+		typeInfo: r.instanceTypeInformation["m5.large"],
+		asg:      asg,
+		region:   r,
+	}
+}
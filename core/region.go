@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"log"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -41,12 +40,34 @@ type region struct {
 
 	tagsToFilterASGsBy []Tag
 
-	wg sync.WaitGroup
+	// The key in this map is the spot product description, it only stores the
+	// information for the platforms other than the globally configured one.
+	platformInstanceTypeInformation map[string]map[string]instanceTypeInformation
+
+	// The spot product descriptions of the AMIs, the key is the image ID
+	imageProductDescriptions map[string]string
+
+	platformMutex sync.Mutex
+
+	// The instance type details only available from the API, the key is the
+	// instance type
+	instanceTypeDetails map[string]instanceTypeDetails
+}
+
+// newRegion returns a region processed using the given configuration, which
+// connects to the account of the role set in the configuration, if any.
+func newRegion(name string, cfg *Config) *region {
+	return &region{
+		name:     name,
+		conf:     cfg,
+		services: connections{roleARN: cfg.roleARN},
+	}
 }
 
 type prices struct {
 	onDemand     float64
 	spot         spotPriceMap
+	spotStats    spotPriceStatsMap
 	ebsSurcharge float64
 	premium      float64
 }
@@ -86,8 +107,15 @@ func (r *region) processRegion() {
 	// setup the filters for asg matching
 	r.setupAsgFilters()
 
+	// the instances detached after interruption warnings no longer belong to
+	// any group, so they're terminated regardless of the enabled groups
+	r.terminateScheduledInstances(time.Now())
+
 	log.Println("Scanning for enabled AutoScaling groups in ", r.name)
-	r.scanForEnabledAutoScalingGroups()
+	if err := r.scanForEnabledAutoScalingGroups(); err != nil {
+		r.conf.report.addRegionError(r.name, err)
+		return
+	}
 
 	// only process further the region if there are any enabled autoscaling groups
 	// within it
@@ -99,6 +127,7 @@ func (r *region) processRegion() {
 		err := r.scanInstances()
 		if err != nil {
 			log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+			r.conf.report.addRegionError(r.name, err)
 		}
 
 		log.Println("Processing enabled AutoScaling groups in", r.name)
@@ -218,8 +247,32 @@ func (r *region) addInstance(inst *ec2.Instance) {
 }
 
 func (r *region) determineInstanceTypeInformation(cfg *Config) {
+	r.platformMutex.Lock()
+	r.platformInstanceTypeInformation = nil
+	r.imageProductDescriptions = nil
+	r.platformMutex.Unlock()
+
+	if catalog, found := r.cachedInstanceTypeCatalog(cfg.SpotProductDescription); found {
+		log.Println("Using the cached instance type information in", r.name)
+		r.instanceTypeDetails = catalog.details
+		r.instanceTypeInformation = catalog.information
+		return
+	}
 
-	r.instanceTypeInformation = make(map[string]instanceTypeInformation)
+	r.instanceTypeDetails = r.describeInstanceTypes()
+	r.instanceTypeInformation = r.buildInstanceTypeInformation(cfg, cfg.SpotProductDescription)
+}
+
+// buildInstanceTypeInformation loads the specs and pricing information of all
+// the instance types available in the region, priced for the given spot
+// product description.
+func (r *region) buildInstanceTypeInformation(cfg *Config, productDescription string) map[string]instanceTypeInformation {
+
+	typeInformation := make(map[string]instanceTypeInformation)
+
+	if cfg.InstanceData == nil {
+		return typeInformation
+	}
 
 	var info instanceTypeInformation
 
@@ -228,8 +281,9 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 		var price prices
 
 		// populate on-demand information
-		price.onDemand = it.Pricing[r.name].Linux.OnDemand * cfg.OnDemandPriceMultiplier
+		price.onDemand = onDemandPrice(it.Pricing[r.name], productDescription) * cfg.OnDemandPriceMultiplier
 		price.spot = make(spotPriceMap)
+		price.spotStats = make(spotPriceStatsMap)
 		price.ebsSurcharge = it.Pricing[r.name].EBSSurcharge
 		price.premium = r.conf.SpotProductPremium
 
@@ -251,60 +305,72 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 				EBSThroughput:       it.EBSThroughput,
 			}
 
+			if details, found := r.instanceTypeDetails[it.InstanceType]; found {
+				info.network = details.network
+				info.accelerators = details.accelerators
+			} else {
+				info.network = staticNetworkInfo(it.NetworkPerformance, it.EnhancedNetworking)
+			}
+
 			if it.Storage != nil {
 				info.hasInstanceStore = true
 				info.instanceStoreDeviceSize = it.Storage.Size
 				info.instanceStoreDeviceCount = it.Storage.Devices
 				info.instanceStoreIsSSD = it.Storage.SSD
 			}
-			r.instanceTypeInformation[it.InstanceType] = info
+			typeInformation[it.InstanceType] = info
 		}
 	}
 	// this is safe to do once outside of the loop because the call will only
 	// return entries about the available instance types, so no invalid instance
 	// types would be returned
 
-	if err := r.requestSpotPrices(); err != nil {
+	if err := r.requestSpotPrices(productDescription, typeInformation); err != nil {
 		log.Println(err.Error())
+		// not cached, so that the spot prices are fetched again next time
+		return typeInformation
 	}
 
+	r.cacheInstanceTypeCatalog(productDescription, typeInformation)
+	return typeInformation
 }
 
-func (r *region) requestSpotPrices() error {
+func (r *region) requestSpotPrices(productDescription string, typeInformation map[string]instanceTypeInformation) error {
 
 	s := spotPrices{conn: r.services}
 
-	// Retrieve all current spot prices from the current region.
-	// TODO: add support for other OSes
-	err := s.fetch(r.conf.SpotProductDescription, 0, nil, nil)
+	// Retrieve all current spot prices from the current region, along with
+	// their history when computing the price volatility.
+	window := r.conf.SpotPriceHistoryWindow
 
-	if err != nil {
-		return errors.New("Couldn't fetch spot prices in " + r.name)
+	if cached, found := r.loadSpotPrices(productDescription); found {
+		log.Println("Using the cached spot prices in", r.name)
+		s.data = cached
+	} else {
+		if err := s.fetch(productDescription, window, nil, nil); err != nil {
+			return errors.New("Couldn't fetch spot prices in " + r.name)
+		}
+		r.saveSpotPrices(productDescription, s.data)
 	}
 
 	// log.Println("Spot Price list in ", r.name, ":\n", s.data)
 
-	for _, priceInfo := range s.data {
-
-		instType, az := *priceInfo.InstanceType, *priceInfo.AvailabilityZone
+	now := time.Now()
+	for pool, points := range s.pools() {
 
-		// failure to parse this means that the instance is not available on the
-		// spot market
-		price, err := strconv.ParseFloat(*priceInfo.SpotPrice, 64)
-		if err != nil {
-			debug.Println(r.name, "Instance type ", instType,
-				"is not available on the spot market")
-			continue
-		}
+		instType, az := pool.instanceType, pool.availabilityZone
 
-		if r.instanceTypeInformation[instType].pricing.spot == nil {
+		if typeInformation[instType].pricing.spot == nil {
 			debug.Println(r.name, "Instance data missing for", instType, "in", az,
 				"skipping because this region is currently not supported")
 			continue
 		}
 
-		r.instanceTypeInformation[instType].pricing.spot[az] = price
+		typeInformation[instType].pricing.spot[az] = latestPrice(points)
 
+		if window > 0 {
+			typeInformation[instType].pricing.spotStats[az] = computeSpotPriceStats(points, window, now)
+		}
 	}
 
 	return nil
@@ -352,6 +418,9 @@ func getTagValueFromASGWithMatchingTag(asg *autoscaling.Group, tagToMatch Tag) *
 	return nil
 }
 
+// stackStatusUnknown is the status of the stacks which couldn't be described
+const stackStatusUnknown = "UNKNOWN"
+
 func (r *region) isStackUpdating(stackName *string) (string, bool) {
 	stackCompleteStatuses := map[string]struct{}{
 		"CREATE_IN_PROGRESS":       {}, // allow replacing instances from brand new ASGs
@@ -365,9 +434,19 @@ func (r *region) isStackUpdating(stackName *string) (string, bool) {
 		StackName: stackName,
 	}
 
-	if output, err := svc.DescribeStacks(&input); err != nil {
+	output, err := svc.DescribeStacks(&input)
+	if err != nil {
+		err = newAWSError("DescribeStacks", err)
 		log.Println("Failed to describe stack", *stackName, "with error:", err.Error())
-	} else {
+		// the stack may still be updating when it couldn't be described
+		// because of throttling, so its groups are left for the next run
+		if defaultRetryPolicy.retryable(classifyError(err)) {
+			return stackStatusUnknown, true
+		}
+		return "", false
+	}
+
+	if len(output.Stacks) > 0 {
 		stackStatus := output.Stacks[0].StackStatus
 		if _, exists := stackCompleteStatuses[*stackStatus]; !exists {
 			return *stackStatus, true
@@ -388,12 +467,6 @@ func (r *region) findMatchingASGsInPageOfResults(groups []*autoscaling.Group,
 	for _, group := range groups {
 		asgName := *group.AutoScalingGroupName
 
-		if group.MixedInstancesPolicy != nil {
-			debug.Printf("Skipping group %s because it's using a mixed instances policy",
-				asgName)
-			continue
-		}
-
 		groupMatchesExpectedTags := isASGWithMatchingTags(group, tagsToMatch)
 		// Go lacks a logical XOR operator, this is the equivalent to that logical
 		// expression. The goal is to add the matching ASGs when running in opt-in
@@ -426,7 +499,7 @@ func (r *region) findMatchingASGsInPageOfResults(groups []*autoscaling.Group,
 	return asgs
 }
 
-func (r *region) scanForEnabledAutoScalingGroups() {
+func (r *region) scanForEnabledAutoScalingGroups() error {
 
 	svc := r.services.autoScaling
 
@@ -445,7 +518,7 @@ func (r *region) scanForEnabledAutoScalingGroups() {
 	if err != nil {
 		log.Println("Failed to describe AutoScalingGroups in", r.name, err.Error())
 	}
-
+	return err
 }
 
 func (r *region) hasEnabledAutoScalingGroups() bool {
@@ -455,19 +528,49 @@ func (r *region) hasEnabledAutoScalingGroups() bool {
 }
 
 func (r *region) processEnabledAutoScalingGroups() {
-	for _, asg := range r.enabledASGs {
+	runConcurrently(len(r.enabledASGs), r.conf.GroupConcurrency, func(i int) {
+		a := r.enabledASGs[i]
 
 		// Pass default configs to the group
-		asg.config = r.conf.AutoScalingConfig
+		a.config = *a.defaults()
+
+		configErrors := a.checkConfigTags()
+		action := a.cronEventAction()
+		onDemand, total := a.alreadyRunningInstanceCount(false, nil)
+		promMetrics.setGroupInstances(r.conf.accountID, r.name, a.name, onDemand, total)
+		if r.conf.DryRun {
+			r.planAction(&a, action)
+		} else {
+			gr := action.run()
+			gr.ConfigErrors = configErrors
+			r.reportAction(&a, gr)
+		}
+	})
+}
+
+// planAction records the action computed for a group into the dry-run plan
+// instead of executing it.
+func (r *region) planAction(a *autoScalingGroup, action runer) {
+	pa := action.describe()
+	if pa.Region == "" {
+		pa.Region = r.name
+	}
+	if pa.AutoScalingGroup == "" {
+		pa.AutoScalingGroup = a.name
+	}
+	log.Println(r.name, a.name, "Dry run, planned action:", pa.String())
+	r.conf.plan.add(pa)
+}
 
-		r.wg.Add(1)
-		go func(a autoScalingGroup) {
-			action := a.cronEventAction()
-			action.run()
-			r.wg.Done()
-		}(asg)
+// reportAction records the outcome of the action taken on a group into the
+// run report.
+func (r *region) reportAction(a *autoScalingGroup, gr GroupReport) {
+	gr.Region, gr.AutoScalingGroup = r.name, a.name
+	if len(gr.Errors) > 0 {
+		log.Println(r.name, a.name, "Failed to", gr.Action, gr.Errors)
 	}
-	r.wg.Wait()
+	r.conf.report.addGroup(gr)
+	promMetrics.countAction(r.conf.accountID, gr)
 }
 
 func (r *region) findEnabledASGByName(name string) *autoScalingGroup {
@@ -552,6 +655,7 @@ func (r *region) calculateSavings() float64 {
 	for inst := range r.instances.instances() {
 
 		if inst.isSpot() && inst.isLaunchedByAutoSpotting() {
+			inst.determinePlatformTypeInformation()
 			is := inst.getSavings()
 			log.Printf("Found AutoSpotting instance %s(%s) in %s with hourly savings %f\n",
 				*inst.InstanceId, *inst.InstanceType, r.name, is)
@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -592,8 +593,8 @@ func TestFilterAsgs(t *testing.T) {
 			want: nullSlice,
 		},
 		{
-			name: "Test skipping execution against mixed groups",
-			want: []string{"asg1"},
+			name: "Test processing mixed groups",
+			want: []string{"asg1", "asg2"},
 			tregion: &region{
 				tagsToFilterASGsBy: []Tag{{Key: "spot-enabled", Value: "true"}},
 				conf:               &Config{},
@@ -692,6 +693,28 @@ func TestIsStackUpdating(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "Stack can't be described because of throttling",
+			region: &region{
+				services: connections{
+					cloudFormation: mockCloudFormation{
+						dserr: awserr.New("Throttling", "Rate exceeded", nil),
+					},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "Stack doesn't exist anymore",
+			region: &region{
+				services: connections{
+					cloudFormation: mockCloudFormation{
+						dserr: awserr.New("ValidationError", "Stack with id dummyStackName does not exist", nil),
+					},
+				},
+			},
+			want: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,286 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReportToStdout is the report file name used for writing the run report to
+// the standard output
+const ReportToStdout = "-"
+
+// Reason codes explaining why an action was taken on a group
+const (
+	reasonOutsideCronSchedule       = "outside-cron-schedule"
+	reasonNoInstancesToReplace      = "no-instances-to-replace"
+	reasonSpotInstanceNotReady      = "spot-instance-not-ready"
+	reasonTooFewOnDemandInstances   = "too-few-on-demand-instances"
+	reasonOnDemandInstanceToSpot    = "on-demand-instance-replaceable"
+	reasonSpotInstanceNotNeeded     = "spot-instance-not-needed"
+	reasonSpotInstanceReady         = "spot-instance-ready"
+	reasonDelegatedToSQS            = "delegated-to-sqs"
+	reasonNoSpotInstanceToTerminate = "no-spot-instance-to-terminate"
+	reasonReplacementInProgress     = "replacement-in-progress"
+	reasonSpotInterruptionWarning   = "spot-interruption-warning"
+	reasonRebalanceRecommendation   = "rebalance-recommendation"
+)
+
+// GroupReport is the outcome of processing an AutoScaling group during a cron
+// run, or of handling an instance event.
+type GroupReport struct {
+	Region           string   `json:"region"`
+	AutoScalingGroup string   `json:"autoscaling_group"`
+	Action           string   `json:"action"`
+	InstanceIDs      []string `json:"instance_ids,omitempty"`
+	Reason           string   `json:"reason"`
+	// Change in the hourly savings caused by the action, negative when a spot
+	// instance was replaced by an on-demand one.
+	SavingsDelta float64  `json:"hourly_savings_delta"`
+	Errors       []string `json:"errors,omitempty"`
+	// Classes of the AWS errors, such as throttling or capacity, telling why
+	// the action failed.
+	ErrorClasses []string `json:"error_classes,omitempty"`
+	// Invalid or unknown configuration tags found on the group, which were
+	// ignored in favor of the default configuration.
+	ConfigErrors []string `json:"config_errors,omitempty"`
+	// Outcomes of the drain steps run on an interrupted spot instance before
+	// taking it out of the group.
+	DrainSteps []drainOutcome `json:"drain_steps,omitempty"`
+}
+
+// RegionReport is the outcome of processing a region during a cron run.
+type RegionReport struct {
+	Region        string   `json:"region"`
+	HourlySavings float64  `json:"hourly_savings"`
+	Errors        []string `json:"errors,omitempty"`
+	ErrorClasses  []string `json:"error_classes,omitempty"`
+}
+
+// RunReport is the machine-readable report of a cron run, or of the action
+// taken on a spot instance event, replacing the free-form final recap
+// previously only available in the logs. When running
+// against multiple accounts, each of them gets its own report listed under
+// Accounts, and the total savings add up the savings of all accounts.
+type RunReport struct {
+	sync.Mutex
+
+	Account            string         `json:"account,omitempty"`
+	StartTime          time.Time      `json:"start_time"`
+	EndTime            time.Time      `json:"end_time"`
+	TotalHourlySavings float64        `json:"total_hourly_savings"`
+	Regions            []RegionReport `json:"regions"`
+	Groups             []GroupReport  `json:"groups"`
+	Accounts           []*RunReport   `json:"accounts,omitempty"`
+	Errors             []string       `json:"errors,omitempty"`
+}
+
+func newRunReport() *RunReport {
+	return &RunReport{
+		StartTime: time.Now().UTC(),
+		Regions:   []RegionReport{},
+		Groups:    []GroupReport{},
+	}
+}
+
+func (r *RunReport) addGroup(gr GroupReport) {
+	r.Lock()
+	defer r.Unlock()
+	r.Groups = append(r.Groups, gr)
+}
+
+// addError records the error which made the action fail.
+func (gr *GroupReport) addError(err error) {
+	gr.Errors = append(gr.Errors, err.Error())
+	gr.ErrorClasses = appendErrorClass(gr.ErrorClasses, err)
+}
+
+func (r *RunReport) addRegionSavings(region string, savings float64) {
+	r.Lock()
+	defer r.Unlock()
+	r.regionReport(region).HourlySavings += savings
+	r.TotalHourlySavings += savings
+}
+
+func (r *RunReport) addRegionError(region string, err error) {
+	r.Lock()
+	defer r.Unlock()
+	rr := r.regionReport(region)
+	rr.Errors = append(rr.Errors, err.Error())
+	rr.ErrorClasses = appendErrorClass(rr.ErrorClasses, err)
+}
+
+func (r *RunReport) addAccount(ar *RunReport) {
+	r.Lock()
+	defer r.Unlock()
+	r.Accounts = append(r.Accounts, ar)
+}
+
+func (r *RunReport) addError(err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// regionReport returns the report of the given region, creating it if needed.
+// Must be called with the lock held.
+func (r *RunReport) regionReport(region string) *RegionReport {
+	for i := range r.Regions {
+		if r.Regions[i].Region == region {
+			return &r.Regions[i]
+		}
+	}
+	r.Regions = append(r.Regions, RegionReport{Region: region})
+	return &r.Regions[len(r.Regions)-1]
+}
+
+// failedRegions returns the sorted list of regions which encountered errors,
+// either while being processed or while taking action on their groups.
+func (r *RunReport) failedRegions() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	failed := map[string]bool{}
+	for _, rr := range r.Regions {
+		if len(rr.Errors) > 0 {
+			failed[rr.Region] = true
+		}
+	}
+	for _, gr := range r.Groups {
+		if len(gr.Errors) > 0 {
+			failed[gr.Region] = true
+		}
+	}
+
+	var regions []string
+	for region := range failed {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// err returns an error when the run failed globally or in any of the regions
+// or accounts
+func (r *RunReport) err() error {
+	if failed := r.failedRegions(); len(failed) > 0 {
+		return fmt.Errorf("run failed in regions: %s", strings.Join(failed, ", "))
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if len(r.Errors) > 0 {
+		return fmt.Errorf("run failed: %s", strings.Join(r.Errors, "; "))
+	}
+
+	var failed []string
+	for _, ar := range r.Accounts {
+		if err := ar.err(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%s)", ar.Account, err.Error()))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("run failed in accounts: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// finish marks the end of the run and sorts the entries, so the output is
+// stable regardless of the order in which the regions were processed.
+func (r *RunReport) finish() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.EndTime = time.Now().UTC()
+
+	for _, ar := range r.Accounts {
+		ar.finish()
+		r.TotalHourlySavings += ar.TotalHourlySavings
+	}
+	sort.SliceStable(r.Accounts, func(i, j int) bool {
+		return r.Accounts[i].Account < r.Accounts[j].Account
+	})
+
+	sort.SliceStable(r.Regions, func(i, j int) bool {
+		return r.Regions[i].Region < r.Regions[j].Region
+	})
+	sort.SliceStable(r.Groups, func(i, j int) bool {
+		if r.Groups[i].Region != r.Groups[j].Region {
+			return r.Groups[i].Region < r.Groups[j].Region
+		}
+		return r.Groups[i].AutoScalingGroup < r.Groups[j].AutoScalingGroup
+	})
+}
+
+func (r *RunReport) writeJSON(w io.Writer) error {
+	r.Lock()
+	defer r.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// write saves the report as JSON into the given file, or to the standard
+// output when the file name is "-".
+func (r *RunReport) write(fileName string) error {
+	if fileName == ReportToStdout {
+		return r.writeJSON(os.Stdout)
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		log.Println("Couldn't create the report file:", err.Error())
+		return err
+	}
+	defer f.Close()
+
+	return r.writeJSON(f)
+}
+
+// logRecap logs a human-readable summary of the actions taken by the run.
+func (r *RunReport) logRecap() {
+	r.Lock()
+	defer r.Unlock()
+
+	log.Println("####### BEGIN FINAL RECAP #######")
+	r.logEntries("")
+	for _, ar := range r.Accounts {
+		ar.Lock()
+		ar.logEntries(ar.Account + " ")
+		ar.Unlock()
+	}
+	log.Println("####### END FINAL RECAP #######")
+}
+
+// logEntries logs the actions and errors of the report, prefixed by the given
+// string. Must be called with the lock held.
+func (r *RunReport) logEntries(prefix string) {
+	for _, gr := range r.Groups {
+		for _, e := range gr.ConfigErrors {
+			log.Printf("%s%s %s invalid configuration tag %s\n", prefix, gr.Region, gr.AutoScalingGroup, e)
+		}
+		if gr.Action == skipRunAction {
+			continue
+		}
+		log.Printf("%s%s %s %s %s [%s] %v\n", prefix, gr.Region, gr.AutoScalingGroup,
+			gr.Action, strings.Join(gr.InstanceIDs, ","), gr.Reason, gr.Errors)
+	}
+	for _, rr := range r.Regions {
+		for _, e := range rr.Errors {
+			log.Printf("%s%s error: %s\n", prefix, rr.Region, e)
+		}
+	}
+	for _, e := range r.Errors {
+		log.Printf("%serror: %s\n", prefix, e)
+	}
+}
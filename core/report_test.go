@@ -0,0 +1,209 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestRunReport_err(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(r *RunReport)
+		wantErr string
+	}{
+		{
+			name: "successful run",
+			prepare: func(r *RunReport) {
+				r.addRegionSavings("us-east-1", 1.5)
+				r.addGroup(GroupReport{Region: "us-east-1", AutoScalingGroup: "asg", Action: skipRunAction})
+			},
+		},
+		{
+			name: "failed group action",
+			prepare: func(r *RunReport) {
+				r.addGroup(GroupReport{Region: "us-east-1", AutoScalingGroup: "asg", Errors: []string{"boom"}})
+				r.addGroup(GroupReport{Region: "eu-west-1", AutoScalingGroup: "asg"})
+			},
+			wantErr: "run failed in regions: us-east-1",
+		},
+		{
+			name: "failed regions",
+			prepare: func(r *RunReport) {
+				r.addRegionError("us-west-2", errors.New("describe failed"))
+				r.addRegionError("eu-west-1", errors.New("scan failed"))
+			},
+			wantErr: "run failed in regions: eu-west-1, us-west-2",
+		},
+		{
+			name: "global failure",
+			prepare: func(r *RunReport) {
+				r.addError(errors.New("no regions"))
+			},
+			wantErr: "run failed: no regions",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRunReport()
+			tt.prepare(r)
+
+			err := r.err()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("err() = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("err() = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunReport_writeJSON(t *testing.T) {
+	r := newRunReport()
+	r.addRegionSavings("us-east-1", 0.5)
+	r.addRegionSavings("eu-west-1", 0.25)
+	r.addRegionSavings("us-east-1", 0.5)
+	r.addGroup(GroupReport{
+		Region:           "us-east-1",
+		AutoScalingGroup: "b",
+		Action:           swapSpotInstanceAction,
+		InstanceIDs:      []string{"i-spot", "i-ondemand"},
+		Reason:           reasonSpotInstanceReady,
+		SavingsDelta:     0.1,
+	})
+	r.addGroup(GroupReport{
+		Region:           "eu-west-1",
+		AutoScalingGroup: "a",
+		Action:           skipRunAction,
+		Reason:           reasonOutsideCronSchedule,
+	})
+	r.finish()
+
+	var buf bytes.Buffer
+	if err := r.writeJSON(&buf); err != nil {
+		t.Fatalf("writeJSON() error = %v", err)
+	}
+
+	var got RunReport
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("couldn't parse the JSON report: %v", err)
+	}
+
+	wantRegions := []RegionReport{
+		{Region: "eu-west-1", HourlySavings: 0.25},
+		{Region: "us-east-1", HourlySavings: 1},
+	}
+	if !reflect.DeepEqual(got.Regions, wantRegions) {
+		t.Errorf("regions = %#v, want %#v", got.Regions, wantRegions)
+	}
+
+	if got.TotalHourlySavings != 1.25 {
+		t.Errorf("total savings = %f, want 1.25", got.TotalHourlySavings)
+	}
+
+	if len(got.Groups) != 2 || got.Groups[0].AutoScalingGroup != "a" ||
+		!reflect.DeepEqual(got.Groups[1].InstanceIDs, []string{"i-spot", "i-ondemand"}) {
+		t.Errorf("unexpected groups %#v", got.Groups)
+	}
+}
+
+func TestRunReport_accounts(t *testing.T) {
+	r := newRunReport()
+
+	first, second := newRunReport(), newRunReport()
+	first.Account, second.Account = "222222222222", "111111111111"
+	r.addAccount(first)
+	r.addAccount(second)
+
+	first.addRegionSavings("us-east-1", 1)
+	second.addRegionSavings("us-east-1", 0.5)
+	first.addGroup(GroupReport{Region: "us-east-1", AutoScalingGroup: "asg", Errors: []string{"boom"}})
+	r.finish()
+
+	if r.TotalHourlySavings != 1.5 {
+		t.Errorf("total savings = %f, want 1.5", r.TotalHourlySavings)
+	}
+
+	if r.Accounts[0].Account != "111111111111" || r.Accounts[1].TotalHourlySavings != 1 {
+		t.Errorf("unexpected account reports %#v", r.Accounts)
+	}
+
+	want := "run failed in accounts: 222222222222 (run failed in regions: us-east-1)"
+	if err := r.err(); err == nil || err.Error() != want {
+		t.Errorf("err() = %v, want %s", err, want)
+	}
+}
+
+func Test_runer_run(t *testing.T) {
+	newSpotInstance := func(ec2Svc mockEC2, state string) *instance {
+		return &instance{
+			Instance: &ec2.Instance{
+				InstanceId: aws.String("i-spot"),
+				State:      &ec2.InstanceState{Name: aws.String(state)},
+			},
+			region: &region{
+				name:     "us-east-1",
+				services: connections{ec2: ec2Svc},
+			},
+		}
+	}
+
+	asg := &autoScalingGroup{name: "asg-foo", Group: &autoscaling.Group{}}
+
+	tests := []struct {
+		name   string
+		action runer
+		want   GroupReport
+	}{
+		{
+			name:   "skip",
+			action: skipRun{reason: reasonNoInstancesToReplace},
+			want: GroupReport{
+				Action: skipRunAction,
+				Reason: reasonNoInstancesToReplace,
+			},
+		},
+		{
+			name: "terminate unneeded spot instance",
+			action: terminateUnneededSpotInstance{target{
+				asg:          asg,
+				spotInstance: newSpotInstance(mockEC2{}, "running"),
+			}},
+			want: GroupReport{
+				Action:      terminateUnneededSpotInstanceAction,
+				InstanceIDs: []string{"i-spot"},
+				Reason:      reasonSpotInstanceNotNeeded,
+			},
+		},
+		{
+			name: "terminate unneeded spot instance fails",
+			action: terminateUnneededSpotInstance{target{
+				asg:          asg,
+				spotInstance: newSpotInstance(mockEC2{tierr: errors.New("denied")}, "running"),
+			}},
+			want: GroupReport{
+				Action:      terminateUnneededSpotInstanceAction,
+				InstanceIDs: []string{"i-spot"},
+				Reason:      reasonSpotInstanceNotNeeded,
+				Errors:      []string{"denied"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.action.run(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("run() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
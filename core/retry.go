@@ -0,0 +1,297 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// retry.go contains the classification of the AWS errors and the retry policy
+// shared by the AWS API calls, which only retries the errors likely to go
+// away on their own. The calls are retried by the AWS sessions, using the
+// sessionRetryer instead of the default retries of the AWS SDK.
+
+// errorClass tells why an AWS API call failed
+type errorClass string
+
+// Classes of the AWS errors
+const (
+	errorClassThrottling errorClass = "throttling"
+	errorClassTransient  errorClass = "transient"
+	errorClassCapacity   errorClass = "capacity"
+	errorClassPermission errorClass = "permission"
+	errorClassValidation errorClass = "validation"
+	errorClassUnknown    errorClass = "unknown"
+)
+
+// awsErrorClasses maps the AWS error codes to their class. The codes missing
+// from it are classified by their prefix or HTTP status code.
+var awsErrorClasses = map[string]errorClass{
+	"Throttling":                             errorClassThrottling,
+	"ThrottlingException":                    errorClassThrottling,
+	"ThrottledException":                     errorClassThrottling,
+	"RequestLimitExceeded":                   errorClassThrottling,
+	"RequestThrottled":                       errorClassThrottling,
+	"RequestThrottledException":              errorClassThrottling,
+	"TooManyRequestsException":               errorClassThrottling,
+	"ProvisionedThroughputExceededException": errorClassThrottling,
+	"SlowDown":                               errorClassThrottling,
+
+	"InternalError":                errorClassTransient,
+	"InternalFailure":              errorClassTransient,
+	"InternalServerError":          errorClassTransient,
+	"ServiceUnavailable":           errorClassTransient,
+	"Unavailable":                  errorClassTransient,
+	"RequestTimeout":               errorClassTransient,
+	"RequestTimeoutException":      errorClassTransient,
+	"ResourceContention":           errorClassTransient,
+	"ScalingActivityInProgress":    errorClassTransient,
+	"IncorrectInstanceState":       errorClassTransient,
+	request.ErrCodeRequestError:    errorClassTransient,
+	request.ErrCodeRead:            errorClassTransient,
+	request.ErrCodeResponseTimeout: errorClassTransient,
+
+	"InsufficientInstanceCapacity":         errorClassCapacity,
+	"InsufficientCapacity":                 errorClassCapacity,
+	"InsufficientHostCapacity":             errorClassCapacity,
+	"InsufficientReservedInstanceCapacity": errorClassCapacity,
+	"InsufficientFreeAddressesInSubnet":    errorClassCapacity,
+	"InstanceLimitExceeded":                errorClassCapacity,
+	"VcpuLimitExceeded":                    errorClassCapacity,
+	"MaxSpotInstanceCountExceeded":         errorClassCapacity,
+	"SpotMaxPriceTooLow":                   errorClassCapacity,
+	"UnfulfillableCapacity":                errorClassCapacity,
+	"LimitExceeded":                        errorClassCapacity,
+
+	"AccessDenied":                errorClassPermission,
+	"AccessDeniedException":       errorClassPermission,
+	"UnauthorizedOperation":       errorClassPermission,
+	"AuthFailure":                 errorClassPermission,
+	"ExpiredToken":                errorClassPermission,
+	"ExpiredTokenException":       errorClassPermission,
+	"InvalidClientTokenId":        errorClassPermission,
+	"UnrecognizedClientException": errorClassPermission,
+	"OptInRequired":               errorClassPermission,
+	"Blocked":                     errorClassPermission,
+
+	"ValidationError":                 errorClassValidation,
+	"ValidationException":             errorClassValidation,
+	"MissingParameter":                errorClassValidation,
+	"UnsupportedOperation":            errorClassValidation,
+	"ConditionalCheckFailedException": errorClassValidation,
+}
+
+// awsError is the error returned by the AWS API calls, telling why they
+// failed. The original error is available using errors.Unwrap.
+type awsError struct {
+	Op    string
+	Class errorClass
+	Code  string
+	Err   error
+}
+
+func (e *awsError) Error() string {
+	return fmt.Sprintf("%s failed with %s error: %s", e.Op, e.Class, e.Err.Error())
+}
+
+func (e *awsError) Unwrap() error {
+	return e.Err
+}
+
+// newAWSError classifies the error returned by the given operation.
+func newAWSError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var aerr *awsError
+	if errors.As(err, &aerr) {
+		return err
+	}
+	return &awsError{Op: op, Class: classifyError(err), Code: errorCode(err), Err: err}
+}
+
+// errorCode returns the AWS error code of the error, if any.
+func errorCode(err error) string {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return aerr.Code()
+	}
+	return ""
+}
+
+// classifyError returns the class of the error, using the class already
+// determined for the AWS errors wrapped into it.
+func classifyError(err error) errorClass {
+	if err == nil {
+		return ""
+	}
+
+	var typed *awsError
+	if errors.As(err, &typed) {
+		return typed.Class
+	}
+
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return errorClassUnknown
+	}
+
+	if class := classifyErrorCode(aerr.Code()); class != errorClassUnknown {
+		return class
+	}
+
+	var rerr awserr.RequestFailure
+	if errors.As(err, &rerr) {
+		switch status := rerr.StatusCode(); {
+		case status == 429:
+			return errorClassThrottling
+		case status >= 500:
+			return errorClassTransient
+		case status == 401 || status == 403:
+			return errorClassPermission
+		case status >= 400:
+			return errorClassValidation
+		}
+	}
+	return errorClassUnknown
+}
+
+// classifyErrorCode returns the class of the AWS error code, which is also
+// used for the errors reported in the CreateFleet responses.
+func classifyErrorCode(code string) errorClass {
+	if class, found := awsErrorClasses[code]; found {
+		return class
+	}
+
+	switch {
+	case strings.HasPrefix(code, "Insufficient"):
+		return errorClassCapacity
+	case strings.HasPrefix(code, "Invalid"), strings.HasSuffix(code, "NotFound"),
+		strings.HasSuffix(code, "NotFoundException"):
+		return errorClassValidation
+	case strings.HasSuffix(code, "LimitExceeded"):
+		return errorClassCapacity
+	}
+	return errorClassUnknown
+}
+
+// retryPolicy retries the throttling and transient errors using exponential
+// backoff with full jitter. The capacity, permission and validation errors
+// won't go away by retrying the same call, so they are returned immediately.
+type retryPolicy struct {
+	// maxAttempts is the number of calls made before giving up
+	maxAttempts int
+
+	// baseDelay and maxDelay bound the delay before the next attempt, which
+	// doubles after every attempt
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// defaultRetryPolicy is used for the AWS API calls, as the only retry layer
+// of the sessions, replacing the default retries of the AWS SDK
+var defaultRetryPolicy = retryPolicy{
+	maxAttempts: 5,
+	baseDelay:   time.Second,
+	maxDelay:    20 * time.Second,
+}
+
+// retryable returns whether retrying the errors of the given class may
+// succeed.
+func (p retryPolicy) retryable(class errorClass) bool {
+	return class == errorClassThrottling || class == errorClassTransient
+}
+
+// delay returns a random delay before the given attempt, counted from zero,
+// up to the exponentially growing limit.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	limit := p.maxDelay
+	if attempt < 32 {
+		if d := p.baseDelay << uint(attempt); d > 0 && d < limit {
+			limit = d
+		}
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+// scaled returns the policy with its delays multiplied by the given factor,
+// such as the SleepMultiplier used in tests.
+func (p retryPolicy) scaled(factor time.Duration) retryPolicy {
+	p.baseDelay *= factor
+	p.maxDelay *= factor
+	return p
+}
+
+// instanceStatusPolicy is used for polling the instances until they reach
+// the expected lifecycle state
+var instanceStatusPolicy = retryPolicy{
+	maxAttempts: 6,
+	baseDelay:   4 * time.Second,
+	maxDelay:    20 * time.Second,
+}
+
+// sleepMultiplier returns the factor applied to the delays, zero when running
+// without configuration in tests.
+func (r *region) sleepMultiplier() time.Duration {
+	if r.conf == nil {
+		return 0
+	}
+	return r.conf.SleepMultiplier
+}
+
+// sessionRetryer is the request.Retryer of the AWS sessions, retrying the
+// calls according to the retry policy and the classification of their errors.
+type sessionRetryer struct {
+	policy retryPolicy
+}
+
+func (s sessionRetryer) MaxRetries() int {
+	return s.policy.maxAttempts - 1
+}
+
+func (s sessionRetryer) RetryRules(r *request.Request) time.Duration {
+	return s.policy.delay(r.RetryCount)
+}
+
+// ShouldRetry honors the errors explicitly marked as retryable or not by the
+// AWS SDK, such as the canceled requests, and classifies the others.
+func (s sessionRetryer) ShouldRetry(r *request.Request) bool {
+	if r.Retryable != nil {
+		return *r.Retryable
+	}
+
+	class := classifyError(r.Error)
+	if !s.policy.retryable(class) {
+		return false
+	}
+	log.Printf("%s failed with %s error, attempt %d of %d: %s\n",
+		r.Operation.Name, class, r.RetryCount+1, s.policy.maxAttempts, r.Error.Error())
+	return true
+}
+
+// appendErrorClass adds the class of the error to the given classes, unless
+// it's already listed or the error couldn't be classified.
+func appendErrorClass(classes []string, err error) []string {
+	class := classifyError(err)
+	if class == "" || class == errorClassUnknown {
+		return classes
+	}
+	for _, c := range classes {
+		if c == string(class) {
+			return classes
+		}
+	}
+	return append(classes, string(class))
+}
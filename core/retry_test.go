@@ -0,0 +1,196 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/corehandlers"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want errorClass
+	}{
+		{name: "no error", err: nil, want: ""},
+		{name: "not an AWS error", err: errors.New("boom"), want: errorClassUnknown},
+		{name: "EC2 throttling", err: awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil), want: errorClassThrottling},
+		{name: "AutoScaling throttling", err: awserr.New("Throttling", "Rate exceeded", nil), want: errorClassThrottling},
+		{name: "network error", err: awserr.New("RequestError", "send request failed", nil), want: errorClassTransient},
+		{name: "scaling activity in progress", err: awserr.New("ScalingActivityInProgress", "", nil), want: errorClassTransient},
+		{name: "spot capacity", err: awserr.New("InsufficientInstanceCapacity", "", nil), want: errorClassCapacity},
+		{name: "vCPU limit", err: awserr.New("VcpuLimitExceeded", "", nil), want: errorClassCapacity},
+		{name: "missing permission", err: awserr.New("UnauthorizedOperation", "", nil), want: errorClassPermission},
+		{name: "invalid parameter", err: awserr.New("InvalidParameterValue", "", nil), want: errorClassValidation},
+		{name: "missing instance", err: awserr.New("InvalidInstanceID.NotFound", "", nil), want: errorClassValidation},
+		{
+			name: "unknown code of a server error",
+			err:  awserr.NewRequestFailure(awserr.New("Oops", "", nil), 503, "req-1"),
+			want: errorClassTransient,
+		},
+		{
+			name: "unknown code of a rejected request",
+			err:  awserr.NewRequestFailure(awserr.New("Oops", "", nil), 429, "req-1"),
+			want: errorClassThrottling,
+		},
+		{
+			name: "already classified",
+			err:  fmt.Errorf("attach: %w", newAWSError("AttachInstances", awserr.New("AccessDenied", "", nil))),
+			want: errorClassPermission,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_delay(t *testing.T) {
+	p := retryPolicy{baseDelay: time.Second, maxDelay: 5 * time.Second}
+
+	for attempt, limit := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		for n := 0; n < 100; n++ {
+			if d := p.delay(attempt); d < 0 || d >= limit {
+				t.Fatalf("delay(%d) = %v, want it within [0, %v)", attempt, d, limit)
+			}
+		}
+	}
+
+	if d := p.scaled(0).delay(3); d != 0 {
+		t.Errorf("delay() of the policy scaled to zero = %v, want 0", d)
+	}
+}
+
+func TestSessionRetryer(t *testing.T) {
+	throttled := awserr.New("Throttling", "Rate exceeded", nil)
+
+	tests := []struct {
+		name      string
+		err       error
+		retryable *bool
+		want      bool
+	}{
+		{name: "throttling", err: throttled, want: true},
+		{name: "network error", err: awserr.New(request.ErrCodeRequestError, "send request failed", nil), want: true},
+		{name: "transient error not retried by the SDK", err: awserr.New("ScalingActivityInProgress", "", nil), want: true},
+		{name: "permission errors aren't retried", err: awserr.New("AccessDenied", "not authorized", nil)},
+		{name: "capacity errors aren't retried", err: awserr.New("InsufficientInstanceCapacity", "", nil)},
+		{name: "canceled by the SDK", err: throttled, retryable: aws.Bool(false)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := sessionRetryer{policy: retryPolicy{maxAttempts: 3}}
+			r := &request.Request{
+				Operation: &request.Operation{Name: "Test"},
+				Error:     tt.err,
+				Retryable: tt.retryable,
+			}
+			if got := s.ShouldRetry(r); got != tt.want {
+				t.Errorf("ShouldRetry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSessionRetryer_request checks that the calls made through the sessions
+// are only retried by the sessionRetryer, up to the attempts of its policy.
+func TestSessionRetryer_request(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      string
+		wantCalls int
+		wantClass errorClass
+	}{
+		{name: "gives up after the last attempt", code: "Throttling", wantCalls: 3, wantClass: errorClassThrottling},
+		{name: "permission errors aren't retried", code: "AccessDenied", wantCalls: 1, wantClass: errorClassPermission},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			handlers := request.Handlers{}
+			handlers.Send.PushBack(func(r *request.Request) {
+				calls++
+				r.Error = awserr.New(tt.code, "", nil)
+			})
+			handlers.AfterRetry.PushBackNamed(corehandlers.AfterRetryHandler)
+
+			r := request.New(aws.Config{}, metadata.ClientInfo{}, handlers,
+				sessionRetryer{policy: retryPolicy{maxAttempts: 3}},
+				&request.Operation{Name: "Test"}, nil, nil)
+
+			err := newAWSError("Test", r.Send())
+			if calls != tt.wantCalls {
+				t.Errorf("Send() made %d calls, want %d", calls, tt.wantCalls)
+			}
+			if got := classifyError(err); got != tt.wantClass {
+				t.Errorf("Send() returned %v, want a %q error", err, tt.wantClass)
+			}
+		})
+	}
+}
+
+func TestAppendErrorClass(t *testing.T) {
+	var classes []string
+	for _, err := range []error{
+		awserr.New("Throttling", "", nil),
+		errors.New("unknown"),
+		awserr.New("InsufficientInstanceCapacity", "", nil),
+		awserr.New("RequestLimitExceeded", "", nil),
+	} {
+		classes = appendErrorClass(classes, err)
+	}
+
+	want := []string{string(errorClassThrottling), string(errorClassCapacity)}
+	if !reflect.DeepEqual(classes, want) {
+		t.Errorf("appendErrorClass() = %v, want %v", classes, want)
+	}
+}
+
+func TestCreateFleetResponseError(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []*ec2.CreateFleetError
+		wantClass errorClass
+	}{
+		{name: "no errors reported"},
+		{
+			name: "no spot capacity",
+			errs: []*ec2.CreateFleetError{{
+				ErrorCode:    aws.String("InsufficientInstanceCapacity"),
+				ErrorMessage: aws.String("There is no Spot capacity available"),
+			}},
+			wantClass: errorClassCapacity,
+		},
+		{
+			name: "invalid launch template",
+			errs: []*ec2.CreateFleetError{
+				{},
+				{ErrorCode: aws.String("InvalidLaunchTemplateId.NotFound")},
+			},
+			wantClass: errorClassValidation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := createFleetResponseError(tt.errs)
+			if got := classifyError(err); got != tt.wantClass {
+				t.Errorf("createFleetResponseError() = %v, want a %q error", err, tt.wantClass)
+			}
+		})
+	}
+}
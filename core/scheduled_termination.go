@@ -0,0 +1,200 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// scheduled_termination.go contains the durable scheduling of the termination
+// of the instances detached after receiving interruption warnings. The due
+// time is persisted in a tag of the instance, and the instances are terminated
+// by the first cron run or instance event of their region after it.
+
+const (
+	// terminateAfterTag stores the time after which the instance is due for
+	// termination, in RFC3339 format
+	terminateAfterTag = "autospotting-terminate-after"
+
+	// detachedFromTag stores the name of the group the instance was detached
+	// from by AutoSpotting, which is required for terminating it, so the
+	// instances merely carrying a copy of the due time tag are left alone
+	detachedFromTag = "autospotting-detached-from"
+
+	// scheduledTerminationDelay is the delay after which the detached
+	// instances are terminated, in case they weren't interrupted already
+	scheduledTerminationDelay = 14 * time.Minute
+)
+
+// scheduleTermination tags the instance detached from the given group with
+// the time after which it's due for termination.
+func (s *SpotTermination) scheduleTermination(instanceID *string, asgName string, delay time.Duration) error {
+	due := time.Now().Add(delay).UTC().Format(time.RFC3339)
+
+	_, err := s.ec2Svc.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{instanceID},
+		Tags: []*ec2.Tag{
+			{
+				Key:   aws.String(terminateAfterTag),
+				Value: aws.String(due),
+			},
+			{
+				Key:   aws.String(detachedFromTag),
+				Value: aws.String(asgName),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to schedule the termination of instance %s with err: %s\n",
+			*instanceID, err.Error())
+		return err
+	}
+
+	log.Printf("Scheduled the termination of instance %s after %s\n", *instanceID, due)
+	return nil
+}
+
+// terminationDueTime returns the time after which the instance is due for
+// termination, or false when it isn't scheduled for termination.
+func terminationDueTime(inst *ec2.Instance) (time.Time, bool) {
+	for _, tag := range inst.Tags {
+		if aws.StringValue(tag.Key) != terminateAfterTag {
+			continue
+		}
+		due, err := time.Parse(time.RFC3339, aws.StringValue(tag.Value))
+		if err != nil {
+			log.Printf("Ignoring the invalid termination time %s of instance %s\n",
+				aws.StringValue(tag.Value), aws.StringValue(inst.InstanceId))
+			return time.Time{}, false
+		}
+		return due, true
+	}
+	return time.Time{}, false
+}
+
+// detachedByAutoSpotting returns whether the instance was detached from its
+// group by AutoSpotting.
+func detachedByAutoSpotting(inst *ec2.Instance) bool {
+	for _, tag := range inst.Tags {
+		if aws.StringValue(tag.Key) == detachedFromTag && aws.StringValue(tag.Value) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduledTerminations returns the instances of the region detached by
+// AutoSpotting which are due for termination at the given time.
+func (r *region) scheduledTerminations(now time.Time) ([]*ec2.Instance, error) {
+	var due []*ec2.Instance
+
+	err := r.services.ec2.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []*string{aws.String(terminateAfterTag)},
+			},
+			{
+				Name:   aws.String("tag:" + detachedFromTag),
+				Values: []*string{aws.String("?*")},
+			},
+			{
+				Name: aws.String("instance-state-name"),
+				Values: aws.StringSlice([]string{
+					ec2.InstanceStateNamePending,
+					ec2.InstanceStateNameRunning,
+					ec2.InstanceStateNameStopping,
+					ec2.InstanceStateNameStopped,
+				}),
+			},
+		},
+	}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, res := range page.Reservations {
+			for _, inst := range res.Instances {
+				if !detachedByAutoSpotting(inst) {
+					continue
+				}
+				if t, found := terminationDueTime(inst); found && !now.Before(t) {
+					due = append(due, inst)
+				}
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		log.Println(r.name, "Couldn't describe the instances scheduled for termination:", err.Error())
+		return nil, err
+	}
+	return due, nil
+}
+
+// terminateScheduledInstances terminates the instances of the region which
+// are due for termination at the given time. The instances which couldn't be
+// terminated keep their tag, so they're retried on the next run.
+func (r *region) terminateScheduledInstances(now time.Time) {
+	instances, err := r.scheduledTerminations(now)
+	if err != nil {
+		r.conf.report.addRegionError(r.name, err)
+		return
+	}
+
+	for _, inst := range instances {
+		if r.conf.DryRun {
+			due, _ := terminationDueTime(inst)
+			r.conf.plan.add(plannedAction{
+				Region:       r.name,
+				Action:       terminateScheduledInstanceAction,
+				InstanceID:   *inst.InstanceId,
+				InstanceType: aws.StringValue(inst.InstanceType),
+				Reason:       "instance was due for termination after " + due.Format(time.RFC3339),
+			})
+			continue
+		}
+
+		if err := r.terminateScheduledInstance(inst); err != nil {
+			r.conf.report.addRegionError(r.name, err)
+		}
+	}
+}
+
+// terminateScheduledInstance terminates the instance detached by AutoSpotting
+// and confirms that it's shutting down.
+func (r *region) terminateScheduledInstance(inst *ec2.Instance) error {
+	instanceID := inst.InstanceId
+
+	if !detachedByAutoSpotting(inst) {
+		return fmt.Errorf("instance %s scheduled for termination wasn't detached by AutoSpotting, missing its %s tag",
+			*instanceID, detachedFromTag)
+	}
+
+	log.Println(r.name, "Terminating instance", *instanceID, "scheduled for termination")
+
+	resp, err := r.services.ec2.TerminateInstances(&ec2.TerminateInstancesInput{
+		InstanceIds: []*string{instanceID},
+	})
+	if err != nil {
+		log.Println(r.name, "Couldn't terminate instance", *instanceID, err.Error())
+		return err
+	}
+
+	for _, ti := range resp.TerminatingInstances {
+		if aws.StringValue(ti.InstanceId) != *instanceID || ti.CurrentState == nil {
+			continue
+		}
+		switch state := aws.StringValue(ti.CurrentState.Name); state {
+		case ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameTerminated:
+			log.Println(r.name, "Confirmed the termination of instance", *instanceID, "now", state)
+			return nil
+		default:
+			return fmt.Errorf("instance %s scheduled for termination is still %s", *instanceID, state)
+		}
+	}
+	return fmt.Errorf("couldn't confirm the termination of instance %s", *instanceID)
+}
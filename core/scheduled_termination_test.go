@@ -0,0 +1,348 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func instanceDueAt(id string, due string) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId:   aws.String(id),
+		InstanceType: aws.String("m5.large"),
+		Tags: []*ec2.Tag{
+			{
+				Key:   aws.String(terminateAfterTag),
+				Value: aws.String(due),
+			},
+			{
+				Key:   aws.String(detachedFromTag),
+				Value: aws.String("asg"),
+			},
+		},
+	}
+}
+
+// notOwnedInstanceDueAt returns an instance carrying the due time tag without
+// having been detached by AutoSpotting, such as when the tag was copied from
+// another instance.
+func notOwnedInstanceDueAt(id string, due string) *ec2.Instance {
+	inst := instanceDueAt(id, due)
+	inst.Tags = inst.Tags[:1]
+	return inst
+}
+
+func TestScheduleTermination(t *testing.T) {
+	tests := []struct {
+		name    string
+		ec2     mockEC2
+		wantErr bool
+	}{
+		{name: "tag created", ec2: mockEC2{cto: &ec2.CreateTagsOutput{}}},
+		{name: "tag not created", ec2: mockEC2{cterr: errors.New("access denied")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SpotTermination{ec2Svc: tt.ec2}
+			err := s.scheduleTermination(aws.String("i-0123"), "asg", scheduledTerminationDelay)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("scheduleTermination() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTerminationDueTime(t *testing.T) {
+	tests := []struct {
+		name      string
+		inst      *ec2.Instance
+		want      time.Time
+		wantFound bool
+	}{
+		{
+			name:      "scheduled instance",
+			inst:      instanceDueAt("i-0123", "2021-06-01T10:14:00Z"),
+			want:      time.Date(2021, 6, 1, 10, 14, 0, 0, time.UTC),
+			wantFound: true,
+		},
+		{
+			name: "instance not scheduled",
+			inst: &ec2.Instance{InstanceId: aws.String("i-0123")},
+		},
+		{
+			name: "invalid due time",
+			inst: instanceDueAt("i-0123", "in 14 minutes"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := terminationDueTime(tt.inst)
+			if found != tt.wantFound || !got.Equal(tt.want) {
+				t.Errorf("terminationDueTime() = %v, %v, want %v, %v", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestScheduledTerminations(t *testing.T) {
+	now := time.Date(2021, 6, 1, 10, 14, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		ec2     mockEC2
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "due and pending terminations",
+			ec2: mockEC2{dio: &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{{
+					Instances: []*ec2.Instance{
+						instanceDueAt("i-past", "2021-06-01T10:00:00Z"),
+						instanceDueAt("i-now", "2021-06-01T10:14:00Z"),
+						instanceDueAt("i-future", "2021-06-01T10:20:00Z"),
+						notOwnedInstanceDueAt("i-not-owned", "2021-06-01T10:00:00Z"),
+						{InstanceId: aws.String("i-untagged")},
+					},
+				}},
+			}},
+			want: []string{"i-past", "i-now"},
+		},
+		{
+			name: "instances can't be described",
+			ec2: mockEC2{
+				dio:    &ec2.DescribeInstancesOutput{},
+				diperr: errors.New("throttled"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{name: "us-east-1", services: connections{ec2: tt.ec2}}
+			instances, err := r.scheduledTerminations(now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("scheduledTerminations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, inst := range instances {
+				got = append(got, *inst.InstanceId)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scheduledTerminations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerminateScheduledInstance(t *testing.T) {
+	terminating := func(id, state string) *ec2.TerminateInstancesOutput {
+		return &ec2.TerminateInstancesOutput{
+			TerminatingInstances: []*ec2.InstanceStateChange{{
+				InstanceId:   aws.String(id),
+				CurrentState: &ec2.InstanceState{Name: aws.String(state)},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		inst    *ec2.Instance
+		ec2     mockEC2
+		wantErr bool
+	}{
+		{
+			name:    "instance not detached by AutoSpotting",
+			inst:    notOwnedInstanceDueAt("i-0123", "2021-06-01T10:14:00Z"),
+			ec2:     mockEC2{tierr: errors.New("shouldn't be terminated")},
+			wantErr: true,
+		},
+		{
+			name: "instance shutting down",
+			ec2:  mockEC2{tio: terminating("i-0123", ec2.InstanceStateNameShuttingDown)},
+		},
+		{
+			name: "instance already terminated",
+			ec2:  mockEC2{tio: terminating("i-0123", ec2.InstanceStateNameTerminated)},
+		},
+		{
+			name:    "instance still running",
+			ec2:     mockEC2{tio: terminating("i-0123", ec2.InstanceStateNameRunning)},
+			wantErr: true,
+		},
+		{
+			name:    "termination not confirmed",
+			ec2:     mockEC2{tio: &ec2.TerminateInstancesOutput{}},
+			wantErr: true,
+		},
+		{
+			name:    "termination failure",
+			ec2:     mockEC2{tierr: errors.New("access denied")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := tt.inst
+			if inst == nil {
+				inst = instanceDueAt("i-0123", "2021-06-01T10:14:00Z")
+			}
+			r := &region{name: "us-east-1", services: connections{ec2: tt.ec2}}
+			err := r.terminateScheduledInstance(inst)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("terminateScheduledInstance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTerminateScheduledInstances_dryRun(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{DryRun: true, plan: newActionPlan(), report: newRunReport()},
+		services: connections{ec2: mockEC2{
+			dio: &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{{
+					Instances: []*ec2.Instance{instanceDueAt("i-0123", "2021-06-01T10:14:00Z")},
+				}},
+			},
+			tierr: errors.New("shouldn't be terminated in dry-run mode"),
+		}},
+	}
+
+	r.terminateScheduledInstances(time.Date(2021, 6, 1, 10, 14, 0, 0, time.UTC))
+
+	got := r.conf.plan.sorted()
+	if len(got) != 1 || got[0].Action != terminateScheduledInstanceAction || got[0].InstanceID != "i-0123" {
+		t.Errorf("terminateScheduledInstances() planned %v", got)
+	}
+	if err := r.conf.report.err(); err != nil {
+		t.Errorf("terminateScheduledInstances() reported %v", err)
+	}
+}
+
+// taggingEC2 keeps the tags and states of its instances, so the scheduling
+// and the termination of an instance can be checked end to end.
+type taggingEC2 struct {
+	mockEC2
+	instances []*ec2.Instance
+}
+
+func (m *taggingEC2) instance(id string) *ec2.Instance {
+	for _, inst := range m.instances {
+		if *inst.InstanceId == id {
+			return inst
+		}
+	}
+	return nil
+}
+
+func (m *taggingEC2) CreateTags(in *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	for _, id := range in.Resources {
+		inst := m.instance(*id)
+		inst.Tags = append(inst.Tags, in.Tags...)
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (m *taggingEC2) DeleteTags(in *ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error) {
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+// DescribeInstancesPages only returns the instances matching all the tag
+// filters of the input.
+func (m *taggingEC2) DescribeInstancesPages(in *ec2.DescribeInstancesInput, f func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	var matching []*ec2.Instance
+	for _, inst := range m.instances {
+		tags := map[string]string{}
+		for _, tag := range inst.Tags {
+			tags[*tag.Key] = *tag.Value
+		}
+
+		matches := true
+		for _, filter := range in.Filters {
+			switch name := *filter.Name; {
+			case name == "tag-key":
+				_, found := tags[*filter.Values[0]]
+				matches = matches && found
+			case strings.HasPrefix(name, "tag:"):
+				matches = matches && tags[strings.TrimPrefix(name, "tag:")] != ""
+			}
+		}
+		if matches {
+			matching = append(matching, inst)
+		}
+	}
+
+	f(&ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{Instances: matching}},
+	}, true)
+	return nil
+}
+
+func (m *taggingEC2) TerminateInstances(in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	out := &ec2.TerminateInstancesOutput{}
+	for _, id := range in.InstanceIds {
+		m.instance(*id).State = &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameShuttingDown)}
+		out.TerminatingInstances = append(out.TerminatingInstances, &ec2.InstanceStateChange{
+			InstanceId:   id,
+			CurrentState: &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameShuttingDown)},
+		})
+	}
+	return out, nil
+}
+
+func TestScheduledTermination_afterDetach(t *testing.T) {
+	running := func(id string, tags ...*ec2.Tag) *ec2.Instance {
+		return &ec2.Instance{
+			InstanceId: aws.String(id),
+			State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			Tags:       tags,
+		}
+	}
+
+	svc := &taggingEC2{instances: []*ec2.Instance{
+		running("i-detached"),
+		// carrying a copy of the due time tag, without being detached
+		running("i-copied", &ec2.Tag{
+			Key:   aws.String(terminateAfterTag),
+			Value: aws.String(time.Now().UTC().Format(time.RFC3339)),
+		}),
+	}}
+
+	s := &SpotTermination{asSvc: mockASG{}, ec2Svc: svc, region: "us-east-1"}
+	if err := s.detachInstance(aws.String("i-detached"), "asg", SpotInstanceInterruptionWarningCode); err != nil {
+		t.Fatalf("detachInstance() error = %v", err)
+	}
+
+	r := &region{
+		name:     "us-east-1",
+		conf:     &Config{report: newRunReport()},
+		services: connections{ec2: svc},
+	}
+
+	// not due yet
+	r.terminateScheduledInstances(time.Now())
+	if state := *svc.instance("i-detached").State.Name; state != ec2.InstanceStateNameRunning {
+		t.Fatalf("instance terminated before its due time, now %s", state)
+	}
+
+	r.terminateScheduledInstances(time.Now().Add(scheduledTerminationDelay + time.Minute))
+	if state := *svc.instance("i-detached").State.Name; state != ec2.InstanceStateNameShuttingDown {
+		t.Errorf("detached instance is %s after its due time, want %s", state, ec2.InstanceStateNameShuttingDown)
+	}
+	if state := *svc.instance("i-copied").State.Name; state != ec2.InstanceStateNameRunning {
+		t.Errorf("instance not detached by AutoSpotting is %s, want it left %s", state, ec2.InstanceStateNameRunning)
+	}
+	if err := r.conf.report.err(); err != nil {
+		t.Errorf("terminateScheduledInstances() reported %v", err)
+	}
+}
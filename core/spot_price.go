@@ -5,6 +5,9 @@ package autospotting
 
 import (
 	"log"
+	"math"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -51,3 +54,125 @@ func (s *spotPrices) fetch(product string,
 
 	return nil
 }
+
+// spotPriceStats summarizes the price history of a spot pool, which is an
+// instance type in an availability zone.
+type spotPriceStats struct {
+	mean   float64
+	max    float64
+	stdDev float64
+
+	// how many times per day the price changed over the history window
+	changesPerDay float64
+}
+
+// volatility is the coefficient of variation of the price, which allows
+// comparing pools with very different prices.
+func (s spotPriceStats) volatility() float64 {
+	if s.mean == 0 {
+		return 0
+	}
+	return s.stdDev / s.mean
+}
+
+// The key in this map is the availability zone
+type spotPriceStatsMap map[string]spotPriceStats
+
+type spotPool struct {
+	instanceType     string
+	availabilityZone string
+}
+
+type spotPricePoint struct {
+	timestamp time.Time
+	price     float64
+}
+
+// pools groups the fetched price history by spot pool, sorted by timestamp.
+// The entries which can't be parsed are skipped, since they belong to instance
+// types not available on the spot market.
+func (s *spotPrices) pools() map[spotPool][]spotPricePoint {
+	pools := make(map[spotPool][]spotPricePoint)
+
+	for _, sp := range s.data {
+		if sp.InstanceType == nil || sp.AvailabilityZone == nil || sp.SpotPrice == nil {
+			continue
+		}
+
+		price, err := strconv.ParseFloat(*sp.SpotPrice, 64)
+		if err != nil {
+			continue
+		}
+
+		pool := spotPool{*sp.InstanceType, *sp.AvailabilityZone}
+		pools[pool] = append(pools[pool], spotPricePoint{
+			timestamp: aws.TimeValue(sp.Timestamp),
+			price:     price,
+		})
+	}
+
+	for _, points := range pools {
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].timestamp.Before(points[j].timestamp)
+		})
+	}
+	return pools
+}
+
+// latestPrice returns the current price of the pool, which is the most recent
+// one from its history.
+func latestPrice(points []spotPricePoint) float64 {
+	return points[len(points)-1].price
+}
+
+// computeSpotPriceStats computes the statistics of the price history of a pool
+// over the window ending at the given time. The price history only contains
+// the price changes, so each price is weighted by how long it was in effect.
+func computeSpotPriceStats(points []spotPricePoint, window time.Duration, end time.Time) spotPriceStats {
+	var stats spotPriceStats
+
+	start := end.Add(-window)
+	var weightedSum, weightedSquares, totalDuration float64
+
+	for i, p := range points {
+		from := p.timestamp
+		if from.Before(start) {
+			from = start
+		}
+
+		to := end
+		if i+1 < len(points) {
+			to = points[i+1].timestamp
+		}
+
+		if p.price > stats.max {
+			stats.max = p.price
+		}
+
+		if i > 0 && p.price != points[i-1].price && p.timestamp.After(start) {
+			stats.changesPerDay++
+		}
+
+		if d := to.Sub(from).Hours(); d > 0 {
+			weightedSum += p.price * d
+			weightedSquares += p.price * p.price * d
+			totalDuration += d
+		}
+	}
+
+	if days := window.Hours() / 24; days > 0 {
+		stats.changesPerDay /= days
+	}
+
+	if totalDuration == 0 {
+		stats.mean = latestPrice(points)
+		return stats
+	}
+
+	stats.mean = weightedSum / totalDuration
+	variance := weightedSquares/totalDuration - stats.mean*stats.mean
+	if variance > 0 {
+		stats.stdDev = math.Sqrt(variance)
+	}
+	return stats
+}
@@ -5,6 +5,7 @@ package autospotting
 
 import (
 	"errors"
+	"math"
 	"testing"
 	"time"
 
@@ -110,3 +111,89 @@ func Test_fetch(t *testing.T) {
 		})
 	}
 }
+
+func Test_spotPrices_pools(t *testing.T) {
+	now := time.Now()
+
+	s := spotPrices{data: []*ec2.SpotPrice{
+		{
+			InstanceType:     aws.String("m5.large"),
+			AvailabilityZone: aws.String("us-east-1a"),
+			SpotPrice:        aws.String("0.04"),
+			Timestamp:        aws.Time(now.Add(-1 * time.Hour)),
+		},
+		{
+			InstanceType:     aws.String("m5.large"),
+			AvailabilityZone: aws.String("us-east-1a"),
+			SpotPrice:        aws.String("0.03"),
+			Timestamp:        aws.Time(now.Add(-2 * time.Hour)),
+		},
+		{
+			InstanceType:     aws.String("m5.large"),
+			AvailabilityZone: aws.String("us-east-1b"),
+			SpotPrice:        aws.String("0.05"),
+			Timestamp:        aws.Time(now.Add(-3 * time.Hour)),
+		},
+		{
+			InstanceType:     aws.String("m5.large"),
+			AvailabilityZone: aws.String("us-east-1c"),
+			SpotPrice:        aws.String("invalid"),
+			Timestamp:        aws.Time(now),
+		},
+	}}
+
+	pools := s.pools()
+
+	if len(pools) != 2 {
+		t.Errorf("pools() returned %d pools, want 2", len(pools))
+	}
+
+	if got := latestPrice(pools[spotPool{"m5.large", "us-east-1a"}]); got != 0.04 {
+		t.Errorf("latest price in us-east-1a = %v, want 0.04", got)
+	}
+
+	if got := latestPrice(pools[spotPool{"m5.large", "us-east-1b"}]); got != 0.05 {
+		t.Errorf("latest price in us-east-1b = %v, want 0.05", got)
+	}
+}
+
+func Test_computeSpotPriceStats(t *testing.T) {
+	end := time.Date(2021, 10, 8, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name   string
+		points []spotPricePoint
+		window time.Duration
+		want   spotPriceStats
+	}{
+		{
+			name: "stable price set before the window",
+			points: []spotPricePoint{
+				{timestamp: end.Add(-10 * day), price: 0.1},
+			},
+			window: 2 * day,
+			want:   spotPriceStats{mean: 0.1, max: 0.1},
+		},
+		{
+			name: "price changing halfway through the window",
+			points: []spotPricePoint{
+				{timestamp: end.Add(-3 * day), price: 0.1},
+				{timestamp: end.Add(-1 * day), price: 0.3},
+			},
+			window: 2 * day,
+			want:   spotPriceStats{mean: 0.2, max: 0.3, stdDev: 0.1, changesPerDay: 0.5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computeSpotPriceStats(tt.points, tt.window, end)
+			if math.Abs(got.mean-tt.want.mean) > 0.000001 ||
+				math.Abs(got.max-tt.want.max) > 0.000001 ||
+				math.Abs(got.stdDev-tt.want.stdDev) > 0.000001 ||
+				math.Abs(got.changesPerDay-tt.want.changesPerDay) > 0.000001 {
+				t.Errorf("computeSpotPriceStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,43 +5,66 @@ package autospotting
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 )
 
 const (
 	// DefaultTerminationNotificationAction is the default value for the termination notification
 	// action configuration option
 	DefaultTerminationNotificationAction = AutoTerminationNotificationAction
+
+	// DefaultRebalanceRecommendationAction is the default value for the
+	// rebalance recommendation action configuration option
+	DefaultRebalanceRecommendationAction = DetachRebalanceRecommendationAction
 )
 
 //SpotTermination is used to detach an instance, used when a spot instance is due for termination
 type SpotTermination struct {
-	asSvc           autoscalingiface.AutoScalingAPI
-	ec2Svc          ec2iface.EC2API
-	SleepMultiplier time.Duration
+	asSvc     autoscalingiface.AutoScalingAPI
+	ec2Svc    ec2iface.EC2API
+	elbSvc    elbv2iface.ELBV2API
+	ssmSvc    ssmiface.SSMAPI
+	lambdaSvc lambdaiface.LambdaAPI
+	region    string
+
+	// config holds the global configuration and the overrides of the
+	// configuration file, which can be overridden by the tags of the group
+	config *Config
+
+	// warningTime is the time of the interruption warning, used for
+	// determining the deadline of the drain steps
+	warningTime time.Time
 }
 
 func newSpotTermination(region string) SpotTermination {
 
 	log.Println("Connection to region ", region)
 
-	session := session.Must(
-		session.NewSession(&aws.Config{Region: aws.String(region)}))
+	session := newSession(region)
 
 	return SpotTermination{
 
-		asSvc:           autoscaling.New(session),
-		ec2Svc:          ec2.New(session),
-		SleepMultiplier: 1,
+		asSvc:     autoscaling.New(session),
+		ec2Svc:    ec2.New(session),
+		elbSvc:    elbv2.New(session),
+		ssmSvc:    ssm.New(session),
+		lambdaSvc: lambda.New(session),
+		region:    region,
 	}
 }
 
@@ -69,30 +92,14 @@ func (s *SpotTermination) detachInstance(instanceID *string, asgName string, eve
 
 	if eventType != InstanceRebalanceRecommendationCode {
 		s.deleteTagInstanceLaunchedForAsg(instanceID)
-		s.delayedTermination(instanceID, 14)
-	}
-
-	return nil
-}
-
-// delayedTermination is used to terminate instances that were marked as being in danger of being terminated.
-func (s *SpotTermination) delayedTermination(instanceID *string, minutes time.Duration) error {
-
-	log.Printf("Terminating instance %s with %d minutes delay, sleeping...\n",
-		*instanceID, minutes)
-
-	time.Sleep(minutes * time.Minute * s.SleepMultiplier)
-
-	log.Println("Terminating instance", *instanceID)
-	// terminate the spot instance
-	terminateParams := ec2.TerminateInstancesInput{
-		InstanceIds: []*string{instanceID},
+		// without its tags the instance would never be found by the sweep of
+		// the scheduled terminations, so it must be terminated manually
+		if err := s.scheduleTermination(instanceID, asgName, scheduledTerminationDelay); err != nil {
+			return fmt.Errorf("instance %s was detached but its termination couldn't be scheduled, "+
+				"it needs to be terminated manually: %w", *instanceID, err)
+		}
 	}
 
-	if _, err := s.ec2Svc.TerminateInstances(&terminateParams); err != nil {
-		log.Println(err.Error())
-		return err
-	}
 	return nil
 }
 
@@ -134,35 +141,171 @@ func (s *SpotTermination) getAsgName(instanceID *string) (string, error) {
 
 // ExecuteAction execute the proper termination action (terminate|detach) based on the value of
 // terminationNotificationAction and the presence of a LifecycleHook on ASG.
-func (s *SpotTermination) executeAction(instanceID *string, terminationNotificationAction string, eventType string) error {
+// The instances receiving an interruption warning are drained first, and
+// since they are interrupted anyway they are taken out of their group even
+// when draining them failed, in which case an error is returned along with
+// the report of the action.
+func (s *SpotTermination) executeAction(instanceID *string, terminationNotificationAction string, eventType string) (GroupReport, error) {
+	gr := GroupReport{
+		Region:      s.region,
+		Action:      skipRunAction,
+		InstanceIDs: []string{*instanceID},
+		Reason:      reasonRebalanceRecommendation,
+	}
+	if eventType == SpotInstanceInterruptionWarningCode {
+		gr.Reason = reasonSpotInterruptionWarning
+	}
+
 	if s.asSvc == nil {
-		return errors.New("AutoScaling service not defined. Please use NewSpotTermination()")
+		return gr, errors.New("AutoScaling service not defined. Please use NewSpotTermination()")
 	}
 
 	asgName, err := s.getAsgName(instanceID)
 
 	if err != nil {
 		log.Printf("Failed get ASG name for %s with err: %s\n", *instanceID, err.Error())
-		return err
+		gr.addError(err)
+		return gr, err
 	} else if asgName == "" {
-		log.Println("Instance", instanceID, "does not belong to an autoscaling group")
-		return nil
+		log.Println("Instance", *instanceID, "does not belong to an autoscaling group")
+		return gr, nil
+	}
+	gr.AutoScalingGroup = asgName
+
+	var drainErr error
+	if eventType == SpotInstanceInterruptionWarningCode {
+		gr.DrainSteps = s.drainInstance(instanceID, asgName)
+		if drainErr = drainError(gr.DrainSteps); drainErr != nil {
+			log.Println("WARNING:", asgName, *instanceID, drainErr.Error(),
+				"taking the instance out of its group before its interruption anyway")
+			gr.addError(drainErr)
+		}
 	}
 
-	switch terminationNotificationAction {
-	case "detach":
-		s.detachInstance(instanceID, asgName, eventType)
-	case "terminate":
-		s.terminateInstance(instanceID, asgName)
-	default:
-		if s.asgHasTerminationLifecycleHook(&asgName) {
-			s.terminateInstance(instanceID, asgName)
-		} else {
-			s.detachInstance(instanceID, asgName, eventType)
+	action := s.resolveAction(asgName, terminationNotificationAction)
+	gr.Action = action + "-instance"
+
+	switch action {
+	case DetachTerminationNotificationAction:
+		err = s.detachInstance(instanceID, asgName, eventType)
+	case TerminateTerminationNotificationAction:
+		err = s.terminateInstance(instanceID, asgName)
+	}
+
+	if err != nil {
+		gr.addError(err)
+		return gr, err
+	}
+	return gr, drainErr
+}
+
+// fileConfig returns the given configuration overridden by the configuration
+// file sections of the group, before applying the overrides of its tags.
+func (s *SpotTermination) fileConfig(asgName string, base AutoScalingConfig) AutoScalingConfig {
+	if s.config == nil {
+		return base
+	}
+	return s.config.overrideConfig(base, s.region, asgName)
+}
+
+// groupConfig returns the given configuration with the settings of the given
+// flags overridden by the valid tags of the group.
+func (s *SpotTermination) groupConfig(asgName string, conf AutoScalingConfig, flags ...string) AutoScalingConfig {
+	resp, err := s.asSvc.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgName)},
+	})
+	if err != nil || resp == nil || len(resp.AutoScalingGroups) == 0 {
+		return conf
+	}
+
+	a := autoScalingGroup{Group: resp.AutoScalingGroups[0], name: asgName}
+
+	for _, flag := range flags {
+		setting, _ := findAutoScalingSetting(flag)
+		if tagValue := a.getTagValue(setting.tagName()); tagValue != nil {
+			if err := setting.apply(&conf, *tagValue); err != nil {
+				log.Printf("Ignoring invalid value %s of tag %s on the group %s: %s\n",
+					*tagValue, setting.tagName(), asgName, err.Error())
+			}
 		}
 	}
+	return conf
+}
 
-	return nil
+// groupTerminationNotificationAction returns the termination notification
+// action set on the group's tag when valid, or otherwise the one of the
+// group's configuration file sections, defaulting to the given one.
+func (s *SpotTermination) groupTerminationNotificationAction(asgName string, terminationNotificationAction string) string {
+	conf := s.groupConfig(asgName,
+		s.fileConfig(asgName, AutoScalingConfig{TerminationNotificationAction: terminationNotificationAction}),
+		"termination_notification_action")
+	return conf.TerminationNotificationAction
+}
+
+// rebalanceRecommendationAction returns the action taken when the instance
+// receives a rebalance recommendation, which can be overridden by the
+// configuration file sections and the tag of its group. Instances not
+// belonging to any group are always detached.
+func (s *SpotTermination) rebalanceRecommendationAction(instanceID *string, rebalanceRecommendationAction string) string {
+	asgName, err := s.getAsgName(instanceID)
+	if err != nil || asgName == "" {
+		return DetachRebalanceRecommendationAction
+	}
+
+	conf := s.groupConfig(asgName,
+		s.fileConfig(asgName, AutoScalingConfig{RebalanceRecommendationAction: rebalanceRecommendationAction}),
+		"rebalance_recommendation_action")
+	return conf.RebalanceRecommendationAction
+}
+
+// resolveAction determines whether the instance should be detached or
+// terminated, based on the configured terminationNotificationAction, which can
+// be overridden by the group's tag, and, when set to auto, on the presence of
+// a termination LifecycleHook on the ASG.
+func (s *SpotTermination) resolveAction(asgName string, terminationNotificationAction string) string {
+	terminationNotificationAction = s.groupTerminationNotificationAction(asgName, terminationNotificationAction)
+
+	switch terminationNotificationAction {
+	case DetachTerminationNotificationAction, TerminateTerminationNotificationAction:
+		return terminationNotificationAction
+	}
+
+	if s.asgHasTerminationLifecycleHook(&asgName) {
+		return TerminateTerminationNotificationAction
+	}
+	return DetachTerminationNotificationAction
+}
+
+// planAction returns the action executeAction would take for the given
+// instance, without changing anything.
+func (s *SpotTermination) planAction(instanceID *string, terminationNotificationAction string, eventType string) (plannedAction, error) {
+	if s.asSvc == nil {
+		return plannedAction{}, errors.New("AutoScaling service not defined. Please use NewSpotTermination()")
+	}
+
+	pa := plannedAction{
+		Region:     s.region,
+		InstanceID: *instanceID,
+		Action:     skipRunAction,
+	}
+
+	asgName, err := s.getAsgName(instanceID)
+	if err != nil {
+		log.Printf("Failed get ASG name for %s with err: %s\n", *instanceID, err.Error())
+		return pa, err
+	} else if asgName == "" {
+		pa.Reason = "instance does not belong to an autoscaling group"
+		return pa, nil
+	}
+
+	pa.AutoScalingGroup = asgName
+	pa.Reason = "spot instance received an interruption warning"
+	if eventType == InstanceRebalanceRecommendationCode {
+		pa.Reason = "spot instance received a rebalance recommendation"
+	}
+
+	pa.Action = s.resolveAction(asgName, terminationNotificationAction) + "-instance"
+	return pa, nil
 }
 
 func (s *SpotTermination) deleteTagInstanceLaunchedForAsg(instanceID *string) error {
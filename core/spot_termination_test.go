@@ -6,6 +6,8 @@ package autospotting
 import (
 	//	"encoding/json"
 	"errors"
+	"reflect"
+	"strings"
 	"testing"
 
 	//	"github.com/aws/aws-lambda-go/events"
@@ -147,6 +149,7 @@ func TestDetachInstance(t *testing.T) {
 	tests := []struct {
 		name            string
 		spotTermination *SpotTermination
+		eventType       string
 		expectedError   error
 	}{
 		{
@@ -154,6 +157,7 @@ func TestDetachInstance(t *testing.T) {
 			spotTermination: &SpotTermination{
 				asSvc: mockASG{dierr: errors.New("")},
 			},
+			eventType:     InstanceRebalanceRecommendationCode,
 			expectedError: errors.New(""),
 		},
 		{
@@ -170,14 +174,36 @@ func TestDetachInstance(t *testing.T) {
 				}},
 				ec2Svc: mockEC2{dto: &ec2.DeleteTagsOutput{}},
 			},
+			eventType:     InstanceRebalanceRecommendationCode,
 			expectedError: nil,
 		},
+		{
+			name: "When the termination of the detached instance is scheduled",
+			spotTermination: &SpotTermination{
+				asSvc:  mockASG{dio: &autoscaling.DetachInstancesOutput{}},
+				ec2Svc: mockEC2{dto: &ec2.DeleteTagsOutput{}, cto: &ec2.CreateTagsOutput{}},
+			},
+			eventType:     SpotInstanceInterruptionWarningCode,
+			expectedError: nil,
+		},
+		{
+			name: "When the termination of the detached instance can't be scheduled",
+			spotTermination: &SpotTermination{
+				asSvc:  mockASG{dio: &autoscaling.DetachInstancesOutput{}},
+				ec2Svc: mockEC2{dto: &ec2.DeleteTagsOutput{}, cterr: errors.New("UnauthorizedOperation")},
+			},
+			eventType: SpotInstanceInterruptionWarningCode,
+			expectedError: errors.New("instance dummyInstanceID was detached but its termination couldn't be scheduled, " +
+				"it needs to be terminated manually: UnauthorizedOperation"),
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			err := tc.spotTermination.detachInstance(&instanceID, asgName, InstanceRebalanceRecommendationCode)
-			if err != nil && err.Error() != tc.expectedError.Error() {
+			err := tc.spotTermination.detachInstance(&instanceID, asgName, tc.eventType)
+			if (err == nil) != (tc.expectedError == nil) {
+				t.Errorf("Error in DetachInstance: expected %v actual %v", tc.expectedError, err)
+			} else if err != nil && err.Error() != tc.expectedError.Error() {
 				t.Errorf("Error in DetachInstance: expected %s actual %s", tc.expectedError.Error(), err.Error())
 			}
 
@@ -420,7 +446,7 @@ func TestExecuteAction(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 
-			err := tc.spotTermination.executeAction(&instanceID, tc.terminationNotificationAction, InstanceRebalanceRecommendationCode)
+			_, err := tc.spotTermination.executeAction(&instanceID, tc.terminationNotificationAction, InstanceRebalanceRecommendationCode)
 
 			if err != nil && err.Error() != tc.expectedError.Error() {
 				t.Errorf("Error in ExecuteAction: expected %s actual %s", tc.expectedError.Error(), err.Error())
@@ -604,3 +630,170 @@ func TestIsInAutoSpottingASG(t *testing.T) {
 		})
 	}
 }
+
+func TestPlanAction(t *testing.T) {
+
+	instanceID := "dummyInstanceID"
+	asgName := "dummyASGName"
+
+	tests := []struct {
+		name                          string
+		spotTermination               *SpotTermination
+		terminationNotificationAction string
+		eventType                     string
+		want                          plannedAction
+		expectedError                 error
+	}{
+		{
+			name:            "When AutoScaling service is nil",
+			spotTermination: &SpotTermination{},
+			expectedError:   errors.New("AutoScaling service not defined. Please use NewSpotTermination()"),
+		},
+		{
+			name: "When the instance is not in an AutoScaling group",
+			spotTermination: &SpotTermination{
+				region: "foo",
+				asSvc: mockASG{dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+					AutoScalingInstances: []*autoscaling.InstanceDetails{},
+				}},
+			},
+			want: plannedAction{
+				Region:     "foo",
+				InstanceID: instanceID,
+				Action:     skipRunAction,
+				Reason:     "instance does not belong to an autoscaling group",
+			},
+		},
+		{
+			name: "When action is auto and the group has no termination hook",
+			spotTermination: &SpotTermination{
+				region: "foo",
+				asSvc: mockASG{
+					dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+						AutoScalingInstances: []*autoscaling.InstanceDetails{
+							{AutoScalingGroupName: &asgName},
+						},
+					},
+					dlho: &autoscaling.DescribeLifecycleHooksOutput{},
+				},
+			},
+			terminationNotificationAction: AutoTerminationNotificationAction,
+			eventType:                     SpotInstanceInterruptionWarningCode,
+			want: plannedAction{
+				Region:           "foo",
+				AutoScalingGroup: asgName,
+				InstanceID:       instanceID,
+				Action:           "detach-instance",
+				Reason:           "spot instance received an interruption warning",
+			},
+		},
+		{
+			name: "When action is terminate on a rebalance recommendation",
+			spotTermination: &SpotTermination{
+				region: "foo",
+				asSvc: mockASG{
+					dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+						AutoScalingInstances: []*autoscaling.InstanceDetails{
+							{AutoScalingGroupName: &asgName},
+						},
+					},
+				},
+			},
+			terminationNotificationAction: TerminateTerminationNotificationAction,
+			eventType:                     InstanceRebalanceRecommendationCode,
+			want: plannedAction{
+				Region:           "foo",
+				AutoScalingGroup: asgName,
+				InstanceID:       instanceID,
+				Action:           "terminate-instance",
+				Reason:           "spot instance received a rebalance recommendation",
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.spotTermination.planAction(&instanceID, tc.terminationNotificationAction, tc.eventType)
+
+			if !errorMatches(err, tc.expectedError) {
+				t.Errorf("planAction() error = %v, expected %v", err, tc.expectedError)
+				return
+			}
+			if err == nil && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("planAction() = %#v, expected %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExecuteAction_drainReport(t *testing.T) {
+	instanceID := "i-0123"
+	s := &SpotTermination{
+		asSvc: mockASG{
+			dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+				AutoScalingInstances: []*autoscaling.InstanceDetails{
+					{AutoScalingGroupName: aws.String("asg")},
+				},
+			},
+		},
+		lambdaSvc: mockLambda{ierr: errors.New("not found")},
+		region:    "us-east-1",
+		config: &Config{
+			AutoScalingConfig: AutoScalingConfig{DrainLambda: "drain"},
+		},
+	}
+
+	gr, err := s.executeAction(&instanceID, TerminateTerminationNotificationAction, SpotInstanceInterruptionWarningCode)
+
+	if err == nil || !strings.Contains(err.Error(), "lambda (failure)") {
+		t.Errorf("executeAction() error = %v, want the failed drain step", err)
+	}
+	if gr.Action != "terminate-instance" || gr.AutoScalingGroup != "asg" || gr.Reason != reasonSpotInterruptionWarning {
+		t.Errorf("executeAction() reported %+v", gr)
+	}
+	if len(gr.DrainSteps) != 1 || gr.DrainSteps[0].Result != drainStepFailed {
+		t.Errorf("executeAction() reported the drain steps %v", gr.DrainSteps)
+	}
+	if len(gr.Errors) != 1 {
+		t.Errorf("executeAction() reported the errors %v", gr.Errors)
+	}
+}
+
+func TestSpotTermination_fileOverrides(t *testing.T) {
+	instanceID := "i-0123"
+	s := &SpotTermination{
+		asSvc: mockASG{
+			dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+				AutoScalingInstances: []*autoscaling.InstanceDetails{
+					{AutoScalingGroupName: aws.String("asg")},
+				},
+			},
+			// the group has no tags overriding the configuration file
+			dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+				AutoScalingGroups: []*autoscaling.Group{{}},
+			},
+		},
+		region: "us-east-1",
+		config: &Config{
+			AutoScalingConfig: AutoScalingConfig{
+				TerminationNotificationAction: AutoTerminationNotificationAction,
+				RebalanceRecommendationAction: DetachRebalanceRecommendationAction,
+			},
+			groupOverrides: map[string]configOverrides{
+				"asg": {
+					"termination_notification_action": TerminateTerminationNotificationAction,
+					"rebalance_recommendation_action": ReplaceRebalanceRecommendationAction,
+				},
+			},
+		},
+	}
+
+	if got := s.groupTerminationNotificationAction("asg", AutoTerminationNotificationAction); got != TerminateTerminationNotificationAction {
+		t.Errorf("groupTerminationNotificationAction() = %v, expected %v", got, TerminateTerminationNotificationAction)
+	}
+	if got := s.rebalanceRecommendationAction(&instanceID, DetachRebalanceRecommendationAction); got != ReplaceRebalanceRecommendationAction {
+		t.Errorf("rebalanceRecommendationAction() = %v, expected %v", got, ReplaceRebalanceRecommendationAction)
+	}
+	if got := s.groupTerminationNotificationAction("other", AutoTerminationNotificationAction); got != AutoTerminationNotificationAction {
+		t.Errorf("groupTerminationNotificationAction() of a group without overrides = %v, expected %v", got, AutoTerminationNotificationAction)
+	}
+}
@@ -0,0 +1,535 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// state_store.go contains the persistent record of the lifecycle of the
+// replacements, keyed by the ID of the replaced instance. The replacements are
+// claimed before launching any instance, so duplicate event deliveries and
+// overlapping cron and SQS runs don't launch more than one replacement for
+// the same instance.
+
+// replacementState is a step of the lifecycle of a replacement
+type replacementState string
+
+// States of the lifecycle of a replacement
+const (
+	replacementRequested replacementState = "requested"
+	replacementLaunched  replacementState = "launched"
+	replacementSwapping  replacementState = "swapping"
+	replacementAttached  replacementState = "attached"
+	replacementCompleted replacementState = "completed"
+	replacementFailed    replacementState = "failed"
+)
+
+const (
+	// replacementTimeout is the time after which a replacement which is still
+	// in flight is considered abandoned, for example after the Lambda
+	// function timed out, and the instance can be claimed again.
+	replacementTimeout = 30 * time.Minute
+
+	// replacementRetention is the time for which the records are kept, using
+	// the expires_at attribute of the table's TTL in DynamoDB and pruning the
+	// older records from the memory and file stores.
+	replacementRetention = 7 * 24 * time.Hour
+)
+
+// errReplacementInProgress is returned when launching a replacement for an
+// instance already claimed by another replacement.
+var errReplacementInProgress = errors.New("replacement already in progress")
+
+// replacementRecord is the persisted state of the replacement of an instance.
+type replacementRecord struct {
+	InstanceID       string           `json:"instance_id" dynamodbav:"instance_id"`
+	AutoScalingGroup string           `json:"autoscaling_group" dynamodbav:"autoscaling_group"`
+	Region           string           `json:"region" dynamodbav:"region"`
+	SpotInstanceID   string           `json:"spot_instance_id,omitempty" dynamodbav:"spot_instance_id,omitempty"`
+	State            replacementState `json:"state" dynamodbav:"replacement_state"`
+	Reason           string           `json:"reason,omitempty" dynamodbav:"reason,omitempty"`
+
+	// UpdatedAt is the Unix time of the last state change
+	UpdatedAt int64 `json:"updated_at" dynamodbav:"updated_at"`
+
+	// ExpiresAt is the Unix time after which DynamoDB deletes the record
+	ExpiresAt int64 `json:"-" dynamodbav:"expires_at"`
+}
+
+// claimable returns whether a new replacement of the instance can be started,
+// which is the case after failures and once in-flight replacements are
+// abandoned. Completed replacements are never started again.
+func (rec *replacementRecord) claimable(now time.Time) bool {
+	switch rec.State {
+	case replacementCompleted:
+		return false
+	case replacementFailed:
+		return true
+	default:
+		return now.Sub(time.Unix(rec.UpdatedAt, 0)) >= replacementTimeout
+	}
+}
+
+// swappable returns whether the instance can be swapped with the given spot
+// instance, which is the case when it's claimable or when its replacement
+// launched that spot instance.
+func (rec *replacementRecord) swappable(spotInstanceID string, now time.Time) bool {
+	if rec.State == replacementLaunched && rec.SpotInstanceID == spotInstanceID {
+		return true
+	}
+	return rec.claimable(now)
+}
+
+// replacementStore persists the lifecycle of the replacements.
+type replacementStore interface {
+	// claim atomically records the requested replacement of the instance,
+	// returning false when it can't be claimed because another replacement
+	// of the instance is in flight or completed.
+	claim(rec replacementRecord) (bool, error)
+
+	// claimSwap atomically records the swap of the instance with the spot
+	// instance of the record, returning false when another swap or
+	// replacement of the instance is in flight or completed.
+	claimSwap(rec replacementRecord) (bool, error)
+
+	// update records the new state of the replacement of the instance.
+	update(instanceID string, state replacementState, spotInstanceID string, reason string) error
+
+	// get returns the replacement of the instance, or nil if there is none.
+	get(instanceID string) (*replacementRecord, error)
+}
+
+// newReplacementStore returns the store configured for persisting the
+// replacements: a DynamoDB table, a local file or, by default, the memory of
+// the current process, which is reported as a warning when running in Lambda.
+func newReplacementStore(cfg *Config) replacementStore {
+	switch {
+	case cfg.StateTable != "":
+		log.Println("Persisting the replacements in the DynamoDB table", cfg.StateTable)
+		return &dynamoDBReplacementStore{
+			svc:   dynamodb.New(newSession(cfg.MainRegion)),
+			table: cfg.StateTable,
+		}
+	case cfg.StateFile != "":
+		log.Println("Persisting the replacements in the file", cfg.StateFile)
+		return &fileReplacementStore{path: cfg.StateFile}
+	default:
+		if RunningFromLambda() {
+			log.Println("WARNING: the replacements are only kept in the memory of the Lambda " +
+				"function, so concurrent invocations may replace the same instance more than once. " +
+				"Set the state_table option to a DynamoDB table to persist them.")
+		}
+		return newMemoryReplacementStore()
+	}
+}
+
+// memoryReplacementStore keeps the replacements in memory, only deduplicating
+// the events processed by the same process.
+type memoryReplacementStore struct {
+	sync.Mutex
+	records map[string]replacementRecord
+}
+
+func newMemoryReplacementStore() *memoryReplacementStore {
+	return &memoryReplacementStore{records: make(map[string]replacementRecord)}
+}
+
+func (m *memoryReplacementStore) claim(rec replacementRecord) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	return claimRecord(m.records, rec), nil
+}
+
+func (m *memoryReplacementStore) claimSwap(rec replacementRecord) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	return claimSwapRecord(m.records, rec), nil
+}
+
+func (m *memoryReplacementStore) update(instanceID string, state replacementState, spotInstanceID string, reason string) error {
+	m.Lock()
+	defer m.Unlock()
+	updateRecord(m.records, instanceID, state, spotInstanceID, reason)
+	return nil
+}
+
+func (m *memoryReplacementStore) get(instanceID string) (*replacementRecord, error) {
+	m.Lock()
+	defer m.Unlock()
+	if rec, found := m.records[instanceID]; found {
+		return &rec, nil
+	}
+	return nil, nil
+}
+
+// fileReplacementStore keeps the replacements in a JSON file, for local runs
+// and tests. It's only safe for use by a single process at a time.
+type fileReplacementStore struct {
+	sync.Mutex
+	path string
+}
+
+func (f *fileReplacementStore) load() (map[string]replacementRecord, error) {
+	records := make(map[string]replacementRecord)
+
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		log.Println("Couldn't read the state file", f.path, err.Error())
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return records, nil
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		log.Println("Couldn't parse the state file", f.path, err.Error())
+		return nil, err
+	}
+	return records, nil
+}
+
+// save writes the records to a temporary file renamed over the state file, so
+// that it's never left partially written.
+func (f *fileReplacementStore) save(records map[string]replacementRecord) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		log.Println("Couldn't write the state file", tmp, err.Error())
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+func (f *fileReplacementStore) claim(rec replacementRecord) (bool, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	records, err := f.load()
+	if err != nil {
+		return false, err
+	}
+	if !claimRecord(records, rec) {
+		return false, nil
+	}
+	return true, f.save(records)
+}
+
+func (f *fileReplacementStore) claimSwap(rec replacementRecord) (bool, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	records, err := f.load()
+	if err != nil {
+		return false, err
+	}
+	if !claimSwapRecord(records, rec) {
+		return false, nil
+	}
+	return true, f.save(records)
+}
+
+func (f *fileReplacementStore) update(instanceID string, state replacementState, spotInstanceID string, reason string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	records, err := f.load()
+	if err != nil {
+		return err
+	}
+	updateRecord(records, instanceID, state, spotInstanceID, reason)
+	return f.save(records)
+}
+
+func (f *fileReplacementStore) get(instanceID string) (*replacementRecord, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	records, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	if rec, found := records[instanceID]; found {
+		return &rec, nil
+	}
+	return nil, nil
+}
+
+// pruneRecords removes the records not updated for longer than the
+// retention, like the records expired by DynamoDB.
+func pruneRecords(records map[string]replacementRecord, now time.Time) {
+	for id, rec := range records {
+		if now.Sub(time.Unix(rec.UpdatedAt, 0)) >= replacementRetention {
+			delete(records, id)
+		}
+	}
+}
+
+// claimRecord adds the requested replacement to the records unless the
+// instance can't be claimed, returning whether it was added.
+func claimRecord(records map[string]replacementRecord, rec replacementRecord) bool {
+	now := time.Now()
+	pruneRecords(records, now)
+	if existing, found := records[rec.InstanceID]; found && !existing.claimable(now) {
+		return false
+	}
+	rec.State = replacementRequested
+	rec.UpdatedAt = now.Unix()
+	records[rec.InstanceID] = rec
+	return true
+}
+
+// claimSwapRecord adds the swap to the records unless the instance can't be
+// claimed, returning whether it was added. Besides the claimable instances,
+// the ones whose replacement launched the spot instance of the swap can be
+// claimed for swapping it.
+func claimSwapRecord(records map[string]replacementRecord, rec replacementRecord) bool {
+	now := time.Now()
+	pruneRecords(records, now)
+	if existing, found := records[rec.InstanceID]; found && !existing.swappable(rec.SpotInstanceID, now) {
+		return false
+	}
+	rec.State = replacementSwapping
+	rec.UpdatedAt = now.Unix()
+	records[rec.InstanceID] = rec
+	return true
+}
+
+func updateRecord(records map[string]replacementRecord, instanceID string, state replacementState, spotInstanceID string, reason string) {
+	now := time.Now()
+	pruneRecords(records, now)
+
+	rec := records[instanceID]
+	rec.InstanceID = instanceID
+	rec.State = state
+	rec.UpdatedAt = now.Unix()
+	rec.Reason = reason
+	if spotInstanceID != "" {
+		rec.SpotInstanceID = spotInstanceID
+	}
+	records[instanceID] = rec
+}
+
+// dynamoDBReplacementStore keeps the replacements in a DynamoDB table with the
+// instance_id string partition key, shared by all the AutoSpotting processes.
+type dynamoDBReplacementStore struct {
+	svc   dynamodbiface.DynamoDBAPI
+	table string
+}
+
+func (d *dynamoDBReplacementStore) claim(rec replacementRecord) (bool, error) {
+	now := time.Now()
+	rec.State = replacementRequested
+	rec.UpdatedAt = now.Unix()
+	rec.ExpiresAt = now.Add(replacementRetention).Unix()
+
+	item, err := dynamodbattribute.MarshalMap(rec)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = d.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item:      item,
+		// the same conditions as replacementRecord.claimable, evaluated
+		// atomically by DynamoDB
+		ConditionExpression: aws.String("attribute_not_exists(instance_id) OR " +
+			"replacement_state = :failed OR " +
+			"(replacement_state <> :completed AND updated_at <= :abandoned)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":failed":    {S: aws.String(string(replacementFailed))},
+			":completed": {S: aws.String(string(replacementCompleted))},
+			":abandoned": {N: aws.String(strconv.FormatInt(now.Add(-replacementTimeout).Unix(), 10))},
+		},
+	})
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		log.Println("Couldn't claim the replacement of", rec.InstanceID, err.Error())
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *dynamoDBReplacementStore) claimSwap(rec replacementRecord) (bool, error) {
+	now := time.Now()
+	rec.State = replacementSwapping
+	rec.UpdatedAt = now.Unix()
+	rec.ExpiresAt = now.Add(replacementRetention).Unix()
+
+	item, err := dynamodbattribute.MarshalMap(rec)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = d.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item:      item,
+		// the same conditions as replacementRecord.swappable, evaluated
+		// atomically by DynamoDB
+		ConditionExpression: aws.String("attribute_not_exists(instance_id) OR " +
+			"(replacement_state = :launched AND spot_instance_id = :spot) OR " +
+			"replacement_state = :failed OR " +
+			"(replacement_state <> :completed AND updated_at <= :abandoned)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":launched":  {S: aws.String(string(replacementLaunched))},
+			":spot":      {S: aws.String(rec.SpotInstanceID)},
+			":failed":    {S: aws.String(string(replacementFailed))},
+			":completed": {S: aws.String(string(replacementCompleted))},
+			":abandoned": {N: aws.String(strconv.FormatInt(now.Add(-replacementTimeout).Unix(), 10))},
+		},
+	})
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		log.Println("Couldn't claim the swap of", rec.InstanceID, err.Error())
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *dynamoDBReplacementStore) update(instanceID string, state replacementState, spotInstanceID string, reason string) error {
+	now := time.Now()
+
+	expression := "SET replacement_state = :state, updated_at = :now, expires_at = :expires, reason = :reason"
+	values := map[string]*dynamodb.AttributeValue{
+		":state":   {S: aws.String(string(state))},
+		":now":     {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		":expires": {N: aws.String(strconv.FormatInt(now.Add(replacementRetention).Unix(), 10))},
+		// empty strings are only allowed in non-key attributes
+		":reason": {S: aws.String(reason)},
+	}
+	if spotInstanceID != "" {
+		expression += ", spot_instance_id = :spot"
+		values[":spot"] = &dynamodb.AttributeValue{S: aws.String(spotInstanceID)}
+	}
+
+	_, err := d.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(d.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"instance_id": {S: aws.String(instanceID)},
+		},
+		UpdateExpression:          aws.String(expression),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		log.Println("Couldn't update the replacement of", instanceID, "to", state, err.Error())
+	}
+	return err
+}
+
+func (d *dynamoDBReplacementStore) get(instanceID string) (*replacementRecord, error) {
+	resp, err := d.svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(d.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"instance_id": {S: aws.String(instanceID)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		log.Println("Couldn't get the replacement of", instanceID, err.Error())
+		return nil, err
+	}
+	if len(resp.Item) == 0 {
+		return nil, nil
+	}
+
+	var rec replacementRecord
+	if err := dynamodbattribute.UnmarshalMap(resp.Item, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// claimReplacement claims the replacement of the instance in the store of the
+// configuration, always succeeding when no store is configured.
+func (r *region) claimReplacement(i *instance) (bool, error) {
+	if r.conf == nil || r.conf.replacements == nil {
+		return true, nil
+	}
+
+	rec := replacementRecord{
+		InstanceID: *i.InstanceId,
+		Region:     r.name,
+	}
+	if i.asg != nil {
+		rec.AutoScalingGroup = i.asg.name
+	}
+	return r.conf.replacements.claim(rec)
+}
+
+// claimSwap claims the swap of the instance with the spot instance in the
+// store of the configuration, always succeeding when no store is configured.
+func (r *region) claimSwap(i *instance, spotInstanceID string) (bool, error) {
+	if r.conf == nil || r.conf.replacements == nil {
+		return true, nil
+	}
+
+	rec := replacementRecord{
+		InstanceID:     *i.InstanceId,
+		Region:         r.name,
+		SpotInstanceID: spotInstanceID,
+	}
+	if i.asg != nil {
+		rec.AutoScalingGroup = i.asg.name
+	}
+	return r.conf.replacements.claimSwap(rec)
+}
+
+// recordReplacement records the new state of the replacement of the instance,
+// only logging the failures since the replacement itself already happened.
+func (r *region) recordReplacement(instanceID string, state replacementState, spotInstanceID string, reason string) {
+	if r.conf == nil || r.conf.replacements == nil {
+		return
+	}
+	if err := r.conf.replacements.update(instanceID, state, spotInstanceID, reason); err != nil {
+		log.Println(r.name, "Couldn't record the", state, "replacement of", instanceID, err.Error())
+	}
+}
+
+// replacementBlocked returns whether the instance can't be claimed for a new
+// replacement, because another one is in flight or completed.
+func (r *region) replacementBlocked(instanceID string) bool {
+	if r.conf == nil || r.conf.replacements == nil {
+		return false
+	}
+	rec, err := r.conf.replacements.get(instanceID)
+	return err == nil && rec != nil && !rec.claimable(time.Now())
+}
+
+// replacementIs returns whether the replacement of the instance is known to
+// be in the given state.
+func (r *region) replacementIs(instanceID string, state replacementState) bool {
+	if r.conf == nil || r.conf.replacements == nil {
+		return false
+	}
+	rec, err := r.conf.replacements.get(instanceID)
+	return err == nil && rec != nil && rec.State == state
+}
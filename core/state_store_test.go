@@ -0,0 +1,327 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestReplacementRecord_claimable(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-time.Minute).Unix()
+	abandoned := now.Add(-replacementTimeout).Unix()
+
+	tests := []struct {
+		name string
+		rec  replacementRecord
+		want bool
+	}{
+		{name: "recently requested", rec: replacementRecord{State: replacementRequested, UpdatedAt: recent}},
+		{name: "recently launched", rec: replacementRecord{State: replacementLaunched, UpdatedAt: recent}},
+		{name: "recently swapping", rec: replacementRecord{State: replacementSwapping, UpdatedAt: recent}},
+		{name: "recently attached", rec: replacementRecord{State: replacementAttached, UpdatedAt: recent}},
+		{name: "abandoned launch", rec: replacementRecord{State: replacementLaunched, UpdatedAt: abandoned}, want: true},
+		{name: "failed", rec: replacementRecord{State: replacementFailed, UpdatedAt: recent}, want: true},
+		{name: "completed", rec: replacementRecord{State: replacementCompleted, UpdatedAt: abandoned}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rec.claimable(now); got != tt.want {
+				t.Errorf("claimable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testReplacementLifecycle checks that the store deduplicates the claims
+// across the whole lifecycle of a replacement.
+func testReplacementLifecycle(t *testing.T, store replacementStore) {
+	rec := replacementRecord{InstanceID: "i-ondemand", AutoScalingGroup: "asg", Region: "us-east-1"}
+
+	if claimed, err := store.claim(rec); !claimed || err != nil {
+		t.Fatalf("first claim() = %v, %v, want true", claimed, err)
+	}
+	if claimed, err := store.claim(rec); claimed || err != nil {
+		t.Fatalf("duplicate claim() = %v, %v, want false", claimed, err)
+	}
+
+	for _, state := range []replacementState{replacementLaunched, replacementAttached, replacementCompleted} {
+		if err := store.update(rec.InstanceID, state, "i-spot", ""); err != nil {
+			t.Fatalf("update(%s) error = %v", state, err)
+		}
+		if claimed, _ := store.claim(rec); claimed {
+			t.Fatalf("claim() succeeded after the replacement was %s", state)
+		}
+	}
+
+	got, err := store.get(rec.InstanceID)
+	if err != nil || got == nil {
+		t.Fatalf("get() = %v, %v", got, err)
+	}
+	if got.State != replacementCompleted || got.SpotInstanceID != "i-spot" || got.AutoScalingGroup != "asg" {
+		t.Errorf("get() = %+v", got)
+	}
+
+	failed := replacementRecord{InstanceID: "i-failed"}
+	store.claim(failed)
+	store.update(failed.InstanceID, replacementFailed, "", "InsufficientInstanceCapacity")
+	if claimed, err := store.claim(failed); !claimed || err != nil {
+		t.Errorf("claim() after failure = %v, %v, want true", claimed, err)
+	}
+
+	if got, err := store.get("i-unknown"); got != nil || err != nil {
+		t.Errorf("get() of unknown instance = %v, %v, want nil", got, err)
+	}
+}
+
+// testSwapClaims checks that the store only lets one swap proceed for the
+// same instance, whether it was launched by this or another run.
+func testSwapClaims(t *testing.T, store replacementStore) {
+	launched := replacementRecord{InstanceID: "i-launched", SpotInstanceID: "i-spot"}
+	store.claim(launched)
+	store.update(launched.InstanceID, replacementLaunched, "i-spot", "")
+
+	tests := []struct {
+		name string
+		rec  replacementRecord
+		want bool
+	}{
+		{name: "spot instance launched for the replacement", rec: launched, want: true},
+		{name: "swap already in flight", rec: launched},
+		{name: "other spot instance", rec: replacementRecord{InstanceID: "i-launched", SpotInstanceID: "i-other"}},
+		{name: "instance without replacement", rec: replacementRecord{InstanceID: "i-cron", SpotInstanceID: "i-spot"}, want: true},
+		{name: "cron and event swapping the same instance", rec: replacementRecord{InstanceID: "i-cron", SpotInstanceID: "i-spot"}},
+	}
+	for _, tt := range tests {
+		if got, err := store.claimSwap(tt.rec); got != tt.want || err != nil {
+			t.Errorf("%s: claimSwap() = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+
+	if got, _ := store.get("i-launched"); got == nil || got.State != replacementSwapping {
+		t.Errorf("get() after claimSwap() = %+v, want %s", got, replacementSwapping)
+	}
+
+	store.update("i-launched", replacementCompleted, "i-spot", "")
+	if claimed, _ := store.claimSwap(launched); claimed {
+		t.Error("claimSwap() succeeded after the replacement was completed")
+	}
+}
+
+// testRecordsPruning checks that the store drops the records older than the
+// retention, once records are claimed or updated.
+func testRecordsPruning(t *testing.T, store replacementStore, expired func(instanceID string)) {
+	store.claim(replacementRecord{InstanceID: "i-old"})
+	store.update("i-old", replacementCompleted, "i-spot", "")
+	expired("i-old")
+
+	tests := []struct {
+		name   string
+		change func()
+	}{
+		{name: "claim", change: func() { store.claim(replacementRecord{InstanceID: "i-new"}) }},
+		{name: "swap claim", change: func() { store.claimSwap(replacementRecord{InstanceID: "i-swap", SpotInstanceID: "i-spot"}) }},
+		{name: "update", change: func() { store.update("i-new", replacementLaunched, "i-spot", "") }},
+	}
+	for _, tt := range tests {
+		tt.change()
+		if got, err := store.get("i-old"); got != nil || err != nil {
+			t.Fatalf("%s: get() of the expired record = %+v, %v, want nil", tt.name, got, err)
+		}
+		if got, _ := store.get("i-new"); got == nil {
+			t.Fatalf("%s: get() of the recent record = nil", tt.name)
+		}
+
+		store.claim(replacementRecord{InstanceID: "i-old"})
+		expired("i-old")
+	}
+}
+
+func TestMemoryReplacementStore(t *testing.T) {
+	testReplacementLifecycle(t, newMemoryReplacementStore())
+	testSwapClaims(t, newMemoryReplacementStore())
+
+	m := newMemoryReplacementStore()
+	testRecordsPruning(t, m, func(instanceID string) {
+		rec := m.records[instanceID]
+		rec.UpdatedAt = time.Now().Add(-replacementRetention).Unix()
+		m.records[instanceID] = rec
+	})
+}
+
+func TestFileReplacementStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replacements.json")
+	testReplacementLifecycle(t, &fileReplacementStore{path: path})
+	testSwapClaims(t, &fileReplacementStore{path: filepath.Join(t.TempDir(), "swaps.json")})
+
+	f := &fileReplacementStore{path: filepath.Join(t.TempDir(), "pruned.json")}
+	testRecordsPruning(t, f, func(instanceID string) {
+		records, _ := f.load()
+		rec := records[instanceID]
+		rec.UpdatedAt = time.Now().Add(-replacementRetention).Unix()
+		records[instanceID] = rec
+		f.save(records)
+	})
+
+	// the records survive across processes
+	reopened := &fileReplacementStore{path: path}
+	if got, err := reopened.get("i-ondemand"); err != nil || got == nil || got.State != replacementCompleted {
+		t.Errorf("get() from the reopened file = %v, %v", got, err)
+	}
+}
+
+func TestDynamoDBReplacementStore_claim(t *testing.T) {
+	tests := []struct {
+		name    string
+		ddb     mockDynamoDB
+		want    bool
+		wantErr bool
+	}{
+		{name: "claimed", ddb: mockDynamoDB{}, want: true},
+		{
+			name: "already claimed",
+			ddb: mockDynamoDB{pierr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException,
+				"The conditional request failed", nil)},
+		},
+		{name: "table missing", ddb: mockDynamoDB{pierr: errors.New("ResourceNotFoundException")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dynamoDBReplacementStore{svc: tt.ddb, table: "replacements"}
+			got, err := d.claim(replacementRecord{InstanceID: "i-ondemand"})
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("claim() = %v, %v, want %v, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDynamoDBReplacementStore_claimSwap(t *testing.T) {
+	tests := []struct {
+		name    string
+		ddb     mockDynamoDB
+		want    bool
+		wantErr bool
+	}{
+		{name: "claimed", ddb: mockDynamoDB{}, want: true},
+		{
+			name: "already swapped",
+			ddb: mockDynamoDB{pierr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException,
+				"The conditional request failed", nil)},
+		},
+		{name: "throttled", ddb: mockDynamoDB{pierr: errors.New("throttled")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dynamoDBReplacementStore{svc: tt.ddb, table: "replacements"}
+			got, err := d.claimSwap(replacementRecord{InstanceID: "i-ondemand", SpotInstanceID: "i-spot"})
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("claimSwap() = %v, %v, want %v, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewReplacementStore(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         *Config
+		lambda      bool
+		wantWarning bool
+	}{
+		{name: "memory outside Lambda", cfg: &Config{}},
+		{name: "memory in Lambda", cfg: &Config{}, lambda: true, wantWarning: true},
+		{name: "file in Lambda", cfg: &Config{StateFile: "replacements.json"}, lambda: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.lambda {
+				os.Setenv("AWS_LAMBDA_FUNCTION_NAME", "AutoSpotting")
+				defer os.Unsetenv("AWS_LAMBDA_FUNCTION_NAME")
+			}
+
+			var logs bytes.Buffer
+			defer log.SetOutput(log.Writer())
+			log.SetOutput(&logs)
+
+			newReplacementStore(tt.cfg)
+			if got := bytes.Contains(logs.Bytes(), []byte("WARNING")); got != tt.wantWarning {
+				t.Errorf("newReplacementStore() logged %q, want warning %v", logs.String(), tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestDynamoDBReplacementStore_get(t *testing.T) {
+	item, _ := dynamodbattribute.MarshalMap(replacementRecord{
+		InstanceID:     "i-ondemand",
+		SpotInstanceID: "i-spot",
+		State:          replacementAttached,
+	})
+
+	tests := []struct {
+		name    string
+		ddb     mockDynamoDB
+		want    *replacementRecord
+		wantErr bool
+	}{
+		{
+			name: "found",
+			ddb:  mockDynamoDB{gio: &dynamodb.GetItemOutput{Item: item}},
+			want: &replacementRecord{InstanceID: "i-ondemand", SpotInstanceID: "i-spot", State: replacementAttached},
+		},
+		{name: "not found", ddb: mockDynamoDB{gio: &dynamodb.GetItemOutput{}}},
+		{name: "throttled", ddb: mockDynamoDB{gierr: errors.New("throttled")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dynamoDBReplacementStore{svc: tt.ddb, table: "replacements"}
+			got, err := d.get("i-ondemand")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDynamoDBReplacementStore_update(t *testing.T) {
+	d := &dynamoDBReplacementStore{svc: mockDynamoDB{uierr: errors.New("throttled")}, table: "replacements"}
+	if err := d.update("i-ondemand", replacementLaunched, "i-spot", ""); err == nil {
+		t.Error("update() didn't return the DynamoDB error")
+	}
+}
+
+func TestLaunchSpotReplacement_alreadyReplaced(t *testing.T) {
+	store := newMemoryReplacementStore()
+	store.claim(replacementRecord{InstanceID: "i-ondemand"})
+	store.update("i-ondemand", replacementCompleted, "i-spot", "")
+
+	i := &instance{
+		Instance: &ec2.Instance{InstanceId: aws.String("i-ondemand")},
+		region: &region{
+			name:     "us-east-1",
+			conf:     &Config{replacements: store},
+			services: connections{ec2: mockEC2{}},
+		},
+	}
+
+	if _, err := i.launchSpotReplacement(); !errors.Is(err, errReplacementInProgress) {
+		t.Errorf("launchSpotReplacement() error = %v, want %v", err, errReplacementInProgress)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// configTagPrefix is the prefix of all the tags configuring AutoSpotting on
+	// a per-group level
+	configTagPrefix = "autospotting_"
+
+	// ConfigErrorsTag is the name of the tag set on the AutoScaling groups
+	// listing the invalid or unknown configuration tags found on them, when
+	// enabled using the write_config_errors_tag option.
+	ConfigErrorsTag = "autospotting_config_errors"
+
+	// maxTagValueLength is the maximum length of an AutoScaling tag value
+	maxTagValueLength = 256
+)
+
+// tagValidators maps the supported configuration tags to the functions
+// validating their values.
+var tagValidators = func() map[string]func(string) error {
+	validators := settingTagValidators()
+	validators[EnableInstanceLaunchEventHandlingTag] = validateBool
+	return validators
+}()
+
+// validateConfigTags checks all the configuration tags of the group, returning
+// a sorted list of errors for the unknown tags and the invalid values, which
+// would otherwise be silently replaced by the default configuration.
+func (a *autoScalingGroup) validateConfigTags() []string {
+	var errs []string
+
+	for _, tag := range a.Tags {
+		key := aws.StringValue(tag.Key)
+		if !strings.HasPrefix(key, configTagPrefix) || key == ConfigErrorsTag {
+			continue
+		}
+
+		validate, known := tagValidators[key]
+		if !known {
+			errs = append(errs, fmt.Sprintf("%s: unknown tag", key))
+			continue
+		}
+
+		if err := validate(aws.StringValue(tag.Value)); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: invalid value %q, %s",
+				key, aws.StringValue(tag.Value), err.Error()))
+		}
+	}
+
+	sort.Strings(errs)
+	return errs
+}
+
+// updateConfigErrorsTag sets the ConfigErrorsTag on the group when any of its
+// configuration tags are invalid, so that the group owners can see them, and
+// removes it once they were fixed.
+func (a *autoScalingGroup) updateConfigErrorsTag(errs []string) error {
+	current := a.getTagValue(ConfigErrorsTag)
+
+	if len(errs) == 0 && current == nil {
+		return nil
+	}
+
+	value := strings.Join(errs, "; ")
+	if len(value) > maxTagValueLength {
+		value = value[:maxTagValueLength-3] + "..."
+	}
+
+	if current != nil && *current == value {
+		return nil
+	}
+
+	if a.region.conf.DryRun {
+		log.Println(a.region.name, a.name, "Dry run, not updating the", ConfigErrorsTag, "tag to", value)
+		return nil
+	}
+
+	svc := a.region.services.autoScaling
+	tag := &autoscaling.Tag{
+		ResourceId:        aws.String(a.name),
+		ResourceType:      aws.String("auto-scaling-group"),
+		Key:               aws.String(ConfigErrorsTag),
+		Value:             aws.String(value),
+		PropagateAtLaunch: aws.Bool(false),
+	}
+
+	var err error
+	if len(errs) == 0 {
+		tag.Value = nil
+		_, err = svc.DeleteTags(&autoscaling.DeleteTagsInput{Tags: []*autoscaling.Tag{tag}})
+	} else {
+		_, err = svc.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{Tags: []*autoscaling.Tag{tag}})
+	}
+
+	if err != nil {
+		log.Println(a.region.name, a.name, "Couldn't update the", ConfigErrorsTag, "tag:", err.Error())
+		return err
+	}
+	return nil
+}
+
+// checkConfigTags validates the configuration tags of the group, logging the
+// errors and optionally writing them to the ConfigErrorsTag.
+func (a *autoScalingGroup) checkConfigTags() []string {
+	errs := a.validateConfigTags()
+	for _, e := range errs {
+		log.Println(a.region.name, a.name, "Invalid configuration tag", e)
+	}
+
+	if a.region.conf.WriteConfigErrorsTag {
+		a.updateConfigErrorsTag(errs)
+	}
+	return errs
+}
+
+func validateFloatRange(min, max float64) func(string) error {
+	return func(value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.New("expected a number")
+		}
+		if f < min || f > max {
+			return fmt.Errorf("expected a number between %v and %v", min, max)
+		}
+		return nil
+	}
+}
+
+func validatePositiveFloat(value string) error {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f <= 0 {
+		return errors.New("expected a positive number")
+	}
+	return nil
+}
+
+func validateNonNegativeFloat(value string) error {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 {
+		return errors.New("expected a non-negative number")
+	}
+	return nil
+}
+
+func validateNonNegativeInt(value string) error {
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return errors.New("expected a non-negative integer")
+	}
+	return nil
+}
+
+func validateBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return errors.New("expected true or false")
+	}
+	return nil
+}
+
+func validateNotEmpty(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("expected a non-empty value")
+	}
+	return nil
+}
+
+func validateOneOf(allowed ...string) func(string) error {
+	return func(value string) error {
+		for _, a := range allowed {
+			if value == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("expected one of %s", strings.Join(allowed, ", "))
+	}
+}
+
+// validateInstanceTypePatterns checks the comma or space separated instance
+// type globs, also accepting the special "current" value.
+func validateInstanceTypePatterns(value string) error {
+	patterns := strings.FieldsFunc(value, func(c rune) bool { return c == ',' || c == ' ' })
+	if len(patterns) == 0 {
+		return errors.New("expected a list of instance types")
+	}
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid instance type pattern %q", p)
+		}
+	}
+	return nil
+}
+
+// validateCronSchedule checks the simplified cron format used by the
+// schedule, only containing the hour and day of week fields.
+func validateCronSchedule(value string) error {
+	if _, err := cron.NewParser(cron.Hour | cron.Dow).Parse(value); err != nil {
+		return fmt.Errorf("expected the hour and day of week cron fields: %s", err.Error())
+	}
+	return nil
+}
+
+// validateAttributeRange checks the ranges of instance type attributes, such
+// as "4-16", "4-", "-16" or "8".
+func validateAttributeRange(value string) error {
+	if _, err := parseAttributeRange(value); err != nil {
+		return fmt.Errorf("expected a range such as 4-16: %s", err.Error())
+	}
+	return nil
+}
+
+// validateGPUModels checks the comma or space separated GPU model globs, also
+// accepting the special "current" value.
+func validateGPUModels(value string) error {
+	patterns := strings.FieldsFunc(value, func(c rune) bool { return c == ',' || c == ' ' })
+	if len(patterns) == 0 {
+		return errors.New("expected a list of GPU models")
+	}
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid GPU model pattern %q", p)
+		}
+	}
+	return nil
+}
+
+// validateImage checks the AMI IDs and the SSM parameter paths storing them.
+func validateImage(value string) error {
+	if strings.HasPrefix(value, "ami-") || strings.HasPrefix(value, "/") ||
+		strings.HasPrefix(value, ssmParameterImagePrefix+"/") {
+		return nil
+	}
+	return errors.New("expected an AMI ID or the path of an SSM parameter")
+}
+
+func validateTimezone(value string) error {
+	if _, err := time.LoadLocation(value); err != nil {
+		return errors.New("expected a timezone name such as Europe/London")
+	}
+	return nil
+}
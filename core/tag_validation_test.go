@@ -0,0 +1,131 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func groupWithTags(tags map[string]string) *autoscaling.Group {
+	group := &autoscaling.Group{}
+	for k, v := range tags {
+		group.Tags = append(group.Tags, &autoscaling.TagDescription{
+			Key:   aws.String(k),
+			Value: aws.String(v),
+		})
+	}
+	return group
+}
+
+func Test_autoScalingGroup_validateConfigTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]string
+		want []string
+	}{
+		{
+			name: "no configuration tags",
+			tags: map[string]string{"spot-enabled": "true", "Name": "web"},
+		},
+		{
+			name: "valid configuration tags",
+			tags: map[string]string{
+				OnDemandNumberLong:           "2",
+				OnDemandPercentageTag:        "33.3",
+				BiddingPolicyTag:             "aggressive",
+				SpotPriceBufferPercentageTag: "0",
+				AllowedInstanceTypesTag:      "m5.*, c5.large",
+				ScheduleTag:                  "9-18 1-5",
+				TimezoneTag:                  "Europe/London",
+				CronScheduleStateTag:         "off",
+				PatchBeanstalkUserdataTag:    "true",
+				SpotAllocationStrategyTag:    "capacity-optimized",
+				ConfigErrorsTag:              "previous errors",
+			},
+		},
+		{
+			name: "invalid and unknown configuration tags",
+			tags: map[string]string{
+				BiddingPolicyTag:                   "agressive",
+				OnDemandPercentageTag:              "150",
+				ScheduleTag:                        "9-18 1-5 *",
+				TimezoneTag:                        "Mars/Olympus",
+				AllowedInstanceTypesTag:            "m5.[",
+				"autospotting_min_on_demand_numbr": "1",
+			},
+			want: []string{
+				`autospotting_allowed_instance_types: invalid value "m5.[", invalid instance type pattern "m5.["`,
+				`autospotting_bidding_policy: invalid value "agressive", expected one of normal, aggressive`,
+				`autospotting_cron_schedule: invalid value "9-18 1-5 *", expected the hour and day of week cron fields: expected exactly 2 fields, found 3: [9-18 1-5 *]`,
+				`autospotting_cron_timezone: invalid value "Mars/Olympus", expected a timezone name such as Europe/London`,
+				`autospotting_min_on_demand_numbr: unknown tag`,
+				`autospotting_min_on_demand_percentage: invalid value "150", expected a number between 0 and 100`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{Group: groupWithTags(tt.tags)}
+			if got := a.validateConfigTags(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateConfigTags() got %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_updateConfigErrorsTag(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    map[string]string
+		errs    []string
+		asg     mockASG
+		wantErr bool
+	}{
+		{
+			name: "nothing to do",
+			asg:  mockASG{couterr: errors.New("unexpected"), dtgerr: errors.New("unexpected")},
+		},
+		{
+			name: "unchanged errors",
+			tags: map[string]string{ConfigErrorsTag: "a; b"},
+			errs: []string{"a", "b"},
+			asg:  mockASG{couterr: errors.New("unexpected"), dtgerr: errors.New("unexpected")},
+		},
+		{
+			name:    "errors written",
+			errs:    []string{"a"},
+			asg:     mockASG{couterr: errors.New("failed")},
+			wantErr: true,
+		},
+		{
+			name:    "fixed errors removed",
+			tags:    map[string]string{ConfigErrorsTag: "a"},
+			asg:     mockASG{dtgerr: errors.New("failed")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: groupWithTags(tt.tags),
+				name:  "web",
+				region: &region{
+					name:     "us-east-1",
+					conf:     &Config{},
+					services: connections{autoScaling: tt.asg},
+				},
+			}
+			if err := a.updateConfigErrorsTag(tt.errs); (err != nil) != tt.wantErr {
+				t.Errorf("updateConfigErrorsTag() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// warm_pool.go contains the functions handling the groups with warm pools,
+// whose pre-initialized instances are always on-demand and wait in the Warmed:*
+// lifecycle states, usually stopped, until the group scales out.
+
+// The scaling activities moving instances from the warm pool into service are
+// described as "Launching a new EC2 instance from warm pool: i-0123456789"
+var warmPoolActivityRegexp = regexp.MustCompile(`from warm pool: (i-[0-9a-f]+)`)
+
+func isWarmPoolLifecycleState(state string) bool {
+	return strings.HasPrefix(state, "Warmed:")
+}
+
+func (i *instance) isStopped() bool {
+	return i.State != nil && aws.StringValue(i.State.Name) == ec2.InstanceStateNameStopped
+}
+
+func (a *autoScalingGroup) hasWarmPool() bool {
+	return a.Group != nil && a.WarmPoolConfiguration != nil
+}
+
+// instanceLifecycleState returns the lifecycle state of the instance within
+// the group, or false when the instance is not a member of the group.
+func (a *autoScalingGroup) instanceLifecycleState(instanceID string) (string, bool) {
+	for _, inst := range a.Instances {
+		if aws.StringValue(inst.InstanceId) == instanceID {
+			return aws.StringValue(inst.LifecycleState), true
+		}
+	}
+	return "", false
+}
+
+// canReplaceWarmPoolGroupInstance checks that the on-demand instance of a group
+// with a warm pool went InService, and that it wasn't launched from the warm
+// pool when those instances are kept on-demand. The instances of the groups
+// without warm pools can always be replaced.
+func (a *autoScalingGroup) canReplaceWarmPoolGroupInstance(i *instance) bool {
+	if !a.hasWarmPool() {
+		return true
+	}
+
+	state, found := a.instanceLifecycleState(*i.InstanceId)
+	if !found || state != autoscaling.LifecycleStateInService {
+		debug.Println(a.name, "skipping instance", *i.InstanceId,
+			"not yet in service, in state", state)
+		return false
+	}
+
+	if a.config.KeepWarmPoolOnDemand && a.isLaunchedFromWarmPool(*i.InstanceId) {
+		debug.Println(a.name, "keeping on-demand instance", *i.InstanceId,
+			"launched from the warm pool")
+		return false
+	}
+	return true
+}
+
+// isLaunchedFromWarmPool looks up the instance in the recent scaling
+// activities of the group moving instances from the warm pool into service.
+// The instances are considered to be launched from the warm pool when the
+// activities can't be described, so that they're kept on-demand.
+func (a *autoScalingGroup) isLaunchedFromWarmPool(instanceID string) bool {
+	if a.warmPoolInstances == nil {
+		resp, err := a.region.services.autoScaling.DescribeScalingActivities(
+			&autoscaling.DescribeScalingActivitiesInput{
+				AutoScalingGroupName: aws.String(a.name),
+				MaxRecords:           aws.Int64(100),
+			})
+
+		if err != nil {
+			log.Println(a.region.name, a.name, "Couldn't describe the scaling activities:", err.Error())
+			return true
+		}
+
+		a.warmPoolInstances = make(map[string]bool)
+		for _, activity := range resp.Activities {
+			if m := warmPoolActivityRegexp.FindStringSubmatch(aws.StringValue(activity.Description)); m != nil {
+				a.warmPoolInstances[m[1]] = true
+			}
+		}
+	}
+	return a.warmPoolInstances[instanceID]
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2016-2021 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestIsWarmPoolLifecycleState(t *testing.T) {
+	tests := []struct {
+		state string
+		want  bool
+	}{
+		{state: "Warmed:Stopped", want: true},
+		{state: "Warmed:Pending:Wait", want: true},
+		{state: "InService", want: false},
+		{state: "Pending", want: false},
+		{state: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			if got := isWarmPoolLifecycleState(tt.state); got != tt.want {
+				t.Errorf("isWarmPoolLifecycleState() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsStopped(t *testing.T) {
+	tests := []struct {
+		name  string
+		state *ec2.InstanceState
+		want  bool
+	}{
+		{name: "no state", state: nil, want: false},
+		{name: "running",
+			state: &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			want:  false},
+		{name: "stopped",
+			state: &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameStopped)},
+			want:  true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{Instance: &ec2.Instance{State: tt.state}}
+			if got := i.isStopped(); got != tt.want {
+				t.Errorf("isStopped() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanReplaceWarmPoolGroupInstance(t *testing.T) {
+	warmPoolActivities := &autoscaling.DescribeScalingActivitiesOutput{
+		Activities: []*autoscaling.Activity{
+			{Description: aws.String("Launching a new EC2 instance: i-0aaa")},
+			{Description: aws.String("Launching a new EC2 instance from warm pool: i-0bbb")},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		instanceID string
+		warmPool   *autoscaling.WarmPoolConfiguration
+		keepOnDem  bool
+		asgMock    *mockASG
+		want       bool
+	}{
+		{name: "group without warm pool",
+			instanceID: "i-0ccc",
+			want:       true,
+		},
+		{name: "instance in service",
+			instanceID: "i-0aaa",
+			warmPool:   &autoscaling.WarmPoolConfiguration{},
+			want:       true,
+		},
+		{name: "instance still pending",
+			instanceID: "i-0ddd",
+			warmPool:   &autoscaling.WarmPoolConfiguration{},
+			want:       false,
+		},
+		{name: "instance not in the group",
+			instanceID: "i-0eee",
+			warmPool:   &autoscaling.WarmPoolConfiguration{},
+			want:       false,
+		},
+		{name: "instance launched from the warm pool and replaced",
+			instanceID: "i-0bbb",
+			warmPool:   &autoscaling.WarmPoolConfiguration{},
+			asgMock:    &mockASG{dsao: warmPoolActivities},
+			want:       true,
+		},
+		{name: "instance launched from the warm pool kept on-demand",
+			instanceID: "i-0bbb",
+			warmPool:   &autoscaling.WarmPoolConfiguration{},
+			keepOnDem:  true,
+			asgMock:    &mockASG{dsao: warmPoolActivities},
+			want:       false,
+		},
+		{name: "instance launched normally with warm pool kept on-demand",
+			instanceID: "i-0aaa",
+			warmPool:   &autoscaling.WarmPoolConfiguration{},
+			keepOnDem:  true,
+			asgMock:    &mockASG{dsao: warmPoolActivities},
+			want:       true,
+		},
+		{name: "scaling activities can't be described",
+			instanceID: "i-0aaa",
+			warmPool:   &autoscaling.WarmPoolConfiguration{},
+			keepOnDem:  true,
+			asgMock:    &mockASG{dsaerr: errors.New("error")},
+			want:       false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asgMock := tt.asgMock
+			if asgMock == nil {
+				asgMock = &mockASG{}
+			}
+			a := &autoScalingGroup{
+				name: "test-asg",
+				Group: &autoscaling.Group{
+					Instances: []*autoscaling.Instance{
+						{InstanceId: aws.String("i-0aaa"), LifecycleState: aws.String("InService")},
+						{InstanceId: aws.String("i-0bbb"), LifecycleState: aws.String("InService")},
+						{InstanceId: aws.String("i-0ccc"), LifecycleState: aws.String("Warmed:Stopped")},
+						{InstanceId: aws.String("i-0ddd"), LifecycleState: aws.String("Pending")},
+					},
+					WarmPoolConfiguration: tt.warmPool,
+				},
+				region: &region{
+					name:     "us-east-1",
+					services: connections{autoScaling: asgMock},
+				},
+				config: AutoScalingConfig{KeepWarmPoolOnDemand: tt.keepOnDem},
+			}
+			i := &instance{Instance: &ec2.Instance{InstanceId: aws.String(tt.instanceID)}}
+
+			if got := a.canReplaceWarmPoolGroupInstance(i); got != tt.want {
+				t.Errorf("canReplaceWarmPoolGroupInstance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -9,10 +9,12 @@ require (
 	github.com/davecgh/go-spew v1.1.1
 	github.com/mattn/goveralls v0.0.9
 	github.com/namsral/flag v0.0.0-20170814194028-67f268f20922
+	github.com/prometheus/client_golang v1.11.0
 	github.com/robfig/cron/v3 v3.0.1
 	golang.org/x/lint v0.0.0-20210508222113-6edffad5e616
 	golang.org/x/mod v0.5.0 // indirect
 	golang.org/x/sys v0.0.0-20210908233432-aa78b53d3365 // indirect
 	golang.org/x/tools v0.1.5
+	gopkg.in/yaml.v2 v2.3.0
 	gotest.tools/v3 v3.0.0
 )